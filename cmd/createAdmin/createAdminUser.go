@@ -33,7 +33,7 @@ func main() {
 	case "mariadb":
 	}
 
-	store, storeError := persistence.NewMariaDBStore(config.MariaDBHost, config.MariaDBPort, config.MariaDBUsername, config.MariaDBPassword, config.DatabaseName)
+	store, storeError := persistence.NewMariaDBStore(config.MariaDBHost, config.MariaDBPort, config.MariaDBUsername, config.MariaDBPassword, config.DatabaseName, config.DBConnectTimeout, config.MigrationLockTimeout)
 	if storeError != nil {
 		log.Fatal().Err(storeError).Msg("an error occured while initialising the persistence store")
 	}
@@ -45,6 +45,11 @@ func main() {
 
 	log.Printf("args %+v", os.Args)
 
+	strengthErr := auth.ValidatePasswordStrength(os.Args[2], os.Args[1])
+	if strengthErr != nil {
+		log.Fatal().Err(strengthErr).Msg("password does not meet the password policy")
+	}
+
 	hash, hashErr := auth.HashPassword(os.Args[2])
 	if hashErr != nil {
 		log.Error().Err(hashErr).Msg("unable to hash user password")
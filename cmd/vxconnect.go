@@ -2,16 +2,22 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/monoxane/vxconnect/internal/audit"
 	"github.com/monoxane/vxconnect/internal/config"
 	"github.com/monoxane/vxconnect/internal/controller"
 	"github.com/monoxane/vxconnect/internal/dns"
+	"github.com/monoxane/vxconnect/internal/events"
 	"github.com/monoxane/vxconnect/internal/logging"
 	"github.com/monoxane/vxconnect/internal/persistence"
 )
 
 const (
 	ERR_CONFIG_LOAD_FAILED = 1
+	ERR_FORCED_EXIT        = 2
 )
 
 var (
@@ -23,8 +29,6 @@ func main() {
 		os.Exit(ERR_CONFIG_LOAD_FAILED)
 	}
 
-	forever := make(chan bool)
-
 	logging.Configure()
 
 	log = logging.Log.With().Str("package", "cmd").Logger()
@@ -33,7 +37,7 @@ func main() {
 	case "mariadb":
 	}
 
-	store, storeError := persistence.NewMariaDBStore(config.MariaDBHost, config.MariaDBPort, config.MariaDBUsername, config.MariaDBPassword, config.DatabaseName)
+	store, storeError := persistence.NewMariaDBStore(config.MariaDBHost, config.MariaDBPort, config.MariaDBUsername, config.MariaDBPassword, config.DatabaseName, config.DBConnectTimeout, config.MigrationLockTimeout)
 	if storeError != nil {
 		log.Fatal().Err(storeError).Msg("an error occured while initialising the persistence store")
 	}
@@ -43,11 +47,91 @@ func main() {
 		log.Fatal().Err(migrationError).Msg("an error occured while migrating the persistence store")
 	}
 
+	// A threshold of 0 leaves the breaker disabled
+	if config.DBBreakerThreshold > 0 {
+		breakerError := store.EnableCircuitBreaker(config.DBBreakerThreshold, time.Duration(config.DBBreakerCooldown)*time.Second)
+		if breakerError != nil {
+			log.Fatal().Err(breakerError).Msg("unable to enable the persistence store circuit breaker")
+		}
+	}
+
 	dnsService := dns.New()
 	go dnsService.Run()
 
-	controllerSingleton := controller.New(8080, store)
-	go controllerSingleton.Run()
+	bus := events.NewBus()
+	// Only the event log is batched, audit entries and security notifications are kept per event
+	batchWindows := map[string]time.Duration{}
+	for eventType, window := range config.EventBatchWindows {
+		batchWindows[eventType] = time.Duration(window) * time.Millisecond
+	}
+	logHandler, flushBatches := events.Batched(events.LogHandler(logging.Log.With().Str("package", "events").Logger()), batchWindows)
+	bus.Subscribe("log", 100, logHandler)
+	recorder := audit.New(store, config.AuditMode, config.AuditSpillFile)
+	go recorder.Run()
+	// The audit log must see every change, so publishing waits on it rather than dropping entries when it falls behind
+	bus.SubscribeBlocking("audit", 1000, recorder.Handler())
+
+	controllerSingleton := controller.New(8080, store, bus, recorder)
+	controllerSingleton.Run()
+
+	go reloadOnHangup(controllerSingleton)
+	go drainOnTerminate(controllerSingleton)
+
+	<-controllerSingleton.Drained()
+
+	// Events published by the last requests may still be queued, and batches still waiting for their window
+	if !bus.Close(time.Duration(config.DrainTimeout) * time.Second) {
+		log.Warn().Msg("events were still being handled when the drain timeout passed")
+	}
+	flushBatches()
+
+	log.Info().Msg("drained, exiting")
+}
+
+// Drain on SIGTERM (and SIGINT) rather than exiting straight away, so requests that are running get to finish
+// A second SIGINT exits without waiting, for when draining is taking too long in a terminal
+func drainOnTerminate(c *controller.Controller) {
+	terminations := make(chan os.Signal, 1)
+	signal.Notify(terminations, syscall.SIGTERM, syscall.SIGINT)
 
-	<-forever
+	for received := range terminations {
+		if !c.Drain() {
+			if received == syscall.SIGINT {
+				log.Warn().Str("signal", received.String()).Msg("already draining, exiting now")
+				os.Exit(ERR_FORCED_EXIT)
+			}
+
+			log.Warn().Str("signal", received.String()).Msg("already draining")
+			continue
+		}
+
+		log.Info().Str("signal", received.String()).Msg("draining before exit")
+	}
+}
+
+// Re-read the config file on every SIGHUP and apply the settings that can change without a restart
+func reloadOnHangup(c *controller.Controller) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		log.Info().Msg("SIGHUP received, reloading configuration")
+
+		settings, reloadErr := config.Reload()
+		if reloadErr != nil {
+			log.Error().Err(reloadErr).Msg("unable to reload configuration, keeping the current settings")
+			continue
+		}
+
+		if len(settings.RestartRequired) > 0 {
+			log.Warn().Strs("keys", settings.RestartRequired).Msg("configuration changes that need a restart to take effect were ignored")
+		}
+
+		if applyErr := c.Reload(settings); applyErr != nil {
+			log.Error().Err(applyErr).Msg("unable to apply reloaded configuration, keeping the current settings")
+			continue
+		}
+
+		log.Info().Strs("changed", settings.Changed).Msg("configuration reloaded")
+	}
 }
@@ -64,12 +64,12 @@ const headers = [
         sortable: false
     },
     {
-        key: 'created_at',
+        key: 'createdAt',
         header: 'Created',
         sortable: true
     },
     {
-        key: 'updated_at',
+        key: 'updatedAt',
         header: 'Updated',
         sortable: true
     }
@@ -319,8 +319,8 @@ const Users = function Users() {
                                             ))}
                                         </TableCell>
                                         <TableCell>{row.zones.join(', ')}</TableCell>
-                                        <TableCell>{row.created_at}</TableCell>
-                                        <TableCell>{row.updated_at}</TableCell>
+                                        <TableCell>{row.createdAt}</TableCell>
+                                        <TableCell>{row.updatedAt}</TableCell>
                                     </TableRow>
                                 ))}
                             </TableBody>
@@ -45,7 +45,7 @@ import StateManager from "../components/StateManager";
 
 const headers = [
   { label: 'Name', key: 'name', sortable: true },
-  { label: 'Created', key: 'created_at', sortable: true },
+  { label: 'Created', key: 'createdAt', sortable: true },
   { label: 'Actions', key: 'actions', sortable: false }
 ];
 
@@ -258,7 +258,7 @@ const Zones = function Zones() {
                     .map((zone) => (
                       <TableRow key={zone.id}>
                         <TableCell>{zone.name}</TableCell>
-                        <TableCell>{zone.created_at}</TableCell>
+                        <TableCell>{zone.createdAt}</TableCell>
                         <TableCell>
                           <StateManager
                             renderLauncher={({ setOpen }) => (
@@ -0,0 +1,256 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/monoxane/vxconnect/internal/encryption"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/logging"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"gorm.io/gorm"
+)
+
+// How the recorder behaves when the audit store can't be written to
+const (
+	MODE_BEST_EFFORT string = "best-effort" // log and drop the entry
+	MODE_SPILL       string = "spill"       // append the entry to the spill file and replay it once the store recovers
+	MODE_STRICT      string = "strict"      // refuse further changes until the store recovers
+)
+
+const probeInterval = 10 * time.Second
+
+type Recorder struct {
+	store     persistence.Store
+	mode      string
+	spillPath string
+	spillMu   sync.Mutex
+	available atomic.Bool
+	log       logging.Logger
+}
+
+func New(store persistence.Store, mode, spillPath string) *Recorder {
+	recorder := &Recorder{
+		store:     store,
+		mode:      mode,
+		spillPath: spillPath,
+		log:       logging.Log.With().Str("package", "audit").Str("mode", mode).Logger(),
+	}
+	recorder.available.Store(true)
+
+	return recorder
+}
+
+// Periodically check the audit store, marking it available again and replaying any spilled entries once it recovers
+func (r *Recorder) Run() {
+	r.probe()
+
+	for range time.Tick(probeInterval) {
+		r.probe()
+	}
+}
+
+func (r *Recorder) probe() {
+	if err := r.store.Ping(); err != nil {
+		if r.available.Swap(false) {
+			r.log.Error().Err(err).Msg("audit store is unavailable")
+		}
+		return
+	}
+
+	if !r.available.Swap(true) {
+		r.log.Info().Msg("audit store has recovered")
+	}
+
+	if r.mode == MODE_SPILL {
+		r.replay()
+	}
+}
+
+// Whether the audit store accepted its last write or probe
+func (r *Recorder) Available() bool {
+	return r.available.Load()
+}
+
+// Whether changes should be refused because they can't be audited
+func (r *Recorder) Blocking() bool {
+	return r.mode == MODE_STRICT && !r.Available()
+}
+
+// Whether entries are written in the request that made the change, by Record, rather than from the event bus
+func (r *Recorder) Strict() bool {
+	return r.mode == MODE_STRICT
+}
+
+// An event bus subscriber that records every event in the audit log
+// In strict mode the entries have already been written by Record, so events are ignored
+func (r *Recorder) Handler() events.Handler {
+	return func(event events.Event) {
+		if r.Strict() {
+			return
+		}
+
+		r.record(auditEntry(event))
+	}
+}
+
+// Write the audit entry of an event before it is published, so the request that made the change can fail if it can't be audited
+// store is the transaction the change is being made in, so the entry and the change are only ever committed together
+func (r *Recorder) Record(store persistence.Store, event events.Event) error {
+	err := store.CreateAuditEntry(auditEntry(event))
+	r.available.Store(err == nil)
+
+	if err != nil {
+		r.log.Error().Err(err).Str("event", event.Type).Str("actor", event.Actor).Str("target_id", event.TargetID).Msg("unable to store audit entry, failing the request")
+	}
+
+	return err
+}
+
+func auditEntry(event events.Event) *entity.AuditEntry {
+	return &entity.AuditEntry{
+		ID:         uuid.NewString(),
+		TenantID:   event.TenantID,
+		Type:       event.Type,
+		Actor:      event.Actor,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		Data:       event.Data,
+		CreatedAt:  event.Time,
+	}
+}
+
+func (r *Recorder) record(entry *entity.AuditEntry) {
+	err := r.store.CreateAuditEntry(entry)
+	if err == nil {
+		r.available.Store(true)
+		return
+	}
+
+	r.available.Store(false)
+
+	if r.mode == MODE_SPILL {
+		spillErr := r.spill(entry)
+		if spillErr == nil {
+			r.log.Warn().Err(err).Str("event", entry.Type).Msg("unable to store audit entry, spilled to file")
+			return
+		}
+		err = fmt.Errorf("%s, and unable to spill: %w", err, spillErr)
+	}
+
+	r.log.Error().Err(err).Str("event", entry.Type).Str("actor", entry.Actor).Str("target_id", entry.TargetID).Msg("unable to store audit entry, it has been dropped")
+}
+
+// Entries are spilled one JSON line each, encrypted like the audit store when field encryption is on
+func (r *Recorder) spill(entry *entity.AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if encryption.Enabled() {
+		encrypted, encryptErr := encryption.Encrypt(line)
+		if encryptErr != nil {
+			return encryptErr
+		}
+		line = []byte(encrypted)
+	}
+
+	r.spillMu.Lock()
+	defer r.spillMu.Unlock()
+
+	file, err := os.OpenFile(r.spillPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+
+	return err
+}
+
+// Write spilled entries back to the store in the order they were spilled
+// Entries that still can't be written are kept in the spill file for the next attempt
+func (r *Recorder) replay() {
+	r.spillMu.Lock()
+	defer r.spillMu.Unlock()
+
+	file, err := os.Open(r.spillPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	if err != nil {
+		r.log.Error().Err(err).Msg("unable to open audit spill file")
+		return
+	}
+
+	lines := [][]byte{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte{}, scanner.Bytes()...))
+	}
+	file.Close()
+
+	if scanErr := scanner.Err(); scanErr != nil {
+		r.log.Error().Err(scanErr).Msg("unable to read audit spill file")
+		return
+	}
+
+	replayed := 0
+	for _, line := range lines {
+		if encryption.Encrypted(string(line)) {
+			decrypted, decryptErr := encryption.Decrypt(string(line))
+			if decryptErr != nil {
+				r.log.Warn().Err(decryptErr).Int("remaining", len(lines)-replayed).Msg("unable to decrypt audit spill file, will retry")
+				break
+			}
+			line = decrypted
+		}
+
+		entry := &entity.AuditEntry{}
+		if jsonErr := json.Unmarshal(line, entry); jsonErr != nil {
+			r.log.Error().Err(jsonErr).Msg("discarding unreadable audit spill entry")
+			replayed++
+			continue
+		}
+
+		if storeErr := r.store.CreateAuditEntry(entry); storeErr != nil && !errors.Is(storeErr, gorm.ErrDuplicatedKey) {
+			r.log.Warn().Err(storeErr).Int("remaining", len(lines)-replayed).Msg("unable to replay audit spill file, will retry")
+			break
+		}
+		replayed++
+	}
+
+	if replayed == 0 {
+		return
+	}
+
+	r.log.Info().Int("entries", replayed).Msg("replayed spilled audit entries")
+
+	if replayed == len(lines) {
+		if removeErr := os.Remove(r.spillPath); removeErr != nil {
+			r.log.Error().Err(removeErr).Msg("unable to remove audit spill file")
+		}
+		return
+	}
+
+	remaining := []byte{}
+	for _, line := range lines[replayed:] {
+		remaining = append(append(remaining, line...), '\n')
+	}
+
+	if writeErr := os.WriteFile(r.spillPath, remaining, 0600); writeErr != nil {
+		r.log.Error().Err(writeErr).Msg("unable to rewrite audit spill file")
+	}
+}
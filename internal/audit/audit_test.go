@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/persistence"
+)
+
+var errStoreDown = errors.New("store is down")
+
+// An audit store that can be taken down, only the methods the recorder uses are implemented
+type auditStore struct {
+	persistence.Store
+	down    bool
+	entries []*entity.AuditEntry
+}
+
+func (s *auditStore) CreateAuditEntry(entry *entity.AuditEntry) error {
+	if s.down {
+		return errStoreDown
+	}
+
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *auditStore) Ping() error {
+	if s.down {
+		return errStoreDown
+	}
+
+	return nil
+}
+
+func TestSpilledEntriesAreEncryptedAndReplayed(t *testing.T) {
+	previousKeys, previousID := config.FieldEncryptionKeys, config.FieldEncryptionKeyID
+	t.Cleanup(func() { config.FieldEncryptionKeys, config.FieldEncryptionKeyID = previousKeys, previousID })
+	config.FieldEncryptionKeys = map[string][]byte{"2024": bytes.Repeat([]byte{1}, 32)}
+	config.FieldEncryptionKeyID = "2024"
+
+	store := &auditStore{down: true}
+	spillPath := filepath.Join(t.TempDir(), "audit.spill")
+	recorder := New(store, MODE_SPILL, spillPath)
+
+	recorder.Handler()(events.Event{Type: events.USER_UPDATED, TargetType: events.TARGET_USER, TargetID: "user", Data: map[string]string{"email": "admin@example.com"}})
+
+	spilled, readErr := os.ReadFile(spillPath)
+	if readErr != nil {
+		t.Fatalf("expected the entry to be spilled, got %s", readErr)
+	}
+
+	if strings.Contains(string(spilled), "admin@example.com") {
+		t.Fatalf("expected the spill file to not hold the email in plain text, got %s", spilled)
+	}
+
+	store.down = false
+	recorder.probe()
+
+	if len(store.entries) != 1 {
+		t.Fatalf("expected the spilled entry to be replayed, got %d entries", len(store.entries))
+	}
+
+	data, ok := store.entries[0].Data.(map[string]interface{})
+	if !ok || data["email"] != "admin@example.com" {
+		t.Fatalf("expected the replayed entry to keep its data, got %#v", store.entries[0].Data)
+	}
+
+	if _, statErr := os.Stat(spillPath); !errors.Is(statErr, os.ErrNotExist) {
+		t.Fatal("expected the spill file to be removed once replayed")
+	}
+}
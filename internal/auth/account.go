@@ -0,0 +1,12 @@
+package auth
+
+// Reports whether the account a token was issued to can still use it, false once it is disabled or deleted
+type AccountCheck func(username string) (bool, error)
+
+var accountCheck AccountCheck
+
+// Check the account behind every token in JWTMiddleware, so disabling or deleting a user takes effect straight away
+// rather than when their tokens expire
+func SetAccountCheck(check AccountCheck) {
+	accountCheck = check
+}
@@ -0,0 +1,41 @@
+package auth
+
+import "github.com/gin-gonic/gin"
+
+// A single authorization check, returning true if the request is allowed
+type Check func(c *gin.Context) bool
+
+// Allow if every check allows, stopping at the first denial
+// Put cheap in-token checks before anything that has to hit the database
+func AllOf(checks ...Check) Check {
+	return func(c *gin.Context) bool {
+		for _, check := range checks {
+			if !check(c) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Allow if any check allows, stopping at the first that does
+// Put cheap in-token checks before anything that has to hit the database
+func AnyOf(checks ...Check) Check {
+	return func(c *gin.Context) bool {
+		for _, check := range checks {
+			if check(c) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Check the current user has a permission, this only reads the token
+func Permission(permission string) Check {
+	return func(c *gin.Context) bool {
+		return HasPermission(c, permission)
+	}
+}
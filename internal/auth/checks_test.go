@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChecksShortCircuit(t *testing.T) {
+	ran := []string{}
+	check := func(name string, allow bool) Check {
+		return func(c *gin.Context) bool {
+			ran = append(ran, name)
+			return allow
+		}
+	}
+
+	if AllOf(check("cheap", false), check("expensive", true))(nil) || len(ran) != 1 {
+		t.Fatalf("expected AllOf to stop at the first denial, ran %v", ran)
+	}
+
+	ran = []string{}
+	if !AnyOf(check("cheap", true), check("expensive", false))(nil) || len(ran) != 1 {
+		t.Fatalf("expected AnyOf to stop at the first allow, ran %v", ran)
+	}
+
+	ran = []string{}
+	if AnyOf(check("cheap", false), check("expensive", false))(nil) || len(ran) != 2 {
+		t.Fatalf("expected AnyOf to try every check before denying, ran %v", ran)
+	}
+}
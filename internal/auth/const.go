@@ -4,6 +4,10 @@ const (
 	issuer         string = "vxconnect"
 	token_lifespan int    = 24
 
+	// Bounds for per-user token lifetime overrides, in minutes
+	TOKEN_TTL_MIN int = 5
+	TOKEN_TTL_MAX int = 30 * 24 * 60
+
 	ROLE_ADMIN      string = "ADMIN"
 	ROLE_ZONE_ADMIN string = "ZONE_ADMIN"
 )
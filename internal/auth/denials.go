@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/logging"
+)
+
+type denialWindow struct {
+	start time.Time
+	count int
+}
+
+var (
+	denialsMu sync.Mutex
+	denials   = map[string]*denialWindow{}
+)
+
+// Log that an authorization check denied a request, limited to AUTHZ_DENIAL_LOG_LIMIT
+// entries per source IP per minute so a scan can't flood the logs
+func LogDenial(c *gin.Context, action, reason string) {
+	if !config.AuthzDenialLogging {
+		return
+	}
+
+	source := c.ClientIP()
+	now := time.Now()
+
+	denialsMu.Lock()
+	for key, window := range denials {
+		if now.Sub(window.start) > time.Minute {
+			delete(denials, key)
+		}
+	}
+
+	window, ok := denials[source]
+	if !ok {
+		window = &denialWindow{start: now}
+		denials[source] = window
+	}
+	window.count++
+	count := window.count
+	denialsMu.Unlock()
+
+	if count > config.AuthzDenialLogLimit {
+		return
+	}
+
+	username, _ := CurrentUser(c)
+
+	event := logging.Log.Warn().
+		Str("package", "auth").
+		Str("user", username).
+		Str("remote", source).
+		Str("method", c.Request.Method).
+		Str("path", c.Request.URL.Path).
+		Str("action", action).
+		Str("reason", reason)
+
+	if count == config.AuthzDenialLogLimit {
+		event = event.Bool("suppressing", true)
+	}
+
+	event.Msg("authorization denied")
+}
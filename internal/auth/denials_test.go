@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/logging"
+	"github.com/rs/zerolog"
+)
+
+// Send denied requests from remote, returning the JSON log entries written for them
+func logDenials(t *testing.T, remote string, requests int) []map[string]interface{} {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	config.JWTSecret = "test-secret"
+
+	output := &bytes.Buffer{}
+	previous := logging.Log
+	logging.Log = zerolog.New(output).With().Caller().Logger()
+	defer func() { logging.Log = previous }()
+
+	engine := gin.New()
+	engine.GET("/", func(c *gin.Context) {
+		LogDenial(c, "users.delete", "missing permission")
+		c.Status(http.StatusForbidden)
+	})
+
+	token, tokenErr := GenerateToken("alice", "", []string{"ADMIN"}, time.Now(), time.Hour)
+	if tokenErr != nil {
+		t.Fatal(tokenErr)
+	}
+
+	for i := 0; i < requests; i++ {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = remote + ":1234"
+		request.Header.Set("Authorization", "Bearer "+token)
+		engine.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	entries := []map[string]interface{}{}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		entry := map[string]interface{}{}
+		if decodeErr := json.Unmarshal(scanner.Bytes(), &entry); decodeErr != nil {
+			t.Fatalf("expected JSON log entries, got %s", scanner.Text())
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestDenialsNameTheUserWithoutClobberingTheCaller(t *testing.T) {
+	entries := logDenials(t, "192.0.2.20", 1)
+	if len(entries) != 1 {
+		t.Fatalf("expected one denial to be logged, got %d", len(entries))
+	}
+
+	if entries[0]["user"] != "alice" {
+		t.Fatalf("expected the denied user to be logged, got %v", entries[0])
+	}
+
+	if caller, _ := entries[0][zerolog.CallerFieldName].(string); !strings.Contains(caller, "denials.go") {
+		t.Fatalf("expected the caller field to still name the code that logged, got %v", entries[0])
+	}
+}
+
+func TestDenialsAreLimitedPerSource(t *testing.T) {
+	previous := config.AuthzDenialLogLimit
+	config.AuthzDenialLogLimit = 3
+	defer func() { config.AuthzDenialLogLimit = previous }()
+
+	entries := logDenials(t, "192.0.2.21", 5)
+	if len(entries) != 3 {
+		t.Fatalf("expected only %d denials to be logged, got %d", config.AuthzDenialLogLimit, len(entries))
+	}
+
+	if entries[2]["suppressing"] != true {
+		t.Fatalf("expected the last logged denial to say the rest are suppressed, got %v", entries[2])
+	}
+}
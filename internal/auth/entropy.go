@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	PASSWORD_MAX_SCORE int = 4
+
+	// Longer passwords are only estimated on their start, anything past this is strong enough already
+	passwordEstimateMaxLength int = 100
+
+	passwordBruteforceCardinality float64 = 10
+	passwordMinMatchGuesses       float64 = 50
+	passwordYearGuesses           float64 = 120
+)
+
+// The number of guesses below which a password gets each score, from zxcvbn
+var passwordScoreThresholds = []float64{1e3 + 5, 1e6 + 5, 1e8 + 5, 1e10 + 5}
+
+// Ordered most common first, a word's rank is how many guesses it takes to reach it
+var passwordCommonWords = []string{
+	"password", "qwerty", "letmein", "welcome", "admin", "administrator", "login", "iloveyou", "monkey", "dragon",
+	"master", "sunshine", "princess", "football", "baseball", "shadow", "superman", "trustno", "hello", "freedom",
+	"whatever", "secret", "changeme", "default", "root", "user", "guest", "test", "starwars", "computer",
+	"michael", "charlie", "batman", "soccer", "hockey", "killer", "access", "flower", "cookie", "summer",
+	"winter", "spring", "autumn", "love", "money", "pass", "qazwsx", "mustang", "jordan", "hunter",
+	"ranger", "buster", "thomas", "tigger", "robert", "maggie", "harley", "orange", "ginger", "pepper",
+	"internet", "service", "server", "network", "system", "office", "company", "manager", "support", "dns",
+	"vxconnect", "zone", "record", "corporate", "private", "public", "temp", "temporary", "backup", "database",
+}
+
+var passwordCommonRanks = func() map[string]int {
+	ranks := map[string]int{}
+	for rank, word := range passwordCommonWords {
+		if _, exists := ranks[word]; !exists {
+			ranks[word] = rank + 1
+		}
+	}
+	return ranks
+}()
+
+var passwordKeyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// Common character substitutions, 1 is tried as both i and l
+var passwordLeetSubstitutions = map[rune]rune{'0': 'o', '1': 'i', '3': 'e', '4': 'a', '@': 'a', '$': 's', '5': 's', '7': 't', '!': 'i', '+': 't'}
+
+const (
+	passwordPatternWord     string = "this is similar to a commonly used password or word"
+	passwordPatternUsername string = "this is based on the username"
+	passwordPatternSequence string = "sequences like abc or 6543 are easy to guess"
+	passwordPatternRepeat   string = "repeated characters like aaa are easy to guess"
+	passwordPatternKeyboard string = "straight rows of keys like qwerty are easy to guess"
+	passwordPatternYear     string = "recent years are easy to guess"
+	passwordSuggestLonger   string = "add another word or two, uncommon words are better"
+)
+
+type PasswordStrength struct {
+	Score    int
+	Guesses  float64
+	Feedback []string
+}
+
+// A pattern found at password[start:end] and the log10 of the guesses needed to hit it
+type passwordMatch struct {
+	start    int
+	end      int
+	guesses  float64
+	feedback string
+}
+
+// Estimate how guessable a password is, scored 0 (trivial) to PASSWORD_MAX_SCORE (very hard), with feedback for weak ones
+// This is a rough zxcvbn style estimate, covering the password with the cheapest combination of patterns (common words,
+// sequences, repeats, keyboard runs and years) and brute forcing whatever is left
+func EstimatePasswordStrength(password, username string) PasswordStrength {
+	characters := []rune(password)
+	if len(characters) > passwordEstimateMaxLength {
+		characters = characters[:passwordEstimateMaxLength]
+	}
+
+	matches := passwordMatches(characters, strings.ToLower(strings.TrimSpace(username)))
+
+	// best[i] is the fewest guesses (as log10) to cover the first i characters, via[i] the match ending there if any
+	best := make([]float64, len(characters)+1)
+	via := make([]*passwordMatch, len(characters)+1)
+	for i := 1; i <= len(characters); i++ {
+		best[i] = best[i-1] + math.Log10(passwordBruteforceCardinality)
+
+		for m := range matches {
+			match := &matches[m]
+			if match.end == i && best[match.start]+match.guesses < best[i] {
+				best[i] = best[match.start] + match.guesses
+				via[i] = match
+			}
+		}
+	}
+
+	strength := PasswordStrength{Guesses: math.Pow(10, best[len(characters)])}
+	for strength.Score < PASSWORD_MAX_SCORE && strength.Guesses >= passwordScoreThresholds[strength.Score] {
+		strength.Score++
+	}
+
+	if strength.Score >= PASSWORD_MAX_SCORE {
+		return strength
+	}
+
+	// Walk the cheapest cover back from the end, then flip it so the feedback follows the password
+	seen := map[string]bool{}
+	for i := len(characters); i > 0; {
+		if via[i] == nil {
+			i--
+			continue
+		}
+
+		if !seen[via[i].feedback] {
+			seen[via[i].feedback] = true
+			strength.Feedback = append(strength.Feedback, via[i].feedback)
+		}
+		i = via[i].start
+	}
+	for i, j := 0, len(strength.Feedback)-1; i < j; i, j = i+1, j-1 {
+		strength.Feedback[i], strength.Feedback[j] = strength.Feedback[j], strength.Feedback[i]
+	}
+	strength.Feedback = append(strength.Feedback, passwordSuggestLonger)
+
+	return strength
+}
+
+func passwordMatches(characters []rune, username string) []passwordMatch {
+	matches := []passwordMatch{}
+
+	for start := range characters {
+		for end := start + 3; end <= len(characters); end++ {
+			if match, ok := wordMatch(characters, start, end, username); ok {
+				matches = append(matches, match)
+			}
+
+			if match, ok := keyboardMatch(characters, start, end); ok {
+				matches = append(matches, match)
+			}
+
+			if match, ok := yearMatch(characters, start, end); ok {
+				matches = append(matches, match)
+			}
+		}
+	}
+
+	matches = append(matches, runMatches(characters)...)
+
+	return matches
+}
+
+// A common word or the username, ignoring case and common substitutions
+func wordMatch(characters []rune, start, end int, username string) (passwordMatch, bool) {
+	original := characters[start:end]
+
+	for _, candidate := range unleet(original) {
+		if candidate.word == username && username != "" {
+			return newPasswordMatch(start, end, 1*caseVariations(original)*candidate.variations, passwordPatternUsername), true
+		}
+
+		if rank, common := passwordCommonRanks[candidate.word]; common {
+			return newPasswordMatch(start, end, float64(rank)*caseVariations(original)*candidate.variations, passwordPatternWord), true
+		}
+	}
+
+	return passwordMatch{}, false
+}
+
+type unleeted struct {
+	word       string
+	variations float64
+}
+
+func unleet(characters []rune) []unleeted {
+	candidates := []unleeted{}
+
+	for _, one := range []rune{'i', 'l'} {
+		word := []rune{}
+		substitutions := 0
+		for _, character := range characters {
+			character = unicode.ToLower(character)
+			if replacement, ok := passwordLeetSubstitutions[character]; ok {
+				if character == '1' {
+					replacement = one
+				}
+				character = replacement
+				substitutions++
+			}
+			word = append(word, character)
+		}
+
+		candidates = append(candidates, unleeted{word: string(word), variations: math.Pow(2, float64(substitutions))})
+	}
+
+	return candidates
+}
+
+// All lower case is 1, capitalised or all upper case doubles it, anything else scales with the length
+func caseVariations(characters []rune) float64 {
+	upper := 0
+	for _, character := range characters {
+		if unicode.IsUpper(character) {
+			upper++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case upper == len(characters), upper == 1 && unicode.IsUpper(characters[0]):
+		return 2
+	default:
+		return float64(2 * len(characters))
+	}
+}
+
+// At least four keys along a row, forwards or backwards
+func keyboardMatch(characters []rune, start, end int) (passwordMatch, bool) {
+	if end-start < 4 {
+		return passwordMatch{}, false
+	}
+
+	run := strings.ToLower(string(characters[start:end]))
+	for _, row := range passwordKeyboardRows {
+		if strings.Contains(row, run) || strings.Contains(reverse(row), run) {
+			return newPasswordMatch(start, end, float64(len(passwordKeyboardRows)*len(row)*(end-start)), passwordPatternKeyboard), true
+		}
+	}
+
+	return passwordMatch{}, false
+}
+
+func yearMatch(characters []rune, start, end int) (passwordMatch, bool) {
+	if end-start != 4 {
+		return passwordMatch{}, false
+	}
+
+	year := string(characters[start:end])
+	if year >= "1900" && year <= "2099" && strings.Trim(year, passwordDigits) == "" {
+		return newPasswordMatch(start, end, passwordYearGuesses, passwordPatternYear), true
+	}
+
+	return passwordMatch{}, false
+}
+
+// Repeats of one character and sequences stepping by one (abc, 987), at least three long
+func runMatches(characters []rune) []passwordMatch {
+	matches := []passwordMatch{}
+
+	for start := 0; start < len(characters)-2; start++ {
+		repeat := start + 1
+		for repeat < len(characters) && unicode.ToLower(characters[repeat]) == unicode.ToLower(characters[start]) {
+			repeat++
+		}
+		if repeat-start >= 3 {
+			matches = append(matches, newPasswordMatch(start, repeat, characterCardinality(characters[start])*float64(repeat-start), passwordPatternRepeat))
+		}
+
+		step := characters[start+1] - characters[start]
+		if step != 1 && step != -1 {
+			continue
+		}
+
+		sequence := start + 2
+		for sequence < len(characters) && characters[sequence]-characters[sequence-1] == step {
+			sequence++
+		}
+		if sequence-start >= 3 {
+			// zxcvbn treats sequences starting at an obvious character as cheaper, and descending ones as dearer
+			base := characterCardinality(characters[start])
+			if strings.ContainsRune("aAzZ019", characters[start]) {
+				base = 4
+			}
+			if step < 0 {
+				base *= 2
+			}
+			matches = append(matches, newPasswordMatch(start, sequence, base*float64(sequence-start), passwordPatternSequence))
+		}
+	}
+
+	return matches
+}
+
+func characterCardinality(character rune) float64 {
+	switch {
+	case unicode.IsDigit(character):
+		return 10
+	case unicode.IsLetter(character):
+		return 26
+	default:
+		return 33
+	}
+}
+
+func newPasswordMatch(start, end int, guesses float64, feedback string) passwordMatch {
+	return passwordMatch{start: start, end: end, guesses: math.Log10(math.Max(guesses, passwordMinMatchGuesses)), feedback: feedback}
+}
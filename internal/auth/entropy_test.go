@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestLongButWeakPasswordsScoreLow(t *testing.T) {
+	weak := map[string]string{
+		"password123!":        passwordPatternWord,
+		"P@ssw0rd2024!":       passwordPatternYear,
+		"qwertyuiop123!":      passwordPatternKeyboard,
+		"aaaaaaaaaaaa1A!":     passwordPatternRepeat,
+		"Welcome2024!Welcome": passwordPatternWord,
+	}
+
+	for password, pattern := range weak {
+		strength := EstimatePasswordStrength(password, "alice")
+		if strength.Score > 2 {
+			t.Fatalf("expected %q to score low, got %d", password, strength.Score)
+		}
+
+		if !contains(strength.Feedback, pattern) || !contains(strength.Feedback, passwordSuggestLonger) {
+			t.Fatalf("expected feedback on %q to say %q and how to improve it, got %v", password, pattern, strength.Feedback)
+		}
+	}
+
+	for _, password := range []string{"Correct-Horse-Battery-Staple-42", "vT9#qLx2!mZr8@Wp", "tangerine-otter-marble"} {
+		if strength := EstimatePasswordStrength(password, "alice"); strength.Score != PASSWORD_MAX_SCORE || len(strength.Feedback) != 0 {
+			t.Fatalf("expected %q to score %d without feedback, got %+v", password, PASSWORD_MAX_SCORE, strength)
+		}
+	}
+}
+
+func TestPasswordsMustMeetTheMinimumScore(t *testing.T) {
+	setConfig(t, &config.PasswordMinLength, 8)
+	setConfig(t, &config.PasswordRequireMixedCase, true)
+	setConfig(t, &config.PasswordRequireDigit, true)
+	setConfig(t, &config.PasswordRequireSymbol, true)
+	setConfig(t, &config.PasswordMinScore, 3)
+
+	// Passes every character class rule, but is still one of the first things an attacker tries
+	weakErr := ValidatePasswordStrength("Password123!", "alice")
+	if weakErr == nil {
+		t.Fatal("expected a long but weak password to be refused")
+	}
+
+	if !strings.Contains(weakErr.Error(), "scored 1 of 4") || !strings.Contains(weakErr.Error(), passwordPatternWord) {
+		t.Fatalf("expected the score and feedback in the error, got %s", weakErr)
+	}
+
+	if strongErr := ValidatePasswordStrength("Correct-Horse-Battery-Staple-42", "alice"); strongErr != nil {
+		t.Fatalf("expected a strong password to pass, got %s", strongErr)
+	}
+
+	setConfig(t, &config.PasswordMinScore, 0)
+	if weakErr := ValidatePasswordStrength("Password123!", "alice"); weakErr != nil {
+		t.Fatalf("expected the estimate to be skipped when it is off, got %s", weakErr)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+type failures struct {
+	count int
+	first time.Time
+}
+
+// LoginGuard tracks failed logins both per (IP, username) pair and per IP,
+// so neither hammering a single account nor spraying one password across
+// many accounts from the same address goes unnoticed
+type LoginGuard struct {
+	mu           sync.Mutex
+	accounts     map[string]*failures
+	addresses    map[string]*failures
+	accountLimit int
+	addressLimit int
+	window       time.Duration
+}
+
+// Create a LoginGuard, a limit of 0 disables that check
+func NewLoginGuard(accountLimit, addressLimit int, window time.Duration) *LoginGuard {
+	return &LoginGuard{
+		accounts:     map[string]*failures{},
+		addresses:    map[string]*failures{},
+		accountLimit: accountLimit,
+		addressLimit: addressLimit,
+		window:       window,
+	}
+}
+
+// Check if logins for this username from this IP should be refused
+func (guard *LoginGuard) Blocked(ip, username string) bool {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	now := time.Now()
+
+	if guard.accountLimit > 0 && guard.current(guard.accounts, ip+"/"+username, now) >= guard.accountLimit {
+		return true
+	}
+
+	if guard.addressLimit > 0 && guard.current(guard.addresses, ip, now) >= guard.addressLimit {
+		return true
+	}
+
+	return false
+}
+
+// Record a failed login for this username from this IP
+func (guard *LoginGuard) RecordFailure(ip, username string) {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	now := time.Now()
+	guard.prune(now)
+
+	guard.increment(guard.accounts, ip+"/"+username, now)
+	guard.increment(guard.addresses, ip, now)
+}
+
+// Forget the failures for this username from this IP after a successful login
+// The per IP count is left alone so a sprayer can't reset it by guessing one account
+func (guard *LoginGuard) Reset(ip, username string) {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	delete(guard.accounts, ip+"/"+username)
+}
+
+func (guard *LoginGuard) current(counters map[string]*failures, key string, now time.Time) int {
+	counter, ok := counters[key]
+	if !ok {
+		return 0
+	}
+
+	if now.Sub(counter.first) > guard.window {
+		delete(counters, key)
+		return 0
+	}
+
+	return counter.count
+}
+
+func (guard *LoginGuard) increment(counters map[string]*failures, key string, now time.Time) {
+	counter, ok := counters[key]
+	if !ok {
+		counters[key] = &failures{count: 1, first: now}
+		return
+	}
+
+	counter.count++
+}
+
+func (guard *LoginGuard) prune(now time.Time) {
+	for _, counters := range []map[string]*failures{guard.accounts, guard.addresses} {
+		for key, counter := range counters {
+			if now.Sub(counter.first) > guard.window {
+				delete(counters, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoginGuardLocksAnAccountFromOneAddress(t *testing.T) {
+	guard := NewLoginGuard(3, 0, time.Minute)
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if guard.Blocked("192.0.2.10", "alice") {
+			t.Fatalf("expected attempt %d to be allowed", attempt)
+		}
+		guard.RecordFailure("192.0.2.10", "alice")
+	}
+
+	if !guard.Blocked("192.0.2.10", "alice") {
+		t.Fatal("expected the account to be locked from this address")
+	}
+
+	if guard.Blocked("192.0.2.11", "alice") || guard.Blocked("192.0.2.10", "bob") {
+		t.Fatal("expected other addresses and other accounts to be unaffected")
+	}
+}
+
+func TestLoginGuardLocksAnAddressSprayingUsernames(t *testing.T) {
+	guard := NewLoginGuard(3, 5, time.Minute)
+
+	for attempt := 0; attempt < 5; attempt++ {
+		guard.RecordFailure("192.0.2.10", fmt.Sprintf("user-%d", attempt))
+	}
+
+	if !guard.Blocked("192.0.2.10", "someone-new") {
+		t.Fatal("expected failures across many usernames to lock the address")
+	}
+
+	if guard.Blocked("192.0.2.11", "someone-new") {
+		t.Fatal("expected other addresses to be unaffected")
+	}
+}
+
+func TestLoginGuardResetKeepsTheAddressCount(t *testing.T) {
+	guard := NewLoginGuard(2, 3, time.Minute)
+
+	guard.RecordFailure("192.0.2.10", "alice")
+	guard.RecordFailure("192.0.2.10", "alice")
+	guard.Reset("192.0.2.10", "alice")
+
+	if guard.Blocked("192.0.2.10", "alice") {
+		t.Fatal("expected a successful login to unlock the account")
+	}
+
+	guard.RecordFailure("192.0.2.10", "bob")
+
+	if !guard.Blocked("192.0.2.10", "carol") {
+		t.Fatal("expected the reset not to forget the address's failures")
+	}
+}
+
+func TestLoginGuardForgetsFailuresOutsideTheWindow(t *testing.T) {
+	guard := NewLoginGuard(1, 1, 20*time.Millisecond)
+
+	guard.RecordFailure("192.0.2.10", "alice")
+	if !guard.Blocked("192.0.2.10", "alice") {
+		t.Fatal("expected the failure to lock the account")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if guard.Blocked("192.0.2.10", "alice") {
+		t.Fatal("expected the lock to lift once the window has passed")
+	}
+}
+
+func TestLoginGuardLimitsOfZeroDisableTheCheck(t *testing.T) {
+	guard := NewLoginGuard(0, 0, time.Minute)
+
+	for attempt := 0; attempt < 100; attempt++ {
+		guard.RecordFailure("192.0.2.10", "alice")
+	}
+
+	if guard.Blocked("192.0.2.10", "alice") {
+		t.Fatal("expected limits of 0 to never lock anything")
+	}
+}
@@ -1,6 +1,11 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 func HashPassword(password string) (string, error) {
 	var passwordBytes = []byte(password)
@@ -16,3 +21,12 @@ func ValidatePassword(hashedPassword, currPassword string) bool {
 		[]byte(hashedPassword), []byte(currPassword))
 	return err == nil
 }
+
+// Compare two secrets in constant time, use this instead of == for any token or key
+// Both sides are hashed first so the comparison doesn't leak their lengths either
+func SecureCompare(expected, actual string) bool {
+	expectedHash := sha256.Sum256([]byte(expected))
+	actualHash := sha256.Sum256([]byte(actual))
+
+	return subtle.ConstantTimeCompare(expectedHash[:], actualHash[:]) == 1
+}
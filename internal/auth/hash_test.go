@@ -0,0 +1,38 @@
+package auth
+
+import "testing"
+
+func TestSecureCompare(t *testing.T) {
+	cases := []struct {
+		expected string
+		actual   string
+		equal    bool
+	}{
+		{"s3cret-credential", "s3cret-credential", true},
+		{"s3cret-credential", "s3cret-credentiaL", false},
+		{"s3cret-credential", "s3cret", false},
+		{"s3cret-credential", "", false},
+		{"", "", true},
+	}
+
+	for _, tc := range cases {
+		if equal := SecureCompare(tc.expected, tc.actual); equal != tc.equal {
+			t.Fatalf("expected comparing %q to %q to be %t", tc.expected, tc.actual, tc.equal)
+		}
+	}
+}
+
+func TestPasswordsAreComparedThroughTheirHash(t *testing.T) {
+	hash, hashErr := HashPassword("correct horse battery staple")
+	if hashErr != nil {
+		t.Fatal(hashErr)
+	}
+
+	if hash == "correct horse battery staple" {
+		t.Fatal("expected the password to be stored hashed")
+	}
+
+	if !ValidatePassword(hash, "correct horse battery staple") || ValidatePassword(hash, "correct horse battery stapler") {
+		t.Fatal("expected only the hashed password to validate")
+	}
+}
@@ -8,21 +8,55 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
 )
 
-func GenerateToken(username string, roles []string) (string, error) {
+// Generate a token for a user, tenant is the ID of the tenant they belong to ("" for the provider)
+// authTime is when they last entered their password, zero if they didn't and the token can't be used where RecentAuthMiddleware applies
+// ttl is how long the token is valid for, 0 uses the default lifespan
+func GenerateToken(username, tenant string, roles []string, authTime time.Time, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = time.Hour * time.Duration(token_lifespan)
+	}
+
 	claims := jwt.MapClaims{}
 	claims["username"] = username
+	claims["tenant"] = tenant
 	claims["roles"] = roles
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(token_lifespan)).Unix()
+	if !authTime.IsZero() {
+		claims["auth_time"] = authTime.Unix()
+	}
+	claims["exp"] = time.Now().Add(ttl).Unix()
 	claims["issuer"] = issuer
 
-	// Actually generate the Token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Actually generate the Token, signed with the preferred configured algorithm
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(config.JWTAlgorithms[0]), claims)
 
 	return token.SignedString([]byte(config.JWTSecret))
 }
 
+// The lifetime of tokens issued to a user, 0 if they don't override the default
+func TokenTTL(user *entity.User) time.Duration {
+	if user.TokenTTL == nil {
+		return 0
+	}
+
+	return time.Duration(*user.TokenTTL) * time.Minute
+}
+
+// Check a per-user token lifetime override is within the allowed bounds
+func ValidateTokenTTL(minutes *int) error {
+	if minutes == nil {
+		return nil
+	}
+
+	if *minutes < TOKEN_TTL_MIN || *minutes > TOKEN_TTL_MAX {
+		return fmt.Errorf("token ttl must be between %d and %d minutes", TOKEN_TTL_MIN, TOKEN_TTL_MAX)
+	}
+
+	return nil
+}
+
 // Validate if the JWT token is valid, is from this issuer, and is signed with the approriate secret
 // Will return an error if anything is off with the token
 func ValidateToken(c *gin.Context) error {
@@ -30,20 +64,47 @@ func ValidateToken(c *gin.Context) error {
 	tokenString := ExtractToken(c)
 
 	// Parse the token with the JWT library
-	// I Don't know how this does things I just read the docs to implement it
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+	_, err := parseToken(tokenString)
+
+	// If theres an error its not valid
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Parse a token, pinning the signing algorithm to the configured allowlist
+// rather than trusting whatever the token header claims, so "none" and
+// algorithm confusion tokens are always rejected
+func parseToken(tokenString string) (*jwt.Token, error) {
+	parser := &jwt.Parser{ValidMethods: config.JWTAlgorithms}
+
+	return parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method == jwt.SigningMethodNone {
+			return nil, fmt.Errorf("unsigned tokens are not accepted")
+		}
+
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+
+		if !isAllowedAlgorithm(token.Method.Alg()) {
+			return nil, fmt.Errorf("signing method not allowed: %v", token.Method.Alg())
+		}
+
 		return []byte(config.JWTSecret), nil
 	})
+}
 
-	// If theres an error its not valid
-	if err != nil {
-		return err
+func isAllowedAlgorithm(algorithm string) bool {
+	for _, allowed := range config.JWTAlgorithms {
+		if allowed == algorithm {
+			return true
+		}
 	}
 
-	return nil
+	return false
 }
 
 // Extract the JWT Token from the Gin Context
@@ -70,13 +131,7 @@ func CurrentUser(c *gin.Context) (string, error) {
 	tokenString := ExtractToken(c)
 
 	// Parse it
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(config.JWTSecret), nil
-	})
+	token, err := parseToken(tokenString)
 
 	if err != nil {
 		return "", err
@@ -95,13 +150,7 @@ func CurrentUser(c *gin.Context) (string, error) {
 func CurrentUserRoles(c *gin.Context) ([]string, error) {
 	tokenString := ExtractToken(c)
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(config.JWTSecret), nil
-	})
+	token, err := parseToken(tokenString)
 
 	if err != nil {
 		return nil, err
@@ -109,12 +158,121 @@ func CurrentUserRoles(c *gin.Context) ([]string, error) {
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if ok && token.Valid {
+		// Users without any roles are issued tokens whose roles claim is null
+		claimed, _ := claims["roles"].([]interface{})
 		roles := []string{}
-		for _, role := range claims["roles"].([]interface{}) {
-			roles = append(roles, role.(string))
+		for _, role := range claimed {
+			if name, ok := role.(string); ok {
+				roles = append(roles, name)
+			}
 		}
 		return roles, nil
 	}
 
 	return nil, nil
 }
+
+// Get the ID of the tenant the current user belongs to, "" for the provider
+func CurrentUserTenant(c *gin.Context) (string, error) {
+	tokenString := ExtractToken(c)
+
+	token, err := parseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if ok && token.Valid {
+		// Tokens issued before tenants existed can only belong to the provider
+		tenant, _ := claims["tenant"].(string)
+		return tenant, nil
+	}
+
+	return "", nil
+}
+
+// Get the time the current user last entered their password
+func CurrentUserAuthTime(c *gin.Context) (time.Time, error) {
+	tokenString := ExtractToken(c)
+
+	token, err := parseToken(tokenString)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if ok && token.Valid {
+		authTime, ok := claims["auth_time"].(float64)
+		if !ok {
+			return time.Time{}, fmt.Errorf("token has no auth_time claim")
+		}
+
+		return time.Unix(int64(authTime), 0), nil
+	}
+
+	return time.Time{}, nil
+}
+
+// Decode a token for debugging without requiring it to be valid
+// The result only describes the token, it must never be used to authenticate anything
+func InspectToken(tokenString string) (*entity.TokenInspection, error) {
+	unverified, _, parseErr := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	claims, _ := unverified.Claims.(jwt.MapClaims)
+
+	inspection := &entity.TokenInspection{
+		Algorithm: unverified.Method.Alg(),
+		Header:    unverified.Header,
+		Claims:    claims,
+	}
+
+	if kid, ok := unverified.Header["kid"].(string); ok {
+		inspection.KeyID = kid
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt := time.Unix(int64(exp), 0)
+		inspection.ExpiresAt = &expiresAt
+		inspection.Expired = time.Now().After(expiresAt)
+	}
+
+	_, validErr := parseToken(tokenString)
+	if validErr != nil {
+		inspection.Error = validErr.Error()
+
+		// The signature is fine if the only problems are with the claims (eg. it has expired)
+		if validationErr, ok := validErr.(*jwt.ValidationError); ok {
+			inspection.SignatureValid = validationErr.Errors&(jwt.ValidationErrorMalformed|jwt.ValidationErrorUnverifiable|jwt.ValidationErrorSignatureInvalid) == 0
+		}
+	} else {
+		inspection.SignatureValid = true
+		inspection.Valid = true
+	}
+
+	return inspection, nil
+}
+
+// Get when the current user's token expires
+func CurrentUserExpiry(c *gin.Context) (time.Time, error) {
+	tokenString := ExtractToken(c)
+
+	token, err := parseToken(tokenString)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if ok && token.Valid {
+		expiry, ok := claims["exp"].(float64)
+		if !ok {
+			return time.Time{}, fmt.Errorf("token has no exp claim")
+		}
+
+		return time.Unix(int64(expiry), 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("token is invalid")
+}
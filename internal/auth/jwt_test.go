@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func tokenClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"username": "alice",
+		"tenant":   "",
+		"roles":    []string{ROLE_ADMIN},
+		"exp":      time.Now().Add(time.Hour).Unix(),
+		"issuer":   issuer,
+	}
+}
+
+func TestTokensMustUseAnAllowedAlgorithm(t *testing.T) {
+	config.JWTSecret = "test-secret"
+
+	previous := config.JWTAlgorithms
+	config.JWTAlgorithms = []string{"HS256"}
+	t.Cleanup(func() { config.JWTAlgorithms = previous })
+
+	signed, signedErr := GenerateToken("alice", "", []string{ROLE_ADMIN}, time.Now(), time.Hour)
+	if signedErr != nil {
+		t.Fatal(signedErr)
+	}
+
+	if _, parseErr := parseToken(signed); parseErr != nil {
+		t.Fatalf("expected a token signed with the allowed algorithm to be accepted, got %s", parseErr)
+	}
+
+	unsigned, unsignedErr := jwt.NewWithClaims(jwt.SigningMethodNone, tokenClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if unsignedErr != nil {
+		t.Fatal(unsignedErr)
+	}
+
+	if _, parseErr := parseToken(unsigned); parseErr == nil {
+		t.Fatal("expected an unsigned token to be rejected")
+	}
+
+	mismatched, mismatchedErr := jwt.NewWithClaims(jwt.SigningMethodHS512, tokenClaims()).SignedString([]byte(config.JWTSecret))
+	if mismatchedErr != nil {
+		t.Fatal(mismatchedErr)
+	}
+
+	if _, parseErr := parseToken(mismatched); parseErr == nil {
+		t.Fatal("expected a token signed with an algorithm that isn't allowed to be rejected")
+	}
+
+	key, keyErr := rsa.GenerateKey(rand.Reader, 2048)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+
+	asymmetric, asymmetricErr := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims()).SignedString(key)
+	if asymmetricErr != nil {
+		t.Fatal(asymmetricErr)
+	}
+
+	config.JWTAlgorithms = []string{"HS256", "RS256"}
+	if _, parseErr := parseToken(asymmetric); parseErr == nil {
+		t.Fatal("expected an RS256 token to never be verified with the shared secret")
+	}
+}
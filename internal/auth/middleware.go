@@ -2,8 +2,11 @@ package auth
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/utilities"
 )
 
 func JWTMiddleware() gin.HandlerFunc {
@@ -16,6 +19,46 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if accountCheck != nil {
+			username, _ := CurrentUser(c)
+
+			active, checkErr := accountCheck(username)
+			if checkErr != nil {
+				utilities.RESTError(c, http.StatusServiceUnavailable, "unable to check account", checkErr)
+				c.Abort()
+				return
+			}
+
+			if !active {
+				LogDenial(c, c.Request.Method+" "+c.FullPath(), "account is disabled or deleted")
+				c.String(http.StatusUnauthorized, "Unauthorized")
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// Require the user to have entered their password within REAUTH_MAX_AGE minutes before
+// allowing a sensitive action, clients can refresh this through the reauth endpoint
+// Tokens without an auth_time were never issued for a password (eg. for a client certificate) and are refused even when REAUTH_MAX_AGE is off
+func RecentAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authTime, err := CurrentUserAuthTime(c)
+		if err == nil && config.ReauthMaxAge <= 0 {
+			c.Next()
+			return
+		}
+
+		if err != nil || time.Since(authTime) > time.Duration(config.ReauthMaxAge)*time.Minute {
+			LogDenial(c, c.Request.Method+" "+c.FullPath(), "authentication is not recent enough")
+			utilities.RESTError(c, http.StatusUnauthorized, "re-authentication required", err)
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestJWTMiddlewareRejectsDisabledAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.JWTSecret = "test-secret"
+
+	disabled := map[string]bool{}
+	var checkErr error
+	SetAccountCheck(func(username string) (bool, error) {
+		return !disabled[username], checkErr
+	})
+	defer SetAccountCheck(nil)
+
+	engine := gin.New()
+	engine.GET("/", JWTMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	token, tokenErr := GenerateToken("alice", "", []string{"ADMIN"}, time.Now(), time.Hour)
+	if tokenErr != nil {
+		t.Fatal(tokenErr)
+	}
+
+	get := func() int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		engine.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if status := get(); status != http.StatusOK {
+		t.Fatalf("expected an active account's token to work, got %d", status)
+	}
+
+	disabled["alice"] = true
+	if status := get(); status != http.StatusUnauthorized {
+		t.Fatalf("expected the token to stop working once the account is disabled, got %d", status)
+	}
+
+	disabled["alice"] = false
+	checkErr = errors.New("database is down")
+	if status := get(); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected a failed account check to fail closed with 503, got %d", status)
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+const (
+	generatedPasswordLength int = 24
+
+	passwordLower   string = "abcdefghijklmnopqrstuvwxyz"
+	passwordUpper   string = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	passwordDigits  string = "0123456789"
+	passwordSymbols string = "!@#$%^&*()-_=+[]{}<>?"
+)
+
+// A username shorter than this is only rejected when it is the whole password, not when contained in it
+const passwordUsernameMinContained int = 3
+
+// Check a password for the given username against the configured password policy
+func ValidatePasswordStrength(password, username string) error {
+	if len(password) < config.PasswordMinLength {
+		return fmt.Errorf("password must be at least %d characters", config.PasswordMinLength)
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, character := range password {
+		switch {
+		case unicode.IsLower(character):
+			hasLower = true
+		case unicode.IsUpper(character):
+			hasUpper = true
+		case unicode.IsDigit(character):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	if config.PasswordRequireMixedCase && (!hasLower || !hasUpper) {
+		return errors.New("password must contain both upper and lower case letters")
+	}
+
+	if config.PasswordRequireDigit && !hasDigit {
+		return errors.New("password must contain a digit")
+	}
+
+	if config.PasswordRequireSymbol && !hasSymbol {
+		return errors.New("password must contain a symbol")
+	}
+
+	if config.PasswordDisallowUsername && derivedFromUsername(password, username) {
+		return errors.New("password must not be based on the username")
+	}
+
+	if config.PasswordMinScore > 0 {
+		strength := EstimatePasswordStrength(password, username)
+		if strength.Score < config.PasswordMinScore {
+			return fmt.Errorf("password is too easy to guess, it scored %d of %d and at least %d is required: %s", strength.Score, PASSWORD_MAX_SCORE, config.PasswordMinScore, strings.Join(strength.Feedback, "; "))
+		}
+	}
+
+	return nil
+}
+
+// Whether a password is the username, contains it, or is it reversed, ignoring case and
+// any digits or symbols tacked on to either end (eg. Admin123!)
+func derivedFromUsername(password, username string) bool {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" {
+		return false
+	}
+
+	password = strings.ToLower(password)
+	core := strings.TrimFunc(password, func(character rune) bool {
+		return !unicode.IsLetter(character)
+	})
+
+	if core == username || core == reverse(username) {
+		return true
+	}
+
+	return len(username) >= passwordUsernameMinContained && strings.Contains(password, username)
+}
+
+func reverse(value string) string {
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+
+	return string(runes)
+}
+
+// Generate a random password that satisfies the configured password policy
+func GeneratePassword() (string, error) {
+	length := generatedPasswordLength
+	if config.PasswordMinLength > length {
+		length = config.PasswordMinLength
+	}
+
+	// Start with one character from every class so the policy is always met, then fill the rest from all of them
+	classes := []string{passwordLower, passwordUpper, passwordDigits, passwordSymbols}
+	all := strings.Join(classes, "")
+
+	password := []byte{}
+	for _, class := range classes {
+		character, err := randomCharacter(class)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, character)
+	}
+
+	for len(password) < length {
+		character, err := randomCharacter(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, character)
+	}
+
+	// Shuffle so the guaranteed characters aren't always at the start
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+func randomCharacter(characters string) (byte, error) {
+	index, err := rand.Int(rand.Reader, big.NewInt(int64(len(characters))))
+	if err != nil {
+		return 0, err
+	}
+
+	return characters[index.Int64()], nil
+}
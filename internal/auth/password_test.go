@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+// Set a config value for the rest of the test
+func setConfig[T any](t *testing.T, setting *T, value T) {
+	t.Helper()
+
+	previous := *setting
+	*setting = value
+	t.Cleanup(func() { *setting = previous })
+}
+
+func strictPasswordPolicy(t *testing.T, minLength int) {
+	t.Helper()
+
+	setConfig(t, &config.PasswordMinLength, minLength)
+	setConfig(t, &config.PasswordRequireMixedCase, true)
+	setConfig(t, &config.PasswordRequireDigit, true)
+	setConfig(t, &config.PasswordRequireSymbol, true)
+	setConfig(t, &config.PasswordDisallowUsername, true)
+	setConfig(t, &config.PasswordMinScore, PASSWORD_MAX_SCORE)
+}
+
+func TestGeneratedPasswordsPassThePolicy(t *testing.T) {
+	for _, minLength := range []int{8, generatedPasswordLength, 40} {
+		strictPasswordPolicy(t, minLength)
+
+		seen := map[string]bool{}
+		for i := 0; i < 200; i++ {
+			password, generateErr := GeneratePassword()
+			if generateErr != nil {
+				t.Fatal(generateErr)
+			}
+
+			if strengthErr := ValidatePasswordStrength(password, "alice"); strengthErr != nil {
+				t.Fatalf("expected generated password %q to pass a %d character policy, got %s", password, minLength, strengthErr)
+			}
+
+			if seen[password] {
+				t.Fatalf("expected generated passwords to never repeat, got %q twice", password)
+			}
+			seen[password] = true
+		}
+	}
+}
+
+func TestPasswordsMustNotBeDerivedFromTheUsername(t *testing.T) {
+	setConfig(t, &config.PasswordMinLength, 4)
+	setConfig(t, &config.PasswordMinScore, 0)
+	setConfig(t, &config.PasswordDisallowUsername, true)
+
+	for _, password := range []string{"alice", "ALICE", "Alice123!", "2024alice", "ecila", "my-alice-password"} {
+		if strengthErr := ValidatePasswordStrength(password, "alice"); strengthErr == nil {
+			t.Fatalf("expected %q to be refused for alice", password)
+		}
+	}
+
+	for _, password := range []string{"correct-horse-battery", "Wonderland42!"} {
+		if strengthErr := ValidatePasswordStrength(password, "alice"); strengthErr != nil {
+			t.Fatalf("expected %q to be accepted for alice, got %s", password, strengthErr)
+		}
+	}
+
+	// Short usernames are only refused as the whole password, otherwise they'd rule out too much
+	if strengthErr := ValidatePasswordStrength("jobs-are-fun", "jo"); strengthErr != nil {
+		t.Fatalf("expected a password merely containing a short username to be accepted, got %s", strengthErr)
+	}
+
+	setConfig(t, &config.PasswordDisallowUsername, false)
+	if strengthErr := ValidatePasswordStrength("Alice123!", "alice"); strengthErr != nil {
+		t.Fatalf("expected the rule to be off when disabled, got %s", strengthErr)
+	}
+}
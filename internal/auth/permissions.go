@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	PERMISSION_USERS_READ    string = "users:read"
+	PERMISSION_USERS_WRITE   string = "users:write"
+	PERMISSION_ZONES_LIST    string = "zones:list"
+	PERMISSION_ZONES_READ    string = "zones:read"
+	PERMISSION_ZONES_WRITE   string = "zones:write"
+	PERMISSION_RECORDS_READ  string = "records:read"
+	PERMISSION_RECORDS_WRITE string = "records:write"
+	PERMISSION_ROLES_READ    string = "roles:read"
+	PERMISSION_AUDIT_READ    string = "audit:read"
+	PERMISSION_TOKENS_DEBUG  string = "tokens:debug"
+	PERMISSION_APPROVALS     string = "approvals:decide"
+	PERMISSION_BUNDLE        string = "bundle:manage"
+	PERMISSION_TENANTS       string = "tenants:manage"
+	PERMISSION_DRAIN         string = "instance:drain"
+	PERMISSION_METRICS       string = "instance:metrics"
+)
+
+// Human readable descriptions of every permission, used when previewing a role
+var permissionDescriptions = map[string]string{
+	PERMISSION_USERS_READ:    "List and view user accounts",
+	PERMISSION_USERS_WRITE:   "Create, update and delete user accounts",
+	PERMISSION_ZONES_LIST:    "List every zone managed by vxconnect",
+	PERMISSION_ZONES_READ:    "View an individual zone",
+	PERMISSION_ZONES_WRITE:   "Create and delete zones",
+	PERMISSION_RECORDS_READ:  "View the records in a zone",
+	PERMISSION_RECORDS_WRITE: "Create, update and delete the records in a zone",
+	PERMISSION_ROLES_READ:    "Preview the permissions granted by a role",
+	PERMISSION_AUDIT_READ:    "Search the audit log",
+	PERMISSION_TOKENS_DEBUG:  "Decode arbitrary tokens for debugging",
+	PERMISSION_APPROVALS:     "View, approve and reject critical actions requested by other admins",
+	PERMISSION_BUNDLE:        "Export and import every user (including password hashes) and zone",
+	PERMISSION_TENANTS:       "List and create tenants, only from the provider tenant",
+	PERMISSION_DRAIN:         "Take an instance out of the load balancer and shut it down once its requests finish",
+	PERMISSION_METRICS:       "View an instance's metrics, such as the state of its database circuit breaker",
+}
+
+// The built in mapping of which permissions each role is granted
+var defaultRolePermissions = map[string][]string{
+	ROLE_ADMIN: {
+		PERMISSION_USERS_READ,
+		PERMISSION_USERS_WRITE,
+		PERMISSION_ZONES_LIST,
+		PERMISSION_ZONES_READ,
+		PERMISSION_ZONES_WRITE,
+		PERMISSION_RECORDS_READ,
+		PERMISSION_RECORDS_WRITE,
+		PERMISSION_ROLES_READ,
+		PERMISSION_AUDIT_READ,
+		PERMISSION_TOKENS_DEBUG,
+		PERMISSION_APPROVALS,
+		PERMISSION_BUNDLE,
+		PERMISSION_TENANTS,
+		PERMISSION_DRAIN,
+		PERMISSION_METRICS,
+	},
+	ROLE_ZONE_ADMIN: {
+		PERMISSION_ZONES_READ,
+		PERMISSION_RECORDS_READ,
+		PERMISSION_RECORDS_WRITE,
+	},
+}
+
+// The role to permission mapping compiled into sets for fast lookups
+// It is only ever replaced whole so a check never sees a half updated mapping
+type compiledPermissions struct {
+	roles map[string][]string
+	sets  map[string]map[string]bool
+}
+
+var currentPermissions atomic.Pointer[compiledPermissions]
+
+func init() {
+	if err := SetRolePermissions(nil); err != nil {
+		panic(err)
+	}
+}
+
+// Replace the role to permission mapping, every check after this returns uses the new mapping
+// An empty mapping puts the built in one back
+func SetRolePermissions(roles map[string][]string) error {
+	if len(roles) == 0 {
+		roles = defaultRolePermissions
+	}
+
+	compiled := &compiledPermissions{
+		roles: make(map[string][]string, len(roles)),
+		sets:  make(map[string]map[string]bool, len(roles)),
+	}
+
+	for role, permissions := range roles {
+		set := make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			if _, ok := permissionDescriptions[permission]; !ok {
+				return fmt.Errorf("role %s is granted unknown permission %s", role, permission)
+			}
+			set[permission] = true
+		}
+
+		compiled.roles[role] = append([]string{}, permissions...)
+		compiled.sets[role] = set
+	}
+
+	currentPermissions.Store(compiled)
+	return nil
+}
+
+// Get the permissions granted by a role, the bool is false if the role is unknown
+func RolePermissions(role string) ([]string, bool) {
+	permissions, ok := currentPermissions.Load().roles[role]
+	return permissions, ok
+}
+
+// Get the human readable description of a permission
+func PermissionDescription(permission string) string {
+	return permissionDescriptions[permission]
+}
+
+func HasPermission(context *gin.Context, permission string) bool {
+	currentUserRoles, err := CurrentUserRoles(context)
+	if err != nil {
+		return false
+	}
+
+	return RolesGrant(currentUserRoles, permission)
+}
+
+// Whether any of the roles grants the permission
+func RolesGrant(roles []string, permission string) bool {
+	compiled := currentPermissions.Load()
+	for _, role := range roles {
+		if compiled.sets[role][permission] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Every permission granted by a set of roles, sorted
+func PermissionsForRoles(roles []string) []string {
+	permissions := []string{}
+	for permission := range permissionDescriptions {
+		if RolesGrant(roles, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	sort.Strings(permissions)
+	return permissions
+}
@@ -0,0 +1,49 @@
+package auth
+
+import "testing"
+
+func TestEveryGrantedPermissionIsDescribed(t *testing.T) {
+	for role, permissions := range defaultRolePermissions {
+		for _, permission := range permissions {
+			if PermissionDescription(permission) == "" {
+				t.Fatalf("expected %s granted to %s to have a description", permission, role)
+			}
+		}
+	}
+}
+
+func TestEditedRolePermissionsApplyToTheNextCheck(t *testing.T) {
+	t.Cleanup(func() {
+		if err := SetRolePermissions(defaultRolePermissions); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if RolesGrant([]string{ROLE_ZONE_ADMIN}, PERMISSION_ZONES_WRITE) {
+		t.Fatal("expected zone admins not to be able to write zones by default")
+	}
+
+	if err := SetRolePermissions(map[string][]string{ROLE_ZONE_ADMIN: {PERMISSION_ZONES_READ, PERMISSION_ZONES_WRITE}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !RolesGrant([]string{ROLE_ZONE_ADMIN}, PERMISSION_ZONES_WRITE) {
+		t.Fatal("expected the granted permission to apply to the next check")
+	}
+
+	if RolesGrant([]string{ROLE_ZONE_ADMIN}, PERMISSION_RECORDS_WRITE) {
+		t.Fatal("expected the removed permission to be refused on the next check")
+	}
+
+	if RolesGrant([]string{ROLE_ADMIN}, PERMISSION_USERS_READ) {
+		t.Fatal("expected a role left out of the mapping to grant nothing")
+	}
+
+	if err := SetRolePermissions(map[string][]string{ROLE_ZONE_ADMIN: {"zones:own"}}); err == nil {
+		t.Fatal("expected a mapping with an unknown permission to be refused")
+	}
+
+	if !RolesGrant([]string{ROLE_ZONE_ADMIN}, PERMISSION_ZONES_WRITE) {
+		t.Fatal("expected a refused mapping to leave the current one in place")
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+// Routes mapped to PERMISSION_DYNAMIC are let through the policy middleware
+// and must perform their own (usually resource scoped) permission checks
+const PERMISSION_DYNAMIC string = "dynamic"
+
+// Authorise every request against a policy map of "METHOD /route" to the permission it requires
+// Routes that are missing from the map are denied so nothing is ever accidentally left open
+func PolicyMiddleware(policies map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + strings.TrimPrefix(c.FullPath(), config.BasePath)
+
+		permission, ok := policies[route]
+		if !ok {
+			denyPolicy(c, route, "route has no policy")
+			return
+		}
+
+		if permission != PERMISSION_DYNAMIC && !HasPermission(c, permission) {
+			denyPolicy(c, route, "missing permission "+permission)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func denyPolicy(c *gin.Context, route, reason string) {
+	LogDenial(c, route, reason)
+	utilities.RESTError(c, http.StatusUnauthorized, "user does not have permission to access this resource", nil)
+	c.Abort()
+}
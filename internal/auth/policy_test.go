@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestPolicyDeniesBeforeTheHandlerRuns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.JWTSecret = "test-secret"
+
+	policies := map[string]string{
+		"GET /users":     PERMISSION_USERS_READ,
+		"GET /zones/:id": PERMISSION_DYNAMIC,
+	}
+
+	ran := map[string]bool{}
+	handler := func(c *gin.Context) {
+		ran[c.FullPath()] = true
+		c.Status(http.StatusOK)
+	}
+
+	engine := gin.New()
+	guarded := engine.Group("", JWTMiddleware(), PolicyMiddleware(policies))
+	guarded.GET("/users", handler)
+	guarded.GET("/zones/:id", handler)
+	guarded.GET("/unmapped", handler)
+
+	token, tokenErr := GenerateToken("zoner", "", []string{ROLE_ZONE_ADMIN}, time.Now(), time.Hour)
+	if tokenErr != nil {
+		t.Fatal(tokenErr)
+	}
+
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		engine.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if status := get("/users"); status != http.StatusUnauthorized || ran["/users"] {
+		t.Fatalf("expected a route needing a permission the user lacks to be denied before its handler, got %d", status)
+	}
+
+	if status := get("/unmapped"); status != http.StatusUnauthorized || ran["/unmapped"] {
+		t.Fatalf("expected a route without a policy to be denied, got %d", status)
+	}
+
+	if status := get("/zones/example"); status != http.StatusOK || !ran["/zones/:id"] {
+		t.Fatalf("expected a dynamic route to be left to its handler, got %d", status)
+	}
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const usernameMaxLength int = 64
+
+// Letters, digits and . _ @ - so usernames are safe in logs, URLs and email addresses, starting with a letter or digit
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// Check a username can be given to a new account
+func ValidateUsername(username string) error {
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+
+	if len(username) > usernameMaxLength {
+		return fmt.Errorf("username must be at most %d characters", usernameMaxLength)
+	}
+
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username may only contain letters, digits and . _ @ -, and must start with a letter or digit")
+	}
+
+	return nil
+}
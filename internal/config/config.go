@@ -1,17 +1,93 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
+// A budget of Requests per Window seconds
+type RateLimit struct {
+	Requests int
+	Window   int
+}
+
 var (
 	AppMode  string = "PROD"
 	LogLevel string = "INFO"
+	BasePath string
+
+	RequestMaxDuration int = 60
+
+	DrainDelay   int = 15
+	DrainTimeout int = 30
+
+	JWTSecret     string
+	JWTAlgorithms []string = []string{"HS256"}
+	ReauthMaxAge  int
+
+	TokenRefreshWindow int = 30
+
+	LoginMaxAccountFailures int = 5
+	LoginMaxAddressFailures int = 20
+	LoginFailureWindow      int = 15
+	LoginHistoryLimit       int = 50
+
+	PasswordMinLength        int = 8
+	PasswordRequireMixedCase bool
+	PasswordRequireDigit     bool
+	PasswordRequireSymbol    bool
+	PasswordDisallowUsername bool = true
+	PasswordMinScore         int  // 0 to 4, 0 skips the guessability estimate entirely
+
+	BreakGlassCredential string
+
+	MagicLinkEnabled  bool
+	MagicLinkTTL      int = 15 // Minutes
+	MagicLinkURL      string
+	MagicLinkCooldown int = 60 // Seconds before another link is sent to the same address or account
+
+	SMTPHost     string
+	SMTPPort     int = 587
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	ApprovalActions []string
+
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	MTLSGroups      []string
+	MTLSUsers       map[string]string = map[string]string{} // Client certificate SHA-256 fingerprint (lower case hex) to username
+
+	EmailLowercaseLocalPart bool
+
+	GoneForDeleted bool
 
-	JWTSecret string
+	AuthzDenialLogging  bool = true
+	AuthzDenialLogLimit int  = 10
+
+	EventBatchWindows map[string]int = map[string]int{} // Event type to the milliseconds its events are batched for
+
+	AuditMode      string = "best-effort"
+	AuditSpillFile string = "audit-spill.jsonl"
+
+	SortDefaults map[string]string = map[string]string{}
+
+	CORSAllowedOrigins []string
+	CORSOverrides      map[string][]string = map[string][]string{}
+
+	RateLimits       map[string]RateLimit = map[string]RateLimit{}
+	RateLimitHeaders bool                 = true
+
+	RolePermissions map[string][]string = map[string][]string{} // Role to the permissions it grants, the built in mapping when empty
 
 	PersistenceDriver string
 	MariaDBHost       string
@@ -19,6 +95,23 @@ var (
 	MariaDBUsername   string
 	MariaDBPassword   string
 	DatabaseName      string
+
+	DBConnectTimeout     int = 60
+	MigrationLockTimeout int = 60
+
+	ResourceLocking     string = "local"
+	ResourceLockTimeout int    = 10
+
+	DBBreakerThreshold int = 5
+	DBBreakerCooldown  int = 30
+
+	FieldEncryptionKeys     map[string][]byte = map[string][]byte{} // Key id to AES-256 key
+	FieldEncryptionKeyID    string                                  // The key new values are encrypted with
+	FieldEncryptionIndexKey []byte                                  // HMAC key for looking up encrypted emails
+
+	MultiTenant  bool
+	TenantHeader string = "X-Tenant"
+	TenantDomain string
 )
 
 func Load() bool {
@@ -34,6 +127,7 @@ func Load() bool {
 			return false
 		}
 	}
+	loadedSettings = viper.AllSettings()
 
 	if viper.IsSet("APP_MODE") {
 		AppMode = viper.GetString("APP_MODE")
@@ -45,6 +139,30 @@ func Load() bool {
 		log.Printf("[ENV] Log Level: %s", LogLevel)
 	}
 
+	// The path prefix vxconnect is served under when behind a reverse proxy, eg. /vxconnect
+	if viper.IsSet("BASE_PATH") {
+		BasePath = "/" + strings.Trim(viper.GetString("BASE_PATH"), "/")
+		if BasePath == "/" {
+			BasePath = ""
+		}
+		log.Printf("[ENV] Base Path: %s", BasePath)
+	}
+
+	if viper.IsSet("REQUEST_MAX_DURATION") {
+		RequestMaxDuration = viper.GetInt("REQUEST_MAX_DURATION")
+		log.Printf("[ENV] Request Max Duration: %d seconds", RequestMaxDuration)
+	}
+
+	if viper.IsSet("DRAIN_DELAY") {
+		DrainDelay = viper.GetInt("DRAIN_DELAY")
+		log.Printf("[ENV] Drain Delay: %d seconds", DrainDelay)
+	}
+
+	if viper.IsSet("DRAIN_TIMEOUT") {
+		DrainTimeout = viper.GetInt("DRAIN_TIMEOUT")
+		log.Printf("[ENV] Drain Timeout: %d seconds", DrainTimeout)
+	}
+
 	if viper.IsSet("JWT_SECRET") {
 		JWTSecret = viper.GetString("JWT_SECRET")
 		log.Printf("[ENV] JWT Secret Set")
@@ -53,6 +171,395 @@ func Load() bool {
 		return false
 	}
 
+	if viper.IsSet("JWT_ALGORITHMS") {
+		JWTAlgorithms = []string{}
+		for _, algorithm := range strings.Split(viper.GetString("JWT_ALGORITHMS"), ",") {
+			algorithm = strings.ToUpper(strings.TrimSpace(algorithm))
+
+			switch algorithm {
+			case "HS256", "HS384", "HS512":
+				JWTAlgorithms = append(JWTAlgorithms, algorithm)
+			default:
+				log.Printf("[ENV] UNSUPPORTED JWT ALGORITHM %s", algorithm)
+				return false
+			}
+		}
+		log.Printf("[ENV] JWT Algorithms: %s", strings.Join(JWTAlgorithms, ","))
+	}
+
+	if viper.IsSet("REAUTH_MAX_AGE") {
+		ReauthMaxAge = viper.GetInt("REAUTH_MAX_AGE")
+		log.Printf("[ENV] Re-authentication Max Age: %d minutes", ReauthMaxAge)
+	}
+
+	if viper.IsSet("TOKEN_REFRESH_WINDOW") {
+		TokenRefreshWindow = viper.GetInt("TOKEN_REFRESH_WINDOW")
+		log.Printf("[ENV] Token Refresh Window: %d minutes", TokenRefreshWindow)
+	}
+
+	if viper.IsSet("LOGIN_MAX_ACCOUNT_FAILURES") {
+		LoginMaxAccountFailures = viper.GetInt("LOGIN_MAX_ACCOUNT_FAILURES")
+		log.Printf("[ENV] Login Max Failures per IP and Username: %d", LoginMaxAccountFailures)
+	}
+
+	if viper.IsSet("LOGIN_MAX_ADDRESS_FAILURES") {
+		LoginMaxAddressFailures = viper.GetInt("LOGIN_MAX_ADDRESS_FAILURES")
+		log.Printf("[ENV] Login Max Failures per IP: %d", LoginMaxAddressFailures)
+	}
+
+	if viper.IsSet("LOGIN_FAILURE_WINDOW") {
+		LoginFailureWindow = viper.GetInt("LOGIN_FAILURE_WINDOW")
+		log.Printf("[ENV] Login Failure Window: %d minutes", LoginFailureWindow)
+	}
+
+	if viper.IsSet("LOGIN_HISTORY_LIMIT") {
+		LoginHistoryLimit = viper.GetInt("LOGIN_HISTORY_LIMIT")
+		if LoginHistoryLimit < 1 {
+			log.Printf("[ENV] LOGIN_HISTORY_LIMIT MUST BE AT LEAST 1")
+			return false
+		}
+		log.Printf("[ENV] Login History Limit: %d per user", LoginHistoryLimit)
+	}
+
+	if viper.IsSet("DB_CONNECT_TIMEOUT") {
+		DBConnectTimeout = viper.GetInt("DB_CONNECT_TIMEOUT")
+		log.Printf("[ENV] DB Connect Timeout: %d seconds", DBConnectTimeout)
+	}
+
+	if viper.IsSet("MIGRATION_LOCK_TIMEOUT") {
+		MigrationLockTimeout = viper.GetInt("MIGRATION_LOCK_TIMEOUT")
+		log.Printf("[ENV] Migration Lock Timeout: %d seconds", MigrationLockTimeout)
+	}
+
+	// How mutations of a single user or zone are serialised, off, local (in process) or db (shared between instances)
+	if viper.IsSet("RESOURCE_LOCKING") {
+		ResourceLocking = strings.ToLower(viper.GetString("RESOURCE_LOCKING"))
+		switch ResourceLocking {
+		case "off", "local", "db":
+		default:
+			log.Printf("[ENV] UNSUPPORTED RESOURCE_LOCKING %s", ResourceLocking)
+			return false
+		}
+		log.Printf("[ENV] Resource Locking: %s", ResourceLocking)
+	}
+
+	if viper.IsSet("RESOURCE_LOCK_TIMEOUT") {
+		ResourceLockTimeout = viper.GetInt("RESOURCE_LOCK_TIMEOUT")
+		log.Printf("[ENV] Resource Lock Timeout: %d seconds", ResourceLockTimeout)
+	}
+
+	if viper.IsSet("DB_BREAKER_THRESHOLD") {
+		DBBreakerThreshold = viper.GetInt("DB_BREAKER_THRESHOLD")
+		log.Printf("[ENV] DB Circuit Breaker Threshold: %d consecutive failures", DBBreakerThreshold)
+	}
+
+	if viper.IsSet("DB_BREAKER_COOLDOWN") {
+		DBBreakerCooldown = viper.GetInt("DB_BREAKER_COOLDOWN")
+		if DBBreakerCooldown < 1 {
+			log.Printf("[ENV] DB_BREAKER_COOLDOWN MUST BE AT LEAST 1 SECOND")
+			return false
+		}
+		log.Printf("[ENV] DB Circuit Breaker Cooldown: %d seconds", DBBreakerCooldown)
+	}
+
+	if viper.IsSet("PASSWORD_MIN_LENGTH") {
+		PasswordMinLength = viper.GetInt("PASSWORD_MIN_LENGTH")
+		log.Printf("[ENV] Password Min Length: %d", PasswordMinLength)
+	}
+
+	if viper.IsSet("PASSWORD_REQUIRE_MIXED_CASE") {
+		PasswordRequireMixedCase = viper.GetBool("PASSWORD_REQUIRE_MIXED_CASE")
+		log.Printf("[ENV] Password Require Mixed Case: %t", PasswordRequireMixedCase)
+	}
+
+	if viper.IsSet("PASSWORD_REQUIRE_DIGIT") {
+		PasswordRequireDigit = viper.GetBool("PASSWORD_REQUIRE_DIGIT")
+		log.Printf("[ENV] Password Require Digit: %t", PasswordRequireDigit)
+	}
+
+	if viper.IsSet("PASSWORD_REQUIRE_SYMBOL") {
+		PasswordRequireSymbol = viper.GetBool("PASSWORD_REQUIRE_SYMBOL")
+		log.Printf("[ENV] Password Require Symbol: %t", PasswordRequireSymbol)
+	}
+
+	if viper.IsSet("PASSWORD_DISALLOW_USERNAME") {
+		PasswordDisallowUsername = viper.GetBool("PASSWORD_DISALLOW_USERNAME")
+		log.Printf("[ENV] Password Disallow Username: %t", PasswordDisallowUsername)
+	}
+
+	if viper.IsSet("PASSWORD_MIN_SCORE") {
+		PasswordMinScore = viper.GetInt("PASSWORD_MIN_SCORE")
+		if PasswordMinScore < 0 || PasswordMinScore > 4 {
+			log.Printf("[ENV] PASSWORD_MIN_SCORE MUST BE BETWEEN 0 AND 4")
+			return false
+		}
+		log.Printf("[ENV] Password Min Score: %d", PasswordMinScore)
+	}
+
+	if viper.IsSet("EMAIL_LOWERCASE_LOCAL_PART") {
+		EmailLowercaseLocalPart = viper.GetBool("EMAIL_LOWERCASE_LOCAL_PART")
+		log.Printf("[ENV] Email Lowercase Local Part: %t", EmailLowercaseLocalPart)
+	}
+
+	if viper.IsSet("MULTI_TENANT") {
+		MultiTenant = viper.GetBool("MULTI_TENANT")
+		log.Printf("[ENV] Multi Tenant: %t", MultiTenant)
+	}
+
+	if viper.IsSet("TENANT_HEADER") {
+		TenantHeader = viper.GetString("TENANT_HEADER")
+		log.Printf("[ENV] Tenant Header: %s", TenantHeader)
+	}
+
+	if viper.IsSet("TENANT_DOMAIN") {
+		TenantDomain = strings.ToLower(strings.Trim(viper.GetString("TENANT_DOMAIN"), "."))
+		log.Printf("[ENV] Tenant Domain: %s", TenantDomain)
+	}
+
+	if viper.IsSet("FIELD_ENCRYPTION_KEYS") {
+		keys, ok := parseEncryptionKeys(viper.GetString("FIELD_ENCRYPTION_KEYS"))
+		if !ok {
+			return false
+		}
+		FieldEncryptionKeys = keys
+
+		if viper.IsSet("FIELD_ENCRYPTION_KEY_ID") {
+			FieldEncryptionKeyID = viper.GetString("FIELD_ENCRYPTION_KEY_ID")
+		} else if len(keys) == 1 {
+			for id := range keys {
+				FieldEncryptionKeyID = id
+			}
+		}
+
+		if _, ok := keys[FieldEncryptionKeyID]; !ok {
+			log.Printf("[ENV] FIELD_ENCRYPTION_KEY_ID MUST NAME ONE OF FIELD_ENCRYPTION_KEYS")
+			return false
+		}
+		log.Printf("[ENV] Field Encryption Key: %s", FieldEncryptionKeyID)
+
+		indexKey, indexErr := base64.StdEncoding.DecodeString(viper.GetString("FIELD_ENCRYPTION_INDEX_KEY"))
+		if indexErr != nil || len(indexKey) < 32 {
+			log.Printf("[ENV] FIELD_ENCRYPTION_INDEX_KEY MUST BE AT LEAST 32 BASE64 ENCODED BYTES")
+			return false
+		}
+		FieldEncryptionIndexKey = indexKey
+		log.Printf("[ENV] Field Encryption Index Key Set")
+	}
+
+	if viper.IsSet("GONE_FOR_DELETED") {
+		GoneForDeleted = viper.GetBool("GONE_FOR_DELETED")
+		log.Printf("[ENV] Gone For Deleted: %t", GoneForDeleted)
+	}
+
+	if viper.IsSet("AUTHZ_DENIAL_LOGGING") {
+		AuthzDenialLogging = viper.GetBool("AUTHZ_DENIAL_LOGGING")
+		log.Printf("[ENV] Authorization Denial Logging: %t", AuthzDenialLogging)
+	}
+
+	if viper.IsSet("AUTHZ_DENIAL_LOG_LIMIT") {
+		AuthzDenialLogLimit = viper.GetInt("AUTHZ_DENIAL_LOG_LIMIT")
+		log.Printf("[ENV] Authorization Denial Log Limit: %d per minute", AuthzDenialLogLimit)
+	}
+
+	if viper.IsSet("BREAK_GLASS_CREDENTIAL") {
+		BreakGlassCredential = viper.GetString("BREAK_GLASS_CREDENTIAL")
+		if len(BreakGlassCredential) < 32 {
+			log.Printf("[ENV] BREAK_GLASS_CREDENTIAL MUST BE AT LEAST 32 CHARACTERS")
+			return false
+		}
+		log.Printf("[ENV] !!! BREAK-GLASS EMERGENCY ADMIN ACCESS IS ENABLED !!!")
+	}
+
+	if viper.IsSet("SMTP_HOST") {
+		SMTPHost = viper.GetString("SMTP_HOST")
+		log.Printf("[ENV] SMTP Host: %s", SMTPHost)
+	}
+
+	if viper.IsSet("SMTP_PORT") {
+		SMTPPort = viper.GetInt("SMTP_PORT")
+		log.Printf("[ENV] SMTP Port: %d", SMTPPort)
+	}
+
+	if viper.IsSet("SMTP_USERNAME") {
+		SMTPUsername = viper.GetString("SMTP_USERNAME")
+		log.Printf("[ENV] SMTP Username Set")
+	}
+
+	if viper.IsSet("SMTP_PASSWORD") {
+		SMTPPassword = viper.GetString("SMTP_PASSWORD")
+		log.Printf("[ENV] SMTP Password Set")
+	}
+
+	if viper.IsSet("SMTP_FROM") {
+		SMTPFrom = viper.GetString("SMTP_FROM")
+		log.Printf("[ENV] SMTP From: %s", SMTPFrom)
+	}
+
+	if viper.IsSet("MAGIC_LINK_ENABLED") {
+		MagicLinkEnabled = viper.GetBool("MAGIC_LINK_ENABLED")
+		log.Printf("[ENV] Magic Link Enabled: %t", MagicLinkEnabled)
+	}
+
+	if viper.IsSet("MAGIC_LINK_TTL") {
+		MagicLinkTTL = viper.GetInt("MAGIC_LINK_TTL")
+		if MagicLinkTTL < 1 || MagicLinkTTL > 60 {
+			log.Printf("[ENV] MAGIC_LINK_TTL MUST BE BETWEEN 1 AND 60 MINUTES")
+			return false
+		}
+		log.Printf("[ENV] Magic Link TTL: %d minutes", MagicLinkTTL)
+	}
+
+	if viper.IsSet("MAGIC_LINK_URL") {
+		MagicLinkURL = viper.GetString("MAGIC_LINK_URL")
+		log.Printf("[ENV] Magic Link URL: %s", MagicLinkURL)
+	}
+
+	if viper.IsSet("MAGIC_LINK_COOLDOWN") {
+		MagicLinkCooldown = viper.GetInt("MAGIC_LINK_COOLDOWN")
+		if MagicLinkCooldown < 1 {
+			log.Printf("[ENV] MAGIC_LINK_COOLDOWN MUST BE AT LEAST 1 SECOND")
+			return false
+		}
+		log.Printf("[ENV] Magic Link Cooldown: %d seconds", MagicLinkCooldown)
+	}
+
+	if MagicLinkEnabled && (SMTPHost == "" || SMTPFrom == "" || MagicLinkURL == "") {
+		log.Printf("[ENV] MAGIC_LINK_ENABLED REQUIRES SMTP_HOST, SMTP_FROM AND MAGIC_LINK_URL")
+		return false
+	}
+
+	// What to do with audit entries when the audit store is unavailable, see internal/audit
+	if viper.IsSet("AUDIT_MODE") {
+		AuditMode = strings.ToLower(viper.GetString("AUDIT_MODE"))
+		switch AuditMode {
+		case "best-effort", "spill", "strict":
+		default:
+			log.Printf("[ENV] UNSUPPORTED AUDIT_MODE %s", AuditMode)
+			return false
+		}
+		log.Printf("[ENV] Audit Mode: %s", AuditMode)
+	}
+
+	if viper.IsSet("AUDIT_SPILL_FILE") {
+		AuditSpillFile = viper.GetString("AUDIT_SPILL_FILE")
+		log.Printf("[ENV] Audit Spill File: %s", AuditSpillFile)
+	}
+
+	// Critical actions that need a second admin to approve them, see internal/controller/approvals.go
+	if viper.IsSet("APPROVAL_ACTIONS") {
+		ApprovalActions = []string{}
+		for _, action := range strings.Split(viper.GetString("APPROVAL_ACTIONS"), ",") {
+			if action = strings.TrimSpace(action); action != "" {
+				ApprovalActions = append(ApprovalActions, action)
+			}
+		}
+		log.Printf("[ENV] Actions Requiring Approval: %s", strings.Join(ApprovalActions, ","))
+	}
+
+	if viper.IsSet("TLS_CERT_FILE") {
+		TLSCertFile = viper.GetString("TLS_CERT_FILE")
+		log.Printf("[ENV] TLS Certificate File: %s", TLSCertFile)
+	}
+
+	if viper.IsSet("TLS_KEY_FILE") {
+		TLSKeyFile = viper.GetString("TLS_KEY_FILE")
+		log.Printf("[ENV] TLS Key File: %s", TLSKeyFile)
+	}
+
+	if (TLSCertFile == "") != (TLSKeyFile == "") {
+		log.Printf("[ENV] TLS_CERT_FILE AND TLS_KEY_FILE MUST BE SET TOGETHER")
+		return false
+	}
+
+	if viper.IsSet("TLS_CLIENT_CA_FILE") {
+		TLSClientCAFile = viper.GetString("TLS_CLIENT_CA_FILE")
+		log.Printf("[ENV] TLS Client CA File: %s", TLSClientCAFile)
+	}
+
+	if viper.IsSet("MTLS_GROUPS") {
+		MTLSGroups = []string{}
+		for _, group := range strings.Split(viper.GetString("MTLS_GROUPS"), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				MTLSGroups = append(MTLSGroups, group)
+			}
+		}
+		log.Printf("[ENV] Route Groups Requiring Client Certificates: %s", strings.Join(MTLSGroups, ","))
+	}
+
+	if len(MTLSGroups) > 0 && (TLSCertFile == "" || TLSClientCAFile == "") {
+		log.Printf("[ENV] MTLS_GROUPS REQUIRES TLS_CERT_FILE, TLS_KEY_FILE AND TLS_CLIENT_CA_FILE")
+		return false
+	}
+
+	// Formatted as fingerprint=username,fingerprint=username, fingerprints are the SHA-256 of the certificate in hex, optionally separated by colons
+	// Names in a certificate are only as unique as the CA makes them, so certificates are identified by fingerprint
+	if viper.IsSet("MTLS_USERS") {
+		for _, mapping := range strings.Split(viper.GetString("MTLS_USERS"), ",") {
+			fingerprint, username, found := strings.Cut(mapping, "=")
+			fingerprint, username = normalizeFingerprint(fingerprint), strings.TrimSpace(username)
+			if decoded, decodeErr := hex.DecodeString(fingerprint); !found || decodeErr != nil || len(decoded) != sha256.Size || username == "" {
+				log.Printf("[ENV] INVALID MTLS USER MAPPING %s", mapping)
+				return false
+			}
+			MTLSUsers[fingerprint] = username
+		}
+		log.Printf("[ENV] Client Certificate User Mappings: %d", len(MTLSUsers))
+	}
+
+	// Default list orders, a field name prefixed with - for descending (eg. -created_at)
+	for resource, key := range map[string]string{"users": "SORT_DEFAULT_USERS", "zones": "SORT_DEFAULT_ZONES", "records": "SORT_DEFAULT_RECORDS"} {
+		if viper.IsSet(key) {
+			SortDefaults[resource] = viper.GetString(key)
+			log.Printf("[ENV] Default Sort for %s: %s", resource, SortDefaults[resource])
+		}
+	}
+
+	if viper.IsSet("CORS_ALLOWED_ORIGINS") {
+		origins, ok := parseOrigins(viper.GetString("CORS_ALLOWED_ORIGINS"))
+		if !ok {
+			return false
+		}
+		CORSAllowedOrigins = origins
+		log.Printf("[ENV] CORS Allowed Origins: %s", strings.Join(CORSAllowedOrigins, ","))
+	}
+
+	if viper.IsSet("CORS_OVERRIDES") {
+		overrides, ok := parseCORSOverrides(viper.GetString("CORS_OVERRIDES"))
+		if !ok {
+			return false
+		}
+		CORSOverrides = overrides
+	}
+
+	if viper.IsSet("RATE_LIMITS") {
+		limits, ok := parseRateLimits(viper.GetString("RATE_LIMITS"))
+		if !ok {
+			return false
+		}
+		RateLimits = limits
+	}
+
+	if viper.IsSet("ROLE_PERMISSIONS") {
+		roles, ok := parseRolePermissions(viper.GetString("ROLE_PERMISSIONS"))
+		if !ok {
+			return false
+		}
+		RolePermissions = roles
+	}
+
+	if viper.IsSet("RATE_LIMIT_HEADERS") {
+		RateLimitHeaders = viper.GetBool("RATE_LIMIT_HEADERS")
+		log.Printf("[ENV] Rate Limit Headers: %t", RateLimitHeaders)
+	}
+
+	if viper.IsSet("EVENT_BATCH_WINDOWS") {
+		windows, ok := parseEventBatchWindows(viper.GetString("EVENT_BATCH_WINDOWS"))
+		if !ok {
+			return false
+		}
+		EventBatchWindows = windows
+	}
+
 	if viper.IsSet("PERSISTENCE_DRIVER") {
 		PersistenceDriver = viper.GetString("PERSISTENCE_DRIVER")
 
@@ -109,3 +616,132 @@ func Load() bool {
 
 	return true
 }
+
+// CORS origins for single route groups, formatted as group=origin,origin;group=origin
+func parseCORSOverrides(list string) (map[string][]string, bool) {
+	overrides := map[string][]string{}
+	for _, override := range strings.Split(list, ";") {
+		group, originList, found := strings.Cut(override, "=")
+		group = strings.TrimSpace(group)
+		if !found || group == "" {
+			log.Printf("[ENV] INVALID CORS OVERRIDE %s", override)
+			return nil, false
+		}
+
+		origins, ok := parseOrigins(originList)
+		if !ok {
+			return nil, false
+		}
+		overrides[group] = origins
+		log.Printf("[ENV] CORS Override for %s: %s", group, strings.Join(origins, ","))
+	}
+
+	return overrides, true
+}
+
+// A comma separated list of event types and how long to batch them for in milliseconds, eg. user.updated=500,record.created=1000
+func parseEventBatchWindows(list string) (map[string]int, bool) {
+	windows := map[string]int{}
+	for _, entry := range strings.Split(list, ",") {
+		eventType, milliseconds, found := strings.Cut(entry, "=")
+		eventType = strings.TrimSpace(eventType)
+		window := 0
+		_, windowErr := fmt.Sscan(milliseconds, &window)
+		if !found || eventType == "" || windowErr != nil || window < 1 {
+			log.Printf("[ENV] INVALID EVENT BATCH WINDOW %s", entry)
+			return nil, false
+		}
+		windows[eventType] = window
+		log.Printf("[ENV] Event Batch Window for %s: %dms", eventType, window)
+	}
+	return windows, true
+}
+
+// Field encryption keys, eg. 2024=<base64>,2025=<base64>, each must be 32 base64 encoded bytes for AES-256
+func parseEncryptionKeys(list string) (map[string][]byte, bool) {
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(list, ",") {
+		id, encoded, found := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		key, keyErr := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if !found || id == "" || strings.Contains(id, ":") || keyErr != nil || len(key) != 32 {
+			log.Printf("[ENV] INVALID FIELD ENCRYPTION KEY %s", id)
+			return nil, false
+		}
+		keys[id] = key
+	}
+	log.Printf("[ENV] Field Encryption Keys: %d", len(keys))
+	return keys, true
+}
+
+// A certificate fingerprint as lower case hex without separators, however it was written
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
+
+// Per route group limits, eg. bundle=5/60;users=300/60 for 5 requests a minute to /bundle and 300 to /users
+func parseRateLimits(list string) (map[string]RateLimit, bool) {
+	limits := map[string]RateLimit{}
+	for _, entry := range strings.Split(list, ";") {
+		group, budget, found := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		requests, window, slash := strings.Cut(strings.TrimSpace(budget), "/")
+		limit := RateLimit{}
+		_, requestsErr := fmt.Sscan(requests, &limit.Requests)
+		_, windowErr := fmt.Sscan(window, &limit.Window)
+		if !found || !slash || group == "" || requestsErr != nil || windowErr != nil || limit.Requests < 1 || limit.Window < 1 {
+			log.Printf("[ENV] INVALID RATE LIMIT %s", entry)
+			return nil, false
+		}
+		limits[group] = limit
+		log.Printf("[ENV] Rate Limit for %s: %d requests per %d seconds", group, limit.Requests, limit.Window)
+	}
+
+	return limits, true
+}
+
+// The permissions granted by each role, eg. ADMIN=users:read,users:write;ZONE_ADMIN=zones:read
+// Permission names are checked when the mapping is applied, a role can be given no permissions with ROLE=
+func parseRolePermissions(list string) (map[string][]string, bool) {
+	roles := map[string][]string{}
+	for _, entry := range strings.Split(list, ";") {
+		role, permissionList, found := strings.Cut(entry, "=")
+		role = strings.TrimSpace(role)
+		if !found || role == "" {
+			log.Printf("[ENV] INVALID ROLE PERMISSIONS %s", entry)
+			return nil, false
+		}
+
+		permissions := []string{}
+		for _, permission := range strings.Split(permissionList, ",") {
+			if permission = strings.TrimSpace(permission); permission != "" {
+				permissions = append(permissions, permission)
+			}
+		}
+		roles[role] = permissions
+		log.Printf("[ENV] Permissions for %s: %s", role, strings.Join(permissions, ","))
+	}
+
+	return roles, true
+}
+
+// Parse a comma separated list of CORS origins, each must be * or a bare http(s) origin
+func parseOrigins(list string) ([]string, bool) {
+	origins := []string{}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			origins = append(origins, origin)
+			continue
+		}
+
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+			log.Printf("[ENV] INVALID CORS ORIGIN %s", origin)
+			return nil, false
+		}
+		origins = append(origins, strings.TrimSuffix(origin, "/"))
+	}
+
+	return origins, true
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// The keys that take effect on SIGHUP, everything else needs a restart
+var reloadableKeys = []string{"LOG_LEVEL", "CORS_ALLOWED_ORIGINS", "CORS_OVERRIDES", "RATE_LIMITS", "ROLE_PERMISSIONS"}
+
+// The config file as it was when the running settings were loaded
+var loadedSettings = map[string]interface{}{}
+
+// The settings that can be changed without a restart
+type Reloadable struct {
+	LogLevel           string
+	CORSAllowedOrigins []string
+	CORSOverrides      map[string][]string
+	RateLimits         map[string]RateLimit
+	RolePermissions    map[string][]string
+
+	// The reloadable keys whose value differs from the running settings
+	Changed []string
+	// Keys that differ from the running settings but only take effect after a restart
+	RestartRequired []string
+
+	settings map[string]interface{}
+}
+
+// Re-read the config file and parse the reloadable settings from it, without applying anything
+// Keys removed from the file go back to their defaults, environment variables still take precedence
+func Reload() (*Reloadable, error) {
+	v := viper.New()
+	v.SetConfigName(".env")
+	v.SetConfigType("env")
+	v.AddConfigPath(".")
+	v.AutomaticEnv()
+
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("unable to read configuration file: %s", err)
+		}
+	}
+
+	reloaded := &Reloadable{
+		LogLevel:        "INFO",
+		CORSOverrides:   map[string][]string{},
+		RateLimits:      map[string]RateLimit{},
+		RolePermissions: map[string][]string{},
+		settings:        v.AllSettings(),
+	}
+
+	if v.IsSet("LOG_LEVEL") {
+		reloaded.LogLevel = v.GetString("LOG_LEVEL")
+	}
+
+	if v.IsSet("CORS_ALLOWED_ORIGINS") {
+		origins, ok := parseOrigins(v.GetString("CORS_ALLOWED_ORIGINS"))
+		if !ok {
+			return nil, fmt.Errorf("invalid CORS_ALLOWED_ORIGINS")
+		}
+		reloaded.CORSAllowedOrigins = origins
+	}
+
+	if v.IsSet("CORS_OVERRIDES") {
+		overrides, ok := parseCORSOverrides(v.GetString("CORS_OVERRIDES"))
+		if !ok {
+			return nil, fmt.Errorf("invalid CORS_OVERRIDES")
+		}
+		reloaded.CORSOverrides = overrides
+	}
+
+	if v.IsSet("RATE_LIMITS") {
+		limits, ok := parseRateLimits(v.GetString("RATE_LIMITS"))
+		if !ok {
+			return nil, fmt.Errorf("invalid RATE_LIMITS")
+		}
+		reloaded.RateLimits = limits
+	}
+
+	if v.IsSet("ROLE_PERMISSIONS") {
+		roles, ok := parseRolePermissions(v.GetString("ROLE_PERMISSIONS"))
+		if !ok {
+			return nil, fmt.Errorf("invalid ROLE_PERMISSIONS")
+		}
+		reloaded.RolePermissions = roles
+	}
+
+	keys := map[string]bool{}
+	for key := range loadedSettings {
+		keys[key] = true
+	}
+	for key := range reloaded.settings {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		if reflect.DeepEqual(loadedSettings[key], reloaded.settings[key]) {
+			continue
+		}
+
+		if reloadable(key) {
+			reloaded.Changed = append(reloaded.Changed, strings.ToUpper(key))
+		} else {
+			reloaded.RestartRequired = append(reloaded.RestartRequired, strings.ToUpper(key))
+		}
+	}
+
+	return reloaded, nil
+}
+
+// Make reloaded settings the running settings, once they have been applied everywhere they are used
+func (reloaded *Reloadable) Commit() {
+	LogLevel = reloaded.LogLevel
+	CORSAllowedOrigins = reloaded.CORSAllowedOrigins
+	CORSOverrides = reloaded.CORSOverrides
+	RateLimits = reloaded.RateLimits
+	RolePermissions = reloaded.RolePermissions
+
+	// Restart only keys keep their loaded value so they are still reported until the restart happens
+	for _, key := range reloadableKeys {
+		key = strings.ToLower(key)
+		if value, ok := reloaded.settings[key]; ok {
+			loadedSettings[key] = value
+		} else {
+			delete(loadedSettings, key)
+		}
+	}
+}
+
+func reloadable(key string) bool {
+	for _, reloadableKey := range reloadableKeys {
+		if strings.EqualFold(reloadableKey, key) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/utilities"
+	"gorm.io/gorm"
+)
+
+// Critical actions that can be configured in APPROVAL_ACTIONS to need a second admin's approval
+const (
+	APPROVAL_DELETE_ADMIN string = "user.delete_admin"
+	APPROVAL_DELETE_ZONE  string = "zone.delete"
+)
+
+// Returned by an approved action that is no longer allowed by the time it is carried out
+var (
+	errZoneReadOnly = errors.New("zone is read-only for maintenance")
+	errLastAdmin    = errors.New("unable to delete the last enabled admin")
+)
+
+// What to do once each kind of action has been approved
+var approvalExecutors = map[string]func(c *Controller, context *gin.Context, action *entity.PendingAction) error{
+	APPROVAL_DELETE_ADMIN: func(c *Controller, context *gin.Context, action *entity.PendingAction) error {
+		// Other admins may have been disabled or deleted since the deletion was requested
+		user, userErr := c.store(context).GetUserById(action.TargetID)
+		if userErr != nil {
+			return userErr
+		}
+
+		unlockAdmins, lockErr := c.lockResources(adminsLock)
+		if lockErr != nil {
+			return lockErr
+		}
+		defer c.untilCommitted(context, unlockAdmins)()
+
+		last, lastErr := lastEnabledAdmin(c.store(context), user)
+		if lastErr != nil {
+			return lastErr
+		}
+
+		if last {
+			return errLastAdmin
+		}
+
+		if err := c.store(context).DeleteUser(action.TargetID); err != nil {
+			return err
+		}
+
+		c.publish(context, events.USER_DELETED, events.TARGET_USER, action.TargetID, approvalEventData(action))
+		return nil
+	},
+	APPROVAL_DELETE_ZONE: func(c *Controller, context *gin.Context, action *entity.PendingAction) error {
+		// The zone may have been marked read-only since the deletion was requested
+		zone, zoneErr := c.store(context).GetZoneByID(action.TargetID)
+		if zoneErr != nil {
+			return zoneErr
+		}
+
+		if zone.ReadOnly {
+			return errZoneReadOnly
+		}
+
+		if err := c.store(context).DeleteZone(action.TargetID); err != nil {
+			return err
+		}
+
+		c.publish(context, events.ZONE_DELETED, events.TARGET_ZONE, action.TargetID, approvalEventData(action))
+		return nil
+	},
+}
+
+func validateApprovalActions() error {
+	for _, action := range config.ApprovalActions {
+		if _, ok := approvalExecutors[action]; !ok {
+			return fmt.Errorf("unknown approval action %s", action)
+		}
+	}
+
+	return nil
+}
+
+func requiresApproval(action string) bool {
+	for _, configured := range config.ApprovalActions {
+		if configured == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func approvalEventData(action *entity.PendingAction) map[string]string {
+	return map[string]string{"approval": action.ID, "requestedBy": action.RequestedBy}
+}
+
+// Record a critical action for another admin to approve instead of carrying it out
+func (c *Controller) requestApproval(context *gin.Context, actionType, targetType, targetID string) {
+	requester, _ := auth.CurrentUser(context)
+
+	action := &entity.PendingAction{
+		ID:          uuid.NewString(),
+		Action:      actionType,
+		TargetType:  targetType,
+		TargetID:    targetID,
+		RequestedBy: requester,
+		Status:      entity.APPROVAL_PENDING,
+	}
+
+	storeErr := c.store(context).CreatePendingAction(action)
+	if storeErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to store pending action", storeErr)
+		return
+	}
+
+	c.publish(context, events.APPROVAL_REQUESTED, events.TARGET_ACTION, action.ID, action)
+
+	context.Header("Location", utilities.URL("/api/v1/approvals/"+action.ID))
+	utilities.RESTResource(context, http.StatusAccepted, action)
+}
+
+func handleApprovals(context *gin.Context) {
+	controller.HandleApprovals(context)
+}
+
+func (controller *Controller) HandleApprovals(context *gin.Context) {
+	status := context.Query("status")
+	switch status {
+	case "", entity.APPROVAL_PENDING, entity.APPROVAL_APPROVED, entity.APPROVAL_REJECTED, entity.APPROVAL_FAILED:
+	default:
+		utilities.RESTError(context, http.StatusBadRequest, "invalid status", nil)
+		return
+	}
+
+	actions, actionsErr := controller.store(context).GetPendingActions(status)
+	if actionsErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get pending actions", actionsErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, entity.RESTResult{
+		Results:      actions,
+		TotalResults: len(actions),
+	})
+}
+
+func handleApproval(context *gin.Context) {
+	controller.HandleApproval(context)
+}
+
+func (controller *Controller) HandleApproval(context *gin.Context) {
+	action, actionErr := controller.store(context).GetPendingActionByID(context.Param("id"))
+	if errors.Is(actionErr, gorm.ErrRecordNotFound) {
+		utilities.RESTError(context, http.StatusNotFound, "pending action not found", nil)
+		return
+	}
+
+	if actionErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get pending action", actionErr)
+		return
+	}
+
+	utilities.RESTResource(context, http.StatusOK, action)
+}
+
+func handleApproveAction(context *gin.Context) {
+	controller.HandleApproveAction(context)
+}
+
+// Approve and carry out a pending action, the approver must be a different admin to the requester
+func (controller *Controller) HandleApproveAction(context *gin.Context) {
+	action, ok := controller.decideAction(context, entity.APPROVAL_APPROVED)
+	if !ok {
+		return
+	}
+
+	// The action has been approved, so if its target is locked for too long it is recorded as failed like any other error
+	unlock, lockErr := controller.lockResources(resourceKey(action.TargetType, action.TargetID))
+	if lockErr == nil {
+		defer controller.untilCommitted(context, unlock)()
+	}
+
+	executeErr := lockErr
+	if executeErr == nil {
+		executeErr = approvalExecutors[action.Action](controller, context, action)
+	}
+	if executeErr != nil {
+		action.Status = entity.APPROVAL_FAILED
+		action.Error = executeErr.Error()
+		if saveErr := controller.store(context).SavePendingAction(action); saveErr != nil {
+			controller.log.Error().Err(saveErr).Str("approval", action.ID).Msg("unable to record failed pending action")
+		}
+
+		utilities.RESTError(context, http.StatusInternalServerError, "approved action failed", executeErr)
+		return
+	}
+
+	controller.publish(context, events.APPROVAL_APPROVED, events.TARGET_ACTION, action.ID, action)
+
+	utilities.RESTResource(context, http.StatusOK, action)
+}
+
+func handleRejectAction(context *gin.Context) {
+	controller.HandleRejectAction(context)
+}
+
+// Reject a pending action, the requester may reject their own to withdraw it
+func (controller *Controller) HandleRejectAction(context *gin.Context) {
+	action, ok := controller.decideAction(context, entity.APPROVAL_REJECTED)
+	if !ok {
+		return
+	}
+
+	controller.publish(context, events.APPROVAL_REJECTED, events.TARGET_ACTION, action.ID, action)
+
+	utilities.RESTResource(context, http.StatusOK, action)
+}
+
+// Move the pending action in the request to approved or rejected, writing the error response if it can't be
+func (controller *Controller) decideAction(context *gin.Context, status string) (*entity.PendingAction, bool) {
+	decider, _ := auth.CurrentUser(context)
+
+	action, actionErr := controller.store(context).GetPendingActionByID(context.Param("id"))
+	if errors.Is(actionErr, gorm.ErrRecordNotFound) {
+		utilities.RESTError(context, http.StatusNotFound, "pending action not found", nil)
+		return nil, false
+	}
+
+	if actionErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get pending action", actionErr)
+		return nil, false
+	}
+
+	if status == entity.APPROVAL_APPROVED && action.RequestedBy == decider {
+		utilities.RESTError(context, http.StatusForbidden, "an action can not be approved by the admin who requested it", nil)
+		return nil, false
+	}
+
+	decideErr := controller.store(context).DecidePendingAction(action.ID, status, decider)
+	if errors.Is(decideErr, gorm.ErrRecordNotFound) {
+		utilities.RESTError(context, http.StatusConflict, "action has already been decided", nil)
+		return nil, false
+	}
+
+	if decideErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to store decision", decideErr)
+		return nil, false
+	}
+
+	decided, decidedErr := controller.store(context).GetPendingActionByID(action.ID)
+	if decidedErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get pending action", decidedErr)
+		return nil, false
+	}
+
+	return decided, true
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestApprovedAdminDeletionRechecksTheLastAdmin(t *testing.T) {
+	setConfig(t, &config.ApprovalActions, []string{APPROVAL_DELETE_ADMIN})
+
+	c, store := newTestController(t)
+
+	requester := addUser(t, store, "requester", auth.ROLE_ADMIN)
+	target := addUser(t, store, "target", auth.ROLE_ADMIN)
+
+	requested := serve(c, http.MethodDelete, "/api/v1/users/"+target.ID, tokenFor(t, requester), nil)
+	if requested.Code != http.StatusAccepted {
+		t.Fatalf("expected the deletion to wait for approval, got %d: %s", requested.Code, requested.Body.String())
+	}
+
+	action := &entity.PendingAction{}
+	decodeResource(t, requested, action)
+
+	// By the time it is approved the target is the only enabled admin left
+	if disableErr := store.SetUsersDisabled([]string{requester.ID}, true); disableErr != nil {
+		t.Fatal(disableErr)
+	}
+
+	approved := serve(c, http.MethodPost, "/api/v1/approvals/"+action.ID+"/approve", tokenFor(t, target), nil)
+	if approved.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the approved deletion of the last enabled admin to fail, got %d: %s", approved.Code, approved.Body.String())
+	}
+
+	if _, userErr := store.GetUserById(target.ID); userErr != nil {
+		t.Fatalf("expected the last enabled admin to still exist, got %s", userErr)
+	}
+
+	failed, _ := store.GetPendingActionByID(action.ID)
+	if failed.Status != entity.APPROVAL_FAILED || failed.Error != errLastAdmin.Error() {
+		t.Fatalf("expected the action to be recorded as failed by the last admin rule, got %s %q", failed.Status, failed.Error)
+	}
+}
+
+func TestApprovedAdminDeletionFailsClosed(t *testing.T) {
+	setConfig(t, &config.ApprovalActions, []string{APPROVAL_DELETE_ADMIN})
+
+	c, store := newTestController(t)
+
+	requester := addUser(t, store, "requester", auth.ROLE_ADMIN)
+	approver := addUser(t, store, "approver", auth.ROLE_ADMIN)
+	target := addUser(t, store, "target", auth.ROLE_ADMIN)
+
+	requested := serve(c, http.MethodDelete, "/api/v1/users/"+target.ID, tokenFor(t, requester), nil)
+	action := &entity.PendingAction{}
+	decodeResource(t, requested, action)
+
+	store.fail("GetUsers", errStoreDown)
+	approved := serve(c, http.MethodPost, "/api/v1/approvals/"+action.ID+"/approve", tokenFor(t, approver), nil)
+	store.fail("GetUsers", nil)
+
+	if approved.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the deletion to fail when the admins can't be counted, got %d", approved.Code)
+	}
+
+	if _, userErr := store.GetUserById(target.ID); userErr != nil {
+		t.Fatal("expected the target to still exist")
+	}
+}
+
+func TestRequestersCantApproveTheirOwnActions(t *testing.T) {
+	setConfig(t, &config.ApprovalActions, []string{APPROVAL_DELETE_ADMIN})
+
+	c, store := newTestController(t)
+
+	requester := tokenFor(t, addUser(t, store, "requester", auth.ROLE_ADMIN))
+	target := addUser(t, store, "target", auth.ROLE_ADMIN)
+
+	requested := serve(c, http.MethodDelete, "/api/v1/users/"+target.ID, requester, nil)
+	if requested.Code != http.StatusAccepted {
+		t.Fatalf("expected the deletion to wait for approval, got %d: %s", requested.Code, requested.Body.String())
+	}
+
+	action := &entity.PendingAction{}
+	decodeResource(t, requested, action)
+
+	approved := serve(c, http.MethodPost, "/api/v1/approvals/"+action.ID+"/approve", requester, nil)
+	if approved.Code != http.StatusForbidden {
+		t.Fatalf("expected the requester to be refused approving their own action, got %d: %s", approved.Code, approved.Body.String())
+	}
+
+	if _, userErr := store.GetUserById(target.ID); userErr != nil {
+		t.Fatalf("expected the deletion not to have been carried out, got %s", userErr)
+	}
+
+	pending, _ := store.GetPendingActionByID(action.ID)
+	if pending.Status != entity.APPROVAL_PENDING || pending.DecidedBy != "" {
+		t.Fatalf("expected the action to still be waiting for another admin, got %s decided by %q", pending.Status, pending.DecidedBy)
+	}
+}
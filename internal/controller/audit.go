@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+const (
+	AUDIT_DEFAULT_PAGE_SIZE = 50
+	AUDIT_MAX_PAGE_SIZE     = 500
+)
+
+func handleAudit(context *gin.Context) {
+	controller.HandleAudit(context)
+}
+
+func (controller *Controller) HandleAudit(context *gin.Context) {
+	targetType := context.Query("targetType")
+	targetID := context.Query("targetId")
+
+	page, pageErr := strconv.Atoi(context.DefaultQuery("page", "1"))
+	if pageErr != nil || page < 1 {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid page", pageErr)
+		return
+	}
+
+	pageSize, pageSizeErr := strconv.Atoi(context.DefaultQuery("pageSize", strconv.Itoa(AUDIT_DEFAULT_PAGE_SIZE)))
+	if pageSizeErr != nil || pageSize < 1 || pageSize > AUDIT_MAX_PAGE_SIZE {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid page size", pageSizeErr)
+		return
+	}
+
+	entries, total, entriesErr := controller.store(context).GetAuditEntries(targetType, targetID, (page-1)*pageSize, pageSize)
+	if entriesErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get audit entries", entriesErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, entity.RESTResult{
+		Results:      entries,
+		TotalResults: int(total),
+	})
+}
+
+// Where emit leaves the error of an audit entry it couldn't write in strict mode
+const contextAuditErr string = "auditErr"
+
+// Where emit and untilCommitted leave what has to wait for the transaction of a strictly audited change to end
+const (
+	contextCommitEvents  string = "commitEvents"  // published once the change is committed
+	contextCommitUnlocks string = "commitUnlocks" // released once the change is committed or rolled back
+)
+
+// In strict audit mode refuse anything that could change state while it can't be audited,
+// and make each change in a transaction with its audit entries, rolling it back and answering 503 instead if they couldn't be written
+func auditGuard(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+
+	if controller == nil || controller.audit == nil || !controller.audit.Strict() {
+		c.Next()
+		return
+	}
+
+	if controller.audit.Blocking() {
+		utilities.RESTError(c, http.StatusServiceUnavailable, "audit log is unavailable, changes are temporarily disabled", nil)
+		c.Abort()
+		return
+	}
+
+	original := c.Writer
+	held := &heldResponseWriter{ResponseWriter: original, header: original.Header().Clone()}
+	c.Writer = held
+
+	// Deferred so a panicking handler still gives up its locks
+	defer releaseCommitUnlocks(c)
+
+	started := false
+	transactionErr := controller.persistence.Transaction(func(store persistence.Store) error {
+		started = true
+		c.Set(contextTransaction, store)
+		c.Next()
+
+		if auditErr, failed := c.Get(contextAuditErr); failed {
+			return auditErr.(error)
+		}
+
+		return nil
+	})
+
+	c.Writer = original
+
+	if !started {
+		utilities.RESTError(c, http.StatusServiceUnavailable, "unable to start a transaction for the change", transactionErr)
+		return
+	}
+
+	if _, failed := c.Get(contextAuditErr); failed {
+		utilities.RESTError(c, http.StatusServiceUnavailable, "the change could not be audited", transactionErr)
+		return
+	}
+
+	if transactionErr != nil {
+		utilities.RESTError(c, http.StatusInternalServerError, "unable to commit the change", transactionErr)
+		return
+	}
+
+	committed, _ := c.Get(contextCommitEvents)
+	pending, _ := committed.([]events.Event)
+	for _, event := range pending {
+		controller.events.Publish(event)
+	}
+
+	held.release()
+}
+
+func releaseCommitUnlocks(c *gin.Context) {
+	held, _ := c.Get(contextCommitUnlocks)
+	unlocks, _ := held.([]func())
+	for i := len(unlocks) - 1; i >= 0; i-- {
+		unlocks[i]()
+	}
+}
+
+// heldResponseWriter keeps a response back until release writes it out
+type heldResponseWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *heldResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *heldResponseWriter) WriteHeader(status int) {
+	if w.body.Len() == 0 {
+		w.status = status
+	}
+}
+
+func (w *heldResponseWriter) WriteHeaderNow() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+}
+
+func (w *heldResponseWriter) Write(body []byte) (int, error) {
+	w.WriteHeaderNow()
+
+	return w.body.Write(body)
+}
+
+func (w *heldResponseWriter) WriteString(body string) (int, error) {
+	return w.Write([]byte(body))
+}
+
+func (w *heldResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+func (w *heldResponseWriter) Size() int {
+	if w.status == 0 {
+		return -1
+	}
+
+	return w.body.Len()
+}
+
+func (w *heldResponseWriter) Written() bool {
+	return w.status != 0
+}
+
+// Nothing is sent until the response is released
+func (w *heldResponseWriter) Flush() {}
+
+func (w *heldResponseWriter) release() {
+	header := w.ResponseWriter.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range w.header {
+		header[key] = values
+	}
+
+	if w.status == 0 {
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
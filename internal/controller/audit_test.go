@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/audit"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+)
+
+func TestStrictAuditWritesEntriesInTheRequest(t *testing.T) {
+	c, store := newTestController(t)
+	c.audit = audit.New(store, audit.MODE_STRICT, "")
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	created := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "example.com"})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected the zone to be created, got %d: %s", created.Code, created.Body.String())
+	}
+
+	zone := &entity.Zone{}
+	decodeResource(t, created, zone)
+
+	// Nothing is left to the bus, the entry has to be there as soon as the response is
+	entries, _, _ := store.GetAuditEntries(events.TARGET_ZONE, zone.ID, 0, 10)
+	if len(entries) != 1 || entries[0].Type != events.ZONE_CREATED {
+		t.Fatalf("expected the zone's creation to be audited before the response, got %+v", entries)
+	}
+}
+
+func TestStrictAuditFailsRequestsThatCantBeAudited(t *testing.T) {
+	c, store := newTestController(t)
+	c.audit = audit.New(store, audit.MODE_STRICT, "")
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	published := make(chan events.Event, 10)
+	c.events.Subscribe("test", 10, func(event events.Event) { published <- event })
+
+	store.fail("CreateAuditEntry", errStoreDown)
+
+	created := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "example.com"})
+	if created.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a change that can't be audited to fail, got %d: %s", created.Code, created.Body.String())
+	}
+
+	if location := created.Header().Get("Location"); location != "" {
+		t.Fatalf("expected none of the held back response to be sent, got Location %s", location)
+	}
+
+	if zones, _ := store.GetZones("name ASC"); len(zones) != 0 {
+		t.Fatalf("expected the change to be rolled back with its audit entry, got %+v", zones)
+	}
+
+	c.events.Close(time.Second)
+	if len(published) != 0 {
+		t.Fatalf("expected nothing to be published for a change that was rolled back, got %+v", <-published)
+	}
+
+	store.fail("CreateAuditEntry", nil)
+
+	blocked := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "example.org"})
+	if blocked.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected changes to stay refused until the audit store is seen to recover, got %d", blocked.Code)
+	}
+}
+
+func TestBestEffortAuditDropsEntriesItCantWrite(t *testing.T) {
+	c, store := newTestController(t)
+	c.events.SubscribeBlocking("audit", 10, c.audit.Handler())
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	store.fail("CreateAuditEntry", errStoreDown)
+
+	lost := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "example.com"})
+	if lost.Code != http.StatusCreated {
+		t.Fatalf("expected changes to carry on while the audit store is down, got %d: %s", lost.Code, lost.Body.String())
+	}
+
+	// The entry is written from the bus, so wait for it to have been tried before the store recovers
+	for deadline := time.Now().Add(time.Second); store.called("CreateAuditEntry") == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the audit subscriber to try to write the entry")
+		}
+	}
+
+	store.fail("CreateAuditEntry", nil)
+
+	kept := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "example.org"})
+	if kept.Code != http.StatusCreated {
+		t.Fatalf("expected the zone to be created, got %d: %s", kept.Code, kept.Body.String())
+	}
+
+	c.events.Close(time.Second)
+
+	entries, _, _ := store.GetAuditEntries(events.TARGET_ZONE, "", 0, 10)
+	if len(entries) != 1 || !strings.Contains(kept.Body.String(), entries[0].TargetID) {
+		t.Fatalf("expected only the change made once the audit store recovered to be audited, got %+v", entries)
+	}
+
+	if c.audit.Blocking() {
+		t.Fatal("expected best-effort auditing to never refuse changes")
+	}
+}
+
+func TestSpillAuditKeepsEntriesItCantWrite(t *testing.T) {
+	c, store := newTestController(t)
+	spillPath := filepath.Join(t.TempDir(), "audit.spill")
+	c.audit = audit.New(store, audit.MODE_SPILL, spillPath)
+	c.events.SubscribeBlocking("audit", 10, c.audit.Handler())
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	store.fail("CreateAuditEntry", errStoreDown)
+
+	created := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "example.com"})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected changes to carry on while the audit store is down, got %d: %s", created.Code, created.Body.String())
+	}
+
+	zone := &entity.Zone{}
+	decodeResource(t, created, zone)
+
+	c.events.Close(time.Second)
+
+	spilled, readErr := os.ReadFile(spillPath)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	if !strings.Contains(string(spilled), zone.ID) || !strings.Contains(string(spilled), events.ZONE_CREATED) {
+		t.Fatalf("expected the zone's creation to be spilled for replay, got %s", spilled)
+	}
+}
+
+func TestAuditSearchByTargetIsNewestFirst(t *testing.T) {
+	c, store := newTestController(t)
+	c.audit = audit.New(store, audit.MODE_STRICT, "")
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	other := addUser(t, store, "bob", auth.ROLE_ZONE_ADMIN)
+
+	created := serve(c, http.MethodPost, "/api/v1/users/new", admin, entity.NewUserBody{User: entity.User{Username: "alice"}, GeneratePassword: true})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected the user to be created, got %d: %s", created.Code, created.Body.String())
+	}
+
+	response := &entity.NewUserResponse{}
+	if decodeErr := json.Unmarshal(created.Body.Bytes(), response); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+	id := response.User.ID
+
+	if updated := serve(c, http.MethodPatch, "/api/v1/users/"+id, admin, `{"email": "alice@example.com"}`); updated.Code != http.StatusOK {
+		t.Fatalf("expected the user to be updated, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	if updated := serve(c, http.MethodPatch, "/api/v1/users/"+other.ID, admin, `{"email": "bob@example.com"}`); updated.Code != http.StatusOK {
+		t.Fatalf("expected the other user to be updated, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	if deleted := serve(c, http.MethodDelete, "/api/v1/users/"+id, admin, nil); deleted.Code != http.StatusOK {
+		t.Fatalf("expected the user to be deleted, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+
+	search := serve(c, http.MethodGet, "/api/v1/audit?targetType="+events.TARGET_USER+"&targetId="+id, admin, nil)
+	if search.Code != http.StatusOK {
+		t.Fatalf("expected the audit log to be searched, got %d: %s", search.Code, search.Body.String())
+	}
+
+	entries := []entity.AuditEntry{}
+	decodeResults(t, search, &entries)
+
+	types := []string{}
+	for _, entry := range entries {
+		if entry.TargetID != id {
+			t.Fatalf("expected only entries about the user, got %+v", entry)
+		}
+		types = append(types, entry.Type)
+	}
+
+	if expected := []string{events.USER_DELETED, events.USER_UPDATED, events.USER_CREATED}; !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected the user's creation, update and deletion newest first, got %v", types)
+	}
+
+	paged := serve(c, http.MethodGet, "/api/v1/audit?targetType="+events.TARGET_USER+"&targetId="+id+"&page=2&pageSize=1", admin, nil)
+	page := []entity.AuditEntry{}
+	decodeResults(t, paged, &page)
+	if len(page) != 1 || page[0].Type != events.USER_UPDATED || !strings.Contains(paged.Body.String(), `"totalResults":3`) {
+		t.Fatalf("expected the second page to hold the update out of 3 entries, got %s", paged.Body.String())
+	}
+
+	if denied := serve(c, http.MethodGet, "/api/v1/audit", tokenFor(t, other), nil); denied.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the audit log to be for admins only, got %d", denied.Code)
+	}
+}
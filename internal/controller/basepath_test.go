@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestRoutesAndLinksUseTheBasePath(t *testing.T) {
+	setConfig(t, &config.BasePath, "/vxconnect")
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	created := serve(c, http.MethodPost, "/vxconnect/api/v1/zones/new", admin, entity.Zone{Name: "example.com"})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected the zone to be created under the base path, got %d: %s", created.Code, created.Body.String())
+	}
+
+	zone := &entity.Zone{}
+	decodeResource(t, created, zone)
+
+	if location := created.Header().Get("Location"); location != "/vxconnect/api/v1/zones/"+zone.ID {
+		t.Fatalf("expected the Location to include the base path, got %q", location)
+	}
+
+	if fetched := serve(c, http.MethodGet, "/vxconnect/api/v1/zones/"+zone.ID, admin, nil); fetched.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be served under the base path, got %d", fetched.Code)
+	}
+
+	if ready := serve(c, http.MethodGet, "/vxconnect/api/v1/ready", "", nil); ready.Code != http.StatusOK {
+		t.Fatalf("expected readiness under the base path, got %d", ready.Code)
+	}
+
+	if outside := serve(c, http.MethodGet, "/api/v1/zones/"+zone.ID, admin, nil); outside.Code != http.StatusNotFound {
+		t.Fatalf("expected nothing to be served outside the base path, got %d", outside.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"github.com/monoxane/vxconnect/internal/utilities"
+	"gorm.io/gorm"
+)
+
+const (
+	breakGlassGuardUsername string = "break-glass"
+)
+
+// Check a username can be given to a new account, break-glass attempts are tracked under a name no account may have
+func validateUsername(username string) error {
+	if username == breakGlassGuardUsername {
+		return fmt.Errorf("username %s is reserved", username)
+	}
+
+	return auth.ValidateUsername(username)
+}
+
+func handleBreakGlass(context *gin.Context) {
+	controller.HandleBreakGlass(context)
+}
+
+// Exchange the one time break-glass credential for a new permanent admin account
+// The credential is spent on first successful use and the endpoint stays disabled until it is rotated
+func (controller *Controller) HandleBreakGlass(context *gin.Context) {
+	if config.BreakGlassCredential == "" {
+		utilities.RESTError(context, http.StatusNotFound, "break-glass access is not enabled", nil)
+		return
+	}
+
+	payload := &entity.BreakGlassBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid body", bindErr)
+		return
+	}
+
+	ip := context.ClientIP()
+	if controller.loginGuard.Blocked(ip, breakGlassGuardUsername) {
+		utilities.RESTError(context, http.StatusTooManyRequests, "too many failed break-glass attempts", nil)
+		return
+	}
+
+	if !auth.SecureCompare(config.BreakGlassCredential, payload.Credential) {
+		controller.loginGuard.RecordFailure(ip, breakGlassGuardUsername)
+		controller.log.Warn().Str("remote", ip).Msg("BREAK-GLASS ACCESS ATTEMPTED WITH AN INVALID CREDENTIAL")
+		utilities.RESTError(context, http.StatusUnauthorized, "invalid credential", nil)
+		return
+	}
+
+	credentialHash := sha256.Sum256([]byte(config.BreakGlassCredential))
+	hash := hex.EncodeToString(credentialHash[:])
+
+	used, usedErr := controller.persistence.BreakGlassUsed(hash)
+	if usedErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to check break-glass credential", usedErr)
+		return
+	}
+
+	if used {
+		controller.log.Warn().Str("remote", ip).Msg("BREAK-GLASS ACCESS ATTEMPTED WITH A SPENT CREDENTIAL")
+		utilities.RESTError(context, http.StatusGone, "break-glass credential has already been used", nil)
+		return
+	}
+
+	usernameErr := validateUsername(payload.Username)
+	if usernameErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid username", usernameErr)
+		return
+	}
+
+	strengthErr := auth.ValidatePasswordStrength(payload.Password, payload.Username)
+	if strengthErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "password does not meet the password policy", strengthErr)
+		return
+	}
+
+	passwordHash, hashErr := auth.HashPassword(payload.Password)
+	if hashErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to hash password", hashErr)
+		return
+	}
+
+	user := &entity.User{
+		ID:           uuid.NewString(),
+		Username:     payload.Username,
+		PasswordHash: passwordHash,
+		Roles:        []string{auth.ROLE_ADMIN},
+		Zones:        []string{},
+	}
+
+	// Spending the credential and creating the admin commit together, so a failure leaves the credential usable
+	// and another instance spending it at the same time can't create a second admin
+	storeErr := controller.unscoped(context).CreateBreakGlassAdmin(&entity.BreakGlassUse{
+		CredentialHash: hash,
+		Username:       user.Username,
+		RemoteAddress:  ip,
+	}, user)
+	if errors.Is(storeErr, persistence.ErrBreakGlassSpent) {
+		controller.log.Warn().Str("remote", ip).Msg("BREAK-GLASS ACCESS ATTEMPTED WITH A SPENT CREDENTIAL")
+		utilities.RESTError(context, http.StatusGone, "break-glass credential has already been used", nil)
+		return
+	}
+
+	if errors.Is(storeErr, gorm.ErrDuplicatedKey) {
+		utilities.RESTError(context, http.StatusConflict, "username in use", storeErr)
+		return
+	}
+
+	if storeErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to create break-glass admin", storeErr)
+		return
+	}
+
+	controller.log.Warn().Str("remote", ip).Str("username", user.Username).Msg("BREAK-GLASS ACCESS USED, A NEW ADMIN HAS BEEN CREATED AND THE CREDENTIAL IS NOW DISABLED")
+
+	controller.emit(context, events.Event{
+		Type:       events.BREAK_GLASS_USED,
+		Actor:      breakGlassGuardUsername,
+		TargetType: events.TARGET_USER,
+		TargetID:   user.ID,
+		Data:       map[string]string{"remote": ip},
+	})
+
+	token, tokenErr := auth.GenerateToken(user.Username, user.TenantID, user.Roles, time.Now(), auth.TokenTTL(user))
+	if tokenErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to generate token", tokenErr)
+		return
+	}
+
+	context.JSON(http.StatusCreated, entity.LoginResponse{
+		Username: user.Username,
+		Token:    token,
+		Zones:    user.Zones,
+		Roles:    user.Roles,
+	})
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+const testBreakGlassCredential string = "an-emergency-credential-of-at-least-32-characters"
+
+func breakGlass(c *Controller, username string) int {
+	return serve(c, http.MethodPost, "/api/v1/break-glass", "", entity.BreakGlassBody{
+		Credential: testBreakGlassCredential,
+		Username:   username,
+		Password:   "Correct-Horse-Battery-Staple-42",
+	}).Code
+}
+
+func TestBreakGlassRefusesInvalidUsernames(t *testing.T) {
+	setConfig(t, &config.BreakGlassCredential, testBreakGlassCredential)
+
+	c, store := newTestController(t)
+	addUser(t, store, "taken", auth.ROLE_ZONE_ADMIN)
+
+	for _, username := range []string{"", "has space", "-leading", breakGlassGuardUsername} {
+		if status := breakGlass(c, username); status != http.StatusBadRequest {
+			t.Fatalf("expected username %q to be refused, got %d", username, status)
+		}
+	}
+
+	if status := breakGlass(c, "taken"); status != http.StatusConflict {
+		t.Fatalf("expected a username in use to be refused, got %d", status)
+	}
+
+	// None of the refused attempts spent the credential
+	if status := breakGlass(c, "emergency"); status != http.StatusCreated {
+		t.Fatalf("expected the credential to still be usable, got %d", status)
+	}
+
+	if status := breakGlass(c, "emergency-two"); status != http.StatusGone {
+		t.Fatalf("expected the credential to be spent, got %d", status)
+	}
+}
+
+func TestBreakGlassCreatesOneAdminWhenUsedConcurrently(t *testing.T) {
+	setConfig(t, &config.BreakGlassCredential, testBreakGlassCredential)
+
+	c, store := newTestController(t)
+
+	statuses := make([]int, 5)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = breakGlass(c, "emergency-"+string(rune('a'+i)))
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusGone:
+		default:
+			t.Fatalf("expected every other attempt to find the credential spent, got %d", status)
+		}
+	}
+
+	users, _ := store.GetUsers("")
+	if created != 1 || len(users) != 1 {
+		t.Fatalf("expected exactly one break-glass admin, got %d created and %d users", created, len(users))
+	}
+}
+
+func TestBreakGlassRefusesNearlyMatchingCredentials(t *testing.T) {
+	setConfig(t, &config.BreakGlassCredential, testBreakGlassCredential)
+
+	c, _ := newTestController(t)
+
+	for _, credential := range []string{testBreakGlassCredential[:len(testBreakGlassCredential)-1], testBreakGlassCredential + "s", "An" + testBreakGlassCredential[2:]} {
+		attempt := serve(c, http.MethodPost, "/api/v1/break-glass", "", entity.BreakGlassBody{
+			Credential: credential,
+			Username:   "emergency",
+			Password:   "Correct-Horse-Battery-Staple-42",
+		})
+		if attempt.Code != http.StatusUnauthorized {
+			t.Fatalf("expected credential %q to be refused, got %d", credential, attempt.Code)
+		}
+	}
+
+	if status := breakGlass(c, "emergency"); status != http.StatusCreated {
+		t.Fatalf("expected the exact credential to be accepted, got %d", status)
+	}
+}
@@ -0,0 +1,455 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"github.com/monoxane/vxconnect/internal/utilities"
+	"gorm.io/gorm"
+)
+
+// How an import treats users, zones and records that already exist
+const (
+	BUNDLE_CONFLICT_SKIP      string = "skip"
+	BUNDLE_CONFLICT_OVERWRITE string = "overwrite"
+	BUNDLE_CONFLICT_FAIL      string = "fail"
+)
+
+var errLastAdminRemoved = errors.New("unable to disable or remove the admin role of the last enabled admin")
+
+func handleExportBundle(context *gin.Context) {
+	controller.HandleExportBundle(context)
+}
+
+func (controller *Controller) HandleExportBundle(context *gin.Context) {
+	bundle := entity.Bundle{
+		Version:    entity.BUNDLE_VERSION,
+		ExportedAt: time.Now().UTC(),
+		Users:      []entity.BundleUser{},
+		Zones:      []entity.BundleZone{},
+	}
+
+	store := controller.store(context)
+
+	users, usersErr := store.GetUsers("")
+	if usersErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get users", usersErr)
+		return
+	}
+
+	for _, user := range users {
+		bundle.Users = append(bundle.Users, entity.BundleUser{User: *user, PasswordHash: user.PasswordHash})
+	}
+
+	zones, zonesErr := store.GetZones("")
+	if zonesErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get zones", zonesErr)
+		return
+	}
+
+	for _, zone := range zones {
+		records, recordsErr := store.GetZoneRecords(zone.ID, "")
+		if recordsErr != nil {
+			utilities.RESTError(context, http.StatusInternalServerError, "unable to get zone records", recordsErr)
+			return
+		}
+
+		bundle.Zones = append(bundle.Zones, entity.BundleZone{Zone: *zone, Records: records})
+	}
+
+	controller.publish(context, events.BUNDLE_EXPORTED, "", "", map[string]int{"users": len(bundle.Users), "zones": len(bundle.Zones)})
+
+	context.Header("Content-Disposition", fmt.Sprintf("attachment; filename=vxconnect-%s.json", bundle.ExportedAt.Format("20060102-150405")))
+	context.JSON(http.StatusOK, bundle)
+}
+
+func handleImportBundle(context *gin.Context) {
+	controller.HandleImportBundle(context)
+}
+
+// Import a bundle from an export, reporting the outcome for every user, zone and record in it
+// ?conflict= decides what happens to items that already exist, skip (the default), overwrite, or fail the whole import
+func (controller *Controller) HandleImportBundle(context *gin.Context) {
+	conflict := context.DefaultQuery("conflict", BUNDLE_CONFLICT_SKIP)
+	switch conflict {
+	case BUNDLE_CONFLICT_SKIP, BUNDLE_CONFLICT_OVERWRITE, BUNDLE_CONFLICT_FAIL:
+	default:
+		utilities.RESTError(context, http.StatusBadRequest, "invalid conflict mode", nil)
+		return
+	}
+
+	body, readErr := io.ReadAll(context.Request.Body)
+	if readErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", readErr)
+		return
+	}
+
+	bundle, bundleErr := decodeBundle(body)
+	if bundleErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid bundle", bundleErr)
+		return
+	}
+
+	store := controller.store(context)
+
+	existing, existingErr := controller.existingBundleItems(store)
+	if existingErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to read current users and zones", existingErr)
+		return
+	}
+
+	// Conflicts are found up front so a failing import doesn't apply anything
+	if conflict == BUNDLE_CONFLICT_FAIL {
+		if item := existing.firstConflict(bundle); item != "" {
+			utilities.RESTError(context, http.StatusConflict, fmt.Sprintf("%s already exists", item), nil)
+			return
+		}
+	}
+
+	// Each item is locked while it is written, but a strictly audited import has to hold its locks until the transaction commits
+	// so it takes all of them up front, as taking them one by one while holding the rest could deadlock with other requests
+	lock := func(key string) (func(), error) { return controller.lockResources(key) }
+	if _, transaction := context.Get(contextTransaction); transaction {
+		unlock, ok := controller.lockForMutation(context, bundleLocks(bundle)...)
+		if !ok {
+			return
+		}
+		defer unlock()
+
+		if conflict == BUNDLE_CONFLICT_OVERWRITE {
+			unlockAdmins, ok := controller.lockForMutation(context, adminsLock)
+			if !ok {
+				return
+			}
+			defer unlockAdmins()
+		}
+
+		lock = func(string) (func(), error) { return func() {}, nil }
+	}
+
+	results := []entity.BulkResult{}
+
+	for i := range bundle.Users {
+		user := bundle.Users[i].User
+		user.PasswordHash = bundle.Users[i].PasswordHash
+		_, exists := existing.users[user.ID]
+
+		// Users are held to the same rules as when they are created or updated through the API
+		if message, invalidErr := validateImportedUser(&user); invalidErr != nil {
+			results = append(results, entity.BulkResult{ID: user.ID, Type: events.TARGET_USER, Status: http.StatusBadRequest, Error: fmt.Sprintf("%s: %s", message, invalidErr)})
+			continue
+		}
+
+		results = append(results, importItem(lock, events.TARGET_USER, user.ID, resourceKey(events.TARGET_USER, user.ID), exists, conflict, existing.userNameTaken(&user),
+			func() error { return store.CreateUser(&user) },
+			func() error { return overwriteUser(lock, store, &user) },
+		))
+	}
+
+	for i := range bundle.Zones {
+		zone := bundle.Zones[i].Zone
+		_, exists := existing.zones[zone.ID]
+
+		zoneResult := importItem(lock, events.TARGET_ZONE, zone.ID, resourceKey(events.TARGET_ZONE, zone.ID), exists, conflict, existing.zoneNameTaken(&zone),
+			func() error { return store.CreateZone(&zone) },
+			func() error { return store.SaveZone(&zone) },
+		)
+		results = append(results, zoneResult)
+		changed := zoneResult.Success
+
+		for _, record := range bundle.Zones[i].Records {
+			// Records can't be imported into a zone that neither existed nor was imported
+			if !exists && !zoneResult.Success {
+				results = append(results, entity.BulkResult{ID: record.ID, Type: events.TARGET_RECORD, Status: http.StatusFailedDependency, Error: "zone was not imported"})
+				continue
+			}
+
+			record := record
+			record.ZoneID = zone.ID
+			_, exists := existing.records[record.ID]
+
+			recordResult := importItem(lock, events.TARGET_RECORD, record.ID, resourceKey(events.TARGET_ZONE, zone.ID), exists, conflict, existing.recordNameTaken(record),
+				func() error { return store.CreateRecord(record) },
+				func() error { return store.SaveRecord(record) },
+			)
+			results = append(results, recordResult)
+			changed = changed || recordResult.Success
+		}
+
+		if changed {
+			if soaErr := controller.updateZoneSOA(store, zone.ID); soaErr != nil {
+				controller.log.Warn().Err(soaErr).Str("zone", zone.ID).Msg("unable to update zone soa after import")
+			}
+		}
+	}
+
+	controller.publish(context, events.BUNDLE_IMPORTED, "", "", map[string]interface{}{"conflict": conflict, "users": len(bundle.Users), "zones": len(bundle.Zones)})
+
+	utilities.RESTBulk(context, results)
+}
+
+func validateImportedUser(user *entity.User) (string, error) {
+	if usernameErr := validateUsername(user.Username); usernameErr != nil {
+		return "invalid username", usernameErr
+	}
+
+	return validateUserChange(user)
+}
+
+// The lock of every user and zone in a bundle, records are locked through their zone
+func bundleLocks(bundle *entity.Bundle) []string {
+	keys := make([]string, 0, len(bundle.Users)+len(bundle.Zones))
+	for _, user := range bundle.Users {
+		keys = append(keys, resourceKey(events.TARGET_USER, user.ID))
+	}
+
+	for _, zone := range bundle.Zones {
+		keys = append(keys, resourceKey(events.TARGET_ZONE, zone.ID))
+	}
+
+	return keys
+}
+
+// Overwrite a user with its imported copy, unless that would disable or demote the last enabled admin
+func overwriteUser(lock func(key string) (func(), error), store persistence.Store, user *entity.User) error {
+	current, currentErr := store.GetUserById(user.ID)
+	if currentErr != nil {
+		return currentErr
+	}
+
+	if user.Disabled || !isAdmin(user) {
+		unlockAdmins, lockErr := lock(adminsLock)
+		if lockErr != nil {
+			return lockErr
+		}
+		defer unlockAdmins()
+
+		last, lastErr := lastEnabledAdmin(store, current)
+		if lastErr != nil {
+			return lastErr
+		}
+
+		if last {
+			return errLastAdminRemoved
+		}
+	}
+
+	return store.SaveUser(user)
+}
+
+// Decode and validate a bundle, rejecting anything from a different version or with fields we don't know about
+func decodeBundle(body []byte) (*entity.Bundle, error) {
+	bundle := &entity.Bundle{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(bundle); err != nil {
+		return nil, err
+	}
+
+	if bundle.Version != entity.BUNDLE_VERSION {
+		return nil, fmt.Errorf("unsupported bundle version %d, expected %d", bundle.Version, entity.BUNDLE_VERSION)
+	}
+
+	seen := map[string]bool{}
+	unique := func(kind, id string) error {
+		if id == "" {
+			return fmt.Errorf("%s is missing an id", kind)
+		}
+
+		if seen[kind+id] {
+			return fmt.Errorf("%s %s appears more than once", kind, id)
+		}
+		seen[kind+id] = true
+
+		return nil
+	}
+
+	for _, user := range bundle.Users {
+		if err := unique("user", user.ID); err != nil {
+			return nil, err
+		}
+
+		if user.Username == "" || user.PasswordHash == "" {
+			return nil, fmt.Errorf("user %s must have a username and password hash", user.ID)
+		}
+	}
+
+	for _, zone := range bundle.Zones {
+		if err := unique("zone", zone.ID); err != nil {
+			return nil, err
+		}
+
+		if zone.Name == "" {
+			return nil, fmt.Errorf("zone %s must have a name", zone.ID)
+		}
+
+		for _, record := range zone.Records {
+			if record == nil {
+				return nil, fmt.Errorf("zone %s has an empty record", zone.ID)
+			}
+
+			if err := unique("record", record.ID); err != nil {
+				return nil, err
+			}
+
+			if record.Name == "" || record.Type == "" {
+				return nil, fmt.Errorf("record %s must have a name and type", record.ID)
+			}
+		}
+	}
+
+	return bundle, nil
+}
+
+// Create or overwrite a single item from a bundle according to the conflict mode
+// nameTaken is set when the item's unique name belongs to a different existing item, which can never be overwritten
+// key names the resource the item is written under, the same lock a request changing it would hold, and is taken with lock
+func importItem(lock func(key string) (func(), error), kind, id, key string, exists bool, conflict string, nameTaken bool, create, overwrite func() error) entity.BulkResult {
+	result := entity.BulkResult{ID: id, Type: kind}
+
+	if nameTaken {
+		result.Status = http.StatusConflict
+		result.Error = fmt.Sprintf("the name of this %s is used by a different %s", kind, kind)
+		return result
+	}
+
+	if exists && conflict != BUNDLE_CONFLICT_OVERWRITE {
+		result.Status = http.StatusConflict
+		result.Error = "already exists, skipped"
+		return result
+	}
+
+	unlock, err := lock(key)
+	if errors.Is(err, persistence.ErrLockTimeout) {
+		result.Status = http.StatusConflict
+		result.Error = err.Error()
+		return result
+	}
+
+	if err == nil {
+		if exists {
+			result.Status = http.StatusOK
+			err = overwrite()
+		} else {
+			result.Status = http.StatusCreated
+			err = create()
+		}
+		unlock()
+	}
+
+	if errors.Is(err, gorm.ErrDuplicatedKey) || errors.Is(err, errLastAdminRemoved) || errors.Is(err, persistence.ErrLockTimeout) {
+		result.Status = http.StatusConflict
+		result.Error = err.Error()
+		return result
+	}
+
+	if err != nil {
+		result.Status = http.StatusInternalServerError
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Success = true
+	return result
+}
+
+// The ids and unique names of everything already stored, for finding import conflicts
+// Zone names are only unique within a tenant, which is all a tenant scoped store lists
+type bundleItems struct {
+	users       map[string]bool
+	usernames   map[string]string
+	zones       map[string]bool
+	zoneNames   map[string]string
+	records     map[string]bool
+	recordNames map[string]string
+}
+
+func (controller *Controller) existingBundleItems(store persistence.Store) (*bundleItems, error) {
+	items := &bundleItems{
+		users:       map[string]bool{},
+		usernames:   map[string]string{},
+		zones:       map[string]bool{},
+		zoneNames:   map[string]string{},
+		records:     map[string]bool{},
+		recordNames: map[string]string{},
+	}
+
+	users, usersErr := store.GetUsers("")
+	if usersErr != nil {
+		return nil, usersErr
+	}
+
+	for _, user := range users {
+		items.users[user.ID] = true
+		items.usernames[user.Username] = user.ID
+	}
+
+	zones, zonesErr := store.GetZones("")
+	if zonesErr != nil {
+		return nil, zonesErr
+	}
+
+	for _, zone := range zones {
+		items.zones[zone.ID] = true
+		items.zoneNames[zone.Name] = zone.ID
+
+		records, recordsErr := store.GetZoneRecords(zone.ID, "")
+		if recordsErr != nil {
+			return nil, recordsErr
+		}
+
+		for _, record := range records {
+			items.records[record.ID] = true
+			items.recordNames[record.Name] = record.ID
+		}
+	}
+
+	return items, nil
+}
+
+func (items *bundleItems) userNameTaken(user *entity.User) bool {
+	id, ok := items.usernames[user.Username]
+	return ok && id != user.ID
+}
+
+func (items *bundleItems) zoneNameTaken(zone *entity.Zone) bool {
+	id, ok := items.zoneNames[zone.Name]
+	return ok && id != zone.ID
+}
+
+func (items *bundleItems) recordNameTaken(record *entity.Record) bool {
+	id, ok := items.recordNames[record.Name]
+	return ok && id != record.ID
+}
+
+// Describe the first item in a bundle that already exists, or "" if none do
+func (items *bundleItems) firstConflict(bundle *entity.Bundle) string {
+	for _, user := range bundle.Users {
+		if items.users[user.ID] || items.userNameTaken(&user.User) {
+			return "user " + user.Username
+		}
+	}
+
+	for _, zone := range bundle.Zones {
+		if items.zones[zone.ID] || items.zoneNameTaken(&zone.Zone) {
+			return "zone " + zone.Name
+		}
+
+		for _, record := range zone.Records {
+			if items.records[record.ID] || items.recordNameTaken(record) {
+				return "record " + record.Name
+			}
+		}
+	}
+
+	return ""
+}
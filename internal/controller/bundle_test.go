@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/audit"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+)
+
+// Export everything the admin's store holds
+func exportBundle(t *testing.T, c *Controller, token string) *entity.Bundle {
+	t.Helper()
+
+	exported := serve(c, http.MethodGet, "/api/v1/bundle/export", token, nil)
+	if exported.Code != http.StatusOK {
+		t.Fatalf("expected the export to succeed, got %d: %s", exported.Code, exported.Body.String())
+	}
+
+	bundle := &entity.Bundle{}
+	if decodeErr := json.Unmarshal(exported.Body.Bytes(), bundle); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	return bundle
+}
+
+func importBundle(t *testing.T, c *Controller, token, conflict string, bundle *entity.Bundle) map[string]entity.BulkResult {
+	t.Helper()
+
+	imported := serve(c, http.MethodPost, "/api/v1/bundle/import?conflict="+conflict, token, bundle)
+	if imported.Code != http.StatusMultiStatus {
+		t.Fatalf("expected the import to report each item, got %d: %s", imported.Code, imported.Body.String())
+	}
+
+	body := struct {
+		Results []entity.BulkResult `json:"results"`
+	}{}
+	if decodeErr := json.Unmarshal(imported.Body.Bytes(), &body); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	results := map[string]entity.BulkResult{}
+	for _, result := range body.Results {
+		results[result.ID] = result
+	}
+
+	return results
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	source, sourceStore := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, sourceStore, "admin", auth.ROLE_ADMIN))
+	operator := addUser(t, sourceStore, "operator", auth.ROLE_ZONE_ADMIN)
+	operator.Labels = map[string]string{"team": "network"}
+	if saveErr := sourceStore.SaveUser(operator); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+	zone := addZone(t, sourceStore, "example.com", false)
+	record := addRecord(t, sourceStore, zone, "www.example.com")
+
+	bundle := exportBundle(t, source, admin)
+
+	target, targetStore := newTestController(t)
+	importer := tokenFor(t, addUser(t, targetStore, "importer", auth.ROLE_ADMIN))
+
+	results := importBundle(t, target, importer, BUNDLE_CONFLICT_SKIP, bundle)
+	for _, id := range []string{operator.ID, zone.ID, record.ID} {
+		if results[id].Status != http.StatusCreated {
+			t.Fatalf("expected %s to be imported, got %+v", id, results[id])
+		}
+	}
+
+	imported, userErr := targetStore.GetUserById(operator.ID)
+	if userErr != nil || imported.Username != "operator" || imported.Labels["team"] != "network" || imported.Roles[0] != auth.ROLE_ZONE_ADMIN {
+		t.Fatalf("expected the user to be imported as exported, got %+v, %v", imported, userErr)
+	}
+
+	if login := serve(target, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "operator-password"}); login.Code != http.StatusOK {
+		t.Fatalf("expected the imported user to log in with their password, got %d", login.Code)
+	}
+
+	if stored, recordErr := targetStore.GetRecordByID(record.ID); recordErr != nil || stored.ZoneID != zone.ID || stored.Target != record.Target {
+		t.Fatalf("expected the record to be imported into its zone, got %+v, %v", stored, recordErr)
+	}
+
+	// Importing the same bundle over itself changes nothing
+	again := importBundle(t, target, importer, BUNDLE_CONFLICT_OVERWRITE, exportBundle(t, target, importer))
+	for id, result := range again {
+		if !result.Success {
+			t.Fatalf("expected %s to be overwritten with itself, got %+v", id, result)
+		}
+	}
+}
+
+func TestBundleOverwriteKeepsAnEnabledAdmin(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	token := tokenFor(t, admin)
+
+	bundle := exportBundle(t, c, token)
+	bundle.Users[0].Roles = []string{auth.ROLE_ZONE_ADMIN}
+
+	results := importBundle(t, c, token, BUNDLE_CONFLICT_OVERWRITE, bundle)
+	if results[admin.ID].Status != http.StatusConflict {
+		t.Fatalf("expected demoting the last admin to be refused, got %+v", results[admin.ID])
+	}
+
+	bundle.Users[0].Roles = []string{auth.ROLE_ADMIN}
+	bundle.Users[0].Disabled = true
+
+	results = importBundle(t, c, token, BUNDLE_CONFLICT_OVERWRITE, bundle)
+	if results[admin.ID].Status != http.StatusConflict {
+		t.Fatalf("expected disabling the last admin to be refused, got %+v", results[admin.ID])
+	}
+
+	if stored, _ := store.GetUserById(admin.ID); stored.Disabled || !isAdmin(stored) {
+		t.Fatalf("expected the admin to be left alone, got %+v", stored)
+	}
+}
+
+func TestBundleImportValidatesUsers(t *testing.T) {
+	c, store := newTestController(t)
+
+	token := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	ttl := -1
+	bundle := &entity.Bundle{
+		Version: entity.BUNDLE_VERSION,
+		Users: []entity.BundleUser{
+			{User: entity.User{ID: "spaced", Username: "has space", Zones: []string{}}, PasswordHash: "hash"},
+			{User: entity.User{ID: "ttl", Username: "ttl", Zones: []string{}, TokenTTL: &ttl}, PasswordHash: "hash"},
+		},
+		Zones: []entity.BundleZone{},
+	}
+
+	results := importBundle(t, c, token, BUNDLE_CONFLICT_SKIP, bundle)
+	for _, id := range []string{"spaced", "ttl"} {
+		if results[id].Status != http.StatusBadRequest {
+			t.Fatalf("expected user %s to be refused, got %+v", id, results[id])
+		}
+
+		if _, userErr := store.GetUserById(id); userErr == nil {
+			t.Fatalf("expected user %s to not be imported", id)
+		}
+	}
+}
+
+func TestStrictlyAuditedImportsHoldTheirLocksUntilCommitted(t *testing.T) {
+	c, store := newTestController(t)
+	c.audit = audit.New(store, audit.MODE_STRICT, "")
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	token := tokenFor(t, admin)
+	operator := addUser(t, store, "operator", auth.ROLE_ZONE_ADMIN)
+	zone := addZone(t, store, "example.com", false)
+	addRecord(t, store, zone, "www.example.com")
+	addRecord(t, store, zone, "mail.example.com")
+
+	bundle := exportBundle(t, c, token)
+
+	// The import's audit entry is written last, so the transaction stays open this long after every item has been written
+	commitDelay := 300 * time.Millisecond
+	store.slow("CreateAuditEntry", commitDelay)
+
+	started := time.Now()
+	imported := make(chan int)
+	go func() {
+		imported <- serve(c, http.MethodPost, "/api/v1/bundle/import?conflict="+BUNDLE_CONFLICT_OVERWRITE, token, bundle).Code
+	}()
+
+	time.Sleep(commitDelay / 3)
+
+	// Records share their zone's lock and every overwritten user takes the admins lock, none of which may be taken twice
+	for _, key := range []string{resourceKey(events.TARGET_USER, operator.ID), resourceKey(events.TARGET_ZONE, zone.ID), adminsLock} {
+		unlock := localLocks.lock(key)
+		if waited := time.Since(started); waited < commitDelay {
+			t.Fatalf("expected %s to be held until the import was committed, it was released after %s", key, waited)
+		}
+		unlock()
+	}
+
+	if code := <-imported; code != http.StatusMultiStatus {
+		t.Fatalf("expected the import to report each item, got %d", code)
+	}
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+)
+
+func TestCheapChecksSpareTheDatabase(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	alice := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+
+	allowed := func(token string) (bool, int) {
+		context, _ := gin.CreateTestContext(httptest.NewRecorder())
+		context.Request = serveRequest(http.MethodGet, "/", token, nil)
+
+		before := store.called("GetUserById")
+		allow := auth.AnyOf(auth.Permission(auth.PERMISSION_USERS_READ), c.isUser(alice.ID))(context)
+		return allow, store.called("GetUserById") - before
+	}
+
+	if allow, lookups := allowed(tokenFor(t, admin)); !allow || lookups != 0 {
+		t.Fatalf("expected the permission in the token to allow without a lookup, got %t after %d lookups", allow, lookups)
+	}
+
+	if allow, lookups := allowed(""); allow || lookups != 0 {
+		t.Fatalf("expected a request without a token to be denied without a lookup, got %t after %d lookups", allow, lookups)
+	}
+
+	if allow, lookups := allowed(tokenFor(t, alice)); !allow || lookups != 1 {
+		t.Fatalf("expected the user themselves to be allowed after one lookup, got %t after %d lookups", allow, lookups)
+	}
+}
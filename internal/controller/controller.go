@@ -1,13 +1,20 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/audit"
 	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
 	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
 	"github.com/monoxane/vxconnect/internal/logging"
+	"github.com/monoxane/vxconnect/internal/mail"
 	"github.com/monoxane/vxconnect/internal/persistence"
 )
 
@@ -15,6 +22,18 @@ type Controller struct {
 	restPort    int
 	restEngine  *gin.Engine
 	persistence persistence.Store
+	loginGuard  *auth.LoginGuard
+	events      *events.Bus
+	audit       *audit.Recorder
+	security    *securityFeed
+	mail        *mail.Queue
+	server      *http.Server
+	draining    chan struct{}
+	stopping    chan struct{}
+	drained     chan struct{}
+	drainOnce   sync.Once
+	linkQueue   chan string
+	cooldowns   *cooldown
 	log         logging.Logger
 }
 
@@ -28,49 +47,168 @@ var (
 	controller *Controller
 )
 
-func New(port int, store persistence.Store) *Controller {
+func New(port int, store persistence.Store, bus *events.Bus, recorder *audit.Recorder) *Controller {
 	c := &Controller{
 		restEngine:  NewRESTServer(),
 		restPort:    port,
 		persistence: store,
+		events:      bus,
+		audit:       recorder,
+		security:    newSecurityFeed(),
+		linkQueue:   make(chan string, magicLinkQueueSize),
+		cooldowns:   newCooldown(),
+		mail:        mail.NewQueue(magicLinkQueueSize, magicLinkSendAttempts, magicLinkSendBackoff, mail.Send),
+		draining:    make(chan struct{}),
+		stopping:    make(chan struct{}),
+		drained:     make(chan struct{}),
+		loginGuard:  auth.NewLoginGuard(config.LoginMaxAccountFailures, config.LoginMaxAddressFailures, time.Duration(config.LoginFailureWindow)*time.Minute),
 		log:         logging.Log.With().Str("package", "controller").Logger(),
 	}
 
 	controller = c
 
+	auth.SetAccountCheck(c.accountActive)
+
+	bus.Subscribe("security", 1000, c.security.Handler())
+
+	go c.processMagicLinks()
+	go c.mail.Run()
+
+	if sortErr := validateSortDefaults(); sortErr != nil {
+		c.log.Fatal().Err(sortErr).Msg("invalid default sort configuration")
+	}
+
+	if approvalErr := validateApprovalActions(); approvalErr != nil {
+		c.log.Fatal().Err(approvalErr).Msg("invalid approval configuration")
+	}
+
+	if mtlsErr := validateMTLSGroups(); mtlsErr != nil {
+		c.log.Fatal().Err(mtlsErr).Msg("invalid client certificate configuration")
+	}
+
+	if config.BreakGlassCredential != "" {
+		c.log.Warn().Msg("BREAK-GLASS EMERGENCY ADMIN ACCESS IS ENABLED, REMOVE BREAK_GLASS_CREDENTIAL ONCE IT IS NO LONGER NEEDED")
+	}
+
 	return controller
 }
 
 func NewRESTServer() *gin.Engine {
 	server := gin.New()
+	server.RedirectTrailingSlash = false
+	server.NoRoute(redirectTrailingSlash)
 	server.Use(logging.GinLogger())
+	server.Use(prettyJSON)
+
+	server.Use(CORSMiddleware())
+	server.Use(auditGuard)
+	server.Use(storeBreaker)
+	server.Use(refreshToken)
 
-	api := server.Group("/api/v1")
+	api := server.Group(config.BasePath + "/api/v1")
 
-	api.POST("/login", handleAuth)
+	api.GET("/ready", clientCertificate("ready"), rateLimit("ready"), handleReady)
+	api.GET("/live", clientCertificate("live"), rateLimit("live"), handleLive)
+	api.POST("/login", clientCertificate("login"), rateLimit("login"), handleAuth)
+	api.POST("/login/magic", clientCertificate("login"), rateLimit("login"), handleRequestMagicLink)
+	api.POST("/login/magic/verify", clientCertificate("login"), rateLimit("login"), handleMagicLinkLogin)
+	api.POST("/reauth", clientCertificate("reauth"), auth.JWTMiddleware(), rateLimit("reauth"), handleReauth)
+	api.POST("/break-glass", clientCertificate("break-glass"), rateLimit("break-glass"), handleBreakGlass)
 
 	users := api.Group("/users")
-	users.Use(auth.JWTMiddleware())
+	users.Use(clientCertificate("users"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("users"))
 
 	users.GET("", handleUsers)
 	users.GET("/me", NotImplemented) // TODO HANDLE CURRENT USER
-	users.POST("/new", handleNewUser)
-	users.PATCH("/:id", handleUpdateUser)
-	users.DELETE("/:id", handleDeleteUser)
+	users.GET("/me/events", handleSecurityEvents)
+	users.GET("/:id", handleUser)
+	users.POST("/new", auth.RecentAuthMiddleware(), handleNewUser)
+	users.POST("/bulk/disabled", auth.RecentAuthMiddleware(), handleBulkDisableUsers)
+	users.POST("/bulk/zones", auth.RecentAuthMiddleware(), handleAssignZoneByLabels)
+	users.PATCH("/:id", auth.RecentAuthMiddleware(), lockResource("user", "id"), handleUpdateUser)
+	users.DELETE("/:id", auth.RecentAuthMiddleware(), lockResource("user", "id"), handleDeleteUser)
+	users.PUT("/:id/labels/:key", auth.RecentAuthMiddleware(), lockResource("user", "id"), handleSetUserLabel)
+	users.DELETE("/:id/labels/:key", auth.RecentAuthMiddleware(), lockResource("user", "id"), handleRemoveUserLabel)
+	users.GET("/:id/logins", handleUserLogins)
+	users.GET("/:id/zones/effective", handleUserEffectiveZones)
+	users.GET("/:id/diff/:other", handleUserAccessDiff)
+	users.POST("/:id/access/what-if", handleUserAccessWhatIf)
 	users.POST("/:id/zones", NotImplemented)         // TODO HANDLE ASSIGNING A USER A ZONE - NEEDS ADMIN
 	users.DELETE("/:id/zones/:zone", NotImplemented) // TODO HANDLE REMOVING A USER ZONE - NEEDS ADMIN
 
 	zones := api.Group("/zones")
-	zones.Use(auth.JWTMiddleware())
+	zones.Use(clientCertificate("zones"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("zones"))
 
 	zones.GET("", handleZones)
 	zones.GET("/:zone", handleZone)
-	zones.POST("/new", handleNewZone)
-	zones.DELETE("/:zone", handleDeleteZone)
+	zones.POST("/new", auth.RecentAuthMiddleware(), handleNewZone)
+	zones.POST("/exists", handleZonesExist)
+	zones.DELETE("/:zone", auth.RecentAuthMiddleware(), lockResource("zone", "zone"), zoneWritable, handleDeleteZone)
+	zones.PUT("/:zone/read-only", auth.RecentAuthMiddleware(), lockResource("zone", "zone"), handleSetZoneReadOnly)
+	zones.POST("/:zone/restore", auth.RecentAuthMiddleware(), lockResource("zone", "zone"), handleRestoreZone)
 	zones.GET("/:zone/records", handleZoneRecords)
-	zones.POST("/:zone/records/new", handleNewZoneRecord)
-	zones.PATCH("/:zone/records/:id", handleUpdateZoneRecord)
-	zones.DELETE("/:zone/records/:id", handleDeleteZoneRecord)
+	zones.POST("/:zone/records/new", lockResource("zone", "zone"), zoneWritable, handleNewZoneRecord)
+	zones.PATCH("/:zone/records/:id", lockResource("zone", "zone"), zoneWritable, handleUpdateZoneRecord)
+	zones.DELETE("/:zone/records/:id", lockResource("zone", "zone"), zoneWritable, handleDeleteZoneRecord)
+
+	roles := api.Group("/roles")
+	roles.Use(clientCertificate("roles"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("roles"))
+
+	roles.GET("/:role/permissions", handleRolePermissions)
+
+	audit := api.Group("/audit")
+	audit.Use(clientCertificate("audit"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("audit"))
+
+	audit.GET("", handleAudit)
+
+	approvals := api.Group("/approvals")
+	approvals.Use(clientCertificate("approvals"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("approvals"))
+
+	approvals.GET("", handleApprovals)
+	approvals.GET("/:id", handleApproval)
+	approvals.POST("/:id/approve", auth.RecentAuthMiddleware(), handleApproveAction)
+	approvals.POST("/:id/reject", handleRejectAction)
+
+	bundle := api.Group("/bundle")
+	bundle.Use(clientCertificate("bundle"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("bundle"), auth.RecentAuthMiddleware())
+
+	bundle.GET("/export", handleExportBundle)
+	bundle.POST("/import", handleImportBundle)
+
+	tenants := api.Group("/tenants")
+	tenants.Use(clientCertificate("tenants"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("tenants"), providerOnly)
+
+	tenants.GET("", handleTenants)
+	tenants.GET("/:id", handleTenant)
+	tenants.POST("/new", auth.RecentAuthMiddleware(), handleNewTenant)
+
+	instance := api.Group("/instance")
+	instance.Use(clientCertificate("instance"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("instance"), providerUsers)
+
+	instance.POST("/drain", auth.RecentAuthMiddleware(), handleDrain)
+	instance.GET("/metrics", handleMetrics)
+
+	debug := api.Group("/debug")
+	debug.Use(clientCertificate("debug"), auth.JWTMiddleware(), tenantScope, auth.PolicyMiddleware(policies), rateLimit("debug"))
+
+	debug.POST("/token", handleInspectToken)
+
+	// Checked once every route group has been registered
+	cors, corsErr := corsPolicies(config.BasePath+"/api/v1", config.CORSAllowedOrigins, config.CORSOverrides)
+	if corsErr != nil {
+		logging.Log.Fatal().Err(corsErr).Msg("invalid CORS configuration")
+	}
+	currentCORS.Store(cors)
+
+	limits, limitsErr := newRateLimiters(config.RateLimits, nil)
+	if limitsErr != nil {
+		logging.Log.Fatal().Err(limitsErr).Msg("invalid rate limit configuration")
+	}
+	currentLimits.Store(&limits)
+
+	if rolesErr := auth.SetRolePermissions(config.RolePermissions); rolesErr != nil {
+		logging.Log.Fatal().Err(rolesErr).Msg("invalid role permission configuration")
+	}
 
 	return server
 }
@@ -80,9 +218,34 @@ func NotImplemented(c *gin.Context) {
 }
 
 func (c *Controller) Run() {
+	c.server = &http.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%d", c.restPort),
+		Handler: headAsGet(maxDuration(c.restEngine, time.Duration(config.RequestMaxDuration)*time.Second, c.log)),
+	}
+
 	go func() {
-		c.log.Info().Msg("starting REST API interface")
-		if err := c.restEngine.Run(fmt.Sprintf("0.0.0.0:%d", c.restPort)); err != nil {
+		server := c.server
+
+		var err error
+		if config.TLSCertFile != "" {
+			server.TLSConfig, err = serverTLSConfig()
+			if err != nil {
+				c.log.Fatal().Err(err).Msg("invalid TLS configuration")
+			}
+
+			c.log.Info().Msg("starting REST API interface with TLS")
+			err = server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			c.log.Info().Msg("starting REST API interface")
+			err = server.ListenAndServe()
+		}
+
+		// Stopped by Drain, which reports when it has finished
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+
+		if err != nil {
 			c.log.Fatal().Err(err).Msg("unable to start Controller")
 		}
 
@@ -90,6 +253,43 @@ func (c *Controller) Run() {
 	}()
 }
 
+// Publish a domain event on behalf of the current user
+func (c *Controller) publish(context *gin.Context, eventType, targetType, targetID string, data interface{}) {
+	actor, _ := auth.CurrentUser(context)
+
+	c.emit(context, events.Event{
+		Type:       eventType,
+		TenantID:   context.GetString(contextTenant),
+		Actor:      actor,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Data:       data,
+	})
+}
+
+// Publish an event from a request, in strict audit mode its audit entry is written first, in the transaction of the change,
+// and the event is only published once auditGuard has committed them together
+func (c *Controller) emit(context *gin.Context, event events.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if c.audit != nil && c.audit.Strict() {
+		if auditErr := c.audit.Record(c.unscoped(context), event); auditErr != nil {
+			context.Set(contextAuditErr, auditErr)
+		}
+
+		if _, transaction := context.Get(contextTransaction); transaction {
+			committed, _ := context.Get(contextCommitEvents)
+			pending, _ := committed.([]events.Event)
+			context.Set(contextCommitEvents, append(pending, event))
+			return
+		}
+	}
+
+	c.events.Publish(event)
+}
+
 func QueryRecord(name string) *entity.Record { return controller.QueryRecord(name) }
 func (c *Controller) QueryRecord(name string) *entity.Record {
 	record, _ := c.persistence.GetRecordbyName(name)
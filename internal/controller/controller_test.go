@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/monoxane/vxconnect/internal/audit"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+)
+
+// A controller backed by a memoryStore, serving its REST API in process
+func newTestController(t *testing.T) (*Controller, *memoryStore) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	config.JWTSecret = "test-secret"
+
+	store := newMemoryStore()
+	c := New(0, store, events.NewBus(), audit.New(store, audit.MODE_BEST_EFFORT, ""))
+
+	t.Cleanup(func() { auth.SetAccountCheck(nil) })
+
+	return c, store
+}
+
+func addUser(t *testing.T, store *memoryStore, username string, roles ...string) *entity.User {
+	t.Helper()
+
+	hash, hashErr := auth.HashPassword(username + "-password")
+	if hashErr != nil {
+		t.Fatal(hashErr)
+	}
+
+	user := &entity.User{
+		ID:           uuid.NewString(),
+		Username:     username,
+		PasswordHash: hash,
+		Roles:        roles,
+		Zones:        []string{},
+	}
+
+	if createErr := store.CreateUser(user); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	return user
+}
+
+func addZone(t *testing.T, store *memoryStore, name string, readOnly bool) *entity.Zone {
+	t.Helper()
+
+	zone := &entity.Zone{ID: uuid.NewString(), Name: name, ReadOnly: readOnly}
+	if createErr := store.CreateZone(zone); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	return zone
+}
+
+func addRecord(t *testing.T, store *memoryStore, zone *entity.Zone, name string) *entity.Record {
+	t.Helper()
+
+	record := &entity.Record{ID: uuid.NewString(), ZoneID: zone.ID, Name: name, Type: "A", Target: "192.0.2.1", TTL: 300}
+	if createErr := store.CreateRecord(record); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	return record
+}
+
+// A token for the user that has just authenticated with their password
+func tokenFor(t *testing.T, user *entity.User) string {
+	t.Helper()
+
+	token, tokenErr := auth.GenerateToken(user.Username, user.TenantID, user.Roles, time.Now(), time.Hour)
+	if tokenErr != nil {
+		t.Fatal(tokenErr)
+	}
+
+	return token
+}
+
+// Send a request to the controller's REST API, body is sent as JSON unless it is already a string
+func serve(c *Controller, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(recorder, serveRequest(method, path, token, body))
+
+	return recorder
+}
+
+func serveRequest(method, path, token string, body interface{}) *http.Request {
+	var reader io.Reader
+	switch value := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(value)
+	default:
+		encoded, _ := json.Marshal(value)
+		reader = bytes.NewBuffer(encoded)
+	}
+
+	request := httptest.NewRequest(method, path, reader)
+	request.RemoteAddr = "192.0.2.10:1234"
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return request
+}
+
+// Decode the resource of a RESTResource response
+func decodeResource(t *testing.T, recorder *httptest.ResponseRecorder, into interface{}) {
+	t.Helper()
+
+	body := struct {
+		Results []json.RawMessage `json:"results"`
+	}{}
+	if decodeErr := json.Unmarshal(recorder.Body.Bytes(), &body); decodeErr != nil || len(body.Results) != 1 {
+		t.Fatalf("unable to decode a single resource from %s: %v", recorder.Body.String(), decodeErr)
+	}
+
+	if decodeErr := json.Unmarshal(body.Results[0], into); decodeErr != nil {
+		t.Fatalf("unable to decode %s: %s", body.Results[0], decodeErr)
+	}
+}
+
+// Decode the results of a list response
+func decodeResults(t *testing.T, recorder *httptest.ResponseRecorder, into interface{}) {
+	t.Helper()
+
+	body := struct {
+		Results json.RawMessage `json:"results"`
+	}{}
+	if decodeErr := json.Unmarshal(recorder.Body.Bytes(), &body); decodeErr != nil {
+		t.Fatalf("unable to decode results from %s: %v", recorder.Body.String(), decodeErr)
+	}
+
+	if decodeErr := json.Unmarshal(body.Results, into); decodeErr != nil {
+		t.Fatalf("unable to decode %s: %s", body.Results, decodeErr)
+	}
+}
+
+// Set a config value for the rest of the test
+func setConfig[T any](t *testing.T, setting *T, value T) {
+	t.Helper()
+
+	previous := *setting
+	*setting = value
+	t.Cleanup(func() { *setting = previous })
+}
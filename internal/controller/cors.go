@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+const (
+	corsAllowedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Authorization, Content-Type, If-None-Match, Last-Event-ID"
+	corsMaxAge         = "600"
+	corsExposedHeaders = "Location, ETag, Retry-After, X-Refresh-Token, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset"
+)
+
+// The route groups that can have their own CORS origins through CORS_OVERRIDES and limits through RATE_LIMITS
+// Filled in by rateLimit as the routes are registered, so a new group can be configured without being listed anywhere else
+var routeGroups = map[string]bool{}
+
+// The global CORS origins and the overrides for each route group prefix
+type corsPolicy struct {
+	origins []string
+	groups  map[string][]string
+}
+
+// Replaced whole when the config is reloaded
+var currentCORS atomic.Pointer[corsPolicy]
+
+// Build the CORS origins for each route group prefix, overrides replace the global origins for their group
+func corsPolicies(prefix string, origins []string, overrides map[string][]string) (*corsPolicy, error) {
+	policy := &corsPolicy{origins: origins, groups: map[string][]string{}}
+
+	for group, groupOrigins := range overrides {
+		if !knownRouteGroup(group) {
+			return nil, fmt.Errorf("CORS override for unknown route group %s", group)
+		}
+
+		policy.groups[prefix+"/"+group] = groupOrigins
+	}
+
+	return policy, nil
+}
+
+func knownRouteGroup(group string) bool {
+	return routeGroups[group]
+}
+
+// Apply the global CORS policy, or a route group's override, and answer preflight requests
+// This runs on the engine rather than the groups so it also sees OPTIONS requests that match no route
+func CORSMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := currentCORS.Load()
+		origins := policy.origins
+		for groupPrefix, groupOrigins := range policy.groups {
+			if c.Request.URL.Path == groupPrefix || strings.HasPrefix(c.Request.URL.Path, groupPrefix+"/") {
+				origins = groupOrigins
+				break
+			}
+		}
+
+		origin := c.GetHeader("Origin")
+		allowed := origin != "" && originAllowed(origins, origin)
+
+		if allowed {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Expose-Headers", corsExposedHeaders)
+			c.Header("Vary", "Origin")
+		}
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			if !allowed {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+
+			c.Header("Access-Control-Allow-Methods", corsAllowedMethods)
+			c.Header("Access-Control-Allow-Headers", corsAllowedHeaders+", "+config.TenantHeader)
+			c.Header("Access-Control-Max-Age", corsMaxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func originAllowed(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+
+	return false
+}
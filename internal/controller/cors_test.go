@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestRouteGroupsComeFromTheRegisteredRoutes(t *testing.T) {
+	c, _ := newTestController(t)
+
+	prefix := config.BasePath + "/api/v1/"
+	for _, route := range c.restEngine.Routes() {
+		group := strings.SplitN(strings.TrimPrefix(route.Path, prefix), "/", 2)[0]
+		if !knownRouteGroup(group) {
+			t.Fatalf("expected the group of %s %s to be configurable, %s isn't known", route.Method, route.Path, group)
+		}
+	}
+
+	if _, corsErr := corsPolicies(config.BasePath+"/api/v1", nil, map[string][]string{"instance": {"https://ops.example"}}); corsErr != nil {
+		t.Fatalf("expected a CORS override for a registered group to be accepted, got %s", corsErr)
+	}
+
+	if _, corsErr := corsPolicies(config.BasePath+"/api/v1", nil, map[string][]string{"unregistered": {"https://ops.example"}}); corsErr == nil {
+		t.Fatal("expected a CORS override for a group with no routes to be refused")
+	}
+
+	if _, limitsErr := newRateLimiters(map[string]config.RateLimit{"unregistered": {Requests: 1, Window: 1}}, nil); limitsErr == nil {
+		t.Fatal("expected a rate limit for a group with no routes to be refused")
+	}
+}
+
+func TestCORSOverridesApplyOnlyToTheirGroup(t *testing.T) {
+	c, _ := newTestController(t)
+
+	policy, policyErr := corsPolicies(config.BasePath+"/api/v1", []string{"https://app.example"}, map[string][]string{"instance": {"https://ops.example"}})
+	if policyErr != nil {
+		t.Fatal(policyErr)
+	}
+
+	previous := currentCORS.Load()
+	currentCORS.Store(policy)
+	t.Cleanup(func() { currentCORS.Store(previous) })
+
+	preflight := func(path, origin string) int {
+		request := serveRequest(http.MethodOptions, path, "", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+		recorder := httptest.NewRecorder()
+		c.restEngine.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	cases := []struct {
+		path   string
+		origin string
+		status int
+	}{
+		{"/api/v1/instance/metrics", "https://ops.example", http.StatusNoContent},
+		{"/api/v1/instance/metrics", "https://app.example", http.StatusForbidden},
+		{"/api/v1/users", "https://app.example", http.StatusNoContent},
+		{"/api/v1/users", "https://ops.example", http.StatusForbidden},
+		{"/api/v1/instances", "https://app.example", http.StatusNoContent},
+	}
+
+	for _, tc := range cases {
+		if status := preflight(tc.path, tc.origin); status != tc.status {
+			t.Fatalf("expected a preflight of %s from %s to get %d, got %d", tc.path, tc.origin, tc.status, status)
+		}
+	}
+}
+
+func TestCORSPreflightAllowsTheHeadersClientsSend(t *testing.T) {
+	setConfig(t, &config.TenantHeader, "X-Customer")
+
+	c, _ := newTestController(t)
+
+	policy, policyErr := corsPolicies(config.BasePath+"/api/v1", []string{"https://app.example"}, nil)
+	if policyErr != nil {
+		t.Fatal(policyErr)
+	}
+
+	previous := currentCORS.Load()
+	currentCORS.Store(policy)
+	t.Cleanup(func() { currentCORS.Store(previous) })
+
+	request := serveRequest(http.MethodOptions, "/api/v1/zones", "", nil)
+	request.Header.Set("Origin", "https://app.example")
+	request.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+	recorder := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(recorder, request)
+
+	allowed := strings.Split(recorder.Header().Get("Access-Control-Allow-Headers"), ", ")
+	for _, header := range []string{"Authorization", "Content-Type", "If-None-Match", "Last-Event-ID", "X-Customer"} {
+		found := false
+		for _, name := range allowed {
+			found = found || name == header
+		}
+
+		if !found {
+			t.Fatalf("expected preflights to allow %s, got %v", header, allowed)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+func handleInspectToken(context *gin.Context) {
+	controller.HandleInspectToken(context)
+}
+
+func (controller *Controller) HandleInspectToken(context *gin.Context) {
+	payload := &entity.TokenInspectBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+		return
+	}
+
+	inspection, inspectErr := auth.InspectToken(payload.Token)
+	if inspectErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "unable to decode token", inspectErr)
+		return
+	}
+
+	utilities.RESTResource(context, http.StatusOK, inspection)
+}
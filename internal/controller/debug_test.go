@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestInspectingAnExpiredTokenReportsItExpired(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	zoneAdmin := tokenFor(t, addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": "admin",
+		"tenant":   "",
+		"roles":    []string{auth.ROLE_ADMIN},
+		"exp":      time.Now().Add(-time.Minute).Unix(),
+	})
+	token.Header["kid"] = "primary"
+
+	expired, signErr := token.SignedString([]byte(config.JWTSecret))
+	if signErr != nil {
+		t.Fatal(signErr)
+	}
+
+	inspected := serve(c, http.MethodPost, "/api/v1/debug/token", admin, entity.TokenInspectBody{Token: expired})
+	if inspected.Code != http.StatusOK {
+		t.Fatalf("expected the token to be decoded, got %d: %s", inspected.Code, inspected.Body.String())
+	}
+
+	inspection := &entity.TokenInspection{}
+	decodeResource(t, inspected, inspection)
+
+	if !inspection.Expired || inspection.Valid || !inspection.SignatureValid {
+		t.Fatalf("expected an expired token with a good signature, got %+v", inspection)
+	}
+
+	if inspection.Algorithm != "HS256" || inspection.KeyID != "primary" || inspection.Claims["username"] != "admin" {
+		t.Fatalf("expected the header and claims to be decoded, got %+v", inspection)
+	}
+
+	if strings.Contains(inspected.Body.String(), config.JWTSecret) {
+		t.Fatal("expected the signing key to never be exposed")
+	}
+
+	if authenticated := serve(c, http.MethodPost, "/api/v1/debug/token", expired, entity.TokenInspectBody{Token: expired}); authenticated.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the expired token to never authenticate, got %d", authenticated.Code)
+	}
+
+	if denied := serve(c, http.MethodPost, "/api/v1/debug/token", zoneAdmin, entity.TokenInspectBody{Token: expired}); denied.Code != http.StatusUnauthorized {
+		t.Fatalf("expected token inspection to be for admins only, got %d", denied.Code)
+	}
+}
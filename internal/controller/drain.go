@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/events"
+)
+
+func handleDrain(context *gin.Context) {
+	controller.HandleDrain(context)
+}
+
+// Start draining this instance, its readiness probe fails from now on so the load balancer stops sending it requests
+func (controller *Controller) HandleDrain(context *gin.Context) {
+	if controller.Drain() {
+		controller.publish(context, events.INSTANCE_DRAINING, "", "", map[string]int{"delay": config.DrainDelay, "timeout": config.DrainTimeout})
+	}
+
+	context.JSON(http.StatusAccepted, controller.readiness())
+}
+
+// Report whether this instance has started draining
+func (c *Controller) Draining() bool {
+	select {
+	case <-c.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Closed once the instance has drained and the REST API has stopped
+func (c *Controller) Drained() <-chan struct{} {
+	return c.drained
+}
+
+// Fail readiness, then once the load balancer has had DRAIN_DELAY seconds to notice stop accepting connections
+// and give the requests still running up to DRAIN_TIMEOUT seconds to finish
+// Liveness keeps passing and requests are still served throughout, returns false if the instance was already draining
+func (c *Controller) Drain() bool {
+	started := false
+
+	c.drainOnce.Do(func() {
+		started = true
+		close(c.draining)
+
+		c.log.Warn().Int("delay", config.DrainDelay).Int("timeout", config.DrainTimeout).Msg("draining, readiness will fail until this instance shuts down")
+
+		// Taken now so a reload part way through can't change the drain that has already been announced
+		delay := time.Duration(config.DrainDelay) * time.Second
+		timeout := time.Duration(config.DrainTimeout) * time.Second
+
+		go func() {
+			time.Sleep(delay)
+
+			// Streams would otherwise hold the shutdown up until the timeout
+			close(c.stopping)
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if shutdownErr := c.server.Shutdown(ctx); shutdownErr != nil {
+				c.log.Warn().Err(shutdownErr).Msg("requests were still running when the drain timeout passed")
+			}
+
+			c.log.Info().Msg("drained")
+			close(c.drained)
+		}()
+	})
+
+	return started
+}
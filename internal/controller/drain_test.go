@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestDrainingFailsReadinessButNotLiveness(t *testing.T) {
+	// Long enough that the instance never gets as far as shutting down during the test
+	setConfig(t, &config.DrainDelay, 3600)
+
+	c, _ := newTestController(t)
+
+	if ready := serve(c, http.MethodGet, "/api/v1/ready", "", nil); ready.Code != http.StatusOK {
+		t.Fatalf("expected the instance to be ready before draining, got %d", ready.Code)
+	}
+
+	if !c.Drain() {
+		t.Fatal("expected the first drain to start draining")
+	}
+
+	if c.Drain() {
+		t.Fatal("expected a second drain to report the instance was already draining")
+	}
+
+	if ready := serve(c, http.MethodGet, "/api/v1/ready", "", nil); ready.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readiness to fail while draining, got %d", ready.Code)
+	}
+
+	if live := serve(c, http.MethodGet, "/api/v1/live", "", nil); live.Code != http.StatusOK {
+		t.Fatalf("expected liveness to keep passing while draining, got %d", live.Code)
+	}
+
+	select {
+	case <-c.Drained():
+		t.Fatal("expected the instance to keep serving until the drain delay has passed")
+	default:
+	}
+}
+
+func TestAdminsDrainTheInstanceWithoutMultiTenancy(t *testing.T) {
+	setConfig(t, &config.MultiTenant, false)
+	setConfig(t, &config.DrainDelay, 3600)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	drained := serve(c, http.MethodPost, "/api/v1/instance/drain", admin, nil)
+	if drained.Code != http.StatusAccepted {
+		t.Fatalf("expected an admin to drain the instance, got %d: %s", drained.Code, drained.Body.String())
+	}
+
+	if !c.Draining() {
+		t.Fatal("expected the instance to be draining")
+	}
+
+	if ready := serve(c, http.MethodGet, "/api/v1/ready", "", nil); ready.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected readiness to fail once drained over the API, got %d", ready.Code)
+	}
+}
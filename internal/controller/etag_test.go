@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func getIfNoneMatch(c *Controller, path, token, etag string) *httptest.ResponseRecorder {
+	request := serveRequest(http.MethodGet, path, token, nil)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+
+	recorder := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSingleResourcesAreNotModifiedUntilTheyChange(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	zone := addZone(t, store, "example.com", false)
+
+	changes := map[string]func(){
+		"/api/v1/users/" + user.ID: func() {
+			serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"email": "alice@example.com"}`)
+		},
+		"/api/v1/zones/" + zone.ID: func() {
+			serve(c, http.MethodPost, "/api/v1/zones/"+zone.ID+"/records/new", admin, entity.Record{Name: "www.example.com", Type: "A", Target: "192.0.2.1", TTL: 300})
+		},
+	}
+
+	for path, change := range changes {
+		first := getIfNoneMatch(c, path, admin, "")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("expected %s to have a weak ETag, got %d %q", path, first.Code, etag)
+		}
+
+		if unchanged := getIfNoneMatch(c, path, admin, etag); unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+			t.Fatalf("expected %s to be not modified for its ETag, got %d", path, unchanged.Code)
+		}
+
+		// Versions come from updatedAt, so make sure the change lands on a later one
+		time.Sleep(time.Millisecond)
+		change()
+
+		changed := getIfNoneMatch(c, path, admin, etag)
+		if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+			t.Fatalf("expected %s to be sent again with a new ETag once it changed, got %d %q", path, changed.Code, changed.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestZoneLookupFailuresAreNotCached(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	zone := addZone(t, store, "example.com", false)
+
+	store.fail("GetZoneByID", errStoreDown)
+	failed := getIfNoneMatch(c, "/api/v1/zones/"+zone.ID, admin, "")
+	store.fail("GetZoneByID", nil)
+
+	if failed.Code != http.StatusInternalServerError || failed.Header().Get("ETag") != "" {
+		t.Fatalf("expected a failed lookup to be a server error without an ETag, got %d %q", failed.Code, failed.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/utilities"
+	"gorm.io/plugin/soft_delete"
+)
+
+// Respond to a lookup of a user that does not exist, 410 if GONE_FOR_DELETED is on and they were soft deleted, otherwise 404
+func (controller *Controller) userMissing(context *gin.Context, id string, err error) {
+	if config.GoneForDeleted {
+		if user, deletedErr := controller.store(context).GetDeletedUserById(id); deletedErr == nil {
+			controller.gone(context, "user has been deleted", user.DeletedAt)
+			return
+		}
+	}
+
+	utilities.RESTError(context, http.StatusNotFound, "user not found", err)
+}
+
+// Respond to a lookup of a zone that does not exist, 410 if GONE_FOR_DELETED is on and it was soft deleted, otherwise 404
+func (controller *Controller) zoneMissing(context *gin.Context, id string, err error) {
+	if config.GoneForDeleted {
+		if zone, deletedErr := controller.store(context).GetDeletedZoneByID(id); deletedErr == nil {
+			controller.gone(context, "zone has been deleted", zone.DeletedAt)
+			return
+		}
+	}
+
+	utilities.RESTError(context, http.StatusNotFound, "zone not found", err)
+}
+
+// Only admins are told when the resource was deleted
+func (controller *Controller) gone(context *gin.Context, message string, deletedAt soft_delete.DeletedAt) {
+	var when *time.Time
+
+	roles, _ := auth.CurrentUserRoles(context)
+	for _, role := range roles {
+		if role == auth.ROLE_ADMIN {
+			deleted := time.Unix(int64(deletedAt), 0).UTC()
+			when = &deleted
+			break
+		}
+	}
+
+	utilities.RESTGone(context, message, when)
+}
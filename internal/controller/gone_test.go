@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func decodeError(t *testing.T, recorder *httptest.ResponseRecorder) *entity.RESTError {
+	t.Helper()
+
+	body := &entity.RESTError{}
+	if decodeErr := json.Unmarshal(recorder.Body.Bytes(), body); decodeErr != nil {
+		t.Fatalf("unable to decode an error from %s: %s", recorder.Body.String(), decodeErr)
+	}
+
+	return body
+}
+
+func TestDeletedResourcesAreGoneAndUnknownOnesNotFound(t *testing.T) {
+	setConfig(t, &config.GoneForDeleted, true)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	zoneAdmin := tokenFor(t, addUser(t, store, "zone-admin", auth.ROLE_ZONE_ADMIN))
+	operator := addUser(t, store, "operator")
+	zone := addZone(t, store, "example.com", false)
+
+	before := time.Now().Add(-time.Second)
+
+	if deleted := serve(c, http.MethodDelete, "/api/v1/users/"+operator.ID, admin, nil); deleted.Code != http.StatusOK {
+		t.Fatalf("expected the user to be deleted, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+
+	if deleted := serve(c, http.MethodDelete, "/api/v1/zones/"+zone.ID, admin, nil); deleted.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be deleted, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+
+	for _, path := range []string{"/api/v1/users/" + operator.ID, "/api/v1/zones/" + zone.ID} {
+		gone := serve(c, http.MethodGet, path, admin, nil)
+		if gone.Code != http.StatusGone {
+			t.Fatalf("expected %s to be gone, got %d: %s", path, gone.Code, gone.Body.String())
+		}
+
+		if deletedAt := decodeError(t, gone).DeletedAt; deletedAt == nil || deletedAt.Before(before) || deletedAt.After(time.Now()) {
+			t.Fatalf("expected admins to be told when %s was deleted, got %v", path, deletedAt)
+		}
+	}
+
+	if gone := serve(c, http.MethodGet, "/api/v1/zones/"+zone.ID, zoneAdmin, nil); gone.Code != http.StatusGone || decodeError(t, gone).DeletedAt != nil {
+		t.Fatalf("expected non admins to be told the zone is gone but not when, got %d: %s", gone.Code, gone.Body.String())
+	}
+
+	for _, path := range []string{"/api/v1/users/missing", "/api/v1/zones/missing"} {
+		if missing := serve(c, http.MethodGet, path, admin, nil); missing.Code != http.StatusNotFound {
+			t.Fatalf("expected %s to be not found, got %d", path, missing.Code)
+		}
+	}
+
+	config.GoneForDeleted = false
+
+	for _, path := range []string{"/api/v1/users/" + operator.ID, "/api/v1/zones/" + zone.ID} {
+		if missing := serve(c, http.MethodGet, path, admin, nil); missing.Code != http.StatusNotFound {
+			t.Fatalf("expected %s to be not found without GONE_FOR_DELETED, got %d", path, missing.Code)
+		}
+	}
+}
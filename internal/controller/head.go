@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+// headResponseWriter swallows the body of a response while counting its length
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *headResponseWriter) Write(body []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	w.length += len(body)
+
+	return len(body), nil
+}
+
+func (w *headResponseWriter) WriteString(body string) (int, error) {
+	return w.Write([]byte(body))
+}
+
+// There is never a body to flush, but streaming handlers still expect to be able to
+func (w *headResponseWriter) Flush() {}
+
+// Paths (below the base path) that stream their response, they would never finish a HEAD request
+var headUnsupportedPaths = []string{"/api/v1/users/me/events"}
+
+// Answer HEAD requests by routing them as the equivalent GET and discarding the body,
+// so every GET endpoint (and its middleware) supports HEAD without registering it per route
+func headAsGet(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		for _, path := range headUnsupportedPaths {
+			if r.URL.Path == config.BasePath+path {
+				w.Header().Set("Allow", http.MethodGet)
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+		}
+
+		r.Method = http.MethodGet
+		writer := &headResponseWriter{ResponseWriter: w}
+
+		handler.ServeHTTP(writer, r)
+
+		if writer.status == 0 {
+			writer.status = http.StatusOK
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(writer.length))
+		w.WriteHeader(writer.status)
+	})
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+)
+
+func TestHeadAnswersLikeGetWithoutABody(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+
+	handler := headAsGet(c.restEngine)
+
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, serveRequest(http.MethodGet, "/api/v1/users", admin, nil))
+
+	head := httptest.NewRecorder()
+	handler.ServeHTTP(head, serveRequest(http.MethodHead, "/api/v1/users", admin, nil))
+
+	if head.Code != get.Code || get.Code != http.StatusOK {
+		t.Fatalf("expected HEAD to answer with the status of GET, got %d and %d", head.Code, get.Code)
+	}
+
+	if head.Body.Len() != 0 {
+		t.Fatalf("expected HEAD to have no body, got %s", head.Body.String())
+	}
+
+	if length := head.Header().Get("Content-Length"); length != strconv.Itoa(get.Body.Len()) {
+		t.Fatalf("expected the Content-Length of the GET body (%d), got %q", get.Body.Len(), length)
+	}
+
+	if head.Header().Get("Content-Type") != get.Header().Get("Content-Type") {
+		t.Fatalf("expected the Content-Type of GET, got %q", head.Header().Get("Content-Type"))
+	}
+
+	missing := httptest.NewRecorder()
+	handler.ServeHTTP(missing, serveRequest(http.MethodHead, "/api/v1/users", "", nil))
+	if missing.Code != http.StatusUnauthorized || missing.Body.Len() != 0 {
+		t.Fatalf("expected HEAD without a token to be refused like GET, got %d", missing.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+)
+
+var camelCaseKey = regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)
+
+// A copy of value with every field set, so omitempty can't hide a key from the check
+func filled[T any](value T) T {
+	fill(reflect.ValueOf(&value).Elem())
+	return value
+}
+
+func fill(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == reflect.TypeOf(time.Time{}) {
+			value.Set(reflect.ValueOf(time.Now()))
+			return
+		}
+
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).IsExported() {
+				fill(value.Field(i))
+			}
+		}
+	case reflect.Pointer:
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		fill(value.Elem())
+	case reflect.Slice:
+		element := reflect.New(value.Type().Elem()).Elem()
+		fill(element)
+		value.Set(reflect.Append(reflect.MakeSlice(value.Type(), 0, 1), element))
+	case reflect.Map:
+		key, element := reflect.New(value.Type().Key()).Elem(), reflect.New(value.Type().Elem()).Elem()
+		fill(key)
+		fill(element)
+		value.Set(reflect.MakeMap(value.Type()))
+		value.SetMapIndex(key, element)
+	case reflect.String:
+		value.SetString("key")
+	case reflect.Bool:
+		value.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(1)
+	}
+}
+
+// Every object key in a decoded JSON document, at any depth
+func jsonKeys(document interface{}) []string {
+	keys := []string{}
+
+	switch document := document.(type) {
+	case map[string]interface{}:
+		for key, value := range document {
+			keys = append(keys, key)
+			keys = append(keys, jsonKeys(value)...)
+		}
+	case []interface{}:
+		for _, value := range document {
+			keys = append(keys, jsonKeys(value)...)
+		}
+	}
+
+	return keys
+}
+
+func TestResponsesOnlyUseCamelCaseKeys(t *testing.T) {
+	responses := map[string]interface{}{
+		"RESTResult":        filled(entity.RESTResult{Results: []entity.Zone{filled(entity.Zone{})}}),
+		"RESTError":         filled(entity.RESTError{}),
+		"BulkResult":        filled(entity.BulkResult{}),
+		"Readiness":         filled(entity.Readiness{}),
+		"Liveness":          filled(entity.Liveness{}),
+		"User":              filled(entity.User{}),
+		"NewUserResponse":   filled(entity.NewUserResponse{}),
+		"LoginResponse":     filled(entity.LoginResponse{}),
+		"LabelZoneResult":   filled(entity.LabelZoneResult{}),
+		"AccessWhatIf":      filled(entity.AccessWhatIf{}),
+		"AccessDiff":        filled(entity.AccessDiff{}),
+		"Zone":              filled(entity.Zone{}),
+		"Record":            filled(entity.Record{}),
+		"ZoneExistence":     filled(entity.ZoneExistence{}),
+		"Bundle":            filled(entity.Bundle{}),
+		"AuditEntry":        filled(entity.AuditEntry{}),
+		"PendingAction":     filled(entity.PendingAction{}),
+		"BreakGlassUse":     filled(entity.BreakGlassUse{}),
+		"LoginAttempt":      filled(entity.LoginAttempt{}),
+		"MagicLinkResponse": filled(entity.MagicLinkResponse{}),
+		"RolePermission":    filled(entity.RolePermission{}),
+		"Tenant":            filled(entity.Tenant{}),
+		"NewTenantResponse": filled(entity.NewTenantResponse{}),
+		"TokenInspection":   filled(entity.TokenInspection{}),
+		"Event":             filled(events.Event{Data: filled(events.Batch{})}),
+	}
+
+	for name, response := range responses {
+		encoded, encodeErr := json.Marshal(response)
+		if encodeErr != nil {
+			t.Fatalf("unable to encode %s: %s", name, encodeErr)
+		}
+
+		var document interface{}
+		if decodeErr := json.Unmarshal(encoded, &document); decodeErr != nil {
+			t.Fatalf("unable to decode %s: %s", name, decodeErr)
+		}
+
+		for _, key := range jsonKeys(document) {
+			if !camelCaseKey.MatchString(key) {
+				t.Errorf("expected %s to only use camelCase keys, got %q in %s", name, key, encoded)
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+const (
+	MAX_USER_LABELS        int = 32
+	MAX_LABEL_KEY_LENGTH   int = 63
+	MAX_LABEL_VALUE_LENGTH int = 256
+)
+
+// Label keys are limited to characters that are safe in a ?label=key:value selector and in the /labels/:key path segment
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+func validateLabel(key, value string) error {
+	if len(key) > MAX_LABEL_KEY_LENGTH || !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("label keys must be up to %d letters, digits, '.', '_' or '-', starting and ending with a letter or digit", MAX_LABEL_KEY_LENGTH)
+	}
+
+	if len(value) > MAX_LABEL_VALUE_LENGTH {
+		return fmt.Errorf("label values must be up to %d characters", MAX_LABEL_VALUE_LENGTH)
+	}
+
+	for _, character := range value {
+		if !unicode.IsPrint(character) {
+			return fmt.Errorf("label values must only contain printable characters")
+		}
+	}
+
+	return nil
+}
+
+// Parse ?label=key:value selectors, a bare key matches any user with that label set
+func labelSelectors(context *gin.Context) (map[string]*string, error) {
+	return parseLabelSelectors(context.QueryArray("label"))
+}
+
+func parseLabelSelectors(list []string) (map[string]*string, error) {
+	selectors := map[string]*string{}
+
+	for _, selector := range list {
+		key, value, hasValue := strings.Cut(selector, ":")
+		if !labelKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid label selector %s", selector)
+		}
+
+		if hasValue {
+			value := value
+			selectors[key] = &value
+		} else {
+			selectors[key] = nil
+		}
+	}
+
+	return selectors, nil
+}
+
+// Whether a user matches every selector
+func matchesLabels(user *entity.User, selectors map[string]*string) bool {
+	for key, value := range selectors {
+		label, ok := user.Labels[key]
+		if !ok || (value != nil && label != *value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func handleSetUserLabel(context *gin.Context) {
+	controller.HandleSetUserLabel(context)
+}
+
+func (controller *Controller) HandleSetUserLabel(context *gin.Context) {
+	id := context.Param("id")
+	key := context.Param("key")
+
+	payload := &entity.LabelBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+		return
+	}
+
+	labelErr := validateLabel(key, payload.Value)
+	if labelErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid label", labelErr)
+		return
+	}
+
+	user, userErr := controller.store(context).GetUserById(id)
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusNotFound, "user does not exist", userErr)
+		return
+	}
+
+	if user.Labels == nil {
+		user.Labels = map[string]string{}
+	}
+
+	if _, exists := user.Labels[key]; !exists && len(user.Labels) >= MAX_USER_LABELS {
+		utilities.RESTError(context, http.StatusBadRequest, fmt.Sprintf("users can have at most %d labels", MAX_USER_LABELS), nil)
+		return
+	}
+
+	user.Labels[key] = payload.Value
+
+	storeErr := controller.store(context).SaveUser(user)
+	if storeErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to store user", storeErr)
+		return
+	}
+
+	controller.publish(context, events.USER_UPDATED, events.TARGET_USER, user.ID, map[string]string{"label": key, "value": payload.Value})
+
+	utilities.RESTResource(context, http.StatusOK, user)
+}
+
+func handleRemoveUserLabel(context *gin.Context) {
+	controller.HandleRemoveUserLabel(context)
+}
+
+func (controller *Controller) HandleRemoveUserLabel(context *gin.Context) {
+	id := context.Param("id")
+	key := context.Param("key")
+
+	user, userErr := controller.store(context).GetUserById(id)
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusNotFound, "user does not exist", userErr)
+		return
+	}
+
+	if _, exists := user.Labels[key]; !exists {
+		utilities.RESTError(context, http.StatusNotFound, "label is not set", nil)
+		return
+	}
+
+	delete(user.Labels, key)
+
+	storeErr := controller.store(context).SaveUser(user)
+	if storeErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to store user", storeErr)
+		return
+	}
+
+	controller.publish(context, events.USER_UPDATED, events.TARGET_USER, user.ID, map[string]string{"label": key})
+
+	utilities.RESTResource(context, http.StatusOK, user)
+}
+
+func handleAssignZoneByLabels(context *gin.Context) {
+	controller.HandleAssignZoneByLabels(context)
+}
+
+// Assign a zone to, or remove it from, every user matching a label selector in one transaction
+func (controller *Controller) HandleAssignZoneByLabels(context *gin.Context) {
+	payload := &entity.LabelZoneBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil || payload.Zone == "" {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+		return
+	}
+
+	// An empty selector would match everyone, that is almost certainly a mistake
+	if len(payload.Labels) == 0 {
+		utilities.RESTError(context, http.StatusBadRequest, "at least one label selector is required", nil)
+		return
+	}
+
+	selectors, selectorErr := parseLabelSelectors(payload.Labels)
+	if selectorErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid label selector", selectorErr)
+		return
+	}
+
+	zone, zoneErr := controller.store(context).GetZoneByID(payload.Zone)
+	if zoneErr != nil {
+		utilities.RESTError(context, http.StatusNotFound, "zone not found", nil)
+		return
+	}
+
+	users, usersErr := controller.store(context).GetUsers("")
+	if usersErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get users", usersErr)
+		return
+	}
+
+	// The users matching now are locked, then matched again in the transaction that changes them
+	// so one whose labels changed before the lock was taken is only given the zone if it still matches
+	candidates := []string{}
+	for _, user := range users {
+		if matchesLabels(user, selectors) {
+			candidates = append(candidates, user.ID)
+		}
+	}
+
+	keys := []string{resourceKey(events.TARGET_ZONE, payload.Zone)}
+	for _, id := range candidates {
+		keys = append(keys, resourceKey(events.TARGET_USER, id))
+	}
+
+	unlock, locked := controller.lockForMutation(context, keys...)
+	if !locked {
+		return
+	}
+	defer unlock()
+
+	result := entity.LabelZoneResult{}
+
+	if len(candidates) > 0 {
+		match := func(user *entity.User) bool { return matchesLabels(user, selectors) }
+
+		// Users hold the names of their zones, which effectiveZones matches along with the zones below them
+		matched, changed, storeErr := controller.store(context).SetUsersZone(candidates, match, zone.Name, !payload.Remove)
+		if storeErr != nil {
+			utilities.RESTError(context, http.StatusInternalServerError, "unable to store users", storeErr)
+			return
+		}
+		result.Matched = matched
+		result.Affected = changed
+	}
+
+	controller.publish(context, events.USERS_ZONE_ASSIGNED, events.TARGET_ZONE, payload.Zone, map[string]interface{}{"labels": payload.Labels, "remove": payload.Remove, "affected": result.Affected})
+
+	utilities.RESTResource(context, http.StatusOK, result)
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestLabelChangesNeedRecentAuthentication(t *testing.T) {
+	setConfig(t, &config.ReauthMaxAge, 5)
+
+	c, store := newTestController(t)
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	user := addUser(t, store, "operator", auth.ROLE_ZONE_ADMIN)
+
+	stale, tokenErr := auth.GenerateToken(admin.Username, admin.TenantID, admin.Roles, time.Now().Add(-time.Hour), 2*time.Hour)
+	if tokenErr != nil {
+		t.Fatal(tokenErr)
+	}
+
+	path := "/api/v1/users/" + user.ID + "/labels/team"
+
+	if set := serve(c, http.MethodPut, path, stale, entity.LabelBody{Value: "network"}); set.Code != http.StatusUnauthorized {
+		t.Fatalf("expected setting a label with a stale login to need re-authentication, got %d", set.Code)
+	}
+
+	if set := serve(c, http.MethodPut, path, tokenFor(t, admin), entity.LabelBody{Value: "network"}); set.Code != http.StatusOK {
+		t.Fatalf("expected the label to be set after a recent login, got %d: %s", set.Code, set.Body.String())
+	}
+
+	if remove := serve(c, http.MethodDelete, path, stale, nil); remove.Code != http.StatusUnauthorized {
+		t.Fatalf("expected removing a label with a stale login to need re-authentication, got %d", remove.Code)
+	}
+
+	stored, _ := store.GetUserById(user.ID)
+	if stored.Labels["team"] != "network" {
+		t.Fatalf("expected the label to be kept, got %v", stored.Labels)
+	}
+}
+
+func TestLabelsAreValidated(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "operator", auth.ROLE_ZONE_ADMIN)
+	path := "/api/v1/users/" + user.ID + "/labels/"
+
+	if set := serve(c, http.MethodPut, path+"-team", admin, entity.LabelBody{Value: "network"}); set.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid key to be refused, got %d", set.Code)
+	}
+
+	if set := serve(c, http.MethodPut, path+"team", admin, entity.LabelBody{Value: "net\nwork"}); set.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unprintable value to be refused, got %d", set.Code)
+	}
+
+	if remove := serve(c, http.MethodDelete, path+"team", admin, nil); remove.Code != http.StatusNotFound {
+		t.Fatalf("expected removing a label that isn't set to fail, got %d", remove.Code)
+	}
+}
+
+func TestUsersAreFilteredByLabel(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	for username, labels := range map[string]map[string]string{
+		"network":    {"team": "network", "site": "syd"},
+		"studio":     {"team": "studio", "site": "syd"},
+		"unlabelled": nil,
+	} {
+		user := addUser(t, store, username, auth.ROLE_ZONE_ADMIN)
+		user.Labels = labels
+		if saveErr := store.SaveUser(user); saveErr != nil {
+			t.Fatal(saveErr)
+		}
+	}
+
+	for query, expected := range map[string][]string{
+		"label=team:network":               {"network"},
+		"label=site":                       {"network", "studio"},
+		"label=site:syd&label=team:studio": {"studio"},
+		"label=team:infra":                 {},
+	} {
+		listed := serve(c, http.MethodGet, "/api/v1/users?sort=username&"+query, admin, nil)
+		if listed.Code != http.StatusOK {
+			t.Fatalf("expected %s to list users, got %d: %s", query, listed.Code, listed.Body.String())
+		}
+
+		users := []entity.User{}
+		decodeResults(t, listed, &users)
+
+		usernames := []string{}
+		for _, user := range users {
+			usernames = append(usernames, user.Username)
+		}
+
+		if !reflect.DeepEqual(usernames, expected) {
+			t.Fatalf("expected %s to list %v, got %v", query, expected, usernames)
+		}
+	}
+
+	if invalid := serve(c, http.MethodGet, "/api/v1/users?label=team/lead:network", admin, nil); invalid.Code != http.StatusBadRequest {
+		t.Fatalf("expected a key that can't be a path segment to be an invalid selector, got %d", invalid.Code)
+	}
+}
+
+func TestZoneAssignmentMatchesUsersAsTheyAreChanged(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	zone := addZone(t, store, "example.com", false)
+	addZone(t, store, "studio.example.com", false)
+
+	kept := addUser(t, store, "kept", auth.ROLE_ZONE_ADMIN)
+	relabelled := addUser(t, store, "relabelled", auth.ROLE_ZONE_ADMIN)
+	for _, user := range []*entity.User{kept, relabelled} {
+		user.Labels = map[string]string{"team": "network"}
+		if saveErr := store.SaveUser(user); saveErr != nil {
+			t.Fatal(saveErr)
+		}
+	}
+
+	// The label is removed after the users were first read, but before the zone is assigned
+	store.slow("GetUsers", 100*time.Millisecond)
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		relabelled.Labels = map[string]string{"team": "storage"}
+		if saveErr := store.SaveUser(relabelled); saveErr != nil {
+			t.Error(saveErr)
+		}
+	}()
+
+	assigned := serve(c, http.MethodPost, "/api/v1/users/bulk/zones", admin, entity.LabelZoneBody{Labels: []string{"team:network"}, Zone: zone.ID})
+	if assigned.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be assigned, got %d: %s", assigned.Code, assigned.Body.String())
+	}
+	store.slow("GetUsers", 0)
+
+	result := &entity.LabelZoneResult{}
+	decodeResource(t, assigned, result)
+	if result.Matched != 1 || result.Affected != 1 {
+		t.Fatalf("expected only the user still matching to be counted, got %+v", result)
+	}
+
+	if stored, _ := store.GetUserById(kept.ID); len(stored.Zones) != 1 || stored.Zones[0] != zone.Name {
+		t.Fatalf("expected the matching user to be given the zone, got %v", stored.Zones)
+	}
+
+	// Zones are held by name, so the assignment grants the zone and those below it like any other
+	effective := serve(c, http.MethodGet, "/api/v1/users/"+kept.ID+"/zones/effective", admin, nil)
+	zones := []entity.Zone{}
+	decodeResults(t, effective, &zones)
+	if len(zones) != 2 {
+		t.Fatalf("expected the assigned zone and the zone below it to be effective, got %+v", zones)
+	}
+
+	if stored, _ := store.GetUserById(relabelled.ID); len(stored.Zones) != 0 {
+		t.Fatalf("expected the user that no longer matches to be left alone, got %v", stored.Zones)
+	}
+}
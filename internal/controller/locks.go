@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+// How mutations on a single user or zone are serialised, see RESOURCE_LOCKING
+const (
+	RESOURCE_LOCKING_OFF   string = "off"
+	RESOURCE_LOCKING_LOCAL string = "local"
+	RESOURCE_LOCKING_DB    string = "db"
+)
+
+type resourceLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// In process locks keyed by resource, entries only live while someone holds or is waiting for them
+type resourceLocks struct {
+	mu    sync.Mutex
+	locks map[string]*resourceLock
+}
+
+var localLocks = &resourceLocks{locks: map[string]*resourceLock{}}
+
+func (l *resourceLocks) lock(key string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &resourceLock{}
+		l.locks[key] = lock
+	}
+	lock.waiters++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Hold a lock on the resource named by a route parameter for the rest of the request,
+// so concurrent mutations of the same user or zone apply one after the other
+func lockResource(kind, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		unlock, ok := controller.lockForMutation(c, resourceKey(kind, c.Param(param)))
+		if !ok {
+			c.Abort()
+			return
+		}
+		defer unlock()
+
+		c.Next()
+	}
+}
+
+// Held by every change that could disable, demote or delete an enabled admin while it counts the others and makes the change,
+// so two changes to different admins can't each count the other and leave none. It is always taken after the users' own locks
+const adminsLock string = "admins"
+
+// The lock name of a single user or zone, records are locked through their zone
+func resourceKey(kind, id string) string {
+	return kind + ":" + id
+}
+
+// Lock the resources a mutation is about to change, writing the conflict response if another request holds one of them
+// for longer than RESOURCE_LOCK_TIMEOUT, bulk changes and approved actions lock their resources this way too
+func (c *Controller) lockForMutation(context *gin.Context, keys ...string) (func(), bool) {
+	unlock, lockErr := c.lockResources(keys...)
+	if errors.Is(lockErr, persistence.ErrLockTimeout) {
+		utilities.RESTError(context, http.StatusConflict, "resource is being modified by another request, try again", lockErr)
+		return nil, false
+	}
+
+	if lockErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to lock resource", lockErr)
+		return nil, false
+	}
+
+	return c.untilCommitted(context, unlock), true
+}
+
+// Hold a lock taken in the transaction of a strictly audited change until auditGuard has ended the transaction,
+// so the next request to take it sees the change, rather than releasing it when the handler returns
+func (c *Controller) untilCommitted(context *gin.Context, unlock func()) func() {
+	if _, transaction := context.Get(contextTransaction); !transaction {
+		return unlock
+	}
+
+	held, _ := context.Get(contextCommitUnlocks)
+	unlocks, _ := held.([]func())
+	context.Set(contextCommitUnlocks, append(unlocks, unlock))
+
+	return func() {}
+}
+
+// Take the locks on every resource, in a fixed order so two requests locking some of the same resources can't deadlock
+// With RESOURCE_LOCKING=db the locks are shared between instances and all taken on one connection, falling back to in process locks if they can't be taken
+func (c *Controller) lockResources(keys ...string) (func(), error) {
+	if config.ResourceLocking == RESOURCE_LOCKING_OFF {
+		return func() {}, nil
+	}
+
+	sorted := make([]string, 0, len(keys))
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+
+	if config.ResourceLocking == RESOURCE_LOCKING_DB {
+		unlock, lockErr := c.persistence.LockResources(sorted, config.ResourceLockTimeout)
+		if lockErr == nil || errors.Is(lockErr, persistence.ErrLockTimeout) {
+			return unlock, lockErr
+		}
+
+		c.log.Warn().Err(lockErr).Strs("resources", sorted).Msg("unable to take database resource locks, falling back to in process locks")
+	}
+
+	unlocks := make([]func(), 0, len(sorted))
+	release := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	for _, key := range sorted {
+		unlocks = append(unlocks, localLocks.lock(key))
+	}
+
+	return release, nil
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/persistence"
+)
+
+func TestConcurrentLabelChangesApplySerially(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "operator", auth.ROLE_ZONE_ADMIN)
+
+	// Without the lock both requests would read the user before either saved it, and one label would be lost
+	store.slow("GetUserById", 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"team", "site"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+
+			if set := serve(c, http.MethodPut, "/api/v1/users/"+user.ID+"/labels/"+key, admin, entity.LabelBody{Value: "value"}); set.Code != http.StatusOK {
+				t.Errorf("expected label %s to be set, got %d: %s", key, set.Code, set.Body.String())
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	store.slow("GetUserById", 0)
+	stored, _ := store.GetUserById(user.ID)
+	if stored.Labels["team"] != "value" || stored.Labels["site"] != "value" {
+		t.Fatalf("expected both labels to be kept, got %v", stored.Labels)
+	}
+}
+
+// Serve a request while key is locked, checking it waits for the lock to be released before it completes
+func servesAfterLock(t *testing.T, c *Controller, key string, request *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	unlock := localLocks.lock(key)
+
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		c.restEngine.ServeHTTP(recorder, request)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		unlock()
+		t.Fatalf("expected the request to wait for the lock on %s, got %d: %s", key, recorder.Code, recorder.Body.String())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-done
+
+	return recorder
+}
+
+func TestBulkChangesWaitForTheirUsers(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "operator", auth.ROLE_ZONE_ADMIN)
+
+	disabled := servesAfterLock(t, c, resourceKey("user", user.ID), serveRequest(http.MethodPost, "/api/v1/users/bulk/disabled", admin, entity.BulkDisableBody{IDs: []string{user.ID}, Disabled: true}))
+	if stored, _ := store.GetUserById(user.ID); !stored.Disabled {
+		t.Fatalf("expected the bulk disable to apply, got %d: %s", disabled.Code, disabled.Body.String())
+	}
+
+	user.Labels = map[string]string{"team": "network"}
+	if saveErr := store.SaveUser(user); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+	zone := addZone(t, store, "example.com", false)
+
+	assigned := servesAfterLock(t, c, resourceKey("user", user.ID), serveRequest(http.MethodPost, "/api/v1/users/bulk/zones", admin, entity.LabelZoneBody{Labels: []string{"team:network"}, Zone: zone.ID}))
+	if assigned.Code != http.StatusOK {
+		t.Fatalf("expected the zone assignment to apply, got %d: %s", assigned.Code, assigned.Body.String())
+	}
+}
+
+func TestApprovedActionsWaitForTheirTarget(t *testing.T) {
+	setConfig(t, &config.ApprovalActions, []string{APPROVAL_DELETE_ZONE})
+
+	c, store := newTestController(t)
+
+	requester := tokenFor(t, addUser(t, store, "requester", auth.ROLE_ADMIN))
+	approver := tokenFor(t, addUser(t, store, "approver", auth.ROLE_ADMIN))
+	zone := addZone(t, store, "example.com", false)
+
+	requested := serve(c, http.MethodDelete, "/api/v1/zones/"+zone.ID, requester, nil)
+	action := &entity.PendingAction{}
+	decodeResource(t, requested, action)
+
+	approved := servesAfterLock(t, c, resourceKey("zone", zone.ID), serveRequest(http.MethodPost, "/api/v1/approvals/"+action.ID+"/approve", approver, nil))
+	if approved.Code != http.StatusOK {
+		t.Fatalf("expected the approved deletion to apply, got %d: %s", approved.Code, approved.Body.String())
+	}
+}
+
+func TestHeldDatabaseLocksTimeOut(t *testing.T) {
+	setConfig(t, &config.ResourceLocking, RESOURCE_LOCKING_DB)
+	setConfig(t, &config.ResourceLockTimeout, 1)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "operator", auth.ROLE_ZONE_ADMIN)
+
+	unlock, lockErr := store.LockResources([]string{resourceKey("user", user.ID)}, 1)
+	if lockErr != nil {
+		t.Fatal(lockErr)
+	}
+	defer unlock()
+
+	disabled := serve(c, http.MethodPost, "/api/v1/users/bulk/disabled", admin, entity.BulkDisableBody{IDs: []string{user.ID}, Disabled: true})
+	if disabled.Code != http.StatusConflict {
+		t.Fatalf("expected a user locked by another instance to be refused, got %d: %s", disabled.Code, disabled.Body.String())
+	}
+
+	stored, _ := store.GetUserById(user.ID)
+	if stored.Disabled {
+		t.Fatal("expected the locked user to be left alone")
+	}
+}
+
+func TestDatabaseLocksForLargeBatchesShareOneConnection(t *testing.T) {
+	setConfig(t, &config.ResourceLocking, RESOURCE_LOCKING_DB)
+	setConfig(t, &config.ResourceLockTimeout, 1)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	// Two batches that each lock more users than there are lock connections, which would starve each other if every lock took its own
+	batches := [2][]string{}
+	for i := 0; i < 2*(persistence.ResourceLockConnections+4); i++ {
+		user := addUser(t, store, fmt.Sprintf("operator-%d", i), auth.ROLE_ZONE_ADMIN)
+		batches[i%2] = append(batches[i%2], user.ID)
+	}
+
+	var wg sync.WaitGroup
+	for _, ids := range batches {
+		wg.Add(1)
+		go func(ids []string) {
+			defer wg.Done()
+
+			if disabled := serve(c, http.MethodPost, "/api/v1/users/bulk/disabled", admin, entity.BulkDisableBody{IDs: ids, Disabled: true}); disabled.Code != http.StatusMultiStatus {
+				t.Errorf("expected a batch of %d users to be disabled, got %d: %s", len(ids), disabled.Code, disabled.Body.String())
+			}
+		}(ids)
+	}
+	wg.Wait()
+
+	users, _ := store.GetUsers("")
+	for _, user := range users {
+		if user.Username != "admin" && !user.Disabled {
+			t.Fatalf("expected every user in the batches to be disabled, %s wasn't", user.Username)
+		}
+	}
+}
+
+func TestLockResourcesOrdersAndReleasesEveryLock(t *testing.T) {
+	c, _ := newTestController(t)
+
+	unlock, lockErr := c.lockResources("user:b", "user:a", "user:b")
+	if lockErr != nil {
+		t.Fatal(lockErr)
+	}
+	unlock()
+
+	localLocks.mu.Lock()
+	defer localLocks.mu.Unlock()
+	if _, held := localLocks.locks["user:a"]; held {
+		t.Fatal("expected the locks to be released")
+	}
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+// Add a login attempt to a user's history, failure is the reason it failed or "" if it succeeded
+// Attempts against usernames that don't exist aren't recorded as there is nobody to show them to
+func (controller *Controller) recordLogin(context *gin.Context, user *entity.User, failure string) {
+	attempt := &entity.LoginAttempt{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Success:   failure == "",
+		Reason:    failure,
+		IP:        context.ClientIP(),
+		UserAgent: context.Request.UserAgent(),
+	}
+
+	if err := controller.persistence.CreateLoginAttempt(attempt, config.LoginHistoryLimit); err != nil {
+		controller.log.Error().Err(err).Str("user", user.ID).Msg("unable to record login attempt")
+	}
+}
+
+func handleUserLogins(context *gin.Context) {
+	controller.HandleUserLogins(context)
+}
+
+// Get a user's recent login attempts, users can see their own and admins can see anyone's
+func (controller *Controller) HandleUserLogins(context *gin.Context) {
+	id := context.Param("id")
+
+	if !auth.AnyOf(auth.Permission(auth.PERMISSION_USERS_READ), controller.isUser(id))(context) {
+		auth.LogDenial(context, "view login history", "user is not the target user")
+		utilities.RESTError(context, http.StatusUnauthorized, "user does not have permission to access this resource", nil)
+		return
+	}
+
+	attempts, attemptsErr := controller.store(context).GetLoginAttempts(id)
+	if attemptsErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get login history", attemptsErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, entity.RESTResult{
+		Results:      attempts,
+		TotalResults: len(attempts),
+	})
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func login(c *Controller, username, password string) *httptest.ResponseRecorder {
+	request := serveRequest(http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: username, Password: password})
+	request.Header.Set("User-Agent", "vxconnect-test")
+
+	recorder := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestLoginHistoryRecordsSuccessesAndFailures(t *testing.T) {
+	setConfig(t, &config.LoginHistoryLimit, 3)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	operator := addUser(t, store, "operator")
+	other := tokenFor(t, addUser(t, store, "other"))
+
+	if failed := login(c, "operator", "wrong"); failed.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the wrong password to be refused, got %d", failed.Code)
+	}
+
+	if succeeded := login(c, "operator", "operator-password"); succeeded.Code != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d: %s", succeeded.Code, succeeded.Body.String())
+	}
+
+	own := serve(c, http.MethodGet, "/api/v1/users/"+operator.ID+"/logins", tokenFor(t, operator), nil)
+	if own.Code != http.StatusOK {
+		t.Fatalf("expected users to see their own login history, got %d: %s", own.Code, own.Body.String())
+	}
+
+	history := []entity.LoginAttempt{}
+	decodeResults(t, own, &history)
+
+	if len(history) != 2 {
+		t.Fatalf("expected both logins in the history, got %+v", history)
+	}
+
+	if !history[0].Success || history[0].Reason != "" {
+		t.Fatalf("expected the newest attempt to be the successful login, got %+v", history[0])
+	}
+
+	if history[1].Success || history[1].Reason != "invalid password" {
+		t.Fatalf("expected the failed login and why it failed, got %+v", history[1])
+	}
+
+	for _, attempt := range history {
+		if attempt.IP != "192.0.2.10" || attempt.UserAgent != "vxconnect-test" {
+			t.Fatalf("expected the attempt's IP and user agent, got %+v", attempt)
+		}
+	}
+
+	if denied := serve(c, http.MethodGet, "/api/v1/users/"+operator.ID+"/logins", other, nil); denied.Code != http.StatusUnauthorized {
+		t.Fatalf("expected other users not to see the history, got %d", denied.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		login(c, "operator", "wrong")
+	}
+
+	capped := serve(c, http.MethodGet, "/api/v1/users/"+operator.ID+"/logins", admin, nil)
+	if capped.Code != http.StatusOK {
+		t.Fatalf("expected admins to see anyone's login history, got %d: %s", capped.Code, capped.Body.String())
+	}
+
+	history = []entity.LoginAttempt{}
+	decodeResults(t, capped, &history)
+
+	if len(history) != 3 {
+		t.Fatalf("expected the history to be capped at 3 attempts, got %d", len(history))
+	}
+
+	for _, attempt := range history {
+		if attempt.Success {
+			t.Fatalf("expected the oldest attempts to be pruned first, got %+v", history)
+		}
+	}
+}
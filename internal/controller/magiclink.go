@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/mail"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+const (
+	magicLinkTokenBytes int    = 32
+	magicLinkSentReply  string = "if the address belongs to an account a login link has been sent to it"
+
+	// How many requests can wait to be looked up, and how many links can wait to be sent, before more are dropped
+	magicLinkQueueSize int = 100
+
+	// A link that can't be sent is retried this many times, with the wait between attempts doubling from magicLinkSendBackoff
+	magicLinkSendAttempts int           = 5
+	magicLinkSendBackoff  time.Duration = 2 * time.Second
+)
+
+// Remembers when a link was last sent for each address and account, so none of them are sent more than one per MAGIC_LINK_COOLDOWN
+type cooldown struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newCooldown() *cooldown {
+	return &cooldown{last: map[string]time.Time{}}
+}
+
+// Report whether key is out of its cooldown, starting a new one if it is
+func (cooldown *cooldown) allow(key string) bool {
+	period := time.Duration(config.MagicLinkCooldown) * time.Second
+	now := time.Now()
+
+	cooldown.mu.Lock()
+	defer cooldown.mu.Unlock()
+
+	for existing, last := range cooldown.last {
+		if now.Sub(last) >= period {
+			delete(cooldown.last, existing)
+		}
+	}
+
+	if _, cooling := cooldown.last[key]; cooling {
+		return false
+	}
+
+	cooldown.last[key] = now
+	return true
+}
+
+func handleRequestMagicLink(context *gin.Context) {
+	controller.HandleRequestMagicLink(context)
+}
+
+// Email a single use login link to the owner of an address
+// The reply is the same whether or not the address belongs to anyone, or a link was sent to it too recently,
+// and the address is looked up in the background so the response time doesn't give it away either
+func (controller *Controller) HandleRequestMagicLink(context *gin.Context) {
+	if !config.MagicLinkEnabled {
+		utilities.RESTError(context, http.StatusNotFound, "magic link login is not enabled", nil)
+		return
+	}
+
+	payload := &entity.MagicLinkBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid body", bindErr)
+		return
+	}
+
+	email, emailErr := utilities.NormalizeEmail(payload.Email)
+	if emailErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid email address", emailErr)
+		return
+	}
+
+	if controller.cooldowns.allow("address:" + email) {
+		select {
+		case controller.linkQueue <- email:
+		default:
+			controller.log.Warn().Msg("magic link queue full, dropping request")
+		}
+	}
+
+	context.JSON(http.StatusAccepted, entity.MagicLinkResponse{Message: magicLinkSentReply})
+}
+
+// Look up the addresses of queued requests one at a time, sending a link to those that belong to an account
+func (c *Controller) processMagicLinks() {
+	for email := range c.linkQueue {
+		c.sendMagicLink(email)
+	}
+}
+
+// Several addresses can normalise to the same account, so it has a cooldown of its own
+func (controller *Controller) sendMagicLink(email string) {
+	user, userErr := controller.persistence.GetUserByEmail(email)
+	if userErr != nil || user.Disabled || !controller.cooldowns.allow("user:"+user.ID) {
+		return
+	}
+
+	raw := make([]byte, magicLinkTokenBytes)
+	if _, randErr := rand.Read(raw); randErr != nil {
+		controller.log.Error().Err(randErr).Str("user", user.ID).Msg("unable to generate magic link token")
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	link := &entity.MagicLink{
+		ID:        uuid.NewString(),
+		TokenHash: hashMagicLinkToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Duration(config.MagicLinkTTL) * time.Minute),
+	}
+
+	if storeErr := controller.persistence.CreateMagicLink(link); storeErr != nil {
+		controller.log.Error().Err(storeErr).Str("user", user.ID).Msg("unable to store magic link")
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nUse this link to log in to vxconnect, it works once and expires in %d minutes:\n\n%s?token=%s\n\nIf you didn't ask for it you can ignore this email.\n",
+		user.Username, config.MagicLinkTTL, config.MagicLinkURL, url.QueryEscape(token))
+
+	controller.mail.Enqueue(mail.Message{To: email, Subject: "Your vxconnect login link", Body: body})
+}
+
+func hashMagicLinkToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func handleMagicLinkLogin(context *gin.Context) {
+	controller.HandleMagicLinkLogin(context)
+}
+
+// Exchange a magic link token for a normal token, spending the link
+func (controller *Controller) HandleMagicLinkLogin(context *gin.Context) {
+	if !config.MagicLinkEnabled {
+		utilities.RESTError(context, http.StatusNotFound, "magic link login is not enabled", nil)
+		return
+	}
+
+	payload := &entity.MagicLinkLoginBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil || payload.Token == "" {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid body", bindErr)
+		return
+	}
+
+	link, linkErr := controller.unscoped(context).UseMagicLink(hashMagicLinkToken(payload.Token))
+	if linkErr != nil {
+		utilities.RESTError(context, http.StatusUnauthorized, "login link is invalid, expired or already used", nil)
+		return
+	}
+
+	user, userErr := controller.persistence.GetUserById(link.UserID)
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusUnauthorized, "user not found", userErr)
+		return
+	}
+
+	if user.Disabled {
+		controller.recordLogin(context, user, "user is disabled")
+		utilities.RESTError(context, http.StatusUnauthorized, "user is disabled", nil)
+		return
+	}
+
+	// Reading the mailbox isn't entering the password, so it has no auth_time and routes needing recent authentication still need /reauth
+	token, tokenErr := auth.GenerateToken(user.Username, user.TenantID, user.Roles, time.Time{}, auth.TokenTTL(user))
+	if tokenErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to generate token", tokenErr)
+		return
+	}
+
+	controller.recordLogin(context, user, "")
+
+	controller.emit(context, events.Event{
+		Type:       events.USER_LOGIN,
+		TenantID:   user.TenantID,
+		Actor:      user.Username,
+		TargetType: events.TARGET_USER,
+		TargetID:   user.ID,
+		Data:       map[string]string{"method": "magic_link"},
+	})
+
+	context.JSON(http.StatusOK, entity.LoginResponse{
+		Username: user.Username,
+		Token:    token,
+		Zones:    user.Zones,
+		Roles:    user.Roles,
+	})
+}
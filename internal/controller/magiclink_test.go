@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/mail"
+)
+
+var magicLinkToken = regexp.MustCompile(`\?token=([A-Za-z0-9_%-]+)`)
+
+// A controller sending magic links to sent instead of an SMTP server
+func newMagicLinkController(t *testing.T) (*Controller, *memoryStore, chan mail.Message) {
+	t.Helper()
+
+	setConfig(t, &config.MagicLinkEnabled, true)
+	setConfig(t, &config.MagicLinkURL, "https://vxconnect.example/login")
+
+	c, store := newTestController(t)
+
+	sent := make(chan mail.Message, 10)
+	c.mail = mail.NewQueue(10, 1, 0, func(to, subject, body string) error {
+		sent <- mail.Message{To: to, Subject: subject, Body: body}
+		return nil
+	})
+	go c.mail.Run()
+
+	return c, store, sent
+}
+
+func addUserWithEmail(t *testing.T, store *memoryStore, username, email string) *entity.User {
+	t.Helper()
+
+	user := addUser(t, store, username, auth.ROLE_ZONE_ADMIN)
+	user.Email = &email
+	if saveErr := store.SaveUser(user); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	return user
+}
+
+// Ask for a link and return the token of the email it was sent in, or "" if none was sent
+func requestMagicLink(t *testing.T, c *Controller, sent chan mail.Message, email string) string {
+	t.Helper()
+
+	requested := serve(c, http.MethodPost, "/api/v1/login/magic", "", entity.MagicLinkBody{Email: email})
+	if requested.Code != http.StatusAccepted {
+		t.Fatalf("expected the request to be accepted, got %d: %s", requested.Code, requested.Body.String())
+	}
+
+	select {
+	case message := <-sent:
+		match := magicLinkToken.FindStringSubmatch(message.Body)
+		if match == nil {
+			t.Fatalf("expected a token in %s", message.Body)
+		}
+		return match[1]
+	case <-time.After(200 * time.Millisecond):
+		return ""
+	}
+}
+
+func verifyMagicLink(c *Controller, token string) int {
+	return serve(c, http.MethodPost, "/api/v1/login/magic/verify", "", entity.MagicLinkLoginBody{Token: token}).Code
+}
+
+func TestMagicLinksWorkOnce(t *testing.T) {
+	c, store, sent := newMagicLinkController(t)
+	addUserWithEmail(t, store, "alice", "alice@example.com")
+
+	token := requestMagicLink(t, c, sent, "alice@example.com")
+	if token == "" {
+		t.Fatal("expected a link to be sent")
+	}
+
+	if status := verifyMagicLink(c, token); status != http.StatusOK {
+		t.Fatalf("expected the link to log in, got %d", status)
+	}
+
+	if status := verifyMagicLink(c, token); status != http.StatusUnauthorized {
+		t.Fatalf("expected a used link to be refused, got %d", status)
+	}
+}
+
+func TestMagicLinksExpire(t *testing.T) {
+	c, store, sent := newMagicLinkController(t)
+	user := addUserWithEmail(t, store, "alice", "alice@example.com")
+
+	token := requestMagicLink(t, c, sent, "alice@example.com")
+	if token == "" {
+		t.Fatal("expected a link to be sent")
+	}
+
+	store.data.mu.Lock()
+	for _, link := range store.data.magicLinks {
+		if link.UserID == user.ID {
+			link.ExpiresAt = time.Now().Add(-time.Second)
+		}
+	}
+	store.data.mu.Unlock()
+
+	if status := verifyMagicLink(c, token); status != http.StatusUnauthorized {
+		t.Fatalf("expected an expired link to be refused, got %d", status)
+	}
+}
+
+func TestMagicLinksAreReplacedByLoggingIn(t *testing.T) {
+	c, store, sent := newMagicLinkController(t)
+	addUserWithEmail(t, store, "alice", "alice@example.com")
+
+	first := requestMagicLink(t, c, sent, "alice@example.com")
+	c.cooldowns = newCooldown()
+	second := requestMagicLink(t, c, sent, "alice@example.com")
+	if first == "" || second == "" {
+		t.Fatal("expected both links to be sent")
+	}
+
+	// Asking for a second link must not stop the first one working
+	if status := verifyMagicLink(c, first); status != http.StatusOK {
+		t.Fatalf("expected the earlier link to still log in, got %d", status)
+	}
+
+	if status := verifyMagicLink(c, second); status != http.StatusUnauthorized {
+		t.Fatalf("expected logging in to spend the user's other links, got %d", status)
+	}
+}
+
+func TestMagicLinksNeverSatisfyRecentAuthentication(t *testing.T) {
+	c, store, sent := newMagicLinkController(t)
+	user := addUserWithEmail(t, store, "alice", "alice@example.com")
+
+	token := requestMagicLink(t, c, sent, "alice@example.com")
+	if token == "" {
+		t.Fatal("expected a link to be sent")
+	}
+
+	verified := serve(c, http.MethodPost, "/api/v1/login/magic/verify", "", entity.MagicLinkLoginBody{Token: token})
+	if verified.Code != http.StatusOK {
+		t.Fatalf("expected the link to log in, got %d", verified.Code)
+	}
+
+	login := &entity.LoginResponse{}
+	if decodeErr := json.Unmarshal(verified.Body.Bytes(), login); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	for _, maxAge := range []int{0, 5} {
+		setConfig(t, &config.ReauthMaxAge, maxAge)
+
+		created := serve(c, http.MethodPost, "/api/v1/zones/new", login.Token, entity.Zone{Name: "example.com"})
+		if created.Code != http.StatusUnauthorized {
+			t.Fatalf("expected a magic link login to need re-authentication with REAUTH_MAX_AGE=%d, got %d", maxAge, created.Code)
+		}
+	}
+
+	if listed := serve(c, http.MethodGet, "/api/v1/users/"+user.ID+"/logins", login.Token, nil); listed.Code != http.StatusOK {
+		t.Fatalf("expected the token to still work for routes without step-up, got %d: %s", listed.Code, listed.Body.String())
+	}
+
+	reauthed := serve(c, http.MethodPost, "/api/v1/reauth", login.Token, entity.ReauthBody{Password: "alice-password"})
+	if reauthed.Code != http.StatusOK {
+		t.Fatalf("expected the password to re-authenticate the magic link login, got %d: %s", reauthed.Code, reauthed.Body.String())
+	}
+}
+
+func TestMagicLinksAreThrottled(t *testing.T) {
+	c, store, sent := newMagicLinkController(t)
+	addUserWithEmail(t, store, "alice", "alice@example.com")
+
+	token := requestMagicLink(t, c, sent, "alice@example.com")
+	if token == "" {
+		t.Fatal("expected a link to be sent")
+	}
+
+	for i := 0; i < 5; i++ {
+		if again := requestMagicLink(t, c, sent, "alice@example.com"); again != "" {
+			t.Fatal("expected no more links to be sent to the address during its cooldown")
+		}
+	}
+
+	if unknown := requestMagicLink(t, c, sent, "nobody@example.com"); unknown != "" {
+		t.Fatal("expected no link to be sent to an address without an account")
+	}
+
+	if status := verifyMagicLink(c, token); status != http.StatusOK {
+		t.Fatalf("expected repeated requests to leave the outstanding link working, got %d", status)
+	}
+}
@@ -0,0 +1,16 @@
+package controller
+
+import (
+	"expvar"
+
+	"github.com/gin-gonic/gin"
+)
+
+func handleMetrics(context *gin.Context) {
+	controller.HandleMetrics(context)
+}
+
+// Report this instance's metrics as published through expvar, eg. database_breaker
+func (controller *Controller) HandleMetrics(context *gin.Context) {
+	expvar.Handler().ServeHTTP(context.Writer, context.Request)
+}
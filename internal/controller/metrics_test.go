@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+)
+
+func TestMetricsIncludeTheDatabaseBreaker(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	metrics := serve(c, http.MethodGet, "/api/v1/instance/metrics", admin, nil)
+	if metrics.Code != http.StatusOK {
+		t.Fatalf("expected the metrics to be served, got %d: %s", metrics.Code, metrics.Body.String())
+	}
+
+	body := map[string]json.RawMessage{}
+	if decodeErr := json.Unmarshal(metrics.Body.Bytes(), &body); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	if _, ok := body["database_breaker"]; !ok {
+		t.Fatalf("expected the database breaker metrics, got %s", metrics.Body.String())
+	}
+
+	if anonymous := serve(c, http.MethodGet, "/api/v1/instance/metrics", "", nil); anonymous.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the metrics to need a token, got %d", anonymous.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+// Tokens minted for a client certificate only have to last for the request they are minted for
+const clientCertificateTokenTTL = time.Minute
+
+func validateMTLSGroups() error {
+	for _, group := range config.MTLSGroups {
+		if !knownRouteGroup(group) {
+			return fmt.Errorf("client certificates required for unknown route group %s", group)
+		}
+	}
+
+	return nil
+}
+
+// The TLS settings for the REST API, client certificates are verified against TLS_CLIENT_CA_FILE when one is presented
+// but only route groups in MTLS_GROUPS insist on one, so the rest of the API keeps working for browsers
+func serverTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.TLSClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, readErr := os.ReadFile(config.TLSClientCAFile)
+	if readErr != nil {
+		return nil, fmt.Errorf("unable to read client CA file: %s", readErr)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", config.TLSClientCAFile)
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return tlsConfig, nil
+}
+
+func mtlsRequired(group string) bool {
+	for _, required := range config.MTLSGroups {
+		if required == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Insist on a client certificate signed by the client CA for route groups in MTLS_GROUPS, answering 403 without one
+// A request with a certificate whose fingerprint is mapped to a user in MTLS_USERS and no token of its own is authenticated as that user
+func clientCertificate(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !mtlsRequired(group) {
+			c.Next()
+			return
+		}
+
+		// The handshake already rejected certificates that don't chain to the client CA, so any verified chain is trusted
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			auth.LogDenial(c, "access "+group, "no trusted client certificate")
+			utilities.RESTError(c, http.StatusForbidden, "a trusted client certificate is required", nil)
+			c.Abort()
+			return
+		}
+
+		if auth.ExtractToken(c) != "" {
+			c.Next()
+			return
+		}
+
+		fingerprint := sha256.Sum256(c.Request.TLS.VerifiedChains[0][0].Raw)
+		username, mapped := config.MTLSUsers[hex.EncodeToString(fingerprint[:])]
+		if !mapped {
+			c.Next()
+			return
+		}
+
+		user, userErr := controller.persistence.GetUserByUsername(username)
+		if userErr != nil || user.Disabled {
+			auth.LogDenial(c, "access "+group, "client certificate is mapped to a missing or disabled user")
+			utilities.RESTError(c, http.StatusForbidden, "client certificate is not mapped to an active user", nil)
+			c.Abort()
+			return
+		}
+
+		// Authenticate the rest of the chain with a token, as if the client had sent one itself
+		// Nobody entered a password for it, so it has no auth_time and routes needing recent authentication still need a real login
+		token, tokenErr := auth.GenerateToken(user.Username, user.TenantID, user.Roles, time.Time{}, clientCertificateTokenTTL)
+		if tokenErr != nil {
+			utilities.RESTError(c, http.StatusInternalServerError, "unable to generate token", tokenErr)
+			c.Abort()
+			return
+		}
+
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+		c.Next()
+	}
+}
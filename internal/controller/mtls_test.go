@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+// A certificate for commonName signed by its own fresh key, as if it had been verified against the client CA
+func testCertificate(t *testing.T, commonName string) *x509.Certificate {
+	t.Helper()
+
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, certErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if certErr != nil {
+		t.Fatal(certErr)
+	}
+
+	certificate, parseErr := x509.ParseCertificate(der)
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	return certificate
+}
+
+func fingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func serveWithCertificate(c *Controller, method, path string, certificate *x509.Certificate, body interface{}) *httptest.ResponseRecorder {
+	request := serveRequest(method, path, "", body)
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+
+	recorder := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestClientCertificatesAreMappedByFingerprint(t *testing.T) {
+	setConfig(t, &config.MTLSGroups, []string{"users", "zones"})
+
+	c, store := newTestController(t)
+	addUser(t, store, "backup", auth.ROLE_ADMIN)
+
+	mapped := testCertificate(t, "backup-job")
+	impostor := testCertificate(t, "backup-job")
+	setConfig(t, &config.MTLSUsers, map[string]string{fingerprint(mapped): "backup"})
+
+	if listed := serveWithCertificate(c, http.MethodGet, "/api/v1/users", mapped, nil); listed.Code != http.StatusOK {
+		t.Fatalf("expected the mapped certificate to authenticate, got %d: %s", listed.Code, listed.Body.String())
+	}
+
+	if listed := serveWithCertificate(c, http.MethodGet, "/api/v1/users", impostor, nil); listed.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a different certificate with the same common name not to authenticate, got %d", listed.Code)
+	}
+}
+
+func TestClientCertificatesNeverSatisfyRecentAuthentication(t *testing.T) {
+	setConfig(t, &config.MTLSGroups, []string{"zones"})
+
+	c, store := newTestController(t)
+	addUser(t, store, "backup", auth.ROLE_ADMIN)
+
+	certificate := testCertificate(t, "backup-job")
+	setConfig(t, &config.MTLSUsers, map[string]string{fingerprint(certificate): "backup"})
+
+	for _, maxAge := range []int{0, 5} {
+		setConfig(t, &config.ReauthMaxAge, maxAge)
+
+		created := serveWithCertificate(c, http.MethodPost, "/api/v1/zones/new", certificate, entity.Zone{Name: "example.com"})
+		if created.Code != http.StatusUnauthorized {
+			t.Fatalf("expected a certificate login to need re-authentication with REAUTH_MAX_AGE=%d, got %d", maxAge, created.Code)
+		}
+	}
+
+	if listed := serveWithCertificate(c, http.MethodGet, "/api/v1/zones", certificate, nil); listed.Code != http.StatusOK {
+		t.Fatalf("expected the certificate to still work for routes without step-up, got %d", listed.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+const (
+	CONTENT_TYPE_JSON_PATCH = "application/json-patch+json"
+)
+
+// The fields of a user that a JSON Patch is allowed to touch
+var userPatchableFields = []string{"/email", "/zones", "/tokenTtl"}
+
+// Apply an RFC 6902 JSON Patch to the editable subset of a user
+func patchUser(user *entity.User, body []byte) (*entity.UserPatch, error) {
+	patch, decodeErr := jsonpatch.DecodePatch(body)
+	if decodeErr != nil {
+		return nil, fmt.Errorf("invalid patch: %s", decodeErr)
+	}
+
+	for _, operation := range patch {
+		path, pathErr := operation.Path()
+		if pathErr != nil {
+			return nil, fmt.Errorf("invalid patch operation: %s", pathErr)
+		}
+
+		if !userPatchable(path) {
+			return nil, fmt.Errorf("patch operation %s targets %s which can not be edited", operation.Kind(), path)
+		}
+
+		// Only move and copy have a from, and it must come from an editable field too
+		if from, fromErr := operation.From(); fromErr == nil && !userPatchable(from) {
+			return nil, fmt.Errorf("patch operation %s reads from %s which can not be edited", operation.Kind(), from)
+		}
+	}
+
+	current := entity.UserPatch{Email: user.Email, Zones: user.Zones, TokenTTL: user.TokenTTL}
+	if current.Zones == nil {
+		current.Zones = []string{}
+	}
+
+	document, marshalErr := json.Marshal(current)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+
+	patched, applyErr := patch.Apply(document)
+	if applyErr != nil {
+		return nil, fmt.Errorf("unable to apply patch: %s", applyErr)
+	}
+
+	result := &entity.UserPatch{}
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if resultErr := decoder.Decode(result); resultErr != nil {
+		return nil, fmt.Errorf("patched user is invalid: %s", resultErr)
+	}
+
+	return result, nil
+}
+
+func userPatchable(path string) bool {
+	for _, field := range userPatchableFields {
+		if path == field || strings.HasPrefix(path, field+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+)
+
+func servePatch(c *Controller, path, token, patch string) *httptest.ResponseRecorder {
+	request := serveRequest(http.MethodPatch, path, token, patch)
+	request.Header.Set("Content-Type", CONTENT_TYPE_JSON_PATCH)
+
+	recorder := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestJSONPatchEditsUserZones(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	path := "/api/v1/users/" + user.ID
+
+	steps := []struct {
+		patch string
+		zones []string
+	}{
+		{`[{"op": "add", "path": "/zones/-", "value": "example.com"}]`, []string{"example.com"}},
+		{`[{"op": "add", "path": "/zones/0", "value": "example.org"}]`, []string{"example.org", "example.com"}},
+		{`[{"op": "replace", "path": "/zones/1", "value": "example.net"}]`, []string{"example.org", "example.net"}},
+		{`[{"op": "remove", "path": "/zones/0"}]`, []string{"example.net"}},
+	}
+
+	for _, step := range steps {
+		patched := servePatch(c, path, admin, step.patch)
+		if patched.Code != http.StatusOK {
+			t.Fatalf("expected %s to apply, got %d: %s", step.patch, patched.Code, patched.Body.String())
+		}
+
+		stored, _ := store.GetUserById(user.ID)
+		if !reflect.DeepEqual(stored.Zones, step.zones) {
+			t.Fatalf("expected %s to leave zones %v, got %v", step.patch, step.zones, stored.Zones)
+		}
+	}
+}
+
+func TestJSONPatchRefusesForbiddenFieldsAndInvalidResults(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	path := "/api/v1/users/" + user.ID
+
+	for _, patch := range []string{
+		`[{"op": "replace", "path": "/passwordHash", "value": "x"}]`,
+		`[{"op": "replace", "path": "/id", "value": "another"}]`,
+		`[{"op": "add", "path": "/roles/-", "value": "ADMIN"}]`,
+		`[{"op": "copy", "from": "/roles", "path": "/zones"}]`,
+		`[{"op": "replace", "path": "/email", "value": "not an email"}]`,
+		`[{"op": "replace", "path": "/tokenTtl", "value": -1}]`,
+		`not a patch`,
+	} {
+		if patched := servePatch(c, path, admin, patch); patched.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be refused, got %d: %s", patch, patched.Code, patched.Body.String())
+		}
+	}
+
+	stored, _ := store.GetUserById(user.ID)
+	if stored.PasswordHash != user.PasswordHash || !reflect.DeepEqual(stored.Roles, user.Roles) || stored.Email != nil || stored.TokenTTL != nil {
+		t.Fatalf("expected refused patches to change nothing, got %+v", stored)
+	}
+}
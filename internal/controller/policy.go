@@ -0,0 +1,58 @@
+package controller
+
+import "github.com/monoxane/vxconnect/internal/auth"
+
+// The permission required to access every authenticated endpoint
+// This is the one place to audit who can do what, handlers should not repeat these checks
+var policies = map[string]string{
+	"GET /api/v1/users":                     auth.PERMISSION_USERS_READ,
+	"GET /api/v1/users/:id":                 auth.PERMISSION_USERS_READ,
+	"POST /api/v1/users/new":                auth.PERMISSION_USERS_WRITE,
+	"POST /api/v1/users/bulk/disabled":      auth.PERMISSION_USERS_WRITE,
+	"POST /api/v1/users/bulk/zones":         auth.PERMISSION_USERS_WRITE,
+	"PATCH /api/v1/users/:id":               auth.PERMISSION_USERS_WRITE,
+	"DELETE /api/v1/users/:id":              auth.PERMISSION_USERS_WRITE,
+	"GET /api/v1/users/me":                  auth.PERMISSION_DYNAMIC,
+	"GET /api/v1/users/me/events":           auth.PERMISSION_DYNAMIC,
+	"PUT /api/v1/users/:id/labels/:key":     auth.PERMISSION_USERS_WRITE,
+	"DELETE /api/v1/users/:id/labels/:key":  auth.PERMISSION_USERS_WRITE,
+	"GET /api/v1/users/:id/logins":          auth.PERMISSION_DYNAMIC,
+	"GET /api/v1/users/:id/zones/effective": auth.PERMISSION_DYNAMIC,
+	"GET /api/v1/users/:id/diff/:other":     auth.PERMISSION_USERS_READ,
+	"POST /api/v1/users/:id/access/what-if": auth.PERMISSION_USERS_READ,
+	"POST /api/v1/users/:id/zones":          auth.PERMISSION_USERS_WRITE,
+	"DELETE /api/v1/users/:id/zones/:zone":  auth.PERMISSION_USERS_WRITE,
+
+	"GET /api/v1/zones":                      auth.PERMISSION_ZONES_LIST,
+	"GET /api/v1/zones/:zone":                auth.PERMISSION_ZONES_READ,
+	"POST /api/v1/zones/new":                 auth.PERMISSION_ZONES_WRITE,
+	"POST /api/v1/zones/exists":              auth.PERMISSION_ZONES_LIST,
+	"PUT /api/v1/zones/:zone/read-only":      auth.PERMISSION_ZONES_WRITE,
+	"POST /api/v1/zones/:zone/restore":       auth.PERMISSION_ZONES_WRITE,
+	"DELETE /api/v1/zones/:zone":             auth.PERMISSION_ZONES_WRITE,
+	"GET /api/v1/zones/:zone/records":        auth.PERMISSION_RECORDS_READ,
+	"POST /api/v1/zones/:zone/records/new":   auth.PERMISSION_RECORDS_WRITE,
+	"PATCH /api/v1/zones/:zone/records/:id":  auth.PERMISSION_RECORDS_WRITE,
+	"DELETE /api/v1/zones/:zone/records/:id": auth.PERMISSION_RECORDS_WRITE,
+
+	"GET /api/v1/roles/:role/permissions": auth.PERMISSION_ROLES_READ,
+
+	"GET /api/v1/audit": auth.PERMISSION_AUDIT_READ,
+
+	"GET /api/v1/approvals":              auth.PERMISSION_APPROVALS,
+	"GET /api/v1/approvals/:id":          auth.PERMISSION_APPROVALS,
+	"POST /api/v1/approvals/:id/approve": auth.PERMISSION_APPROVALS,
+	"POST /api/v1/approvals/:id/reject":  auth.PERMISSION_APPROVALS,
+
+	"GET /api/v1/bundle/export":  auth.PERMISSION_BUNDLE,
+	"POST /api/v1/bundle/import": auth.PERMISSION_BUNDLE,
+
+	"GET /api/v1/tenants":      auth.PERMISSION_TENANTS,
+	"GET /api/v1/tenants/:id":  auth.PERMISSION_TENANTS,
+	"POST /api/v1/tenants/new": auth.PERMISSION_TENANTS,
+
+	"POST /api/v1/instance/drain":  auth.PERMISSION_DRAIN,
+	"GET /api/v1/instance/metrics": auth.PERMISSION_METRICS,
+
+	"POST /api/v1/debug/token": auth.PERMISSION_TOKENS_DEBUG,
+}
@@ -0,0 +1,23 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestEveryPolicyIsForARegisteredRoute(t *testing.T) {
+	c, _ := newTestController(t)
+
+	registered := map[string]bool{}
+	for _, route := range c.restEngine.Routes() {
+		registered[route.Method+" "+strings.TrimPrefix(route.Path, config.BasePath)] = true
+	}
+
+	for route := range policies {
+		if !registered[route] {
+			t.Fatalf("expected the policy for %s to be for a registered route", route)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+// Buffers JSON responses so they can be indented once the handler is done
+// Anything that isn't JSON (eg. event streams) is passed straight through
+type prettyWriter struct {
+	gin.ResponseWriter
+	buffer bytes.Buffer
+}
+
+func (w *prettyWriter) json() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *prettyWriter) Write(data []byte) (int, error) {
+	if !w.json() {
+		return w.ResponseWriter.Write(data)
+	}
+
+	return w.buffer.Write(data)
+}
+
+func (w *prettyWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+// Indent JSON responses when asked with ?pretty=true, or always in DEV mode unless ?pretty=false
+func prettyJSON(c *gin.Context) {
+	pretty := config.AppMode == "DEV"
+	if query, ok := c.GetQuery("pretty"); ok {
+		pretty, _ = strconv.ParseBool(query)
+	}
+
+	if !pretty {
+		c.Next()
+		return
+	}
+
+	writer := &prettyWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	c.Next()
+
+	c.Writer = writer.ResponseWriter
+	if writer.buffer.Len() == 0 {
+		return
+	}
+
+	indented := &bytes.Buffer{}
+	if err := json.Indent(indented, writer.buffer.Bytes(), "", "  "); err != nil {
+		writer.ResponseWriter.Write(writer.buffer.Bytes())
+		return
+	}
+	indented.WriteByte('\n')
+
+	writer.ResponseWriter.Write(indented.Bytes())
+}
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestPrettyOutputIsTheIndentedCompactOutput(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	addZone(t, store, "example.com", false)
+	addZone(t, store, "example.net", true)
+
+	compact := serve(c, http.MethodGet, "/api/v1/zones", admin, nil)
+	pretty := serve(c, http.MethodGet, "/api/v1/zones?pretty=true", admin, nil)
+	if compact.Code != http.StatusOK || pretty.Code != http.StatusOK {
+		t.Fatalf("expected both listings to succeed, got %d and %d", compact.Code, pretty.Code)
+	}
+
+	if bytes.Contains(bytes.TrimSpace(compact.Body.Bytes()), []byte("\n")) {
+		t.Fatalf("expected compact output by default, got %s", compact.Body.String())
+	}
+
+	if !bytes.Contains(pretty.Body.Bytes(), []byte("\n  \"results\"")) {
+		t.Fatalf("expected indented output with ?pretty=true, got %s", pretty.Body.String())
+	}
+
+	compacted := &bytes.Buffer{}
+	if compactErr := json.Compact(compacted, pretty.Body.Bytes()); compactErr != nil {
+		t.Fatal(compactErr)
+	}
+
+	if compacted.String() != string(bytes.TrimSpace(compact.Body.Bytes())) {
+		t.Fatalf("expected the same payload either way, got %s and %s", compact.Body.String(), pretty.Body.String())
+	}
+
+	if pretty.Header().Get("Content-Type") != compact.Header().Get("Content-Type") {
+		t.Fatalf("expected the same content type either way, got %q and %q", compact.Header().Get("Content-Type"), pretty.Header().Get("Content-Type"))
+	}
+}
+
+func TestDevModeIsPrettyUnlessAskedNotToBe(t *testing.T) {
+	c, store := newTestController(t)
+	setConfig(t, &config.AppMode, "DEV")
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	if pretty := serve(c, http.MethodGet, "/api/v1/zones", admin, nil); !bytes.Contains(pretty.Body.Bytes(), []byte("\n  ")) {
+		t.Fatalf("expected indented output in DEV mode, got %s", pretty.Body.String())
+	}
+
+	if compact := serve(c, http.MethodGet, "/api/v1/zones?pretty=false", admin, nil); bytes.Contains(bytes.TrimSpace(compact.Body.Bytes()), []byte("\n")) {
+		t.Fatalf("expected compact output with ?pretty=false, got %s", compact.Body.String())
+	}
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+type rateWindow struct {
+	count int
+	start time.Time
+}
+
+// A fixed window request limiter, counting requests per client
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	clients map[string]*rateWindow
+}
+
+// The outcome of counting a request against a limiter
+type rateDecision struct {
+	allowed   bool
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		clients: map[string]*rateWindow{},
+	}
+}
+
+// Count a request from a client, it is allowed if the client has budget left in the current window
+func (limiter *rateLimiter) allow(client string) rateDecision {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+
+	current, ok := limiter.clients[client]
+	if !ok || now.Sub(current.start) >= limiter.window {
+		limiter.prune(now)
+		current = &rateWindow{start: now}
+		limiter.clients[client] = current
+	}
+
+	decision := rateDecision{limit: limiter.limit, reset: current.start.Add(limiter.window)}
+
+	if current.count >= limiter.limit {
+		return decision
+	}
+
+	current.count++
+	decision.allowed = true
+	decision.remaining = limiter.limit - current.count
+
+	return decision
+}
+
+func (limiter *rateLimiter) prune(now time.Time) {
+	for client, window := range limiter.clients {
+		if now.Sub(window.start) >= limiter.window {
+			delete(limiter.clients, client)
+		}
+	}
+}
+
+// A limiter for each route group that has one configured in RATE_LIMITS
+type rateLimiters map[string]*rateLimiter
+
+// Replaced whole when the config is reloaded
+var currentLimits atomic.Pointer[rateLimiters]
+
+// Build the limiters for each group, reusing the limiter (and so the counts) from previous for groups whose limit hasn't changed
+func newRateLimiters(limits map[string]config.RateLimit, previous rateLimiters) (rateLimiters, error) {
+	limiters := rateLimiters{}
+
+	for group, limit := range limits {
+		if !knownRouteGroup(group) {
+			return nil, fmt.Errorf("rate limit for unknown route group %s", group)
+		}
+
+		window := time.Duration(limit.Window) * time.Second
+		if existing, ok := previous[group]; ok && existing.limit == limit.Requests && existing.window == window {
+			limiters[group] = existing
+			continue
+		}
+
+		limiters[group] = newRateLimiter(limit.Requests, window)
+	}
+
+	return limiters, nil
+}
+
+const (
+	HEADER_RATE_LIMIT           = "X-RateLimit-Limit"
+	HEADER_RATE_LIMIT_REMAINING = "X-RateLimit-Remaining"
+	HEADER_RATE_LIMIT_RESET     = "X-RateLimit-Reset"
+)
+
+// Limit the requests to a route group, each group has its own budget so a tight limit on one never affects another
+// Clients are counted by username once authenticated, or by IP before that
+// Unless RATE_LIMIT_HEADERS is off every response reports the client's budget, the reset being a unix timestamp
+// Every route group is limited, so this is also where the group becomes known to CORS_OVERRIDES, RATE_LIMITS and MTLS_GROUPS
+func rateLimit(group string) gin.HandlerFunc {
+	routeGroups[group] = true
+
+	return func(c *gin.Context) {
+		limiter, ok := (*currentLimits.Load())[group]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		client := "ip:" + c.ClientIP()
+		if username, err := auth.CurrentUser(c); err == nil && username != "" {
+			client = "user:" + username
+		}
+
+		decision := limiter.allow(client)
+		if config.RateLimitHeaders {
+			c.Header(HEADER_RATE_LIMIT, strconv.Itoa(decision.limit))
+			c.Header(HEADER_RATE_LIMIT_REMAINING, strconv.Itoa(decision.remaining))
+			c.Header(HEADER_RATE_LIMIT_RESET, strconv.FormatInt(decision.reset.Unix(), 10))
+		}
+
+		if !decision.allowed {
+			retryAfter := int(math.Ceil(time.Until(decision.reset).Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			utilities.RESTError(c, http.StatusTooManyRequests, "rate limit exceeded", nil)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestExportIsThrottledIndependentlyOfOtherRoutes(t *testing.T) {
+	setConfig(t, &config.RateLimits, map[string]config.RateLimit{"bundle": {Requests: 2, Window: 60}})
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	for i := 0; i < 2; i++ {
+		if exported := serve(c, http.MethodGet, "/api/v1/bundle/export", admin, nil); exported.Code != http.StatusOK {
+			t.Fatalf("expected export %d to be within the limit, got %d: %s", i+1, exported.Code, exported.Body.String())
+		}
+	}
+
+	throttled := serve(c, http.MethodGet, "/api/v1/bundle/export", admin, nil)
+	if throttled.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the export over the limit to be throttled, got %d", throttled.Code)
+	}
+
+	retryAfter, retryErr := strconv.Atoi(throttled.Header().Get("Retry-After"))
+	if retryErr != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Fatalf("expected a Retry-After within the window, got %q", throttled.Header().Get("Retry-After"))
+	}
+
+	for i := 0; i < 5; i++ {
+		if listed := serve(c, http.MethodGet, "/api/v1/users", admin, nil); listed.Code != http.StatusOK {
+			t.Fatalf("expected other routes not to share the export's budget, got %d: %s", listed.Code, listed.Body.String())
+		}
+	}
+
+	other := tokenFor(t, addUser(t, store, "other", auth.ROLE_ADMIN))
+	if exported := serve(c, http.MethodGet, "/api/v1/bundle/export", other, nil); exported.Code != http.StatusOK {
+		t.Fatalf("expected another user to have their own export budget, got %d", exported.Code)
+	}
+}
+
+func TestRateLimitHeadersCountDownEachClientsBudget(t *testing.T) {
+	setConfig(t, &config.RateLimits, map[string]config.RateLimit{"zones": {Requests: 3, Window: 60}, "login": {Requests: 3, Window: 60}})
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	other := tokenFor(t, addUser(t, store, "other", auth.ROLE_ADMIN))
+
+	var reset string
+	for i, expected := range []string{"2", "1", "0", "0"} {
+		listed := serve(c, http.MethodGet, "/api/v1/zones", admin, nil)
+
+		if limit := listed.Header().Get(HEADER_RATE_LIMIT); limit != "3" {
+			t.Fatalf("expected request %d to report the limit, got %q", i+1, limit)
+		}
+
+		if remaining := listed.Header().Get(HEADER_RATE_LIMIT_REMAINING); remaining != expected {
+			t.Fatalf("expected request %d to have %s remaining, got %q", i+1, expected, remaining)
+		}
+
+		resetAt, resetErr := strconv.ParseInt(listed.Header().Get(HEADER_RATE_LIMIT_RESET), 10, 64)
+		if resetErr != nil || resetAt < time.Now().Unix() || resetAt > time.Now().Add(time.Minute).Unix() {
+			t.Fatalf("expected request %d to reset within the window, got %q", i+1, listed.Header().Get(HEADER_RATE_LIMIT_RESET))
+		}
+
+		if reset != "" && listed.Header().Get(HEADER_RATE_LIMIT_RESET) != reset {
+			t.Fatalf("expected every request in the window to share its reset, got %q after %q", listed.Header().Get(HEADER_RATE_LIMIT_RESET), reset)
+		}
+		reset = listed.Header().Get(HEADER_RATE_LIMIT_RESET)
+	}
+
+	// Authenticated clients are counted by username, so another user on the same IP has their own bucket
+	if listed := serve(c, http.MethodGet, "/api/v1/zones", other, nil); listed.Header().Get(HEADER_RATE_LIMIT_REMAINING) != "2" {
+		t.Fatalf("expected another user to have their own budget, got %q", listed.Header().Get(HEADER_RATE_LIMIT_REMAINING))
+	}
+
+	// Until then they are counted by IP
+	for _, expected := range []string{"2", "1"} {
+		login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "admin", Password: "wrong"})
+		if remaining := login.Header().Get(HEADER_RATE_LIMIT_REMAINING); remaining != expected {
+			t.Fatalf("expected logins from the IP to have %s remaining, got %q", expected, remaining)
+		}
+	}
+
+	setConfig(t, &config.RateLimitHeaders, false)
+	if listed := serve(c, http.MethodGet, "/api/v1/zones", other, nil); listed.Header().Get(HEADER_RATE_LIMIT) != "" {
+		t.Fatal("expected no headers with RATE_LIMIT_HEADERS off")
+	}
+}
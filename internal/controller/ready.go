@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+func handleReady(context *gin.Context) {
+	controller.HandleReady(context)
+}
+
+// Report whether this instance can serve requests, for load balancer and orchestrator readiness probes
+// An instance isn't ready while the database is unavailable or once it has started draining
+func (controller *Controller) HandleReady(context *gin.Context) {
+	readiness := controller.readiness()
+
+	if !readiness.Ready {
+		context.JSON(http.StatusServiceUnavailable, readiness)
+		return
+	}
+
+	context.JSON(http.StatusOK, readiness)
+}
+
+func (controller *Controller) readiness() entity.Readiness {
+	readiness := entity.Readiness{
+		Draining: controller.Draining(),
+		Database: controller.persistence.BreakerState(),
+	}
+	readiness.Ready = !readiness.Draining && readiness.Database != persistence.BREAKER_OPEN
+
+	return readiness
+}
+
+func handleLive(context *gin.Context) {
+	controller.HandleLive(context)
+}
+
+// Report that the process is up, for liveness probes, this keeps passing while the database is down or the instance drains
+// so the orchestrator doesn't restart an instance that is only waiting
+func (controller *Controller) HandleLive(context *gin.Context) {
+	context.JSON(http.StatusOK, entity.Liveness{Live: true})
+}
+
+// Fail requests straight away with 503 while the database circuit breaker is open
+func storeBreaker(c *gin.Context) {
+	if controller == nil || c.Request.URL.Path == config.BasePath+"/api/v1/ready" || c.Request.URL.Path == config.BasePath+"/api/v1/live" {
+		c.Next()
+		return
+	}
+
+	if controller.persistence.BreakerState() == persistence.BREAKER_OPEN {
+		c.Header("Retry-After", strconv.Itoa(config.DBBreakerCooldown))
+		utilities.RESTError(c, http.StatusServiceUnavailable, "database is unavailable", nil)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+const HEADER_REFRESH_TOKEN = "X-Refresh-Token"
+
+// Hand out a new token in X-Refresh-Token when a read is made with one that expires within TOKEN_REFRESH_WINDOW minutes
+// The new token keeps the original auth_time so it never extends how recently the user entered their password
+func refreshToken(c *gin.Context) {
+	if config.TokenRefreshWindow <= 0 || controller == nil || (c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) {
+		c.Next()
+		return
+	}
+
+	expiry, expiryErr := auth.CurrentUserExpiry(c)
+	if expiryErr != nil || time.Until(expiry) > time.Duration(config.TokenRefreshWindow)*time.Minute {
+		c.Next()
+		return
+	}
+
+	username, _ := auth.CurrentUser(c)
+	authTime, authTimeErr := auth.CurrentUserAuthTime(c)
+	user, userErr := controller.persistence.GetUserByUsername(username)
+	if authTimeErr != nil || userErr != nil || user.Disabled {
+		c.Next()
+		return
+	}
+
+	token, tokenErr := auth.GenerateToken(user.Username, user.TenantID, user.Roles, authTime, auth.TokenTTL(user))
+	if tokenErr != nil {
+		controller.log.Error().Err(tokenErr).Str("username", username).Msg("unable to generate refresh token")
+		c.Next()
+		return
+	}
+
+	c.Header(HEADER_REFRESH_TOKEN, token)
+	c.Next()
+}
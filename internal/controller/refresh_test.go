@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestNearExpiryReadsAreHandedARefreshToken(t *testing.T) {
+	setConfig(t, &config.TokenRefreshWindow, 5)
+
+	c, store := newTestController(t)
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	expiring, expiringErr := auth.GenerateToken(admin.Username, admin.TenantID, admin.Roles, authTime, 2*time.Minute)
+	if expiringErr != nil {
+		t.Fatal(expiringErr)
+	}
+
+	if fresh := serve(c, http.MethodGet, "/api/v1/zones", tokenFor(t, admin), nil); fresh.Header().Get(HEADER_REFRESH_TOKEN) != "" {
+		t.Fatal("expected a fresh token not to be refreshed")
+	}
+
+	if written := serve(c, http.MethodPost, "/api/v1/zones/new", expiring, map[string]string{"name": "example.com"}); written.Header().Get(HEADER_REFRESH_TOKEN) != "" {
+		t.Fatal("expected writes not to be handed a refresh token")
+	}
+
+	read := serve(c, http.MethodGet, "/api/v1/zones", expiring, nil)
+	if read.Code != http.StatusOK {
+		t.Fatalf("expected the read to succeed, got %d: %s", read.Code, read.Body.String())
+	}
+
+	refreshed := read.Header().Get(HEADER_REFRESH_TOKEN)
+	if refreshed == "" {
+		t.Fatal("expected a near expiry read to be handed a refresh token")
+	}
+
+	inspected, inspectErr := auth.InspectToken(refreshed)
+	if inspectErr != nil || !inspected.Valid {
+		t.Fatalf("expected the refresh token to be valid, got %+v: %v", inspected, inspectErr)
+	}
+
+	if inspected.ExpiresAt == nil || time.Until(*inspected.ExpiresAt) <= 5*time.Minute {
+		t.Fatalf("expected the refresh token to outlive the window, expires %v", inspected.ExpiresAt)
+	}
+
+	if issued, _ := inspected.Claims["auth_time"].(float64); int64(issued) != authTime.Unix() {
+		t.Fatalf("expected the refresh token to keep the original auth_time, got %v", inspected.Claims["auth_time"])
+	}
+
+	admin.Disabled = true
+	if saveErr := store.SaveUser(admin); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	if disabled := serve(c, http.MethodGet, "/api/v1/zones", expiring, nil); disabled.Header().Get(HEADER_REFRESH_TOKEN) != "" {
+		t.Fatal("expected a disabled user not to be handed a refresh token")
+	}
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/logging"
+)
+
+// Apply reloaded settings, everything is validated first so either all of it takes effect or none of it does
+// In flight requests keep the settings they started with
+func (c *Controller) Reload(settings *config.Reloadable) error {
+	if !logging.ValidLevel(settings.LogLevel) {
+		return fmt.Errorf("invalid log level %s", settings.LogLevel)
+	}
+
+	cors, corsErr := corsPolicies(config.BasePath+"/api/v1", settings.CORSAllowedOrigins, settings.CORSOverrides)
+	if corsErr != nil {
+		return corsErr
+	}
+
+	limits, limitsErr := newRateLimiters(settings.RateLimits, *currentLimits.Load())
+	if limitsErr != nil {
+		return limitsErr
+	}
+
+	// Applied last of the checked settings, it is only replaced if the mapping is valid
+	if rolesErr := auth.SetRolePermissions(settings.RolePermissions); rolesErr != nil {
+		return rolesErr
+	}
+
+	currentCORS.Store(cors)
+	currentLimits.Store(&limits)
+	logging.SetLevel(settings.LogLevel)
+
+	settings.Commit()
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/logging"
+	"github.com/rs/zerolog"
+)
+
+// Settings as they are running, for a reload to change
+func runningSettings() *config.Reloadable {
+	return &config.Reloadable{
+		LogLevel:           config.LogLevel,
+		CORSAllowedOrigins: config.CORSAllowedOrigins,
+		CORSOverrides:      config.CORSOverrides,
+		RateLimits:         config.RateLimits,
+		RolePermissions:    config.RolePermissions,
+	}
+}
+
+// Put the running settings back once the test is done, starting it at the INFO level
+func restoreSettings(t *testing.T) {
+	t.Helper()
+
+	setConfig(t, &config.LogLevel, config.LogLevel)
+	setConfig(t, &config.CORSAllowedOrigins, config.CORSAllowedOrigins)
+	setConfig(t, &config.CORSOverrides, config.CORSOverrides)
+	setConfig(t, &config.RateLimits, config.RateLimits)
+	setConfig(t, &config.RolePermissions, config.RolePermissions)
+	t.Cleanup(func() {
+		if err := auth.SetRolePermissions(config.RolePermissions); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	level := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+	config.LogLevel = "INFO"
+	logging.SetLevel(config.LogLevel)
+}
+
+func TestReloadedLogLevelTakesEffect(t *testing.T) {
+	restoreSettings(t)
+
+	c, _ := newTestController(t)
+
+	settings := runningSettings()
+	settings.LogLevel = "DEBUG"
+	if reloadErr := c.Reload(settings); reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+
+	if zerolog.GlobalLevel() != zerolog.DebugLevel || config.LogLevel != "DEBUG" {
+		t.Fatalf("expected the reloaded log level to take effect, got %s", zerolog.GlobalLevel())
+	}
+
+	settings = runningSettings()
+	settings.LogLevel = "VERBOSE"
+	if reloadErr := c.Reload(settings); reloadErr == nil {
+		t.Fatal("expected an unknown log level to be refused")
+	}
+
+	if zerolog.GlobalLevel() != zerolog.DebugLevel || config.LogLevel != "DEBUG" {
+		t.Fatalf("expected a refused reload to keep the running log level, got %s", zerolog.GlobalLevel())
+	}
+}
+
+func TestReloadIsAllOrNothing(t *testing.T) {
+	restoreSettings(t)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	settings := runningSettings()
+	settings.LogLevel = "ERROR"
+	settings.RateLimits = map[string]config.RateLimit{"zones": {Requests: 1, Window: 60}, "nowhere": {Requests: 1, Window: 60}}
+	if reloadErr := c.Reload(settings); reloadErr == nil {
+		t.Fatal("expected a rate limit for an unknown route group to be refused")
+	}
+
+	if zerolog.GlobalLevel() != zerolog.InfoLevel {
+		t.Fatalf("expected the log level not to change when the rate limits are refused, got %s", zerolog.GlobalLevel())
+	}
+
+	for i := 0; i < 2; i++ {
+		if listed := serve(c, http.MethodGet, "/api/v1/zones", admin, nil); listed.Code != http.StatusOK {
+			t.Fatalf("expected the refused rate limit not to apply, got %d", listed.Code)
+		}
+	}
+
+	settings.RateLimits = map[string]config.RateLimit{"zones": {Requests: 1, Window: 60}}
+	if reloadErr := c.Reload(settings); reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+
+	serve(c, http.MethodGet, "/api/v1/zones", admin, nil)
+	if throttled := serve(c, http.MethodGet, "/api/v1/zones", admin, nil); throttled.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the reloaded rate limit to apply, got %d", throttled.Code)
+	}
+}
+
+func TestReloadedRolePermissionsApplyToTheNextCheck(t *testing.T) {
+	restoreSettings(t)
+
+	c, store := newTestController(t)
+
+	zoneAdmin := tokenFor(t, addUser(t, store, "zoneadmin", auth.ROLE_ZONE_ADMIN))
+
+	if listed := serve(c, http.MethodGet, "/api/v1/zones", zoneAdmin, nil); listed.Code != http.StatusUnauthorized {
+		t.Fatalf("expected zone admins not to be able to list zones by default, got %d", listed.Code)
+	}
+
+	settings := runningSettings()
+	settings.RolePermissions = map[string][]string{
+		auth.ROLE_ADMIN:      {auth.PERMISSION_USERS_READ},
+		auth.ROLE_ZONE_ADMIN: {auth.PERMISSION_ZONES_LIST, auth.PERMISSION_ZONES_READ},
+	}
+	if reloadErr := c.Reload(settings); reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+
+	if listed := serve(c, http.MethodGet, "/api/v1/zones", zoneAdmin, nil); listed.Code != http.StatusOK {
+		t.Fatalf("expected the reloaded permissions to apply to the next request, got %d", listed.Code)
+	}
+
+	settings = runningSettings()
+	settings.LogLevel = "ERROR"
+	settings.RolePermissions = map[string][]string{auth.ROLE_ZONE_ADMIN: {"zones:own"}}
+	if reloadErr := c.Reload(settings); reloadErr == nil {
+		t.Fatal("expected a mapping with an unknown permission to be refused")
+	}
+
+	if zerolog.GlobalLevel() != zerolog.InfoLevel {
+		t.Fatalf("expected the log level not to change when the permissions are refused, got %s", zerolog.GlobalLevel())
+	}
+
+	if listed := serve(c, http.MethodGet, "/api/v1/zones", zoneAdmin, nil); listed.Code != http.StatusOK {
+		t.Fatalf("expected a refused reload to keep the running permissions, got %d", listed.Code)
+	}
+
+	settings = runningSettings()
+	settings.RolePermissions = map[string][]string{}
+	if reloadErr := c.Reload(settings); reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+
+	if listed := serve(c, http.MethodGet, "/api/v1/zones", zoneAdmin, nil); listed.Code != http.StatusUnauthorized {
+		t.Fatalf("expected removing the mapping to put the built in permissions back, got %d", listed.Code)
+	}
+}
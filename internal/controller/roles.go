@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+func handleRolePermissions(context *gin.Context) {
+	controller.HandleRolePermissions(context)
+}
+
+func (controller *Controller) HandleRolePermissions(context *gin.Context) {
+	role := context.Param("role")
+
+	permissions, ok := auth.RolePermissions(role)
+	if !ok {
+		utilities.RESTError(context, http.StatusNotFound, "role not found", nil)
+		return
+	}
+
+	preview := []entity.RolePermission{}
+	for _, permission := range permissions {
+		preview = append(preview, entity.RolePermission{
+			Permission:  permission,
+			Description: auth.PermissionDescription(permission),
+		})
+	}
+
+	context.JSON(http.StatusOK, entity.RESTResult{
+		Results:      preview,
+		TotalResults: len(preview),
+	})
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestAdminRolePreviewIncludesUserManagement(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	preview := serve(c, http.MethodGet, "/api/v1/roles/"+auth.ROLE_ADMIN+"/permissions", admin, nil)
+	if preview.Code != http.StatusOK {
+		t.Fatalf("expected the admin role to be previewed, got %d: %s", preview.Code, preview.Body.String())
+	}
+
+	body := struct {
+		Results []entity.RolePermission `json:"results"`
+	}{}
+	if decodeErr := json.Unmarshal(preview.Body.Bytes(), &body); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	described := map[string]string{}
+	for _, permission := range body.Results {
+		described[permission.Permission] = permission.Description
+	}
+
+	for _, permission := range []string{auth.PERMISSION_USERS_READ, auth.PERMISSION_USERS_WRITE} {
+		if described[permission] == "" {
+			t.Fatalf("expected the admin preview to describe %s, got %+v", permission, body.Results)
+		}
+	}
+
+	if missing := serve(c, http.MethodGet, "/api/v1/roles/NOBODY/permissions", admin, nil); missing.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown role to be not found, got %d", missing.Code)
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/utilities"
+)
+
+const (
+	// How many recent security events are kept for streams resuming with Last-Event-ID
+	securityEventBuffer int = 256
+	// How many events can wait for a single stream before it is closed so the client resumes from the buffer
+	securityStreamBuffer int = 16
+	// Comments are sent this often so proxies don't close idle streams
+	securityStreamKeepAlive time.Duration = 30 * time.Second
+)
+
+// The events a user is notified of when they are about their own account
+var securityEventTypes = map[string]bool{
+	events.USER_LOGIN:   true,
+	events.USER_UPDATED: true,
+	events.USER_DELETED: true,
+}
+
+type securityEvent struct {
+	id    uint64
+	event events.Event
+}
+
+// Keeps the most recent security events and fans new ones out to the streams of the users they are about
+type securityFeed struct {
+	mu      sync.Mutex
+	last    uint64
+	recent  []securityEvent
+	streams map[chan securityEvent]string
+}
+
+func newSecurityFeed() *securityFeed {
+	return &securityFeed{
+		recent:  []securityEvent{},
+		streams: map[chan securityEvent]string{},
+	}
+}
+
+// A bus subscriber that numbers security events and delivers them to the streams of their target user
+// A stream that can't keep up is closed rather than blocking the feed, its client resumes from the buffer with Last-Event-ID
+// Streams of a user that has been disabled or deleted are closed once the event saying so has been delivered
+func (feed *securityFeed) Handler() events.Handler {
+	return func(event events.Event) {
+		if event.TargetType != events.TARGET_USER || !securityEventTypes[event.Type] {
+			return
+		}
+
+		feed.mu.Lock()
+		defer feed.mu.Unlock()
+
+		feed.last++
+		numbered := securityEvent{id: feed.last, event: event}
+
+		feed.recent = append(feed.recent, numbered)
+		if len(feed.recent) > securityEventBuffer {
+			feed.recent = feed.recent[len(feed.recent)-securityEventBuffer:]
+		}
+
+		for stream, user := range feed.streams {
+			if user != event.TargetID {
+				continue
+			}
+
+			select {
+			case stream <- numbered:
+				if endsStream(event) {
+					delete(feed.streams, stream)
+					close(stream)
+				}
+			default:
+				delete(feed.streams, stream)
+				close(stream)
+			}
+		}
+	}
+}
+
+// Whether an event takes away its target user's access, so their streams must not outlive it
+func endsStream(event events.Event) bool {
+	switch event.Type {
+	case events.USER_DELETED:
+		return true
+	case events.USER_UPDATED:
+		switch data := event.Data.(type) {
+		case map[string]bool:
+			return data["disabled"]
+		case *entity.User:
+			return data.Disabled
+		}
+	}
+
+	return false
+}
+
+// Open a stream of a user's security events, along with the buffered ones after lastID
+// An id newer than any the feed has numbered can only come from before a restart, so everything buffered is replayed
+func (feed *securityFeed) subscribe(user string, lastID uint64) ([]securityEvent, chan securityEvent) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	if lastID > feed.last {
+		lastID = 0
+	}
+
+	missed := []securityEvent{}
+	for _, buffered := range feed.recent {
+		if buffered.id > lastID && buffered.event.TargetID == user {
+			missed = append(missed, buffered)
+		}
+	}
+
+	stream := make(chan securityEvent, securityStreamBuffer)
+	feed.streams[stream] = user
+
+	return missed, stream
+}
+
+func (feed *securityFeed) unsubscribe(stream chan securityEvent) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	if _, open := feed.streams[stream]; open {
+		delete(feed.streams, stream)
+		close(stream)
+	}
+}
+
+func handleSecurityEvents(context *gin.Context) {
+	controller.HandleSecurityEvents(context)
+}
+
+// Stream the current user's security events (logins and changes to or removal of their account) as server-sent events
+// The stream ends when the token it was opened with expires or the instance shuts down, clients reconnect with a fresh one and Last-Event-ID
+func (controller *Controller) HandleSecurityEvents(context *gin.Context) {
+	username, usernameErr := auth.CurrentUser(context)
+	if usernameErr != nil {
+		utilities.RESTError(context, http.StatusUnauthorized, "unable to get current user", usernameErr)
+		return
+	}
+
+	user, userErr := controller.store(context).GetUserByUsername(username)
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusUnauthorized, "user not found", userErr)
+		return
+	}
+
+	expiry, expiryErr := auth.CurrentUserExpiry(context)
+	if expiryErr != nil {
+		utilities.RESTError(context, http.StatusUnauthorized, "unable to get token expiry", expiryErr)
+		return
+	}
+
+	var lastID uint64
+	if header := context.GetHeader("Last-Event-ID"); header != "" {
+		parsed, parseErr := strconv.ParseUint(header, 10, 64)
+		if parseErr != nil {
+			utilities.RESTError(context, http.StatusBadRequest, "invalid Last-Event-ID", parseErr)
+			return
+		}
+		lastID = parsed
+	}
+
+	missed, stream := controller.security.subscribe(user.ID, lastID)
+	defer controller.security.unsubscribe(stream)
+
+	context.Header("Content-Type", "text/event-stream")
+	context.Header("Cache-Control", "no-cache")
+	context.Header("X-Accel-Buffering", "no")
+	context.Status(http.StatusOK)
+
+	for _, event := range missed {
+		if writeErr := writeSecurityEvent(context, event); writeErr != nil {
+			return
+		}
+	}
+	context.Writer.Flush()
+
+	keepAlive := time.NewTicker(securityStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	expired := time.NewTimer(time.Until(expiry))
+	defer expired.Stop()
+
+	for {
+		select {
+		case <-context.Request.Context().Done():
+			return
+		case <-expired.C:
+			return
+		case <-controller.stopping:
+			return
+		case <-keepAlive.C:
+			if _, writeErr := fmt.Fprint(context.Writer, ": keep-alive\n\n"); writeErr != nil {
+				return
+			}
+		case event, open := <-stream:
+			if !open {
+				return
+			}
+
+			if writeErr := writeSecurityEvent(context, event); writeErr != nil {
+				return
+			}
+		}
+
+		context.Writer.Flush()
+	}
+}
+
+func writeSecurityEvent(context *gin.Context, event securityEvent) error {
+	data, marshalErr := json.Marshal(event.event)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	_, writeErr := fmt.Fprintf(context.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.event.Type, data)
+	return writeErr
+}
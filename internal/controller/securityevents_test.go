@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+)
+
+// Open a user's security event stream, returning once it is subscribed along with a channel closed when it ends
+func openSecurityStream(t *testing.T, c *Controller, token string) (*httptest.ResponseRecorder, chan struct{}) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	ended := make(chan struct{})
+
+	go func() {
+		c.restEngine.ServeHTTP(recorder, serveRequest(http.MethodGet, "/api/v1/users/me/events", token, nil))
+		close(ended)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.security.mu.Lock()
+		subscribed := len(c.security.streams)
+		c.security.mu.Unlock()
+
+		if subscribed > 0 {
+			return recorder, ended
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the stream to subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitForStreamEnd(t *testing.T, ended chan struct{}, reason string) {
+	t.Helper()
+
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the stream to end %s", reason)
+	}
+}
+
+func TestSecurityStreamsEndWhenTheUserIsDisabled(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	alice := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+
+	recorder, ended := openSecurityStream(t, c, tokenFor(t, alice))
+
+	disabled := serve(c, http.MethodPost, "/api/v1/users/bulk/disabled", admin, entity.BulkDisableBody{IDs: []string{alice.ID}, Disabled: true})
+	if disabled.Code != http.StatusMultiStatus {
+		t.Fatalf("expected alice to be disabled, got %d: %s", disabled.Code, disabled.Body.String())
+	}
+
+	waitForStreamEnd(t, ended, "once the user is disabled")
+
+	if !strings.Contains(recorder.Body.String(), "event: "+events.USER_UPDATED) {
+		t.Fatalf("expected the disabling to be delivered before the stream ended, got %s", recorder.Body.String())
+	}
+}
+
+func TestSecurityStreamsEndWhenTheUserIsDeleted(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	alice := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+
+	recorder, ended := openSecurityStream(t, c, tokenFor(t, alice))
+
+	if deleted := serve(c, http.MethodDelete, "/api/v1/users/"+alice.ID, admin, nil); deleted.Code != http.StatusOK {
+		t.Fatalf("expected alice to be deleted, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+
+	waitForStreamEnd(t, ended, "once the user is deleted")
+
+	if !strings.Contains(recorder.Body.String(), "event: "+events.USER_DELETED) {
+		t.Fatalf("expected the deletion to be delivered before the stream ended, got %s", recorder.Body.String())
+	}
+}
+
+func TestSecurityStreamsKeepRunningForOtherChanges(t *testing.T) {
+	c, store := newTestController(t)
+
+	alice := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	_, ended := openSecurityStream(t, c, tokenFor(t, alice))
+
+	c.security.Handler()(events.Event{Type: events.USER_UPDATED, TargetType: events.TARGET_USER, TargetID: alice.ID, Data: map[string]string{"label": "team"}})
+
+	select {
+	case <-ended:
+		t.Fatal("expected the stream to stay open after a change that leaves the user able to log in")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.security.Handler()(events.Event{Type: events.USER_DELETED, TargetType: events.TARGET_USER, TargetID: alice.ID})
+	waitForStreamEnd(t, ended, "once the user is deleted")
+}
+
+func TestSecurityStreamsRefuseHead(t *testing.T) {
+	c, store := newTestController(t)
+
+	alice := tokenFor(t, addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN))
+
+	recorder := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		headAsGet(c.restEngine).ServeHTTP(recorder, serveRequest(http.MethodHead, "/api/v1/users/me/events", alice, nil))
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("expected HEAD of the stream to be answered straight away")
+	}
+
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != http.MethodGet {
+		t.Fatalf("expected 405 allowing only GET, got %d %v", recorder.Code, recorder.Header())
+	}
+}
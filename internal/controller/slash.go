@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+// Redirect paths with a trailing slash to the same path without one
+// 308 is used for every method so clients repeat the same method and body against the new location
+func redirectTrailingSlash(c *gin.Context) {
+	requested := c.Request.URL.Path
+	if len(requested) > 1 && strings.HasSuffix(requested, "/") {
+		// Cleaning collapses repeated slashes, so the target is always a path on this host and never //host (an open redirect)
+		target := path.Clean("/" + strings.TrimLeft(requested, "/"))
+
+		if underBasePath(target) {
+			if c.Request.URL.RawQuery != "" {
+				target += "?" + c.Request.URL.RawQuery
+			}
+
+			c.Redirect(http.StatusPermanentRedirect, target)
+			return
+		}
+	}
+
+	c.String(http.StatusNotFound, "404 page not found")
+}
+
+func underBasePath(target string) bool {
+	return config.BasePath == "" || target == config.BasePath || strings.HasPrefix(target, config.BasePath+"/")
+}
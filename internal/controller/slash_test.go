@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestRedirectTrailingSlash(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		basePath string
+		path     string
+		status   int
+		location string
+	}{
+		{"trailing slash", "", "/api/v1/users/", http.StatusPermanentRedirect, "/api/v1/users"},
+		{"keeps query", "", "/api/v1/users/?sort=username", http.StatusPermanentRedirect, "/api/v1/users?sort=username"},
+		{"repeated trailing slashes", "", "/api/v1/users///", http.StatusPermanentRedirect, "/api/v1/users"},
+		{"protocol relative host", "", "//evil.com/", http.StatusPermanentRedirect, "/evil.com"},
+		{"many leading slashes", "", "////evil.com/path/", http.StatusPermanentRedirect, "/evil.com/path"},
+		{"under base path", "/vxconnect", "/vxconnect/api/v1/users/", http.StatusPermanentRedirect, "/vxconnect/api/v1/users"},
+		{"outside base path", "/vxconnect", "//evil.com/", http.StatusNotFound, ""},
+		{"no trailing slash", "", "/api/v1/missing", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(previous string) { config.BasePath = previous }(config.BasePath)
+			config.BasePath = test.basePath
+
+			engine := gin.New()
+			engine.RedirectTrailingSlash = false
+			engine.NoRoute(redirectTrailingSlash)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "http://vxconnect.local"+test.path, nil)
+			engine.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d, got %d", test.status, recorder.Code)
+			}
+
+			if location := recorder.Header().Get("Location"); location != test.location {
+				t.Fatalf("expected location %q, got %q", test.location, location)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+const (
+	SORT_USERS   = "users"
+	SORT_ZONES   = "zones"
+	SORT_RECORDS = "records"
+)
+
+// The fields each list endpoint can be sorted by, by their camelCase JSON key, and the column each sorts on
+var sortable = map[string]map[string]string{
+	SORT_USERS:   {"username": "username", "createdAt": "created_at", "updatedAt": "updated_at"},
+	SORT_ZONES:   {"name": "name", "createdAt": "created_at", "updatedAt": "updated_at"},
+	SORT_RECORDS: {"name": "name", "type": "type", "target": "target", "ttl": "ttl", "createdAt": "created_at", "updatedAt": "updated_at"},
+}
+
+// Check every configured default sort is allowed for its resource
+func validateSortDefaults() error {
+	for resource, sort := range config.SortDefaults {
+		if _, ok := sortable[resource]; !ok {
+			return fmt.Errorf("default sort configured for unknown resource %s", resource)
+		}
+
+		if _, err := sortOrder(resource, sort); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get the order for a list from its ?sort= query, falling back to the configured default for the resource
+// Sorts are a field's JSON key, prefixed with - for descending
+func listOrder(context *gin.Context, resource string) (string, error) {
+	sort := context.Query("sort")
+	if sort == "" {
+		sort = config.SortDefaults[resource]
+	}
+
+	return sortOrder(resource, sort)
+}
+
+func sortOrder(resource, sort string) (string, error) {
+	if sort == "" {
+		return "", nil
+	}
+
+	direction := "ASC"
+	field := sort
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+		field = strings.TrimPrefix(sort, "-")
+	}
+
+	if column, ok := sortable[resource][field]; ok {
+		return column + " " + direction, nil
+	}
+
+	// Column names were accepted before sorts used JSON keys, so existing clients and SORT_DEFAULT_* settings keep working
+	for _, column := range sortable[resource] {
+		if column == field {
+			return column + " " + direction, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s can not be sorted by %s", resource, field)
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestSortsUseJSONKeys(t *testing.T) {
+	cases := []struct {
+		resource string
+		sort     string
+		order    string
+	}{
+		{SORT_USERS, "createdAt", "created_at ASC"},
+		{SORT_USERS, "-updatedAt", "updated_at DESC"},
+		{SORT_ZONES, "name", "name ASC"},
+		{SORT_RECORDS, "-ttl", "ttl DESC"},
+		{SORT_RECORDS, "created_at", "created_at ASC"},
+		{SORT_RECORDS, "", ""},
+	}
+
+	for _, tc := range cases {
+		order, orderErr := sortOrder(tc.resource, tc.sort)
+		if orderErr != nil {
+			t.Fatalf("expected %s to be sortable by %q, got %s", tc.resource, tc.sort, orderErr)
+		}
+
+		if order != tc.order {
+			t.Fatalf("expected sorting %s by %q to order by %q, got %q", tc.resource, tc.sort, tc.order, order)
+		}
+	}
+
+	for _, sort := range []string{"passwordHash", "password_hash", "ttl; DROP TABLE users", "target"} {
+		if _, orderErr := sortOrder(SORT_USERS, sort); orderErr == nil {
+			t.Fatalf("expected users not to be sortable by %q", sort)
+		}
+	}
+}
+
+func TestDefaultSortsOrderUnsortedLists(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	for _, name := range []string{"b.example", "c.example", "a.example"} {
+		addZone(t, store, name, false)
+	}
+
+	names := func(path string) []string {
+		listed := serve(c, http.MethodGet, path, admin, nil)
+		if listed.Code != http.StatusOK {
+			t.Fatalf("expected the zones to be listed, got %d: %s", listed.Code, listed.Body.String())
+		}
+
+		zones := []entity.Zone{}
+		decodeResults(t, listed, &zones)
+
+		names := []string{}
+		for _, zone := range zones {
+			names = append(names, zone.Name)
+		}
+		return names
+	}
+
+	ascending := []string{"a.example", "b.example", "c.example"}
+	descending := []string{"c.example", "b.example", "a.example"}
+
+	if listed := names("/api/v1/zones"); !reflect.DeepEqual(listed, ascending) {
+		t.Fatalf("expected zones in name order without a default, got %v", listed)
+	}
+
+	setConfig(t, &config.SortDefaults, map[string]string{SORT_ZONES: "-name"})
+
+	if listed := names("/api/v1/zones"); !reflect.DeepEqual(listed, descending) {
+		t.Fatalf("expected the default sort to order the list, got %v", listed)
+	}
+
+	if listed := names("/api/v1/zones?sort=name"); !reflect.DeepEqual(listed, ascending) {
+		t.Fatalf("expected a requested sort to override the default, got %v", listed)
+	}
+}
+
+func TestDefaultSortsAreValidated(t *testing.T) {
+	setConfig(t, &config.SortDefaults, map[string]string{SORT_USERS: "-createdAt", SORT_RECORDS: "ttl"})
+	if validateErr := validateSortDefaults(); validateErr != nil {
+		t.Fatalf("expected sortable defaults to be accepted, got %s", validateErr)
+	}
+
+	for _, defaults := range []map[string]string{{SORT_USERS: "passwordHash"}, {"tenants": "name"}} {
+		setConfig(t, &config.SortDefaults, defaults)
+		if validateErr := validateSortDefaults(); validateErr == nil {
+			t.Fatalf("expected default sorts %v to be refused", defaults)
+		}
+	}
+}
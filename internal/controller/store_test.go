@@ -0,0 +1,1036 @@
+package controller
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"gorm.io/gorm"
+	"gorm.io/plugin/soft_delete"
+)
+
+// The rows shared by every tenant's view of a memoryStore
+type memoryData struct {
+	mu          sync.Mutex
+	users       map[string]*entity.User
+	zones       map[string]*entity.Zone
+	records     map[string]*entity.Record
+	breakGlass  map[string]*entity.BreakGlassUse
+	actions     map[string]*entity.PendingAction
+	magicLinks  map[string]*entity.MagicLink
+	attempts    []*entity.LoginAttempt
+	audit       []*entity.AuditEntry
+	tenants     map[string]*entity.Tenant
+	locks       map[string]chan struct{}
+	lockConns   chan struct{}
+	failures    map[string]error
+	calls       map[string]int
+	delays      map[string]time.Duration
+	breakerOpen bool
+}
+
+// An in-memory persistence.Store for tests, scoped to tenants the same way as the MariaDB store
+type memoryStore struct {
+	data   *memoryData
+	tenant *string
+}
+
+var _ persistence.Store = &memoryStore{}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: &memoryData{
+		users:      map[string]*entity.User{},
+		zones:      map[string]*entity.Zone{},
+		records:    map[string]*entity.Record{},
+		breakGlass: map[string]*entity.BreakGlassUse{},
+		actions:    map[string]*entity.PendingAction{},
+		magicLinks: map[string]*entity.MagicLink{},
+		tenants:    map[string]*entity.Tenant{},
+		locks:      map[string]chan struct{}{},
+		lockConns:  make(chan struct{}, persistence.ResourceLockConnections),
+		failures:   map[string]error{},
+		calls:      map[string]int{},
+		delays:     map[string]time.Duration{},
+	}}
+}
+
+// Make every call to the named method fail with err, a nil err makes it work again
+func (s *memoryStore) fail(method string, err error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err == nil {
+		delete(s.data.failures, method)
+		return
+	}
+
+	s.data.failures[method] = err
+}
+
+// Make every call to the named method take at least delay, so concurrent requests overlap
+func (s *memoryStore) slow(method string, delay time.Duration) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	s.data.delays[method] = delay
+}
+
+// Wait out the injected delay of a method, the caller must not hold mu
+func (s *memoryStore) delay(method string) {
+	s.data.mu.Lock()
+	delay := s.data.delays[method]
+	s.data.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// The injected failure of a method, which also counts the call, the caller must hold mu
+func (s *memoryStore) failure(method string) error {
+	s.data.calls[method]++
+	return s.data.failures[method]
+}
+
+// How many times a method that can be made to fail has been called
+func (s *memoryStore) called(method string) int {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	return s.data.calls[method]
+}
+
+func (s *memoryStore) visible(tenant string) bool {
+	return s.tenant == nil || *s.tenant == tenant
+}
+
+func (s *memoryStore) creatingTenant() string {
+	if s.tenant == nil {
+		return ""
+	}
+
+	return *s.tenant
+}
+
+// Records belong to the tenant of their zone, even once it is soft deleted
+func (s *memoryStore) recordVisible(record *entity.Record) bool {
+	zone, ok := s.data.zones[record.ZoneID]
+	return ok && s.visible(zone.TenantID)
+}
+
+func copyUser(user *entity.User) *entity.User {
+	copied := *user
+	copied.Roles = append([]string(nil), user.Roles...)
+	copied.Zones = append([]string(nil), user.Zones...)
+	if user.Labels != nil {
+		copied.Labels = make(map[string]string, len(user.Labels))
+		for key, value := range user.Labels {
+			copied.Labels[key] = value
+		}
+	}
+
+	return &copied
+}
+
+func (s *memoryStore) Migrate() error { return nil }
+
+func (s *memoryStore) Ping() error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	return s.failure("Ping")
+}
+
+func (s *memoryStore) BreakerState() string {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if s.data.breakerOpen {
+		return persistence.BREAKER_OPEN
+	}
+
+	return persistence.BREAKER_CLOSED
+}
+
+// Like the MariaDB store every call holds one connection of a limited pool until its locks are released
+func (s *memoryStore) LockResources(names []string, timeout int) (func(), error) {
+	expired := time.After(time.Duration(timeout) * time.Second)
+
+	select {
+	case s.data.lockConns <- struct{}{}:
+	case <-expired:
+		return nil, persistence.ErrLockTimeout
+	}
+
+	held := []chan struct{}{}
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			<-held[i]
+		}
+		<-s.data.lockConns
+	}
+
+	for _, name := range names {
+		s.data.mu.Lock()
+		lock, ok := s.data.locks[name]
+		if !ok {
+			lock = make(chan struct{}, 1)
+			s.data.locks[name] = lock
+		}
+		s.data.mu.Unlock()
+
+		select {
+		case lock <- struct{}{}:
+			held = append(held, lock)
+		case <-expired:
+			release()
+			return nil, persistence.ErrLockTimeout
+		}
+	}
+
+	return release, nil
+}
+
+func (s *memoryStore) ForTenant(id string) persistence.Store {
+	return &memoryStore{data: s.data, tenant: &id}
+}
+
+// Changes made by fn are undone if it returns an error, there is no isolation from other callers in the meantime
+func (s *memoryStore) Transaction(fn func(store persistence.Store) error) error {
+	s.data.mu.Lock()
+	saved := s.data.snapshot()
+	s.data.mu.Unlock()
+
+	if err := fn(s); err != nil {
+		s.data.mu.Lock()
+		s.data.restore(saved)
+		s.data.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// A copy of every row, the caller must hold mu
+func (d *memoryData) snapshot() *memoryData {
+	saved := &memoryData{
+		users:      make(map[string]*entity.User, len(d.users)),
+		zones:      copyRows(d.zones),
+		records:    copyRows(d.records),
+		breakGlass: copyRows(d.breakGlass),
+		actions:    copyRows(d.actions),
+		magicLinks: copyRows(d.magicLinks),
+		tenants:    copyRows(d.tenants),
+		attempts:   append([]*entity.LoginAttempt(nil), d.attempts...),
+		audit:      append([]*entity.AuditEntry(nil), d.audit...),
+	}
+
+	for id, user := range d.users {
+		saved.users[id] = copyUser(user)
+	}
+
+	return saved
+}
+
+// Put back the rows of a snapshot, the caller must hold mu
+func (d *memoryData) restore(saved *memoryData) {
+	d.users = saved.users
+	d.zones = saved.zones
+	d.records = saved.records
+	d.breakGlass = saved.breakGlass
+	d.actions = saved.actions
+	d.magicLinks = saved.magicLinks
+	d.tenants = saved.tenants
+	d.attempts = saved.attempts
+	d.audit = saved.audit
+}
+
+func copyRows[T any](rows map[string]*T) map[string]*T {
+	copied := make(map[string]*T, len(rows))
+	for id, row := range rows {
+		row := *row
+		copied[id] = &row
+	}
+
+	return copied
+}
+
+// Lists are always ordered by name, only the direction of a sort is honoured
+func descending(order string) bool {
+	return strings.HasSuffix(order, " DESC")
+}
+
+func (s *memoryStore) GetUsers(order string) ([]*entity.User, error) {
+	defer s.delay("GetUsers")
+
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("GetUsers"); err != nil {
+		return nil, err
+	}
+
+	users := []*entity.User{}
+	for _, user := range s.data.users {
+		if user.DeletedAt == 0 && s.visible(user.TenantID) {
+			users = append(users, copyUser(user))
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return (users[i].Username < users[j].Username) != descending(order) })
+
+	return users, nil
+}
+
+func (s *memoryStore) GetUserById(id string) (*entity.User, error) {
+	defer s.delay("GetUserById")
+
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("GetUserById"); err != nil {
+		return nil, err
+	}
+
+	user, ok := s.data.users[id]
+	if !ok || user.DeletedAt != 0 || !s.visible(user.TenantID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (s *memoryStore) GetDeletedUserById(id string) (*entity.User, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	user, ok := s.data.users[id]
+	if !ok || user.DeletedAt == 0 || !s.visible(user.TenantID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (s *memoryStore) GetUserByUsername(username string) (*entity.User, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("GetUserByUsername"); err != nil {
+		return nil, err
+	}
+
+	for _, user := range s.data.users {
+		if user.Username == username && user.DeletedAt == 0 && s.visible(user.TenantID) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) GetUserByEmail(email string) (*entity.User, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for _, user := range s.data.users {
+		if user.Email != nil && *user.Email == email && user.DeletedAt == 0 && s.visible(user.TenantID) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// Usernames and emails are unique across deleted users too, as they are in the database
+func (s *memoryStore) userConflicts(user *entity.User) bool {
+	for _, existing := range s.data.users {
+		if existing.ID == user.ID {
+			continue
+		}
+
+		if existing.Username == user.Username {
+			return true
+		}
+
+		if existing.Email != nil && user.Email != nil && *existing.Email == *user.Email {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *memoryStore) CreateUser(user *entity.User) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("CreateUser"); err != nil {
+		return err
+	}
+
+	if _, exists := s.data.users[user.ID]; exists || s.userConflicts(user) {
+		return gorm.ErrDuplicatedKey
+	}
+
+	user.TenantID = s.creatingTenant()
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	s.data.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (s *memoryStore) SaveUser(user *entity.User) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("SaveUser"); err != nil {
+		return err
+	}
+
+	existing, ok := s.data.users[user.ID]
+	if s.tenant != nil && (!ok || !s.visible(existing.TenantID)) {
+		return gorm.ErrRecordNotFound
+	}
+
+	if s.userConflicts(user) {
+		return gorm.ErrDuplicatedKey
+	}
+
+	saved := copyUser(user)
+	if ok {
+		saved.TenantID = existing.TenantID
+		saved.Username = existing.Username
+		saved.CreatedAt = existing.CreatedAt
+	}
+	// gorm sets the saved timestamp on the caller's user too
+	saved.UpdatedAt = time.Now()
+	user.UpdatedAt = saved.UpdatedAt
+	s.data.users[user.ID] = saved
+
+	return nil
+}
+
+func (s *memoryStore) DeleteUser(id string) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("DeleteUser"); err != nil {
+		return err
+	}
+
+	if user, ok := s.data.users[id]; ok && user.DeletedAt == 0 && s.visible(user.TenantID) {
+		user.DeletedAt = soft_delete.DeletedAt(time.Now().Unix())
+	}
+
+	return nil
+}
+
+func (s *memoryStore) SetUsersDisabled(ids []string, disabled bool) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("SetUsersDisabled"); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if user, ok := s.data.users[id]; ok && user.DeletedAt == 0 && s.visible(user.TenantID) {
+			user.Disabled = disabled
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) SetUsersZone(ids []string, match func(user *entity.User) bool, zone string, assigned bool) (int, int, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("SetUsersZone"); err != nil {
+		return 0, 0, err
+	}
+
+	matched := 0
+	changed := 0
+	for _, id := range ids {
+		user, ok := s.data.users[id]
+		if !ok || user.DeletedAt != 0 || !s.visible(user.TenantID) || !match(copyUser(user)) {
+			continue
+		}
+		matched++
+
+		zones := []string{}
+		has := false
+		for _, existing := range user.Zones {
+			if existing == zone {
+				has = true
+				if !assigned {
+					continue
+				}
+			}
+			zones = append(zones, existing)
+		}
+
+		if has == assigned {
+			continue
+		}
+
+		if assigned {
+			zones = append(zones, zone)
+		}
+
+		user.Zones = zones
+		changed++
+	}
+
+	return matched, changed, nil
+}
+
+func (s *memoryStore) GetZones(order string) ([]*entity.Zone, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	zones := []*entity.Zone{}
+	for _, zone := range s.data.zones {
+		if zone.DeletedAt == 0 && s.visible(zone.TenantID) {
+			copied := *zone
+			zones = append(zones, &copied)
+		}
+	}
+
+	sort.Slice(zones, func(i, j int) bool { return (zones[i].Name < zones[j].Name) != descending(order) })
+
+	return zones, nil
+}
+
+func (s *memoryStore) GetZoneByID(id string) (*entity.Zone, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("GetZoneByID"); err != nil {
+		return nil, err
+	}
+
+	zone, ok := s.data.zones[id]
+	if !ok || zone.DeletedAt != 0 || !s.visible(zone.TenantID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	copied := *zone
+	return &copied, nil
+}
+
+func (s *memoryStore) GetDeletedZoneByID(id string) (*entity.Zone, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	zone, ok := s.data.zones[id]
+	if !ok || zone.DeletedAt == 0 || !s.visible(zone.TenantID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	copied := *zone
+	return &copied, nil
+}
+
+func (s *memoryStore) CreateZone(zone *entity.Zone) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	tenant := s.creatingTenant()
+	for _, existing := range s.data.zones {
+		if existing.ID == zone.ID || (existing.TenantID == tenant && existing.Name == zone.Name) {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+
+	zone.TenantID = tenant
+	zone.CreatedAt = time.Now()
+	copied := *zone
+	s.data.zones[zone.ID] = &copied
+
+	return nil
+}
+
+func (s *memoryStore) SaveZone(zone *entity.Zone) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	existing, ok := s.data.zones[zone.ID]
+	if s.tenant != nil && (!ok || !s.visible(existing.TenantID)) {
+		return gorm.ErrRecordNotFound
+	}
+
+	copied := *zone
+	if ok {
+		copied.TenantID = existing.TenantID
+		copied.Name = existing.Name
+	}
+	s.data.zones[zone.ID] = &copied
+
+	return nil
+}
+
+func (s *memoryStore) DeleteZone(id string) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if zone, ok := s.data.zones[id]; ok && zone.DeletedAt == 0 && s.visible(zone.TenantID) {
+		zone.DeletedAt = soft_delete.DeletedAt(time.Now().Unix())
+	}
+
+	return nil
+}
+
+func (s *memoryStore) RestoreZone(id string) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	zone, ok := s.data.zones[id]
+	if !ok || zone.DeletedAt == 0 || !s.visible(zone.TenantID) {
+		return gorm.ErrRecordNotFound
+	}
+
+	zone.DeletedAt = 0
+	return nil
+}
+
+func (s *memoryStore) ZonesExist(names []string) (map[string]bool, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	stored := map[string]bool{}
+	for _, zone := range s.data.zones {
+		if zone.DeletedAt == 0 && s.visible(zone.TenantID) {
+			stored[strings.ToLower(zone.Name)] = true
+		}
+	}
+
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = stored[strings.ToLower(name)]
+	}
+
+	return exists, nil
+}
+
+func (s *memoryStore) GetZoneRecords(zone, order string) ([]*entity.Record, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	records := []*entity.Record{}
+	for _, record := range s.data.records {
+		if record.ZoneID == zone && s.recordVisible(record) {
+			copied := *record
+			records = append(records, &copied)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool { return (records[i].Name < records[j].Name) != descending(order) })
+
+	return records, nil
+}
+
+func (s *memoryStore) GetRecordByID(id string) (*entity.Record, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	record, ok := s.data.records[id]
+	if !ok || !s.recordVisible(record) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	copied := *record
+	return &copied, nil
+}
+
+func (s *memoryStore) GetRecordbyName(name string) (*entity.Record, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for _, record := range s.data.records {
+		if zone, ok := s.data.zones[record.ZoneID]; ok && zone.DeletedAt == 0 && record.Name == name {
+			copied := *record
+			return &copied, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CreateRecord(record *entity.Record) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if zone, ok := s.data.zones[record.ZoneID]; !ok || !s.visible(zone.TenantID) {
+		return gorm.ErrRecordNotFound
+	}
+
+	for _, existing := range s.data.records {
+		if existing.ID == record.ID || existing.Name == record.Name {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+
+	copied := *record
+	s.data.records[record.ID] = &copied
+
+	return nil
+}
+
+func (s *memoryStore) SaveRecord(record *entity.Record) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	existing, ok := s.data.records[record.ID]
+	if !ok || !s.recordVisible(existing) {
+		return gorm.ErrRecordNotFound
+	}
+
+	copied := *record
+	copied.ZoneID = existing.ZoneID
+	copied.Name = existing.Name
+	s.data.records[record.ID] = &copied
+
+	return nil
+}
+
+func (s *memoryStore) DeleteRecord(id string) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if record, ok := s.data.records[id]; ok && s.recordVisible(record) {
+		delete(s.data.records, id)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) BreakGlassUsed(credentialHash string) (bool, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	_, used := s.data.breakGlass[credentialHash]
+	return used, nil
+}
+
+func (s *memoryStore) CreateBreakGlassAdmin(use *entity.BreakGlassUse, user *entity.User) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if _, used := s.data.breakGlass[use.CredentialHash]; used {
+		return persistence.ErrBreakGlassSpent
+	}
+
+	if err := s.failure("CreateUser"); err != nil {
+		return err
+	}
+
+	if _, exists := s.data.users[user.ID]; exists || s.userConflicts(user) {
+		return gorm.ErrDuplicatedKey
+	}
+
+	copied := *use
+	copied.CreatedAt = time.Now()
+	s.data.breakGlass[use.CredentialHash] = &copied
+
+	user.TenantID = s.creatingTenant()
+	user.CreatedAt = copied.CreatedAt
+	user.UpdatedAt = user.CreatedAt
+	s.data.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (s *memoryStore) CreatePendingAction(action *entity.PendingAction) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	action.TenantID = s.creatingTenant()
+	action.CreatedAt = time.Now()
+	copied := *action
+	s.data.actions[action.ID] = &copied
+
+	return nil
+}
+
+func (s *memoryStore) GetPendingActions(status string) ([]*entity.PendingAction, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	actions := []*entity.PendingAction{}
+	for _, action := range s.data.actions {
+		if s.visible(action.TenantID) && (status == "" || action.Status == status) {
+			copied := *action
+			actions = append(actions, &copied)
+		}
+	}
+
+	sort.Slice(actions, func(i, j int) bool { return actions[i].CreatedAt.After(actions[j].CreatedAt) })
+
+	return actions, nil
+}
+
+func (s *memoryStore) GetPendingActionByID(id string) (*entity.PendingAction, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	action, ok := s.data.actions[id]
+	if !ok || !s.visible(action.TenantID) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	copied := *action
+	return &copied, nil
+}
+
+func (s *memoryStore) DecidePendingAction(id, status, decidedBy string) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	action, ok := s.data.actions[id]
+	if !ok || !s.visible(action.TenantID) || action.Status != entity.APPROVAL_PENDING {
+		return gorm.ErrRecordNotFound
+	}
+
+	now := time.Now()
+	action.Status = status
+	action.DecidedBy = decidedBy
+	action.DecidedAt = &now
+
+	return nil
+}
+
+func (s *memoryStore) SavePendingAction(action *entity.PendingAction) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	existing, ok := s.data.actions[action.ID]
+	if !ok || !s.visible(existing.TenantID) {
+		return gorm.ErrRecordNotFound
+	}
+
+	copied := *action
+	s.data.actions[action.ID] = &copied
+
+	return nil
+}
+
+func (s *memoryStore) CreateMagicLink(link *entity.MagicLink) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("CreateMagicLink"); err != nil {
+		return err
+	}
+
+	for id, existing := range s.data.magicLinks {
+		if existing.ExpiresAt.Before(time.Now()) {
+			delete(s.data.magicLinks, id)
+		}
+	}
+
+	link.CreatedAt = time.Now()
+	copied := *link
+	s.data.magicLinks[link.ID] = &copied
+
+	return nil
+}
+
+func (s *memoryStore) UseMagicLink(tokenHash string) (*entity.MagicLink, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	now := time.Now()
+	for _, link := range s.data.magicLinks {
+		if link.TokenHash != tokenHash || link.UsedAt != nil || !link.ExpiresAt.After(now) {
+			continue
+		}
+
+		for _, other := range s.data.magicLinks {
+			if other.UserID == link.UserID && other.UsedAt == nil {
+				other.UsedAt = &now
+			}
+		}
+
+		copied := *link
+		return &copied, nil
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// The magic links stored for a user, used or not
+func (s *memoryStore) magicLinks(userID string) []*entity.MagicLink {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	links := []*entity.MagicLink{}
+	for _, link := range s.data.magicLinks {
+		if link.UserID == userID {
+			copied := *link
+			links = append(links, &copied)
+		}
+	}
+
+	return links
+}
+
+func (s *memoryStore) CreateLoginAttempt(attempt *entity.LoginAttempt, keep int) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("CreateLoginAttempt"); err != nil {
+		return err
+	}
+
+	attempt.CreatedAt = time.Now()
+	copied := *attempt
+	s.data.attempts = append(s.data.attempts, &copied)
+
+	// Keep only the newest attempts of the user, like the MariaDB store's prune after each insert
+	kept := 0
+	for i := len(s.data.attempts) - 1; i >= 0; i-- {
+		if s.data.attempts[i].UserID != attempt.UserID {
+			continue
+		}
+
+		kept++
+		if kept > keep {
+			s.data.attempts = append(s.data.attempts[:i], s.data.attempts[i+1:]...)
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetLoginAttempts(userID string) ([]*entity.LoginAttempt, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	attempts := []*entity.LoginAttempt{}
+	for i := len(s.data.attempts) - 1; i >= 0; i-- {
+		if attempt := s.data.attempts[i]; attempt.UserID == userID {
+			copied := *attempt
+			attempts = append(attempts, &copied)
+		}
+	}
+
+	return attempts, nil
+}
+
+func (s *memoryStore) CreateAuditEntry(entry *entity.AuditEntry) error {
+	defer s.delay("CreateAuditEntry")
+
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	if err := s.failure("CreateAuditEntry"); err != nil {
+		return err
+	}
+
+	copied := *entry
+	s.data.audit = append(s.data.audit, &copied)
+
+	return nil
+}
+
+func (s *memoryStore) GetAuditEntries(targetType, targetID string, offset, limit int) ([]*entity.AuditEntry, int64, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	matching := []*entity.AuditEntry{}
+	for i := len(s.data.audit) - 1; i >= 0; i-- {
+		entry := s.data.audit[i]
+		if !s.visible(entry.TenantID) || (targetType != "" && entry.TargetType != targetType) || (targetID != "" && entry.TargetID != targetID) {
+			continue
+		}
+
+		copied := *entry
+		matching = append(matching, &copied)
+	}
+
+	total := int64(len(matching))
+	if offset > len(matching) {
+		offset = len(matching)
+	}
+	matching = matching[offset:]
+	if limit < len(matching) {
+		matching = matching[:limit]
+	}
+
+	return matching, total, nil
+}
+
+func (s *memoryStore) GetTenants() ([]*entity.Tenant, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	tenants := []*entity.Tenant{}
+	for _, tenant := range s.data.tenants {
+		copied := *tenant
+		tenants = append(tenants, &copied)
+	}
+
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+
+	return tenants, nil
+}
+
+func (s *memoryStore) GetTenantByID(id string) (*entity.Tenant, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	tenant, ok := s.data.tenants[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	copied := *tenant
+	return &copied, nil
+}
+
+func (s *memoryStore) GetTenantByName(name string) (*entity.Tenant, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for _, tenant := range s.data.tenants {
+		if strings.EqualFold(tenant.Name, name) {
+			copied := *tenant
+			return &copied, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *memoryStore) CreateTenant(tenant *entity.Tenant, admin *entity.User) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	for _, existing := range s.data.tenants {
+		if existing.ID == tenant.ID || existing.Name == tenant.Name {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+
+	if s.userConflicts(admin) {
+		return gorm.ErrDuplicatedKey
+	}
+
+	tenant.CreatedAt = time.Now()
+	copied := *tenant
+	s.data.tenants[tenant.ID] = &copied
+
+	admin.TenantID = tenant.ID
+	s.data.users[admin.ID] = copyUser(admin)
+
+	return nil
+}
+
+var errStoreDown = errors.New("store is down")
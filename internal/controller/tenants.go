@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/persistence"
+	"github.com/monoxane/vxconnect/internal/utilities"
+	"gorm.io/gorm"
+)
+
+// Where tenantScope leaves the ID of the caller's tenant
+const contextTenant string = "tenant"
+
+// Tenant names are used as subdomains so must be a single DNS label
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Where auditGuard leaves the transaction a change is made in when it is audited strictly
+const contextTransaction string = "transaction"
+
+// The store the request should use, limited to the caller's tenant when MULTI_TENANT is enabled
+func (controller *Controller) store(context *gin.Context) persistence.Store {
+	if !config.MultiTenant {
+		return controller.unscoped(context)
+	}
+
+	return controller.unscoped(context).ForTenant(context.GetString(contextTenant))
+}
+
+// The store the request should use for data outside any one tenant, which is the transaction of the change when auditGuard has started one
+func (controller *Controller) unscoped(context *gin.Context) persistence.Store {
+	if transaction, ok := context.Get(contextTransaction); ok {
+		return transaction.(persistence.Store)
+	}
+
+	return controller.persistence
+}
+
+// Resolve the caller's tenant from their token for store to scope everything the request touches to
+// A tenant named in the TENANT_HEADER header or a subdomain of TENANT_DOMAIN only has to match the token, it never overrides it
+func tenantScope(c *gin.Context) {
+	if !config.MultiTenant {
+		c.Next()
+		return
+	}
+
+	tenant, tenantErr := auth.CurrentUserTenant(c)
+	if tenantErr != nil {
+		utilities.RESTError(c, http.StatusUnauthorized, "unable to get current tenant", tenantErr)
+		c.Abort()
+		return
+	}
+
+	if requested := requestedTenant(c); requested != "" {
+		resolved, resolveErr := controller.persistence.GetTenantByName(requested)
+		if resolveErr != nil && !errors.Is(resolveErr, gorm.ErrRecordNotFound) {
+			utilities.RESTError(c, http.StatusInternalServerError, "unable to resolve tenant", resolveErr)
+			c.Abort()
+			return
+		}
+
+		if resolveErr != nil || resolved.ID != tenant {
+			auth.LogDenial(c, "use tenant "+requested, "token belongs to a different tenant")
+			utilities.RESTError(c, http.StatusForbidden, "token is not valid for this tenant", nil)
+			c.Abort()
+			return
+		}
+	}
+
+	c.Set(contextTenant, tenant)
+	c.Next()
+}
+
+func requestedTenant(c *gin.Context) string {
+	if header := strings.ToLower(strings.TrimSpace(c.GetHeader(config.TenantHeader))); header != "" {
+		return header
+	}
+
+	if config.TenantDomain == "" {
+		return ""
+	}
+
+	host := c.Request.Host
+	if hostname, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		host = hostname
+	}
+
+	if subdomain := strings.TrimSuffix(strings.ToLower(host), "."+config.TenantDomain); subdomain != strings.ToLower(host) {
+		return subdomain
+	}
+
+	return ""
+}
+
+// Tenants are managed by the provider, to every tenant it's as if they don't exist
+func providerOnly(c *gin.Context) {
+	if !config.MultiTenant {
+		utilities.RESTError(c, http.StatusNotFound, "multi-tenancy is not enabled", nil)
+		c.Abort()
+		return
+	}
+
+	if c.GetString(contextTenant) != "" {
+		auth.LogDenial(c, "manage tenants", "user does not belong to the provider")
+		utilities.RESTError(c, http.StatusNotFound, "not found", nil)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+// The instance is run by the provider, so only its users may manage it, which is every user when MULTI_TENANT is disabled
+func providerUsers(c *gin.Context) {
+	if c.GetString(contextTenant) != "" {
+		auth.LogDenial(c, "manage the instance", "user does not belong to the provider")
+		utilities.RESTError(c, http.StatusNotFound, "not found", nil)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
+func handleTenants(context *gin.Context) {
+	controller.HandleTenants(context)
+}
+
+func (controller *Controller) HandleTenants(context *gin.Context) {
+	tenants, tenantsErr := controller.persistence.GetTenants()
+	if tenantsErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get tenants", tenantsErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, entity.RESTResult{
+		Results:      tenants,
+		TotalResults: len(tenants),
+	})
+}
+
+func handleTenant(context *gin.Context) {
+	controller.HandleTenant(context)
+}
+
+func (controller *Controller) HandleTenant(context *gin.Context) {
+	tenant, tenantErr := controller.persistence.GetTenantByID(context.Param("id"))
+	if errors.Is(tenantErr, gorm.ErrRecordNotFound) {
+		utilities.RESTError(context, http.StatusNotFound, "tenant not found", nil)
+		return
+	}
+
+	if tenantErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get tenant", tenantErr)
+		return
+	}
+
+	utilities.RESTResource(context, http.StatusOK, tenant)
+}
+
+func handleNewTenant(context *gin.Context) {
+	controller.HandleNewTenant(context)
+}
+
+// Create a tenant and its first admin, who manages the rest of the tenant from then on
+func (controller *Controller) HandleNewTenant(context *gin.Context) {
+	payload := &entity.NewTenantBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil || payload.AdminUsername == "" {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+		return
+	}
+
+	if !tenantNamePattern.MatchString(payload.Name) {
+		utilities.RESTError(context, http.StatusBadRequest, "tenant name must be a lower case DNS label", nil)
+		return
+	}
+
+	usernameErr := validateUsername(payload.AdminUsername)
+	if usernameErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid admin username", usernameErr)
+		return
+	}
+
+	strengthErr := auth.ValidatePasswordStrength(payload.AdminPassword, payload.AdminUsername)
+	if strengthErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "password does not meet the password policy", strengthErr)
+		return
+	}
+
+	passwordHash, hashErr := auth.HashPassword(payload.AdminPassword)
+	if hashErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to hash password", hashErr)
+		return
+	}
+
+	tenant := &entity.Tenant{
+		ID:   uuid.NewString(),
+		Name: payload.Name,
+	}
+
+	admin := &entity.User{
+		ID:           uuid.NewString(),
+		Username:     payload.AdminUsername,
+		PasswordHash: passwordHash,
+		Roles:        []string{auth.ROLE_ADMIN},
+		Zones:        []string{},
+	}
+
+	storeErr := controller.unscoped(context).CreateTenant(tenant, admin)
+	if errors.Is(storeErr, gorm.ErrDuplicatedKey) {
+		utilities.RESTError(context, http.StatusConflict, "tenant name or admin username in use", storeErr)
+		return
+	}
+
+	if storeErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to store tenant", storeErr)
+		return
+	}
+
+	controller.publish(context, events.TENANT_CREATED, events.TARGET_TENANT, tenant.ID, map[string]string{"name": tenant.Name, "admin": admin.Username})
+
+	context.Header("Location", utilities.URL("/api/v1/tenants/"+tenant.ID))
+	utilities.RESTResource(context, http.StatusCreated, entity.NewTenantResponse{Tenant: tenant, Admin: admin})
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+// Create a tenant through the provider's API and log in as its first admin
+func newTenant(t *testing.T, c *Controller, provider, name string) (*entity.NewTenantResponse, string) {
+	t.Helper()
+
+	password := "Correct-Horse-Battery-Staple-42"
+	created := serve(c, http.MethodPost, "/api/v1/tenants/new", provider, entity.NewTenantBody{Name: name, AdminUsername: name + "-admin", AdminPassword: password})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected the tenant to be created, got %d: %s", created.Code, created.Body.String())
+	}
+
+	tenant := &entity.NewTenantResponse{}
+	decodeResource(t, created, tenant)
+
+	loggedIn := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: name + "-admin", Password: password})
+	if loggedIn.Code != http.StatusOK {
+		t.Fatalf("expected the tenant admin to log in, got %d: %s", loggedIn.Code, loggedIn.Body.String())
+	}
+
+	login := &entity.LoginResponse{}
+	if decodeErr := json.Unmarshal(loggedIn.Body.Bytes(), login); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	return tenant, login.Token
+}
+
+func TestTenantsCantReachEachOthersData(t *testing.T) {
+	setConfig(t, &config.MultiTenant, true)
+
+	c, store := newTestController(t)
+
+	provider := tokenFor(t, addUser(t, store, "provider", auth.ROLE_ADMIN))
+	operator := addUser(t, store, "operator")
+	zone := addZone(t, store, "provider.example", false)
+	record := addRecord(t, store, zone, "www.provider.example")
+
+	acme, acmeAdmin := newTenant(t, c, provider, "acme")
+	_, globexAdmin := newTenant(t, c, provider, "globex")
+
+	created := serve(c, http.MethodPost, "/api/v1/zones/new", acmeAdmin, entity.Zone{Name: "acme.example"})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected the tenant admin to create a zone, got %d: %s", created.Code, created.Body.String())
+	}
+
+	acmeZone := &entity.Zone{}
+	decodeResource(t, created, acmeZone)
+
+	listed := func(token, path string) []string {
+		recorder := serve(c, http.MethodGet, path, token, nil)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected %s to be listed, got %d: %s", path, recorder.Code, recorder.Body.String())
+		}
+
+		items := []struct {
+			ID string `json:"id"`
+		}{}
+		decodeResults(t, recorder, &items)
+
+		ids := []string{}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	if zones := listed(acmeAdmin, "/api/v1/zones"); len(zones) != 1 || zones[0] != acmeZone.ID {
+		t.Fatalf("expected a tenant to only list its own zones, got %v", zones)
+	}
+
+	if zones := listed(globexAdmin, "/api/v1/zones"); len(zones) != 0 {
+		t.Fatalf("expected another tenant's zones to be hidden, got %v", zones)
+	}
+
+	if zones := listed(provider, "/api/v1/zones"); len(zones) != 1 || zones[0] != zone.ID {
+		t.Fatalf("expected the provider to only list its own zones, got %v", zones)
+	}
+
+	if users := listed(acmeAdmin, "/api/v1/users"); len(users) != 1 || users[0] != acme.Admin.ID {
+		t.Fatalf("expected a tenant to only list its own users, got %v", users)
+	}
+
+	// Another tenant's resources must look exactly like ones that never existed
+	requests := []struct {
+		token   string
+		method  string
+		path    string
+		missing string
+		body    interface{}
+	}{
+		{acmeAdmin, http.MethodGet, "/api/v1/zones/" + zone.ID, "/api/v1/zones/missing", nil},
+		{acmeAdmin, http.MethodPut, "/api/v1/zones/" + zone.ID + "/read-only", "/api/v1/zones/missing/read-only", map[string]bool{"readOnly": true}},
+		{acmeAdmin, http.MethodDelete, "/api/v1/zones/" + zone.ID, "/api/v1/zones/missing", nil},
+		{acmeAdmin, http.MethodGet, "/api/v1/zones/" + zone.ID + "/records", "/api/v1/zones/missing/records", nil},
+		{acmeAdmin, http.MethodPatch, "/api/v1/zones/" + zone.ID + "/records/" + record.ID, "/api/v1/zones/missing/records/missing", entity.Record{Target: "192.0.2.99", TTL: 60}},
+		{acmeAdmin, http.MethodDelete, "/api/v1/zones/" + zone.ID + "/records/" + record.ID, "/api/v1/zones/missing/records/missing", nil},
+		{acmeAdmin, http.MethodGet, "/api/v1/users/" + operator.ID, "/api/v1/users/missing", nil},
+		{acmeAdmin, http.MethodPatch, "/api/v1/users/" + operator.ID, "/api/v1/users/missing", `{"email": "stolen@example.com"}`},
+		{acmeAdmin, http.MethodDelete, "/api/v1/users/" + operator.ID, "/api/v1/users/missing", nil},
+		{globexAdmin, http.MethodGet, "/api/v1/zones/" + acmeZone.ID, "/api/v1/zones/missing", nil},
+		{globexAdmin, http.MethodGet, "/api/v1/users/" + acme.Admin.ID, "/api/v1/users/missing", nil},
+	}
+
+	for _, request := range requests {
+		missing := serve(c, request.method, request.missing, request.token, request.body)
+		denied := serve(c, request.method, request.path, request.token, request.body)
+
+		if denied.Code < http.StatusBadRequest || denied.Code != missing.Code || denied.Body.String() != missing.Body.String() {
+			t.Fatalf("expected %s %s from another tenant to look like it doesn't exist (%d), got %d: %s", request.method, request.path, missing.Code, denied.Code, denied.Body.String())
+		}
+	}
+
+	if stored, _ := store.GetZoneByID(zone.ID); stored == nil || stored.ReadOnly {
+		t.Fatal("expected the provider's zone to be untouched")
+	}
+
+	if stored, _ := store.GetRecordByID(record.ID); stored == nil || stored.Target != record.Target {
+		t.Fatal("expected the provider's record to be untouched")
+	}
+
+	if stored, _ := store.GetUserById(operator.ID); stored == nil || stored.Email != nil {
+		t.Fatal("expected the provider's user to be untouched")
+	}
+
+	headerRequest := serveRequest(http.MethodGet, "/api/v1/zones", acmeAdmin, nil)
+	headerRequest.Header.Set(config.TenantHeader, "globex")
+	forbidden := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(forbidden, headerRequest)
+	if forbidden.Code != http.StatusForbidden {
+		t.Fatalf("expected naming another tenant not to override the token's, got %d", forbidden.Code)
+	}
+
+	if hidden := serve(c, http.MethodGet, "/api/v1/tenants", acmeAdmin, nil); hidden.Code != http.StatusNotFound {
+		t.Fatalf("expected tenants not to see the tenants API, got %d", hidden.Code)
+	}
+
+	if hidden := serve(c, http.MethodGet, "/api/v1/instance/metrics", acmeAdmin, nil); hidden.Code != http.StatusNotFound {
+		t.Fatalf("expected tenants not to see the instance API, got %d", hidden.Code)
+	}
+}
+
+func TestTenantAdminsMustHaveValidUsernames(t *testing.T) {
+	setConfig(t, &config.MultiTenant, true)
+
+	c, store := newTestController(t)
+
+	provider := tokenFor(t, addUser(t, store, "provider", auth.ROLE_ADMIN))
+
+	for _, username := range []string{"has space", "-leading", breakGlassGuardUsername} {
+		created := serve(c, http.MethodPost, "/api/v1/tenants/new", provider, entity.NewTenantBody{Name: "acme", AdminUsername: username, AdminPassword: "Correct-Horse-Battery-Staple-42"})
+		if created.Code != http.StatusBadRequest {
+			t.Fatalf("expected a tenant admin named %q to be refused, got %d: %s", username, created.Code, created.Body.String())
+		}
+	}
+
+	if tenants, _ := store.GetTenants(); len(tenants) != 0 {
+		t.Fatalf("expected no tenant to be created, got %+v", tenants)
+	}
+}
+
+func TestTenantsCanUseTheSameZoneName(t *testing.T) {
+	setConfig(t, &config.MultiTenant, true)
+
+	c, store := newTestController(t)
+
+	provider := tokenFor(t, addUser(t, store, "provider", auth.ROLE_ADMIN))
+
+	_, acmeAdmin := newTenant(t, c, provider, "acme")
+	_, globexAdmin := newTenant(t, c, provider, "globex")
+
+	for _, admin := range []string{acmeAdmin, globexAdmin} {
+		if created := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "shared.example"}); created.Code != http.StatusCreated {
+			t.Fatalf("expected each tenant to create the zone, got %d: %s", created.Code, created.Body.String())
+		}
+	}
+
+	if duplicate := serve(c, http.MethodPost, "/api/v1/zones/new", acmeAdmin, entity.Zone{Name: "shared.example"}); duplicate.Code != http.StatusConflict {
+		t.Fatalf("expected a zone name to stay unique within its tenant, got %d: %s", duplicate.Code, duplicate.Body.String())
+	}
+
+	zones, _ := store.GetZones("")
+	if len(zones) != 2 || zones[0].TenantID == zones[1].TenantID {
+		t.Fatalf("expected one zone in each tenant, got %+v", zones)
+	}
+}
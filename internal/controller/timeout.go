@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/logging"
+)
+
+// Paths (below the base path) that may legitimately run for longer than the maximum request duration
+var durationExemptPaths = []string{"/api/v1/bundle", "/api/v1/users/me/events"}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+
+	// Only http.TimeoutHandler's own 503 has no content type, the handler's responses always carry theirs
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Forcibly answer with 503 once a request has run for longer than limit, even if the handler ignores its context
+// The handler's own response is discarded once the limit passes
+func maxDuration(handler http.Handler, limit time.Duration, log logging.Logger) http.Handler {
+	if limit <= 0 {
+		return handler
+	}
+
+	// Answered in the same shape as every other error so clients can parse it
+	body, _ := json.Marshal(entity.RESTError{StatusCode: http.StatusServiceUnavailable, Message: "request exceeded the maximum duration"})
+	limited := http.TimeoutHandler(handler, limit, string(body))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range durationExemptPaths {
+			if strings.HasPrefix(r.URL.Path, config.BasePath+path) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		limited.ServeHTTP(recorder, r)
+
+		if recorder.status == http.StatusServiceUnavailable && time.Since(start) >= limit {
+			log.Warn().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("remote", r.RemoteAddr).
+				Dur("limit", limit).
+				Msg("request exceeded the maximum duration and was terminated")
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/logging"
+)
+
+func TestRequestsPastTheMaximumDurationAreTerminated(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	sleeping := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ignores the request context, as a pathological handler would
+		select {
+		case <-release:
+		case <-time.After(200 * time.Millisecond):
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := maxDuration(sleeping, 20*time.Millisecond, logging.Log)
+
+	started := time.Now()
+	limited := httptest.NewRecorder()
+	handler.ServeHTTP(limited, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	if limited.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a request past the limit to be answered with 503, got %d", limited.Code)
+	}
+
+	terminated := entity.RESTError{}
+	if decodeErr := json.Unmarshal(limited.Body.Bytes(), &terminated); decodeErr != nil || terminated.StatusCode != http.StatusServiceUnavailable || terminated.Message == "" {
+		t.Fatalf("expected the 503 to be a JSON error, got %s: %v", limited.Body.String(), decodeErr)
+	}
+
+	if contentType := limited.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Fatalf("expected the 503 to be sent as JSON, got %s", contentType)
+	}
+
+	if elapsed := time.Since(started); elapsed >= 200*time.Millisecond {
+		t.Fatalf("expected the request to be terminated at the limit, it took %s", elapsed)
+	}
+
+	exempt := httptest.NewRecorder()
+	handler.ServeHTTP(exempt, httptest.NewRequest(http.MethodGet, "/api/v1/bundle/export", nil))
+	if exempt.Code != http.StatusOK {
+		t.Fatalf("expected exports to be exempt from the limit, got %d", exempt.Code)
+	}
+
+	fast := maxDuration(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusCreated) }), 20*time.Millisecond, logging.Log)
+	quick := httptest.NewRecorder()
+	fast.ServeHTTP(quick, httptest.NewRequest(http.MethodPost, "/api/v1/zones/new", nil))
+	if quick.Code != http.StatusCreated {
+		t.Fatalf("expected requests within the limit to be unaffected, got %d", quick.Code)
+	}
+}
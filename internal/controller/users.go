@@ -2,12 +2,18 @@ package controller
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/monoxane/vxconnect/internal/auth"
 	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+	"github.com/monoxane/vxconnect/internal/persistence"
 	"github.com/monoxane/vxconnect/internal/utilities"
 	"gorm.io/gorm"
 )
@@ -24,7 +30,93 @@ func (controller *Controller) HandleAuth(context *gin.Context) {
 		return
 	}
 
+	ip := context.ClientIP()
+	if controller.loginGuard.Blocked(ip, payload.Username) {
+		utilities.RESTError(context, http.StatusTooManyRequests, "too many failed login attempts", nil)
+		return
+	}
+
+	// Only failed credential checks count towards the lockout, not the database being unavailable
 	dbUser, userErr := controller.persistence.GetUserByUsername(payload.Username)
+	if errors.Is(userErr, gorm.ErrRecordNotFound) {
+		controller.loginGuard.RecordFailure(ip, payload.Username)
+		utilities.RESTError(context, http.StatusUnauthorized, "user not found", userErr)
+		return
+	}
+
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get user", userErr)
+		return
+	}
+
+	valid := auth.ValidatePassword(dbUser.PasswordHash, payload.Password)
+	if !valid {
+		controller.loginGuard.RecordFailure(ip, payload.Username)
+		controller.recordLogin(context, dbUser, "invalid password")
+		utilities.RESTError(context, http.StatusUnauthorized, "invalid password", nil)
+		return
+	}
+
+	if dbUser.Disabled {
+		controller.recordLogin(context, dbUser, "user is disabled")
+		utilities.RESTError(context, http.StatusUnauthorized, "user is disabled", nil)
+		return
+	}
+
+	controller.loginGuard.Reset(ip, payload.Username)
+
+	token, tokenErr := auth.GenerateToken(dbUser.Username, dbUser.TenantID, dbUser.Roles, time.Now(), auth.TokenTTL(dbUser))
+	if tokenErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to generate token", tokenErr)
+		return
+	}
+
+	controller.recordLogin(context, dbUser, "")
+
+	controller.emit(context, events.Event{
+		Type:       events.USER_LOGIN,
+		TenantID:   dbUser.TenantID,
+		Actor:      dbUser.Username,
+		TargetType: events.TARGET_USER,
+		TargetID:   dbUser.ID,
+	})
+
+	resp := entity.LoginResponse{
+		Username: dbUser.Username,
+		Token:    token,
+		Zones:    dbUser.Zones,
+		Roles:    dbUser.Roles,
+	}
+
+	context.JSON(http.StatusOK, resp)
+}
+
+func handleReauth(context *gin.Context) {
+	controller.HandleReauth(context)
+}
+
+func (controller *Controller) HandleReauth(context *gin.Context) {
+	payload := &entity.ReauthBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid body", bindErr)
+		return
+	}
+
+	username, currentUserErr := auth.CurrentUser(context)
+	if currentUserErr != nil {
+		utilities.RESTError(context, http.StatusUnauthorized, "unable to get current user", currentUserErr)
+		return
+	}
+
+	// Guarded like a login, otherwise a stolen token could guess the password without limit
+	ip := context.ClientIP()
+	if controller.loginGuard.Blocked(ip, username) {
+		utilities.RESTError(context, http.StatusTooManyRequests, "too many failed login attempts", nil)
+		return
+	}
+
+	dbUser, userErr := controller.persistence.GetUserByUsername(username)
 	if userErr != nil {
 		utilities.RESTError(context, http.StatusUnauthorized, "user not found", userErr)
 		return
@@ -32,11 +124,19 @@ func (controller *Controller) HandleAuth(context *gin.Context) {
 
 	valid := auth.ValidatePassword(dbUser.PasswordHash, payload.Password)
 	if !valid {
+		controller.loginGuard.RecordFailure(ip, username)
 		utilities.RESTError(context, http.StatusUnauthorized, "invalid password", nil)
 		return
 	}
 
-	token, tokenErr := auth.GenerateToken(dbUser.Username, dbUser.Roles)
+	if dbUser.Disabled {
+		utilities.RESTError(context, http.StatusUnauthorized, "user is disabled", nil)
+		return
+	}
+
+	controller.loginGuard.Reset(ip, username)
+
+	token, tokenErr := auth.GenerateToken(dbUser.Username, dbUser.TenantID, dbUser.Roles, time.Now(), auth.TokenTTL(dbUser))
 	if tokenErr != nil {
 		utilities.RESTError(context, http.StatusInternalServerError, "unable to generate token", tokenErr)
 		return
@@ -57,33 +157,65 @@ func handleUsers(context *gin.Context) {
 }
 
 func (controller *Controller) HandleUsers(context *gin.Context) {
-	if !auth.HasRole(context, auth.ROLE_ADMIN) {
-		utilities.RESTError(context, http.StatusUnauthorized, "user does not have permission to access this resource", nil)
+	order, orderErr := listOrder(context, SORT_USERS)
+	if orderErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid sort", orderErr)
 		return
 	}
 
-	users, usersErr := controller.persistence.GetUsers()
+	selectors, selectorErr := labelSelectors(context)
+	if selectorErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid label selector", selectorErr)
+		return
+	}
+
+	users, usersErr := controller.store(context).GetUsers(order)
 	if usersErr != nil {
 		utilities.RESTError(context, http.StatusInternalServerError, "unable to get users", usersErr)
 		return
 	}
 
+	if len(selectors) > 0 {
+		matched := []*entity.User{}
+		for _, user := range users {
+			if matchesLabels(user, selectors) {
+				matched = append(matched, user)
+			}
+		}
+		users = matched
+	}
+
 	context.JSON(http.StatusOK, entity.RESTResult{
 		Results:      users,
 		TotalResults: len(users),
 	})
 }
 
-func handleNewUser(context *gin.Context) {
-	controller.HandleNewUser(context)
+func handleUser(context *gin.Context) {
+	controller.HandleUser(context)
 }
 
-func (controller *Controller) HandleNewUser(context *gin.Context) {
-	if !auth.HasRole(context, auth.ROLE_ADMIN) {
-		utilities.RESTError(context, http.StatusUnauthorized, "user does not have permission to access this resource", nil)
+func (controller *Controller) HandleUser(context *gin.Context) {
+	id := context.Param("id")
+
+	user, userErr := controller.store(context).GetUserById(id)
+	if userErr != nil {
+		controller.userMissing(context, id, userErr)
+		return
+	}
+
+	if utilities.NotModified(context, fmt.Sprintf("%s-%d", user.ID, user.UpdatedAt.UnixNano())) {
 		return
 	}
 
+	utilities.RESTResource(context, http.StatusOK, user)
+}
+
+func handleNewUser(context *gin.Context) {
+	controller.HandleNewUser(context)
+}
+
+func (controller *Controller) HandleNewUser(context *gin.Context) {
 	payload := &entity.NewUserBody{}
 	bindErr := context.BindJSON(payload)
 	if bindErr != nil {
@@ -96,6 +228,42 @@ func (controller *Controller) HandleNewUser(context *gin.Context) {
 	// 	return
 	// }
 
+	// The generated password is only ever returned in this response, it is not stored in plain text
+	generatedPassword := ""
+	if payload.GeneratePassword {
+		password, generateErr := auth.GeneratePassword()
+		if generateErr != nil {
+			utilities.RESTError(context, http.StatusInternalServerError, "unable to generate password", generateErr)
+			return
+		}
+		generatedPassword = password
+		payload.Password = password
+	}
+
+	usernameErr := validateUsername(payload.Username)
+	if usernameErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid username", usernameErr)
+		return
+	}
+
+	strengthErr := auth.ValidatePasswordStrength(payload.Password, payload.Username)
+	if strengthErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "password does not meet the password policy", strengthErr)
+		return
+	}
+
+	ttlErr := auth.ValidateTokenTTL(payload.TokenTTL)
+	if ttlErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid token ttl", ttlErr)
+		return
+	}
+
+	emailErr := normalizeUserEmail(&payload.User)
+	if emailErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid email address", emailErr)
+		return
+	}
+
 	hash, hashErr := auth.HashPassword(payload.Password)
 	if hashErr != nil {
 		utilities.RESTError(context, http.StatusInternalServerError, "unable to hash password", hashErr)
@@ -109,14 +277,16 @@ func (controller *Controller) HandleNewUser(context *gin.Context) {
 	user := &entity.User{
 		ID:           uuid.NewString(),
 		Username:     payload.Username,
+		Email:        payload.Email,
 		PasswordHash: hash,
 		Roles:        payload.Roles,
 		Zones:        payload.Zones,
+		TokenTTL:     payload.TokenTTL,
 	}
 
-	storeErr := controller.persistence.CreateUser(user)
+	storeErr := controller.store(context).CreateUser(user)
 	if errors.Is(storeErr, gorm.ErrDuplicatedKey) {
-		utilities.RESTError(context, http.StatusConflict, "username in use", storeErr)
+		utilities.RESTError(context, http.StatusConflict, "username or email in use", storeErr)
 		return
 	}
 
@@ -124,6 +294,14 @@ func (controller *Controller) HandleNewUser(context *gin.Context) {
 		utilities.RESTError(context, http.StatusInternalServerError, "unable to store user", storeErr)
 		return
 	}
+
+	controller.publish(context, events.USER_CREATED, events.TARGET_USER, user.ID, user)
+
+	context.Header("Location", utilities.URL("/api/v1/users/"+user.ID))
+	context.JSON(http.StatusCreated, entity.NewUserResponse{
+		User:              user,
+		GeneratedPassword: generatedPassword,
+	})
 }
 
 func handleUpdateUser(context *gin.Context) {
@@ -131,48 +309,132 @@ func handleUpdateUser(context *gin.Context) {
 }
 
 func (controller *Controller) HandleUpdateUser(context *gin.Context) {
-	if !auth.HasRole(context, auth.ROLE_ADMIN) {
-		utilities.RESTError(context, http.StatusUnauthorized, "user does not have permission to access this resource", nil)
+	id := context.Param("id")
+
+	user, userErr := controller.store(context).GetUserById(id)
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "user does not exist", userErr)
 		return
 	}
 
-	id := context.Param("id")
+	if context.ContentType() == CONTENT_TYPE_JSON_PATCH {
+		body, readErr := io.ReadAll(context.Request.Body)
+		if readErr != nil {
+			utilities.RESTError(context, http.StatusBadRequest, "invalid request body", readErr)
+			return
+		}
+
+		patched, patchErr := patchUser(user, body)
+		if patchErr != nil {
+			utilities.RESTError(context, http.StatusBadRequest, "invalid patch", patchErr)
+			return
+		}
+
+		user.Email = patched.Email
+		user.Zones = patched.Zones
+		user.TokenTTL = patched.TokenTTL
+	} else {
+		payload := &entity.UserPatch{}
+		bindErr := context.BindJSON(payload)
+		if bindErr != nil {
+			utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+			return
+		}
+
+		// Omitting a field leaves it as is, zones are cleared with [], email with "" and a TTL override with 0
+		if payload.Zones != nil {
+			user.Zones = payload.Zones
+		}
+
+		if payload.Email != nil {
+			user.Email = payload.Email
+		}
+
+		if payload.TokenTTL != nil {
+			user.TokenTTL = payload.TokenTTL
+			if *payload.TokenTTL == 0 {
+				user.TokenTTL = nil
+			}
+		}
+	}
 
-	payload := &entity.User{}
-	bindErr := context.BindJSON(payload)
-	if bindErr != nil {
-		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+	if message, validateErr := validateUserChange(user); validateErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, message, validateErr)
 		return
 	}
 
-	user, userErr := controller.persistence.GetUserById(id)
-	if userErr != nil {
-		utilities.RESTError(context, http.StatusBadRequest, "user does not exist", userErr)
+	storeErr := controller.store(context).SaveUser(user)
+	if errors.Is(storeErr, gorm.ErrDuplicatedKey) {
+		utilities.RESTError(context, http.StatusConflict, "email in use", storeErr)
 		return
 	}
 
-	user.Zones = payload.Zones
-
-	storeErr := controller.persistence.SaveUser(user)
 	if storeErr != nil {
 		utilities.RESTError(context, http.StatusInternalServerError, "unable to store user", storeErr)
 		return
 	}
+
+	controller.publish(context, events.USER_UPDATED, events.TARGET_USER, user.ID, user)
+
+	utilities.RESTResource(context, http.StatusOK, user)
+}
+
+// Check the fields of a changed user before it is stored, normalising its email, returning what was wrong with it
+func validateUserChange(user *entity.User) (string, error) {
+	if ttlErr := auth.ValidateTokenTTL(user.TokenTTL); ttlErr != nil {
+		return "invalid token ttl", ttlErr
+	}
+
+	if emailErr := normalizeUserEmail(user); emailErr != nil {
+		return "invalid email address", emailErr
+	}
+
+	return "", nil
 }
 
 func handleDeleteUser(context *gin.Context) {
 	controller.HandleDeleteUser(context)
 }
 
+// Delete a user, the last enabled admin can never be deleted and other admins may need a second admin's approval
+// If the user can't be looked up nothing is deleted, so a failing lookup can't skip either check
 func (controller *Controller) HandleDeleteUser(context *gin.Context) {
-	if !auth.HasRole(context, auth.ROLE_ADMIN) {
-		utilities.RESTError(context, http.StatusUnauthorized, "user does not have permission to access this resource", nil)
+	id := context.Param("id")
+
+	user, userErr := controller.store(context).GetUserById(id)
+	if errors.Is(userErr, gorm.ErrRecordNotFound) {
+		utilities.RESTError(context, http.StatusBadRequest, "user does not exist", nil)
 		return
 	}
 
-	id := context.Param("id")
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get user", userErr)
+		return
+	}
+
+	unlockAdmins, locked := controller.lockForMutation(context, adminsLock)
+	if !locked {
+		return
+	}
+	defer unlockAdmins()
 
-	deleteErr := controller.persistence.DeleteUser(id)
+	last, lastErr := lastEnabledAdmin(controller.store(context), user)
+	if lastErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get users", lastErr)
+		return
+	}
+
+	if last {
+		utilities.RESTError(context, http.StatusConflict, errLastAdmin.Error(), nil)
+		return
+	}
+
+	if requiresApproval(APPROVAL_DELETE_ADMIN) && isAdmin(user) {
+		controller.requestApproval(context, APPROVAL_DELETE_ADMIN, events.TARGET_USER, id)
+		return
+	}
+
+	deleteErr := controller.store(context).DeleteUser(id)
 	if errors.Is(deleteErr, gorm.ErrRecordNotFound) {
 		utilities.RESTError(context, http.StatusBadRequest, "user does not exist", nil)
 		return
@@ -183,4 +445,378 @@ func (controller *Controller) HandleDeleteUser(context *gin.Context) {
 		return
 	}
 
+	controller.publish(context, events.USER_DELETED, events.TARGET_USER, id, nil)
+}
+
+func handleUserEffectiveZones(context *gin.Context) {
+	controller.HandleUserEffectiveZones(context)
+}
+
+func (controller *Controller) HandleUserEffectiveZones(context *gin.Context) {
+	id := context.Param("id")
+
+	// Admins can inspect anyone, everyone else can only inspect themselves
+	if !auth.AnyOf(auth.Permission(auth.PERMISSION_USERS_READ), controller.isUser(id))(context) {
+		auth.LogDenial(context, "view effective zones", "user is not the target user")
+		utilities.RESTError(context, http.StatusUnauthorized, "user does not have permission to access this resource", nil)
+		return
+	}
+
+	user, userErr := controller.store(context).GetUserById(id)
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "user does not exist", userErr)
+		return
+	}
+
+	zones, zonesErr := controller.effectiveZones(controller.store(context), user.Zones)
+	if zonesErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get zones", zonesErr)
+		return
+	}
+
+	context.JSON(http.StatusOK, entity.RESTResult{
+		Results:      zones,
+		TotalResults: len(zones),
+	})
+}
+
+// Expand a set of assigned zone names into every zone they cover,
+// a zone covers itself and all of the zones nested beneath it (eg. example.com covers studio.example.com)
+func (controller *Controller) effectiveZones(store persistence.Store, assigned []string) ([]*entity.Zone, error) {
+	zones, zonesErr := store.GetZones("")
+	if zonesErr != nil {
+		return nil, zonesErr
+	}
+
+	// Zone names ignore case, as they do when checking which zones exist
+	effective := []*entity.Zone{}
+	for _, zone := range zones {
+		zoneName := strings.ToLower(zone.Name)
+		for _, name := range assigned {
+			name = strings.ToLower(name)
+			if zoneName == name || strings.HasSuffix(zoneName, "."+name) {
+				effective = append(effective, zone)
+				break
+			}
+		}
+	}
+
+	return effective, nil
+}
+
+func handleBulkDisableUsers(context *gin.Context) {
+	controller.HandleBulkDisableUsers(context)
+}
+
+// Enable or disable a batch of users in one transaction, reporting the outcome for each id
+// Disabling is refused for any entry that would leave no enabled admins
+func (controller *Controller) HandleBulkDisableUsers(context *gin.Context) {
+	payload := &entity.BulkDisableBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil || len(payload.IDs) == 0 {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+		return
+	}
+
+	keys := make([]string, 0, len(payload.IDs))
+	for _, id := range payload.IDs {
+		keys = append(keys, resourceKey(events.TARGET_USER, id))
+	}
+
+	unlock, locked := controller.lockForMutation(context, keys...)
+	if !locked {
+		return
+	}
+	defer unlock()
+
+	if payload.Disabled {
+		unlockAdmins, adminsLocked := controller.lockForMutation(context, adminsLock)
+		if !adminsLocked {
+			return
+		}
+		defer unlockAdmins()
+	}
+
+	users, usersErr := controller.store(context).GetUsers("")
+	if usersErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get users", usersErr)
+		return
+	}
+
+	usersByID := map[string]*entity.User{}
+	enabledAdmins := map[string]bool{}
+	for _, user := range users {
+		usersByID[user.ID] = user
+		if !user.Disabled && isAdmin(user) {
+			enabledAdmins[user.ID] = true
+		}
+	}
+
+	results := []entity.BulkResult{}
+	accepted := []string{}
+	for _, id := range payload.IDs {
+		user, ok := usersByID[id]
+		if !ok {
+			results = append(results, entity.BulkResult{ID: id, Status: http.StatusNotFound, Error: "user does not exist"})
+			continue
+		}
+
+		if payload.Disabled && enabledAdmins[id] {
+			if len(enabledAdmins) == 1 {
+				results = append(results, entity.BulkResult{ID: id, Status: http.StatusConflict, Error: "unable to disable the last enabled admin"})
+				continue
+			}
+			delete(enabledAdmins, id)
+		}
+
+		accepted = append(accepted, user.ID)
+		results = append(results, entity.BulkResult{ID: id, Status: http.StatusOK, Success: true})
+	}
+
+	if len(accepted) > 0 {
+		storeErr := controller.store(context).SetUsersDisabled(accepted, payload.Disabled)
+		if storeErr != nil {
+			utilities.RESTError(context, http.StatusInternalServerError, "unable to store users", storeErr)
+			return
+		}
+
+		for _, id := range accepted {
+			controller.publish(context, events.USER_UPDATED, events.TARGET_USER, id, map[string]bool{"disabled": payload.Disabled})
+		}
+	}
+
+	utilities.RESTBulk(context, results)
+}
+
+func isAdmin(user *entity.User) bool {
+	for _, role := range user.Roles {
+		if role == auth.ROLE_ADMIN {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether user is the only enabled admin left, without them nobody could manage the deployment
+func lastEnabledAdmin(store persistence.Store, user *entity.User) (bool, error) {
+	if user.Disabled || !isAdmin(user) {
+		return false, nil
+	}
+
+	users, usersErr := store.GetUsers("")
+	if usersErr != nil {
+		return false, usersErr
+	}
+
+	for _, other := range users {
+		if other.ID != user.ID && !other.Disabled && isAdmin(other) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func handleUserAccessDiff(context *gin.Context) {
+	controller.HandleUserAccessDiff(context)
+}
+
+// Compare the roles and expanded zones of two users
+func (controller *Controller) HandleUserAccessDiff(context *gin.Context) {
+	user, userErr := controller.store(context).GetUserById(context.Param("id"))
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "user does not exist", userErr)
+		return
+	}
+
+	other, otherErr := controller.store(context).GetUserById(context.Param("other"))
+	if otherErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "other user does not exist", otherErr)
+		return
+	}
+
+	userZones, userZonesErr := controller.effectiveZones(controller.store(context), user.Zones)
+	if userZonesErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get zones", userZonesErr)
+		return
+	}
+
+	otherZones, otherZonesErr := controller.effectiveZones(controller.store(context), other.Zones)
+	if otherZonesErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get zones", otherZonesErr)
+		return
+	}
+
+	userZoneNames := zoneNames(userZones)
+	otherZoneNames := zoneNames(otherZones)
+
+	utilities.RESTResource(context, http.StatusOK, entity.AccessDiff{
+		UserID:         user.ID,
+		OtherID:        other.ID,
+		RolesOnlyUser:  difference(user.Roles, other.Roles),
+		RolesOnlyOther: difference(other.Roles, user.Roles),
+		ZonesOnlyUser:  difference(userZoneNames, otherZoneNames),
+		ZonesOnlyOther: difference(otherZoneNames, userZoneNames),
+	})
+}
+
+func handleUserAccessWhatIf(context *gin.Context) {
+	controller.HandleUserAccessWhatIf(context)
+}
+
+// Preview the permissions and zones a user would have after a change to their roles and zones, without storing anything
+func (controller *Controller) HandleUserAccessWhatIf(context *gin.Context) {
+	payload := &entity.AccessWhatIfBody{}
+	bindErr := context.BindJSON(payload)
+	if bindErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "invalid request body", bindErr)
+		return
+	}
+
+	for _, role := range payload.AddRoles {
+		if _, ok := auth.RolePermissions(role); !ok {
+			utilities.RESTError(context, http.StatusBadRequest, fmt.Sprintf("unknown role %s", role), nil)
+			return
+		}
+	}
+
+	store := controller.store(context)
+
+	user, userErr := store.GetUserById(context.Param("id"))
+	if userErr != nil {
+		utilities.RESTError(context, http.StatusBadRequest, "user does not exist", userErr)
+		return
+	}
+
+	if len(payload.AddZones) > 0 {
+		exists, existsErr := store.ZonesExist(payload.AddZones)
+		if existsErr != nil {
+			utilities.RESTError(context, http.StatusInternalServerError, "unable to check zones", existsErr)
+			return
+		}
+
+		for _, zone := range payload.AddZones {
+			if !exists[zone] {
+				utilities.RESTError(context, http.StatusBadRequest, fmt.Sprintf("zone %s does not exist", zone), nil)
+				return
+			}
+		}
+	}
+
+	roles := union(difference(user.Roles, payload.RemoveRoles), payload.AddRoles)
+	zones := union(difference(user.Zones, payload.RemoveZones), payload.AddZones)
+
+	currentZones, currentZonesErr := controller.effectiveZones(store, user.Zones)
+	if currentZonesErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get zones", currentZonesErr)
+		return
+	}
+
+	proposedZones, proposedZonesErr := controller.effectiveZones(store, zones)
+	if proposedZonesErr != nil {
+		utilities.RESTError(context, http.StatusInternalServerError, "unable to get zones", proposedZonesErr)
+		return
+	}
+
+	currentPermissions := auth.PermissionsForRoles(user.Roles)
+	proposedPermissions := auth.PermissionsForRoles(roles)
+	currentZoneNames := zoneNames(currentZones)
+	proposedZoneNames := zoneNames(proposedZones)
+
+	utilities.RESTResource(context, http.StatusOK, entity.AccessWhatIf{
+		UserID:            user.ID,
+		Roles:             roles,
+		Zones:             zones,
+		Permissions:       proposedPermissions,
+		EffectiveZones:    proposedZoneNames,
+		GainedPermissions: difference(proposedPermissions, currentPermissions),
+		LostPermissions:   difference(currentPermissions, proposedPermissions),
+		GainedZones:       difference(proposedZoneNames, currentZoneNames),
+		LostZones:         difference(currentZoneNames, proposedZoneNames),
+	})
+}
+
+func zoneNames(zones []*entity.Zone) []string {
+	names := []string{}
+	for _, zone := range zones {
+		names = append(names, zone.Name)
+	}
+
+	return names
+}
+
+// Get the values in a that are not in b
+func difference(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, value := range b {
+		inB[value] = true
+	}
+
+	result := []string{}
+	for _, value := range a {
+		if !inB[value] {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+// Get the values in a followed by those in b that aren't already in a
+func union(a, b []string) []string {
+	return append(append([]string{}, a...), difference(b, a)...)
+}
+
+// Tokens stop working as soon as their user is disabled or deleted, see auth.SetAccountCheck
+// Usernames are unique across tenants so the lookup doesn't need the tenant scope
+func (c *Controller) accountActive(username string) (bool, error) {
+	user, userErr := c.persistence.GetUserByUsername(username)
+	if errors.Is(userErr, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+
+	if userErr != nil {
+		return false, userErr
+	}
+
+	return !user.Disabled, nil
+}
+
+// Check the current user is the user with this id
+// The username is read from the token first so requests without one never reach the database
+func (controller *Controller) isUser(id string) auth.Check {
+	return func(context *gin.Context) bool {
+		username, currentUserErr := auth.CurrentUser(context)
+		if currentUserErr != nil || username == "" {
+			return false
+		}
+
+		user, userErr := controller.store(context).GetUserById(id)
+		if userErr != nil {
+			return false
+		}
+
+		return user.Username == username
+	}
+}
+
+// Normalise a user's email address in place, an empty address is treated as no address
+func normalizeUserEmail(user *entity.User) error {
+	if user.Email == nil {
+		return nil
+	}
+
+	if *user.Email == "" {
+		user.Email = nil
+		return nil
+	}
+
+	email, err := utilities.NormalizeEmail(*user.Email)
+	if err != nil {
+		return err
+	}
+	user.Email = &email
+
+	return nil
 }
@@ -0,0 +1,649 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/events"
+)
+
+func TestUpdateUserOnlyChangesFieldsInTheBody(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	user.Zones = []string{"example.com", "example.org"}
+	if saveErr := store.SaveUser(user); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	updated := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"email": "alice@example.com"}`)
+	if updated.Code != http.StatusOK {
+		t.Fatalf("expected the email to be updated, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	stored, _ := store.GetUserById(user.ID)
+	if stored.Email == nil || *stored.Email != "alice@example.com" {
+		t.Fatalf("expected the new email to be stored, got %v", stored.Email)
+	}
+
+	if !reflect.DeepEqual(stored.Zones, user.Zones) {
+		t.Fatalf("expected an update without zones to keep them, got %v", stored.Zones)
+	}
+
+	updated = serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"tokenTtl": 30}`)
+	if updated.Code != http.StatusOK {
+		t.Fatalf("expected the token TTL to be updated, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	stored, _ = store.GetUserById(user.ID)
+	if !reflect.DeepEqual(stored.Zones, user.Zones) || stored.Email == nil || stored.TokenTTL == nil || *stored.TokenTTL != 30 {
+		t.Fatalf("expected only the token TTL to change, got %+v", stored)
+	}
+
+	cleared := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"zones": []}`)
+	if cleared.Code != http.StatusOK {
+		t.Fatalf("expected the zones to be cleared, got %d: %s", cleared.Code, cleared.Body.String())
+	}
+
+	stored, _ = store.GetUserById(user.ID)
+	if len(stored.Zones) != 0 || stored.Email == nil {
+		t.Fatalf("expected an empty list to clear only the zones, got %+v", stored)
+	}
+}
+
+// How long after now the token from logging in as username expires
+func loginExpiresIn(t *testing.T, c *Controller, username string) time.Duration {
+	t.Helper()
+
+	loggedIn := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: username, Password: username + "-password"})
+	if loggedIn.Code != http.StatusOK {
+		t.Fatalf("expected %s to log in, got %d: %s", username, loggedIn.Code, loggedIn.Body.String())
+	}
+
+	login := &entity.LoginResponse{}
+	if decodeErr := json.Unmarshal(loggedIn.Body.Bytes(), login); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, parseErr := jwt.ParseWithClaims(login.Token, claims, func(*jwt.Token) (interface{}, error) { return []byte(config.JWTSecret), nil }); parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	return time.Until(time.Unix(int64(claims["exp"].(float64)), 0))
+}
+
+func TestTokenTTLOverridesSetTheExpiryOfLogins(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+
+	standard := loginExpiresIn(t, c, "alice")
+
+	if updated := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"tokenTtl": 10}`); updated.Code != http.StatusOK {
+		t.Fatalf("expected the token TTL to be overridden, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	if expiresIn := loginExpiresIn(t, c, "alice"); expiresIn <= 9*time.Minute || expiresIn > 10*time.Minute {
+		t.Fatalf("expected the login token to expire in 10 minutes, expires in %s", expiresIn)
+	}
+
+	if cleared := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"tokenTtl": 0}`); cleared.Code != http.StatusOK {
+		t.Fatalf("expected the token TTL override to be cleared, got %d: %s", cleared.Code, cleared.Body.String())
+	}
+
+	if stored, _ := store.GetUserById(user.ID); stored.TokenTTL != nil {
+		t.Fatalf("expected no override to be stored, got %d", *stored.TokenTTL)
+	}
+
+	if expiresIn := loginExpiresIn(t, c, "alice"); expiresIn < standard-time.Minute {
+		t.Fatalf("expected the login token to expire after the default %s again, expires in %s", standard, expiresIn)
+	}
+}
+
+func TestDeleteUserRefusesTheLastEnabledAdmin(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	disabled := addUser(t, store, "disabled", auth.ROLE_ADMIN)
+	if disableErr := store.SetUsersDisabled([]string{disabled.ID}, true); disableErr != nil {
+		t.Fatal(disableErr)
+	}
+
+	deleted := serve(c, http.MethodDelete, "/api/v1/users/"+admin.ID, tokenFor(t, admin), nil)
+	if deleted.Code != http.StatusConflict {
+		t.Fatalf("expected deleting the last enabled admin to be refused, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+
+	if _, userErr := store.GetUserById(admin.ID); userErr != nil {
+		t.Fatalf("expected the last enabled admin to still exist, got %s", userErr)
+	}
+}
+
+func TestConcurrentChangesToDifferentAdminsLeaveOneEnabled(t *testing.T) {
+	c, store := newTestController(t)
+
+	alice := addUser(t, store, "alice", auth.ROLE_ADMIN)
+	bob := addUser(t, store, "bob", auth.ROLE_ADMIN)
+
+	// Counting the admins is slow enough for the two changes to overlap if nothing serialises them
+	store.slow("GetUsers", 50*time.Millisecond)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	var deleted, disabled int
+	go func() {
+		defer wg.Done()
+		deleted = serve(c, http.MethodDelete, "/api/v1/users/"+bob.ID, tokenFor(t, alice), nil).Code
+	}()
+	go func() {
+		defer wg.Done()
+		response := serve(c, http.MethodPost, "/api/v1/users/bulk/disabled", tokenFor(t, bob), entity.BulkDisableBody{IDs: []string{alice.ID}, Disabled: true})
+
+		results := []entity.BulkResult{}
+		decodeResults(t, response, &results)
+		if len(results) == 1 {
+			disabled = results[0].Status
+		}
+	}()
+
+	wg.Wait()
+
+	if (deleted == http.StatusOK) == (disabled == http.StatusOK) {
+		t.Fatalf("expected exactly one of the changes to go ahead, got a delete of %d and a disable of %d", deleted, disabled)
+	}
+
+	store.slow("GetUsers", 0)
+	users, _ := store.GetUsers("")
+	enabled := 0
+	for _, user := range users {
+		if !user.Disabled && isAdmin(user) {
+			enabled++
+		}
+	}
+
+	if enabled != 1 {
+		t.Fatalf("expected one enabled admin to be left, got %d", enabled)
+	}
+}
+
+func TestDeleteUserFailsClosedWhenTheUserCantBeLookedUp(t *testing.T) {
+	setConfig(t, &config.ApprovalActions, []string{APPROVAL_DELETE_ADMIN})
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	other := addUser(t, store, "other", auth.ROLE_ADMIN)
+
+	store.fail("GetUserById", errStoreDown)
+	deleted := serve(c, http.MethodDelete, "/api/v1/users/"+other.ID, admin, nil)
+	store.fail("GetUserById", nil)
+
+	if deleted.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a failed lookup to fail the deletion, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+
+	if _, userErr := store.GetUserById(other.ID); userErr != nil {
+		t.Fatal("expected an admin to never be deleted without approval because their lookup failed")
+	}
+}
+
+func TestLoginLockoutOnlyCountsFailedCredentials(t *testing.T) {
+	c, store := newTestController(t)
+
+	addUser(t, store, "operator")
+
+	store.fail("GetUserByUsername", errStoreDown)
+	for attempt := 0; attempt < config.LoginMaxAccountFailures+1; attempt++ {
+		if login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "operator-password"}); login.Code != http.StatusInternalServerError {
+			t.Fatalf("expected a failed lookup to be a server error, got %d: %s", login.Code, login.Body.String())
+		}
+	}
+	store.fail("GetUserByUsername", nil)
+
+	if login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "operator-password"}); login.Code != http.StatusOK {
+		t.Fatalf("expected the database being down not to lock the account, got %d: %s", login.Code, login.Body.String())
+	}
+
+	for attempt := 0; attempt < config.LoginMaxAccountFailures; attempt++ {
+		serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "wrong"})
+	}
+
+	if login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "operator-password"}); login.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected wrong passwords to lock the account, got %d", login.Code)
+	}
+
+	for attempt := 0; attempt < config.LoginMaxAccountFailures; attempt++ {
+		serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "nobody", Password: "wrong"})
+	}
+
+	if login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "nobody", Password: "wrong"}); login.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected unknown usernames to count as failed logins, got %d", login.Code)
+	}
+}
+
+func TestLoginLockoutCatchesUsernameSpraying(t *testing.T) {
+	setConfig(t, &config.LoginMaxAddressFailures, 10)
+
+	c, store := newTestController(t)
+
+	addUser(t, store, "operator")
+
+	// Each username is only tried once, so no single account gets near its own limit
+	for attempt := 0; attempt < config.LoginMaxAddressFailures; attempt++ {
+		username := fmt.Sprintf("user-%d", attempt)
+		if login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: username, Password: "Summer2024!"}); login.Code != http.StatusUnauthorized {
+			t.Fatalf("expected %s to be refused, got %d", username, login.Code)
+		}
+	}
+
+	if login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "operator-password"}); login.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected spraying many usernames to lock the address, got %d", login.Code)
+	}
+
+	elsewhere := serveRequest(http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "operator-password"})
+	elsewhere.RemoteAddr = "192.0.2.20:1234"
+
+	recorder := httptest.NewRecorder()
+	c.restEngine.ServeHTTP(recorder, elsewhere)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected other addresses to still log in, got %d", recorder.Code)
+	}
+}
+
+func TestReauthenticationIsLockedOutLikeLogins(t *testing.T) {
+	c, store := newTestController(t)
+
+	token := tokenFor(t, addUser(t, store, "operator"))
+
+	for attempt := 0; attempt < config.LoginMaxAccountFailures; attempt++ {
+		if wrong := serve(c, http.MethodPost, "/api/v1/reauth", token, entity.ReauthBody{Password: "wrong"}); wrong.Code != http.StatusUnauthorized {
+			t.Fatalf("expected a wrong password to be refused, got %d", wrong.Code)
+		}
+	}
+
+	if reauth := serve(c, http.MethodPost, "/api/v1/reauth", token, entity.ReauthBody{Password: "operator-password"}); reauth.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected wrong passwords to lock re-authentication, got %d", reauth.Code)
+	}
+
+	if login := serve(c, http.MethodPost, "/api/v1/login", "", entity.LoginBody{Username: "operator", Password: "operator-password"}); login.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected guessing through re-authentication to lock logins too, got %d", login.Code)
+	}
+}
+
+func TestStaleSessionsMustReauthenticateForSensitiveActions(t *testing.T) {
+	setConfig(t, &config.ReauthMaxAge, 5)
+
+	c, store := newTestController(t)
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	zone := addZone(t, store, "example.com", false)
+
+	stale, staleErr := auth.GenerateToken(admin.Username, admin.TenantID, admin.Roles, time.Now().Add(-time.Hour), 2*time.Hour)
+	if staleErr != nil {
+		t.Fatal(staleErr)
+	}
+
+	if blocked := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, stale, `{"email": "alice@example.com"}`); blocked.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a stale session to be refused a sensitive action, got %d", blocked.Code)
+	}
+
+	if blocked := serve(c, http.MethodPut, "/api/v1/zones/"+zone.ID+"/read-only", stale, entity.ZoneReadOnlyBody{ReadOnly: true}); blocked.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a stale session to be refused making a zone read-only, got %d", blocked.Code)
+	}
+
+	if frozen, _ := store.GetZoneByID(zone.ID); frozen.ReadOnly {
+		t.Fatal("expected the refused request to leave the zone writable")
+	}
+
+	if listed := serve(c, http.MethodGet, "/api/v1/users", stale, nil); listed.Code != http.StatusOK {
+		t.Fatalf("expected a stale session to still be able to read, got %d", listed.Code)
+	}
+
+	if wrong := serve(c, http.MethodPost, "/api/v1/reauth", stale, entity.ReauthBody{Password: "wrong"}); wrong.Code != http.StatusUnauthorized {
+		t.Fatalf("expected re-authenticating with the wrong password to fail, got %d", wrong.Code)
+	}
+
+	reauth := serve(c, http.MethodPost, "/api/v1/reauth", stale, entity.ReauthBody{Password: "admin-password"})
+	if reauth.Code != http.StatusOK {
+		t.Fatalf("expected re-authentication to succeed, got %d: %s", reauth.Code, reauth.Body.String())
+	}
+
+	fresh := entity.LoginResponse{}
+	if decodeErr := json.Unmarshal(reauth.Body.Bytes(), &fresh); decodeErr != nil {
+		t.Fatal(decodeErr)
+	}
+
+	if allowed := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, fresh.Token, `{"email": "alice@example.com"}`); allowed.Code != http.StatusOK {
+		t.Fatalf("expected the re-authenticated session to be allowed the action, got %d: %s", allowed.Code, allowed.Body.String())
+	}
+
+	if allowed := serve(c, http.MethodPut, "/api/v1/zones/"+zone.ID+"/read-only", fresh.Token, entity.ZoneReadOnlyBody{ReadOnly: true}); allowed.Code != http.StatusOK {
+		t.Fatalf("expected the re-authenticated session to be allowed to make the zone read-only, got %d: %s", allowed.Code, allowed.Body.String())
+	}
+}
+
+func TestEffectiveZonesIncludeDescendants(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	other := tokenFor(t, addUser(t, store, "bob", auth.ROLE_ZONE_ADMIN))
+
+	// Names ignore case, as they do when checking which zones exist
+	user.Zones = []string{"Example.com"}
+	if saveErr := store.SaveUser(user); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	for _, name := range []string{"example.com", "studio.example.com", "edge.Studio.EXAMPLE.com", "notexample.com", "example.org"} {
+		addZone(t, store, name, false)
+	}
+
+	for _, token := range []string{admin, tokenFor(t, user)} {
+		effective := serve(c, http.MethodGet, "/api/v1/users/"+user.ID+"/zones/effective", token, nil)
+		if effective.Code != http.StatusOK {
+			t.Fatalf("expected the effective zones to be listed, got %d: %s", effective.Code, effective.Body.String())
+		}
+
+		zones := []entity.Zone{}
+		decodeResults(t, effective, &zones)
+
+		names := []string{}
+		for _, zone := range zones {
+			names = append(names, zone.Name)
+		}
+		sort.Strings(names)
+
+		if expected := []string{"edge.Studio.EXAMPLE.com", "example.com", "studio.example.com"}; !reflect.DeepEqual(names, expected) {
+			t.Fatalf("expected the assigned zone and its descendants, got %v", names)
+		}
+	}
+
+	if denied := serve(c, http.MethodGet, "/api/v1/users/"+user.ID+"/zones/effective", other, nil); denied.Code != http.StatusUnauthorized {
+		t.Fatalf("expected another user to be refused, got %d", denied.Code)
+	}
+}
+
+func TestUpdateUserReturnsTheUpdatedUser(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	addUser(t, store, "bob", auth.ROLE_ZONE_ADMIN)
+	before, _ := store.GetUserById(user.ID)
+
+	updated := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"email": "alice@example.com", "zones": ["example.com"]}`)
+	if updated.Code != http.StatusOK {
+		t.Fatalf("expected the user to be updated, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	returned := &entity.User{}
+	decodeResource(t, updated, returned)
+
+	if returned.ID != user.ID || returned.Email == nil || *returned.Email != "alice@example.com" || !reflect.DeepEqual(returned.Zones, []string{"example.com"}) {
+		t.Fatalf("expected the returned user to have the changes applied, got %+v", returned)
+	}
+
+	if !returned.UpdatedAt.After(before.UpdatedAt) {
+		t.Fatalf("expected the returned user to have a new updatedAt, got %s after %s", returned.UpdatedAt, before.UpdatedAt)
+	}
+
+	if strings.Contains(updated.Body.String(), user.PasswordHash) {
+		t.Fatal("expected the returned user to leave out the password hash")
+	}
+
+	bob, _ := store.GetUserByUsername("bob")
+	if conflict := serve(c, http.MethodPatch, "/api/v1/users/"+bob.ID, admin, `{"email": "alice@example.com"}`); conflict.Code != http.StatusConflict {
+		t.Fatalf("expected an email in use to still conflict, got %d", conflict.Code)
+	}
+}
+
+func TestAccessDiffReportsZonesEachUserHasAlone(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	alice := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	bob := addUser(t, store, "bob", auth.ROLE_ZONE_ADMIN, auth.ROLE_ADMIN)
+
+	for _, name := range []string{"example.com", "studio.example.com", "example.org", "example.net"} {
+		addZone(t, store, name, false)
+	}
+
+	alice.Zones = []string{"example.com"}
+	bob.Zones = []string{"studio.example.com", "example.org"}
+	for _, user := range []*entity.User{alice, bob} {
+		if saveErr := store.SaveUser(user); saveErr != nil {
+			t.Fatal(saveErr)
+		}
+	}
+
+	compared := serve(c, http.MethodGet, "/api/v1/users/"+alice.ID+"/diff/"+bob.ID, admin, nil)
+	if compared.Code != http.StatusOK {
+		t.Fatalf("expected the users to be compared, got %d: %s", compared.Code, compared.Body.String())
+	}
+
+	diff := &entity.AccessDiff{}
+	decodeResource(t, compared, diff)
+
+	if !reflect.DeepEqual(diff.ZonesOnlyUser, []string{"example.com"}) || !reflect.DeepEqual(diff.ZonesOnlyOther, []string{"example.org"}) {
+		t.Fatalf("expected only the zones the users don't share, got %v and %v", diff.ZonesOnlyUser, diff.ZonesOnlyOther)
+	}
+
+	if len(diff.RolesOnlyUser) != 0 || !reflect.DeepEqual(diff.RolesOnlyOther, []string{auth.ROLE_ADMIN}) {
+		t.Fatalf("expected only bob to have the admin role, got %v and %v", diff.RolesOnlyUser, diff.RolesOnlyOther)
+	}
+
+	if denied := serve(c, http.MethodGet, "/api/v1/users/"+alice.ID+"/diff/"+bob.ID, tokenFor(t, alice), nil); denied.Code != http.StatusUnauthorized {
+		t.Fatalf("expected comparing users to be for admins only, got %d", denied.Code)
+	}
+}
+
+func TestEmailsAreUniqueOnceNormalised(t *testing.T) {
+	// Emails are only indexed while encrypted, they must stay unique without it
+	setConfig(t, &config.FieldEncryptionKeys, nil)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	alice := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+	bob := addUser(t, store, "bob", auth.ROLE_ZONE_ADMIN)
+
+	if updated := serve(c, http.MethodPatch, "/api/v1/users/"+alice.ID, admin, `{"email": " alice@EXAMPLE.com "}`); updated.Code != http.StatusOK {
+		t.Fatalf("expected the email to be set, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	stored, _ := store.GetUserById(alice.ID)
+	if stored.Email == nil || *stored.Email != "alice@example.com" {
+		t.Fatalf("expected the email to be stored normalised, got %v", stored.Email)
+	}
+
+	if conflict := serve(c, http.MethodPatch, "/api/v1/users/"+bob.ID, admin, `{"email": "alice@Example.Com"}`); conflict.Code != http.StatusConflict {
+		t.Fatalf("expected the same address in another form to conflict, got %d", conflict.Code)
+	}
+
+	if malformed := serve(c, http.MethodPatch, "/api/v1/users/"+bob.ID, admin, `{"email": "bob@localhost"}`); malformed.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed address to be refused, got %d", malformed.Code)
+	}
+}
+
+func TestNewUsersCantUseTheirUsernameAsAPassword(t *testing.T) {
+	setConfig(t, &config.PasswordDisallowUsername, true)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	refused := serve(c, http.MethodPost, "/api/v1/users/new", admin, entity.NewUserBody{User: entity.User{Username: "operator"}, Password: "Operator2024!"})
+	if refused.Code != http.StatusBadRequest {
+		t.Fatalf("expected a password based on the username to be refused, got %d: %s", refused.Code, refused.Body.String())
+	}
+
+	accepted := serve(c, http.MethodPost, "/api/v1/users/new", admin, entity.NewUserBody{User: entity.User{Username: "operator"}, Password: "Correct-Horse-Battery-Staple-42"})
+	if accepted.Code != http.StatusCreated {
+		t.Fatalf("expected an unrelated password to be accepted, got %d: %s", accepted.Code, accepted.Body.String())
+	}
+}
+
+func TestBulkDisableReportsEachUserAsMultiStatus(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := addUser(t, store, "admin", auth.ROLE_ADMIN)
+	operator := addUser(t, store, "operator")
+
+	disabled := serve(c, http.MethodPost, "/api/v1/users/bulk/disabled", tokenFor(t, admin), entity.BulkDisableBody{IDs: []string{operator.ID, "missing", admin.ID}, Disabled: true})
+	if disabled.Code != http.StatusMultiStatus {
+		t.Fatalf("expected a multi-status response, got %d: %s", disabled.Code, disabled.Body.String())
+	}
+
+	results := []entity.BulkResult{}
+	decodeResults(t, disabled, &results)
+
+	expected := []entity.BulkResult{
+		{ID: operator.ID, Status: http.StatusOK, Success: true},
+		{ID: "missing", Status: http.StatusNotFound, Error: "user does not exist"},
+		{ID: admin.ID, Status: http.StatusConflict, Error: "unable to disable the last enabled admin"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, results)
+	}
+
+	if stored, _ := store.GetUserById(operator.ID); !stored.Disabled {
+		t.Fatal("expected the accepted user to be disabled")
+	}
+
+	if stored, _ := store.GetUserById(admin.ID); stored.Disabled {
+		t.Fatal("expected the last enabled admin to stay enabled")
+	}
+}
+
+func TestAccessWhatIfMatchesApplyingTheChange(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	user := addUser(t, store, "alice", auth.ROLE_ZONE_ADMIN)
+
+	user.Zones = []string{"example.org"}
+	if saveErr := store.SaveUser(user); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	for _, name := range []string{"example.com", "studio.example.com", "example.org"} {
+		addZone(t, store, name, false)
+	}
+
+	change := entity.AccessWhatIfBody{AddRoles: []string{auth.ROLE_ADMIN}, RemoveRoles: []string{auth.ROLE_ZONE_ADMIN}, AddZones: []string{"example.com"}, RemoveZones: []string{"example.org"}}
+	previewed := serve(c, http.MethodPost, "/api/v1/users/"+user.ID+"/access/what-if", admin, change)
+	if previewed.Code != http.StatusOK {
+		t.Fatalf("expected the change to be previewed, got %d: %s", previewed.Code, previewed.Body.String())
+	}
+
+	whatIf := &entity.AccessWhatIf{}
+	decodeResource(t, previewed, whatIf)
+
+	if unchanged, _ := store.GetUserById(user.ID); !reflect.DeepEqual(unchanged.Roles, user.Roles) || !reflect.DeepEqual(unchanged.Zones, user.Zones) {
+		t.Fatalf("expected the preview not to store anything, got %+v", unchanged)
+	}
+
+	if !reflect.DeepEqual(whatIf.LostZones, []string{"example.org"}) || !reflect.DeepEqual(whatIf.LostPermissions, []string{}) || len(whatIf.GainedPermissions) == 0 {
+		t.Fatalf("expected the preview to report what changes, got %+v", whatIf)
+	}
+
+	// Roles can't be changed through the API yet, so they are applied to the store directly
+	applied, _ := store.GetUserById(user.ID)
+	applied.Roles = whatIf.Roles
+	if saveErr := store.SaveUser(applied); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	zones, _ := json.Marshal(whatIf.Zones)
+	if updated := serve(c, http.MethodPatch, "/api/v1/users/"+user.ID, admin, `{"zones": `+string(zones)+`}`); updated.Code != http.StatusOK {
+		t.Fatalf("expected the zones to be applied, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	stored, _ := store.GetUserById(user.ID)
+	if permissions := auth.PermissionsForRoles(stored.Roles); !reflect.DeepEqual(permissions, whatIf.Permissions) {
+		t.Fatalf("expected the applied roles to grant %v, got %v", whatIf.Permissions, permissions)
+	}
+
+	effective := serve(c, http.MethodGet, "/api/v1/users/"+user.ID+"/zones/effective", admin, nil)
+	if effective.Code != http.StatusOK {
+		t.Fatalf("expected the effective zones to be listed, got %d: %s", effective.Code, effective.Body.String())
+	}
+
+	effectiveZones := []entity.Zone{}
+	decodeResults(t, effective, &effectiveZones)
+
+	names := []string{}
+	for _, zone := range effectiveZones {
+		names = append(names, zone.Name)
+	}
+	sort.Strings(names)
+
+	previewedZones := append([]string{}, whatIf.EffectiveZones...)
+	sort.Strings(previewedZones)
+
+	if !reflect.DeepEqual(names, previewedZones) {
+		t.Fatalf("expected the applied zones to be %v, got %v", previewedZones, names)
+	}
+
+	if listed := serve(c, http.MethodGet, "/api/v1/users", tokenFor(t, stored), nil); listed.Code != http.StatusOK {
+		t.Fatalf("expected a previewed permission to be granted once applied, got %d", listed.Code)
+	}
+}
+
+func TestBulkChangesAreLoggedAsOneBatch(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	ids := []string{}
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		ids = append(ids, addUser(t, store, name).ID)
+	}
+
+	var mu sync.Mutex
+	logged := []events.Event{}
+	handler, flush := events.Batched(func(event events.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		logged = append(logged, event)
+	}, map[string]time.Duration{events.USER_UPDATED: time.Hour})
+	c.events.Subscribe("log", 100, handler)
+
+	if disabled := serve(c, http.MethodPost, "/api/v1/users/bulk/disabled", admin, entity.BulkDisableBody{IDs: ids, Disabled: true}); disabled.Code != http.StatusMultiStatus {
+		t.Fatalf("expected the users to be disabled, got %d: %s", disabled.Code, disabled.Body.String())
+	}
+
+	if !c.events.Close(time.Second) {
+		t.Fatal("expected the events to be handled")
+	}
+	flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(logged) != 1 || logged[0].Type != events.USER_UPDATED+events.BATCH_SUFFIX {
+		t.Fatalf("expected one aggregated event for the bulk change, got %+v", logged)
+	}
+
+	if batch, ok := logged[0].Data.(events.Batch); !ok || batch.Count != len(ids) || !reflect.DeepEqual(batch.TargetIDs, ids) {
+		t.Fatalf("expected the batch to list every user in order, got %+v", logged[0].Data)
+	}
+}
@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/auth"
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+func TestZoneRecordsMustBelongToTheURLZone(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	frozen := addZone(t, store, "frozen.example", true)
+	open := addZone(t, store, "open.example", false)
+	record := addRecord(t, store, frozen, "www.frozen.example")
+
+	frozenBefore, _ := store.GetZoneByID(frozen.ID)
+	openBefore, _ := store.GetZoneByID(open.ID)
+
+	update := serve(c, http.MethodPatch, "/api/v1/zones/"+open.ID+"/records/"+record.ID, admin, entity.Record{Target: "192.0.2.99", TTL: 60})
+	if update.Code != http.StatusBadRequest {
+		t.Fatalf("expected updating a record through another zone's URL to fail, got %d", update.Code)
+	}
+
+	remove := serve(c, http.MethodDelete, "/api/v1/zones/"+open.ID+"/records/"+record.ID, admin, nil)
+	if remove.Code != http.StatusBadRequest {
+		t.Fatalf("expected deleting a record through another zone's URL to fail, got %d", remove.Code)
+	}
+
+	stored, storedErr := store.GetRecordByID(record.ID)
+	if storedErr != nil {
+		t.Fatalf("expected the record in the read-only zone to still exist, got %s", storedErr)
+	}
+
+	if stored.Target != record.Target || stored.TTL != record.TTL {
+		t.Fatalf("expected the record in the read-only zone to be unchanged, got %+v", stored)
+	}
+
+	frozenAfter, _ := store.GetZoneByID(frozen.ID)
+	openAfter, _ := store.GetZoneByID(open.ID)
+	if frozenAfter.UpdatedAt != frozenBefore.UpdatedAt || openAfter.UpdatedAt != openBefore.UpdatedAt {
+		t.Fatal("expected neither zone's SOA to be bumped by a rejected change")
+	}
+}
+
+func TestZoneRecordChangesThroughTheirOwnZone(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	zone := addZone(t, store, "open.example", false)
+	record := addRecord(t, store, zone, "www.open.example")
+
+	update := serve(c, http.MethodPatch, "/api/v1/zones/"+zone.ID+"/records/"+record.ID, admin, entity.Record{Target: "192.0.2.99", TTL: 60})
+	if update.Code != http.StatusOK {
+		t.Fatalf("expected the record to be updated, got %d: %s", update.Code, update.Body.String())
+	}
+
+	remove := serve(c, http.MethodDelete, "/api/v1/zones/"+zone.ID+"/records/"+record.ID, admin, nil)
+	if remove.Code != http.StatusOK {
+		t.Fatalf("expected the record to be deleted, got %d: %s", remove.Code, remove.Body.String())
+	}
+
+	if _, storedErr := store.GetRecordByID(record.ID); storedErr == nil {
+		t.Fatal("expected the record to be gone")
+	}
+}
+
+func TestReadOnlyZonesRefuseChangesWhileOthersStayWritable(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	frozen := addZone(t, store, "frozen.example", false)
+	open := addZone(t, store, "open.example", false)
+	frozenRecord := addRecord(t, store, frozen, "www.frozen.example")
+	openRecord := addRecord(t, store, open, "www.open.example")
+
+	if set := serve(c, http.MethodPut, "/api/v1/zones/"+frozen.ID+"/read-only", admin, entity.ZoneReadOnlyBody{ReadOnly: true}); set.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be made read-only, got %d: %s", set.Code, set.Body.String())
+	}
+
+	writes := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodPost, "/records/new", entity.Record{Name: "mail", Type: "A", Target: "192.0.2.25", TTL: 300}},
+		{http.MethodPatch, "/records/" + frozenRecord.ID, entity.Record{Target: "192.0.2.99", TTL: 60}},
+		{http.MethodDelete, "/records/" + frozenRecord.ID, nil},
+	}
+
+	for _, write := range writes {
+		refused := serve(c, write.method, "/api/v1/zones/"+frozen.ID+write.path, admin, write.body)
+		if refused.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected %s %s in a read-only zone to be refused, got %d: %s", write.method, write.path, refused.Code, refused.Body.String())
+		}
+	}
+
+	if stored, storedErr := store.GetRecordByID(frozenRecord.ID); storedErr != nil || stored.Target != frozenRecord.Target {
+		t.Fatalf("expected the read-only zone's record to be unchanged, got %+v, %v", stored, storedErr)
+	}
+
+	if deleted := serve(c, http.MethodDelete, "/api/v1/zones/"+frozen.ID, admin, nil); deleted.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected deleting a read-only zone to be refused, got %d", deleted.Code)
+	}
+
+	if updated := serve(c, http.MethodPatch, "/api/v1/zones/"+open.ID+"/records/"+openRecord.ID, admin, entity.Record{Target: "192.0.2.99", TTL: 60}); updated.Code != http.StatusOK {
+		t.Fatalf("expected other zones to stay writable, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	if set := serve(c, http.MethodPut, "/api/v1/zones/"+frozen.ID+"/read-only", admin, entity.ZoneReadOnlyBody{ReadOnly: false}); set.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be made writable again, got %d: %s", set.Code, set.Body.String())
+	}
+
+	if updated := serve(c, http.MethodPatch, "/api/v1/zones/"+frozen.ID+"/records/"+frozenRecord.ID, admin, entity.Record{Target: "192.0.2.99", TTL: 60}); updated.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be writable once maintenance ends, got %d: %s", updated.Code, updated.Body.String())
+	}
+}
+
+func TestZoneWritesFailWhenTheZoneCantBeLookedUp(t *testing.T) {
+	_, store := newTestController(t)
+
+	zone := addZone(t, store, "example.com", true)
+
+	// Handlers look the zone up again themselves, so zoneWritable is served alone to see what it lets through
+	reached := false
+	engine := gin.New()
+	engine.DELETE("/zones/:zone", zoneWritable, func(c *gin.Context) { reached = true })
+
+	store.fail("GetZoneByID", errStoreDown)
+	failed := httptest.NewRecorder()
+	engine.ServeHTTP(failed, httptest.NewRequest(http.MethodDelete, "/zones/"+zone.ID, nil))
+	store.fail("GetZoneByID", nil)
+
+	if failed.Code != http.StatusInternalServerError || reached {
+		t.Fatalf("expected a failed zone lookup to stop the change, got %d and reached the handler %t", failed.Code, reached)
+	}
+
+	missing := httptest.NewRecorder()
+	engine.ServeHTTP(missing, httptest.NewRequest(http.MethodDelete, "/zones/unknown", nil))
+	if !reached {
+		t.Fatalf("expected a zone that doesn't exist to be left for the handler to report, got %d", missing.Code)
+	}
+}
+
+func TestZoneRecordsFailWhenTheZoneCantBeLookedUp(t *testing.T) {
+	c, store := newTestController(t)
+
+	zone := addZone(t, store, "example.com", false)
+
+	// Served without zoneWritable, which would otherwise refuse the new record before its handler looks the zone up
+	engine := gin.New()
+	engine.GET("/zones/:zone/records", c.handleZoneRecords)
+	engine.POST("/zones/:zone/records/new", c.HandleNewZoneRecord)
+
+	store.fail("GetZoneByID", errStoreDown)
+	defer store.fail("GetZoneByID", nil)
+
+	listed := httptest.NewRecorder()
+	engine.ServeHTTP(listed, serveRequest(http.MethodGet, "/zones/"+zone.ID+"/records", "", nil))
+	if listed.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a failed zone lookup to be a server error when listing records, got %d: %s", listed.Code, listed.Body.String())
+	}
+
+	created := httptest.NewRecorder()
+	engine.ServeHTTP(created, serveRequest(http.MethodPost, "/zones/"+zone.ID+"/records/new", "", entity.Record{Name: "www.example.com", Type: "A", Target: "192.0.2.1", TTL: 60}))
+	if created.Code != http.StatusInternalServerError {
+		t.Fatalf("expected a failed zone lookup to be a server error when creating a record, got %d: %s", created.Code, created.Body.String())
+	}
+
+	if records, _ := store.GetZoneRecords(zone.ID, ""); len(records) != 0 {
+		t.Fatalf("expected no record to be created when the zone couldn't be looked up, got %+v", records)
+	}
+}
+
+func TestApprovedZoneDeletionRefusesReadOnlyZones(t *testing.T) {
+	setConfig(t, &config.ApprovalActions, []string{APPROVAL_DELETE_ZONE})
+
+	c, store := newTestController(t)
+
+	requester := tokenFor(t, addUser(t, store, "requester", auth.ROLE_ADMIN))
+	approver := tokenFor(t, addUser(t, store, "approver", auth.ROLE_ADMIN))
+	zone := addZone(t, store, "example.com", false)
+
+	requested := serve(c, http.MethodDelete, "/api/v1/zones/"+zone.ID, requester, nil)
+	if requested.Code != http.StatusAccepted {
+		t.Fatalf("expected the deletion to wait for approval, got %d: %s", requested.Code, requested.Body.String())
+	}
+
+	action := &entity.PendingAction{}
+	decodeResource(t, requested, action)
+
+	zone.ReadOnly = true
+	if saveErr := store.SaveZone(zone); saveErr != nil {
+		t.Fatal(saveErr)
+	}
+
+	approved := serve(c, http.MethodPost, "/api/v1/approvals/"+action.ID+"/approve", approver, nil)
+	if approved.Code != http.StatusInternalServerError {
+		t.Fatalf("expected approving the deletion of a read-only zone to fail, got %d", approved.Code)
+	}
+
+	if _, zoneErr := store.GetZoneByID(zone.ID); zoneErr != nil {
+		t.Fatalf("expected the read-only zone to still exist, got %s", zoneErr)
+	}
+
+	failed, _ := store.GetPendingActionByID(action.ID)
+	if failed.Status != entity.APPROVAL_FAILED {
+		t.Fatalf("expected the action to be recorded as failed, got %s", failed.Status)
+	}
+}
+
+func TestRecordsOfDeletedZonesAreKeptButCantChange(t *testing.T) {
+	setConfig(t, &config.GoneForDeleted, true)
+
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	zone := addZone(t, store, "example.com", false)
+	record := addRecord(t, store, zone, "www.example.com")
+
+	if deleted := serve(c, http.MethodDelete, "/api/v1/zones/"+zone.ID, admin, nil); deleted.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be deleted, got %d: %s", deleted.Code, deleted.Body.String())
+	}
+
+	update := serve(c, http.MethodPatch, "/api/v1/zones/"+zone.ID+"/records/"+record.ID, admin, entity.Record{Target: "192.0.2.99", TTL: 60})
+	if update.Code != http.StatusGone {
+		t.Fatalf("expected updating a record of a deleted zone to be gone, got %d: %s", update.Code, update.Body.String())
+	}
+
+	remove := serve(c, http.MethodDelete, "/api/v1/zones/"+zone.ID+"/records/"+record.ID, admin, nil)
+	if remove.Code != http.StatusGone {
+		t.Fatalf("expected deleting a record of a deleted zone to be gone, got %d: %s", remove.Code, remove.Body.String())
+	}
+
+	created := serve(c, http.MethodPost, "/api/v1/zones/"+zone.ID+"/records/new", admin, entity.Record{Name: "mail.example.com", Type: "A", Target: "192.0.2.2", TTL: 300})
+	if created.Code != http.StatusGone {
+		t.Fatalf("expected adding a record to a deleted zone to be gone, got %d: %s", created.Code, created.Body.String())
+	}
+
+	if restored := serve(c, http.MethodPost, "/api/v1/zones/"+zone.ID+"/restore", admin, nil); restored.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be restored, got %d: %s", restored.Code, restored.Body.String())
+	}
+
+	stored, storedErr := store.GetRecordByID(record.ID)
+	if storedErr != nil {
+		t.Fatalf("expected the record to be kept through the deletion, got %s", storedErr)
+	}
+
+	if stored.Target != record.Target || stored.TTL != record.TTL {
+		t.Fatalf("expected the record to be unchanged while its zone was deleted, got %+v", stored)
+	}
+
+	if update := serve(c, http.MethodPatch, "/api/v1/zones/"+zone.ID+"/records/"+record.ID, admin, entity.Record{Target: "192.0.2.99", TTL: 60}); update.Code != http.StatusOK {
+		t.Fatalf("expected the record to be changeable once its zone is restored, got %d: %s", update.Code, update.Body.String())
+	}
+}
+
+func TestZoneCreateAndUpdateReturnTheZone(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+
+	created := serve(c, http.MethodPost, "/api/v1/zones/new", admin, entity.Zone{Name: "example.com"})
+	if created.Code != http.StatusCreated {
+		t.Fatalf("expected the zone to be created, got %d: %s", created.Code, created.Body.String())
+	}
+
+	zone := &entity.Zone{}
+	decodeResource(t, created, zone)
+	if zone.ID == "" || zone.Name != "example.com" {
+		t.Fatalf("expected the created zone to be returned, got %+v", zone)
+	}
+
+	if location := created.Header().Get("Location"); !strings.HasSuffix(location, "/api/v1/zones/"+zone.ID) {
+		t.Fatalf("expected the Location of the created zone, got %q", location)
+	}
+
+	updated := serve(c, http.MethodPut, "/api/v1/zones/"+zone.ID+"/read-only", admin, map[string]bool{"readOnly": true})
+	if updated.Code != http.StatusOK {
+		t.Fatalf("expected the zone to be updated, got %d: %s", updated.Code, updated.Body.String())
+	}
+
+	changed := &entity.Zone{}
+	decodeResource(t, updated, changed)
+	if changed.ID != zone.ID || changed.Name != zone.Name || !changed.ReadOnly {
+		t.Fatalf("expected the updated zone to be returned, got %+v", changed)
+	}
+
+	if stored, _ := store.GetZoneByID(zone.ID); !stored.ReadOnly {
+		t.Fatal("expected the update to be stored")
+	}
+
+	if missing := serve(c, http.MethodPut, "/api/v1/zones/missing/read-only", admin, map[string]bool{"readOnly": false}); missing.Code != http.StatusNotFound {
+		t.Fatalf("expected updating a missing zone to be not found, got %d", missing.Code)
+	}
+}
+
+func TestZonesExistIgnoresCase(t *testing.T) {
+	c, store := newTestController(t)
+
+	admin := tokenFor(t, addUser(t, store, "admin", auth.ROLE_ADMIN))
+	addZone(t, store, "example.com", false)
+
+	checked := serve(c, http.MethodPost, "/api/v1/zones/exists", admin, entity.ZoneExistenceBody{Names: []string{"Example.COM", "missing.example", "example.com", "MISSING.example"}})
+	if checked.Code != http.StatusOK {
+		t.Fatalf("expected the zones to be checked, got %d: %s", checked.Code, checked.Body.String())
+	}
+
+	result := &entity.ZoneExistence{}
+	decodeResource(t, checked, result)
+
+	if len(result.Exists) != 1 || result.Exists[0] != "Example.COM" {
+		t.Fatalf("expected the zone to exist under the first spelling asked for, got %v", result.Exists)
+	}
+
+	if len(result.Missing) != 1 || result.Missing[0] != "missing.example" {
+		t.Fatalf("expected the missing zone to be reported once, got %v", result.Missing)
+	}
+}
@@ -0,0 +1,100 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+// Encrypted values are stored as enc:<key id>:<base64 nonce and ciphertext>
+const PREFIX string = "enc:"
+
+var ErrMalformed = errors.New("malformed encrypted value")
+
+// Field encryption is on once FIELD_ENCRYPTION_KEYS is set
+func Enabled() bool {
+	return len(config.FieldEncryptionKeys) > 0
+}
+
+// Encrypt a value with AES-GCM under the current key, naming the key alongside it so it can still be read after a rotation
+func Encrypt(plaintext []byte) (string, error) {
+	aead, aeadErr := newAEAD(config.FieldEncryptionKeyID)
+	if aeadErr != nil {
+		return "", aeadErr
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, randErr := rand.Read(nonce); randErr != nil {
+		return "", fmt.Errorf("unable to generate nonce: %s", randErr)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(config.FieldEncryptionKeyID))
+
+	return PREFIX + config.FieldEncryptionKeyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Report whether a stored value was encrypted by Encrypt, rather than written before encryption was turned on
+func Encrypted(stored string) bool {
+	return strings.HasPrefix(stored, PREFIX)
+}
+
+// Report whether a stored value is encrypted under the current key, anything else should be encrypted again
+func Current(stored string) bool {
+	return strings.HasPrefix(stored, PREFIX+config.FieldEncryptionKeyID+":")
+}
+
+// Decrypt a value from Encrypt with whichever configured key it names
+func Decrypt(stored string) ([]byte, error) {
+	id, encoded, found := strings.Cut(strings.TrimPrefix(stored, PREFIX), ":")
+	if !Encrypted(stored) || !found {
+		return nil, ErrMalformed
+	}
+
+	aead, aeadErr := newAEAD(id)
+	if aeadErr != nil {
+		return nil, aeadErr
+	}
+
+	sealed, decodeErr := base64.StdEncoding.DecodeString(encoded)
+	if decodeErr != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	plaintext, openErr := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if openErr != nil {
+		return nil, fmt.Errorf("unable to decrypt value with key %s: %s", id, openErr)
+	}
+
+	return plaintext, nil
+}
+
+// A keyed hash of a value, so an encrypted column can still be looked up and kept unique without decrypting every row
+func Index(value string) string {
+	mac := hmac.New(sha256.New, config.FieldEncryptionIndexKey)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAEAD(id string) (cipher.AEAD, error) {
+	key, ok := config.FieldEncryptionKeys[id]
+	if !ok {
+		return nil, fmt.Errorf("field encryption key %s is not configured", id)
+	}
+
+	block, blockErr := aes.NewCipher(key)
+	if blockErr != nil {
+		return nil, blockErr
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,91 @@
+package encryption
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+// Configure field encryption with the given keys for the rest of the test, encrypting under current
+func useKeys(t *testing.T, current string, ids ...string) {
+	t.Helper()
+
+	previousKeys, previousID := config.FieldEncryptionKeys, config.FieldEncryptionKeyID
+	t.Cleanup(func() { config.FieldEncryptionKeys, config.FieldEncryptionKeyID = previousKeys, previousID })
+
+	keys := map[string][]byte{}
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+
+	config.FieldEncryptionKeys = keys
+	config.FieldEncryptionKeyID = current
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	useKeys(t, "2024", "2024")
+
+	stored, encryptErr := Encrypt([]byte("admin@example.com"))
+	if encryptErr != nil {
+		t.Fatal(encryptErr)
+	}
+
+	if !Encrypted(stored) || !Current(stored) || strings.Contains(stored, "admin@example.com") {
+		t.Fatalf("expected ciphertext under the current key, got %s", stored)
+	}
+
+	plaintext, decryptErr := Decrypt(stored)
+	if decryptErr != nil || string(plaintext) != "admin@example.com" {
+		t.Fatalf("expected the value back, got %q, %v", plaintext, decryptErr)
+	}
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	useKeys(t, "2024", "2024", "2025")
+
+	stored, encryptErr := Encrypt([]byte("admin@example.com"))
+	if encryptErr != nil {
+		t.Fatal(encryptErr)
+	}
+
+	config.FieldEncryptionKeyID = "2025"
+
+	if Current(stored) {
+		t.Fatal("expected a value under the previous key to no longer be current")
+	}
+
+	plaintext, decryptErr := Decrypt(stored)
+	if decryptErr != nil || string(plaintext) != "admin@example.com" {
+		t.Fatalf("expected the previous key to still decrypt, got %q, %v", plaintext, decryptErr)
+	}
+
+	delete(config.FieldEncryptionKeys, "2024")
+	if _, decryptErr := Decrypt(stored); decryptErr == nil {
+		t.Fatal("expected decrypting with a removed key to fail")
+	}
+}
+
+func TestDecryptRefusesTamperedValues(t *testing.T) {
+	useKeys(t, "2024", "2024", "2025")
+
+	stored, encryptErr := Encrypt([]byte("admin@example.com"))
+	if encryptErr != nil {
+		t.Fatal(encryptErr)
+	}
+
+	// The key id is authenticated, so a value can't be moved under another key
+	moved := strings.Replace(stored, PREFIX+"2024:", PREFIX+"2025:", 1)
+	if _, decryptErr := Decrypt(moved); decryptErr == nil {
+		t.Fatal("expected a value relabelled with another key to fail")
+	}
+
+	if _, decryptErr := Decrypt(stored[:len(stored)-4] + "AAA="); decryptErr == nil {
+		t.Fatal("expected a modified value to fail")
+	}
+
+	if _, decryptErr := Decrypt("admin@example.com"); decryptErr != ErrMalformed {
+		t.Fatalf("expected a plain text value to be malformed, got %v", decryptErr)
+	}
+}
@@ -0,0 +1,25 @@
+package entity
+
+import "time"
+
+const (
+	APPROVAL_PENDING  string = "pending"
+	APPROVAL_APPROVED string = "approved"
+	APPROVAL_REJECTED string = "rejected"
+	APPROVAL_FAILED   string = "failed"
+)
+
+// A critical action waiting for a second admin to approve it before it is carried out
+type PendingAction struct {
+	ID          string     `json:"id" gorm:"primaryKey;<-:create"`
+	TenantID    string     `json:"tenantId" gorm:"index;<-:create"`
+	Action      string     `json:"action" gorm:"<-:create"`
+	TargetType  string     `json:"targetType" gorm:"<-:create"`
+	TargetID    string     `json:"targetId" gorm:"<-:create"`
+	RequestedBy string     `json:"requestedBy" gorm:"<-:create"`
+	Status      string     `json:"status" gorm:"index"`
+	DecidedBy   string     `json:"decidedBy,omitempty"`
+	DecidedAt   *time.Time `json:"decidedAt,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+type AuditEntry struct {
+	ID         string      `json:"id" gorm:"primaryKey;<-:create"`
+	TenantID   string      `json:"tenantId" gorm:"index"`
+	Type       string      `json:"type"`
+	Actor      string      `json:"actor"`
+	TargetType string      `json:"targetType" gorm:"index:idx_audit_target"`
+	TargetID   string      `json:"targetId" gorm:"index:idx_audit_target"`
+	Data       interface{} `json:"data" gorm:"serializer:encrypted"` // Encrypted along with the user fields it may copy, see FIELD_ENCRYPTION_KEYS
+	CreatedAt  time.Time   `json:"createdAt" gorm:"index"`
+}
@@ -0,0 +1,17 @@
+package entity
+
+import "time"
+
+type BreakGlassBody struct {
+	Credential string `json:"credential"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+}
+
+// Records that a break-glass credential has been spent so it can never be used again
+type BreakGlassUse struct {
+	CredentialHash string    `json:"-" gorm:"primaryKey;<-:create"`
+	Username       string    `json:"username"`
+	RemoteAddress  string    `json:"remoteAddress"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
@@ -0,0 +1,25 @@
+package entity
+
+import "time"
+
+// The current version of the export bundle format, bump it whenever the shape of Bundle changes
+const BUNDLE_VERSION int = 1
+
+// A portable export of every user and zone, for moving between instances or disaster recovery
+type Bundle struct {
+	Version    int          `json:"version"`
+	ExportedAt time.Time    `json:"exportedAt"`
+	Users      []BundleUser `json:"users"`
+	Zones      []BundleZone `json:"zones"`
+}
+
+// A user including their password hash, plain text passwords are never stored so can't be exported
+type BundleUser struct {
+	User
+	PasswordHash string `json:"passwordHash"`
+}
+
+type BundleZone struct {
+	Zone
+	Records []*Record `json:"records"`
+}
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// A single login attempt against a known user, kept so users can spot access they don't recognise
+type LoginAttempt struct {
+	ID        string    `json:"id" gorm:"primaryKey;<-:create"`
+	UserID    string    `json:"userId" gorm:"index:idx_login_user;<-:create"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index:idx_login_user"`
+}
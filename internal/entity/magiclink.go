@@ -0,0 +1,26 @@
+package entity
+
+import "time"
+
+// A single use password-less login link, only the hash of its token is stored
+type MagicLink struct {
+	ID        string     `json:"id" gorm:"primaryKey;<-:create"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;size:64;<-:create"`
+	UserID    string     `json:"userId" gorm:"index;<-:create"`
+	ExpiresAt time.Time  `json:"expiresAt" gorm:"<-:create"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type MagicLinkBody struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkLoginBody struct {
+	Token string `json:"token"`
+}
+
+// The same response is given whether or not a link was sent, so it can't be used to find registered addresses
+type MagicLinkResponse struct {
+	Message string `json:"message"`
+}
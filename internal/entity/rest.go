@@ -1,12 +1,34 @@
 package entity
 
+import "time"
+
 type RESTError struct {
-	StatusCode int    `json:"code"`
-	Message    string `json:"message"`
-	Error      string `json:"error"`
+	StatusCode int        `json:"code"`
+	Message    string     `json:"message"`
+	Error      string     `json:"error"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"` // Only set on 410 responses to admins
 }
 
 type RESTResult struct {
 	Results      interface{} `json:"results"`
-	TotalResults int         `json:"total_results"`
+	TotalResults int         `json:"totalResults"`
+}
+
+// The outcome for one item of a bulk request, Status is the HTTP status the item would have had on its own
+type BulkResult struct {
+	ID      string `json:"id"`
+	Type    string `json:"type,omitempty"`
+	Status  int    `json:"status"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Ready    bool   `json:"ready"`
+	Draining bool   `json:"draining"`
+	Database string `json:"database"`
+}
+
+type Liveness struct {
+	Live bool `json:"live"`
 }
@@ -0,0 +1,6 @@
+package entity
+
+type RolePermission struct {
+	Permission  string `json:"permission"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,23 @@
+package entity
+
+import "time"
+
+// An organisation sharing the deployment, it owns its users, zones, pending actions and audit log
+// Anything without a tenant belongs to the provider running the deployment
+type Tenant struct {
+	ID        string    `json:"id" gorm:"primaryKey;<-:create"`
+	Name      string    `json:"name" gorm:"unique;<-:create"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// A new tenant and the first admin of it, who can then create the rest of its users
+type NewTenantBody struct {
+	Name          string `json:"name"`
+	AdminUsername string `json:"adminUsername"`
+	AdminPassword string `json:"adminPassword"`
+}
+
+type NewTenantResponse struct {
+	Tenant *Tenant `json:"tenant"`
+	Admin  *User   `json:"admin"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+type TokenInspectBody struct {
+	Token string `json:"token"`
+}
+
+type TokenInspection struct {
+	Algorithm      string                 `json:"algorithm"`
+	KeyID          string                 `json:"kid"`
+	Header         map[string]interface{} `json:"header"`
+	Claims         map[string]interface{} `json:"claims"`
+	ExpiresAt      *time.Time             `json:"expiresAt"`
+	Expired        bool                   `json:"expired"`
+	SignatureValid bool                   `json:"signatureValid"`
+	Valid          bool                   `json:"valid"`
+	Error          string                 `json:"error,omitempty"`
+}
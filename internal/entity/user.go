@@ -11,6 +11,10 @@ type LoginBody struct {
 	Password string `json:"password"`
 }
 
+type ReauthBody struct {
+	Password string `json:"password"`
+}
+
 type LoginResponse struct {
 	Username string   `json:"username"`
 	Token    string   `json:"token"`
@@ -20,16 +24,87 @@ type LoginResponse struct {
 
 type User struct {
 	ID           string                `json:"id" gorm:"<-:create"`
+	TenantID     string                `json:"tenantId" gorm:"index;<-:create"`
 	Username     string                `json:"username" gorm:"unique;<-:create"`
 	PasswordHash string                `json:"-"`
+	Email        *string               `json:"email" gorm:"size:512;serializer:encrypted"` // Stored normalised, see utilities.NormalizeEmail, unique unless encrypted
+	EmailIndex   *string               `json:"-" gorm:"unique;size:64"`                    // Looks up encrypted emails and keeps them unique, see FIELD_ENCRYPTION_KEYS
 	Roles        []string              `json:"roles" gorm:"serializer:json"`
 	Zones        []string              `json:"zones" gorm:"serializer:json"`
-	CreatedAt    time.Time             `json:"created_at"`
-	UpdatedAt    time.Time             `json:"updated_at"`
-	DeletedAt    soft_delete.DeletedAt `json:"deleted_at"`
+	Labels       map[string]string     `json:"labels" gorm:"serializer:encrypted"`
+	Disabled     bool                  `json:"disabled"`
+	TokenTTL     *int                  `json:"tokenTtl"` // Token lifetime override in minutes, null uses the default
+	CreatedAt    time.Time             `json:"createdAt"`
+	UpdatedAt    time.Time             `json:"updatedAt"`
+	DeletedAt    soft_delete.DeletedAt `json:"deletedAt"`
 }
 
 type NewUserBody struct {
 	User
-	Password string `json:"password"`
+	Password         string `json:"password"`
+	GeneratePassword bool   `json:"generatePassword"`
+}
+
+type NewUserResponse struct {
+	User              *User  `json:"user"`
+	GeneratedPassword string `json:"generatedPassword,omitempty"`
+}
+
+// The subset of a user that can be changed with a JSON Patch
+type UserPatch struct {
+	Email    *string  `json:"email"`
+	Zones    []string `json:"zones"`
+	TokenTTL *int     `json:"tokenTtl"`
+}
+
+type LabelBody struct {
+	Value string `json:"value"`
+}
+
+// Assign Zone to (or with Remove, remove it from) every user matching all of the Labels selectors
+type LabelZoneBody struct {
+	Labels []string `json:"labels"`
+	Zone   string   `json:"zone"`
+	Remove bool     `json:"remove"`
+}
+
+type LabelZoneResult struct {
+	Matched  int `json:"matched"`
+	Affected int `json:"affected"`
+}
+
+type BulkDisableBody struct {
+	IDs      []string `json:"ids"`
+	Disabled bool     `json:"disabled"`
+}
+
+// Proposed changes to a user's roles and zones to preview without applying them
+type AccessWhatIfBody struct {
+	AddRoles    []string `json:"addRoles"`
+	RemoveRoles []string `json:"removeRoles"`
+	AddZones    []string `json:"addZones"`
+	RemoveZones []string `json:"removeZones"`
+}
+
+// What a user could do once the proposed changes were applied, and how that differs from now
+type AccessWhatIf struct {
+	UserID            string   `json:"userId"`
+	Roles             []string `json:"roles"`
+	Zones             []string `json:"zones"`
+	Permissions       []string `json:"permissions"`
+	EffectiveZones    []string `json:"effectiveZones"`
+	GainedPermissions []string `json:"gainedPermissions"`
+	LostPermissions   []string `json:"lostPermissions"`
+	GainedZones       []string `json:"gainedZones"`
+	LostZones         []string `json:"lostZones"`
+}
+
+// The access one user has that another doesn't, and vice versa
+type AccessDiff struct {
+	UserID         string   `json:"userId"`
+	OtherID        string   `json:"otherId"`
+	RolesOnlyUser  []string `json:"rolesOnlyUser"`
+	RolesOnlyOther []string `json:"rolesOnlyOther"`
+	ZonesOnlyUser  []string `json:"zonesOnlyUser"`
+	ZonesOnlyOther []string `json:"zonesOnlyOther"`
 }
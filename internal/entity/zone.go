@@ -8,19 +8,34 @@ import (
 
 type Zone struct {
 	ID        string                `json:"id" gorm:"primaryKey"`
-	Name      string                `json:"name" gorm:"unique;<-:create"`
-	CreatedAt time.Time             `json:"created_at"`
-	UpdatedAt int                   `json:"updated_at"`
-	DeletedAt soft_delete.DeletedAt `json:"deleted_at"`
+	TenantID  string                `json:"tenantId" gorm:"index;uniqueIndex:idx_zones_tenant_name,priority:1;<-:create"`
+	Name      string                `json:"name" gorm:"uniqueIndex:idx_zones_tenant_name,priority:2;<-:create"` // Unique within its tenant
+	ReadOnly  bool                  `json:"readOnly"` // Set while the zone is under maintenance, rejecting changes to it
+	CreatedAt time.Time             `json:"createdAt"`
+	UpdatedAt int                   `json:"updatedAt"`
+	DeletedAt soft_delete.DeletedAt `json:"deletedAt"`
 }
 
 type Record struct {
 	ID        string    `json:"id" gorm:"primaryKey;<-:create"`
-	ZoneID    string    `json:"zone_id" gorm:"<-:create"`
+	ZoneID    string    `json:"zoneId" gorm:"<-:create"`
 	Name      string    `json:"name" gorm:"unique;<-:create"`
 	Type      string    `json:"type"`
 	Target    string    `json:"target"`
 	TTL       int       `json:"ttl"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt int       `json:"updated_at"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt int       `json:"updatedAt"`
+}
+
+type ZoneExistenceBody struct {
+	Names []string `json:"names"`
+}
+
+type ZoneExistence struct {
+	Exists  []string `json:"exists"`
+	Missing []string `json:"missing"`
+}
+
+type ZoneReadOnlyBody struct {
+	ReadOnly bool `json:"readOnly"`
 }
@@ -0,0 +1,166 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Appended to the type of an event to name the event aggregating a batch of them (eg. user.updated.batch)
+const BATCH_SUFFIX string = ".batch"
+
+// The data of an aggregated event, the targets of every event in the batch in the order they were published
+type Batch struct {
+	Count     int       `json:"count"`
+	TargetIDs []string  `json:"targetIds"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+}
+
+// Events are batched by type, tenant and actor, so a batch never mixes changes made by different people
+type batchKey struct {
+	eventType string
+	tenant    string
+	actor     string
+}
+
+type pendingBatch struct {
+	first  Event
+	events []Event
+}
+
+type batcher struct {
+	mu       sync.Mutex
+	deliver  sync.Mutex
+	windows  map[string]time.Duration
+	handler  Handler
+	pending  map[batchKey]*pendingBatch
+	sequence []batchKey
+}
+
+// Wrap a handler so events of the types in windows are collected for that long after the first of them is published
+// and handed to it as a single aggregated event, every other event is passed straight through
+// Batches are delivered in the order they were started and always ahead of any unbatched event published after them
+// The returned func delivers every pending batch straight away, for when the process is about to exit
+func Batched(handler Handler, windows map[string]time.Duration) (Handler, func()) {
+	if len(windows) == 0 {
+		return handler, func() {}
+	}
+
+	batcher := &batcher{
+		windows: windows,
+		handler: handler,
+		pending: map[batchKey]*pendingBatch{},
+	}
+
+	return batcher.handle, batcher.flushPending
+}
+
+func (batcher *batcher) handle(event Event) {
+	window, batched := batcher.windows[event.Type]
+
+	batcher.mu.Lock()
+
+	if !batched {
+		ready := batcher.take(len(batcher.sequence))
+		batcher.deliver.Lock()
+		batcher.mu.Unlock()
+
+		batcher.flush(ready)
+		batcher.handler(event)
+		batcher.deliver.Unlock()
+		return
+	}
+
+	key := batchKey{eventType: event.Type, tenant: event.TenantID, actor: event.Actor}
+	if pending, ok := batcher.pending[key]; ok {
+		pending.events = append(pending.events, event)
+		batcher.mu.Unlock()
+		return
+	}
+
+	batcher.pending[key] = &pendingBatch{first: event, events: []Event{event}}
+	batcher.sequence = append(batcher.sequence, key)
+	batcher.mu.Unlock()
+
+	time.AfterFunc(window, func() { batcher.expire(key) })
+}
+
+// Deliver a batch once its window is over, along with any batches that were started before it
+func (batcher *batcher) expire(key batchKey) {
+	batcher.mu.Lock()
+
+	position := -1
+	for i, pending := range batcher.sequence {
+		if pending == key {
+			position = i
+			break
+		}
+	}
+
+	// Already delivered ahead of a later event
+	if position == -1 {
+		batcher.mu.Unlock()
+		return
+	}
+
+	ready := batcher.take(position + 1)
+	batcher.deliver.Lock()
+	batcher.mu.Unlock()
+
+	batcher.flush(ready)
+	batcher.deliver.Unlock()
+}
+
+// Deliver every pending batch without waiting for its window to end, their timers find nothing left when they fire
+func (batcher *batcher) flushPending() {
+	batcher.mu.Lock()
+	ready := batcher.take(len(batcher.sequence))
+	batcher.deliver.Lock()
+	batcher.mu.Unlock()
+
+	batcher.flush(ready)
+	batcher.deliver.Unlock()
+}
+
+// Remove the oldest count pending batches, the caller must hold mu
+func (batcher *batcher) take(count int) []*pendingBatch {
+	ready := make([]*pendingBatch, 0, count)
+	for _, key := range batcher.sequence[:count] {
+		ready = append(ready, batcher.pending[key])
+		delete(batcher.pending, key)
+	}
+	batcher.sequence = batcher.sequence[count:]
+
+	return ready
+}
+
+// Hand batches to the handler, the caller must hold deliver
+// A batch of one is delivered as the event itself
+func (batcher *batcher) flush(ready []*pendingBatch) {
+	for _, pending := range ready {
+		if len(pending.events) == 1 {
+			batcher.handler(pending.first)
+			continue
+		}
+
+		batch := Batch{
+			Count:     len(pending.events),
+			TargetIDs: make([]string, 0, len(pending.events)),
+			From:      pending.first.Time,
+			To:        pending.events[len(pending.events)-1].Time,
+		}
+
+		for _, event := range pending.events {
+			batch.TargetIDs = append(batch.TargetIDs, event.TargetID)
+		}
+
+		batcher.handler(Event{
+			Type:       pending.first.Type + BATCH_SUFFIX,
+			TenantID:   pending.first.TenantID,
+			Actor:      pending.first.Actor,
+			TargetType: pending.first.TargetType,
+			Data:       batch,
+			Time:       pending.first.Time,
+		})
+	}
+}
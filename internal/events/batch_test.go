@@ -0,0 +1,80 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorded struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorded) handle(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorded) all() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+func TestBatchedAggregatesEventsInTheWindow(t *testing.T) {
+	handled := &recorded{}
+	handler, _ := Batched(handled.handle, map[string]time.Duration{USER_UPDATED: 20 * time.Millisecond})
+
+	handler(Event{Type: USER_UPDATED, Actor: "admin", TargetID: "a"})
+	handler(Event{Type: USER_UPDATED, Actor: "admin", TargetID: "b"})
+	handler(Event{Type: USER_UPDATED, Actor: "other", TargetID: "c"})
+
+	if len(handled.all()) != 0 {
+		t.Fatal("expected batched events to wait for their window")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	events := handled.all()
+	if len(events) != 2 {
+		t.Fatalf("expected one batch per actor, got %+v", events)
+	}
+
+	batch, ok := events[0].Data.(Batch)
+	if events[0].Type != USER_UPDATED+BATCH_SUFFIX || !ok || batch.Count != 2 || batch.TargetIDs[0] != "a" || batch.TargetIDs[1] != "b" {
+		t.Fatalf("expected the admin's changes to be aggregated in order, got %+v", events[0])
+	}
+
+	if events[1].Type != USER_UPDATED || events[1].TargetID != "c" {
+		t.Fatalf("expected a batch of one to be delivered as the event itself, got %+v", events[1])
+	}
+}
+
+func TestBatchesAreDeliveredAheadOfLaterEvents(t *testing.T) {
+	handled := &recorded{}
+	handler, _ := Batched(handled.handle, map[string]time.Duration{USER_UPDATED: time.Hour})
+
+	handler(Event{Type: USER_UPDATED, TargetID: "a"})
+	handler(Event{Type: USER_DELETED, TargetID: "a"})
+
+	events := handled.all()
+	if len(events) != 2 || events[0].Type != USER_UPDATED || events[1].Type != USER_DELETED {
+		t.Fatalf("expected the pending update to be delivered before the deletion, got %+v", events)
+	}
+}
+
+func TestFlushDeliversPendingBatches(t *testing.T) {
+	handled := &recorded{}
+	handler, flush := Batched(handled.handle, map[string]time.Duration{USER_UPDATED: time.Hour})
+
+	handler(Event{Type: USER_UPDATED, TargetID: "a"})
+	handler(Event{Type: USER_UPDATED, TargetID: "b"})
+	flush()
+
+	events := handled.all()
+	if len(events) != 1 || events[0].Type != USER_UPDATED+BATCH_SUFFIX {
+		t.Fatalf("expected the pending batch to be delivered by flush, got %+v", events)
+	}
+}
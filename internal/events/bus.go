@@ -0,0 +1,149 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/logging"
+)
+
+type Handler func(event Event)
+
+type subscriber struct {
+	name    string
+	queue   chan Event
+	handler Handler
+	wait    bool // Publish waits for room in the queue rather than dropping the event
+}
+
+// Bus fans domain events out to every subscriber
+// Each subscriber has its own buffered queue and goroutine so a slow one can never block the publisher,
+// except for those subscribed with SubscribeBlocking that must see every event
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+	closed      bool
+	handling    sync.WaitGroup
+	log         logging.Logger
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: []*subscriber{},
+		log:         logging.Log.With().Str("package", "events").Logger(),
+	}
+}
+
+// Register a handler that will receive every published event
+// Events are dropped (and logged) if more than bufferSize are waiting for this handler
+func (bus *Bus) Subscribe(name string, bufferSize int, handler Handler) {
+	bus.subscribe(&subscriber{
+		name:    name,
+		queue:   make(chan Event, bufferSize),
+		handler: handler,
+	})
+}
+
+// Register a handler that must receive every published event, such as the audit log
+// Once bufferSize events are waiting for this handler Publish blocks until it catches up instead of dropping any
+func (bus *Bus) SubscribeBlocking(name string, bufferSize int, handler Handler) {
+	bus.subscribe(&subscriber{
+		name:    name,
+		queue:   make(chan Event, bufferSize),
+		handler: handler,
+		wait:    true,
+	})
+}
+
+func (bus *Bus) subscribe(sub *subscriber) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	if bus.closed {
+		bus.log.Warn().Str("subscriber", sub.name).Msg("bus is closed, not subscribing")
+		return
+	}
+
+	bus.subscribers = append(bus.subscribers, sub)
+	bus.handling.Add(1)
+
+	go func() {
+		defer bus.handling.Done()
+
+		for event := range sub.queue {
+			sub.handler(event)
+		}
+	}()
+}
+
+// Stop accepting events and wait up to timeout for every subscriber to handle the events already queued for it
+// Returns false if some were still being handled when the timeout passed
+func (bus *Bus) Close(timeout time.Duration) bool {
+	bus.mu.Lock()
+	if !bus.closed {
+		bus.closed = true
+		for _, sub := range bus.subscribers {
+			close(sub.queue)
+		}
+	}
+	bus.mu.Unlock()
+
+	handled := make(chan struct{})
+	go func() {
+		bus.handling.Wait()
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Publish an event to every subscriber without waiting for them to handle it,
+// only waiting for room in the queues of blocking subscribers that have fallen behind
+func (bus *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	if bus.closed {
+		bus.log.Warn().Str("event", event.Type).Msg("bus is closed, dropping event")
+		return
+	}
+
+	for _, sub := range bus.subscribers {
+		if sub.wait {
+			sub.queue <- event
+			continue
+		}
+
+		select {
+		case sub.queue <- event:
+		default:
+			bus.log.Warn().Str("subscriber", sub.name).Str("event", event.Type).Msg("subscriber queue full, dropping event")
+		}
+	}
+}
+
+// A subscriber that writes every event to the application log
+func LogHandler(log logging.Logger) Handler {
+	return func(event Event) {
+		entry := log.Info().
+			Str("event", event.Type).
+			Str("actor", event.Actor).
+			Str("target_type", event.TargetType).
+			Str("target_id", event.TargetID)
+
+		if batch, ok := event.Data.(Batch); ok {
+			entry = entry.Int("count", batch.Count).Strs("target_ids", batch.TargetIDs)
+		}
+
+		entry.Msg("event")
+	}
+}
@@ -0,0 +1,156 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCloseWaitsForQueuedEvents(t *testing.T) {
+	bus := NewBus()
+
+	release := make(chan struct{})
+	handled := []string{}
+	var mu sync.Mutex
+	bus.Subscribe("slow", 10, func(event Event) {
+		<-release
+		mu.Lock()
+		handled = append(handled, event.TargetID)
+		mu.Unlock()
+	})
+
+	for _, id := range []string{"a", "b", "c"} {
+		bus.Publish(Event{Type: USER_UPDATED, TargetID: id})
+	}
+
+	if bus.Close(10 * time.Millisecond) {
+		t.Fatal("expected close to time out while the subscriber is stuck")
+	}
+
+	close(release)
+
+	if !bus.Close(time.Second) {
+		t.Fatal("expected close to return once the queued events were handled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 3 {
+		t.Fatalf("expected every queued event to be handled before close returned, got %v", handled)
+	}
+}
+
+func TestPublishAfterCloseIsDropped(t *testing.T) {
+	bus := NewBus()
+
+	received := make(chan Event, 1)
+	bus.Subscribe("sub", 10, func(event Event) { received <- event })
+	bus.Close(time.Second)
+
+	bus.Publish(Event{Type: USER_UPDATED})
+
+	select {
+	case <-received:
+		t.Fatal("expected events published after close to be dropped")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestPublishReachesEverySubscriber(t *testing.T) {
+	bus := NewBus()
+
+	audit := make(chan Event, 1)
+	notify := make(chan Event, 1)
+	bus.Subscribe("audit", 10, func(event Event) { audit <- event })
+	bus.Subscribe("notify", 10, func(event Event) { notify <- event })
+
+	bus.Publish(Event{Type: USER_CREATED, TargetType: TARGET_USER, TargetID: "alice"})
+
+	for name, received := range map[string]chan Event{"audit": audit, "notify": notify} {
+		select {
+		case event := <-received:
+			if event.Type != USER_CREATED || event.TargetID != "alice" || event.Time.IsZero() {
+				t.Fatalf("expected %s to receive the stamped event, got %+v", name, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to receive the event", name)
+		}
+	}
+}
+
+func TestSlowSubscribersDontBlockPublishing(t *testing.T) {
+	bus := NewBus()
+
+	release := make(chan struct{})
+	defer close(release)
+	bus.Subscribe("stuck", 1, func(event Event) { <-release })
+
+	fast := make(chan Event, 10)
+	bus.Subscribe("fast", 10, func(event Event) { fast <- event })
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			bus.Publish(Event{Type: USER_UPDATED})
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("expected publishing to never wait for a stuck subscriber")
+	}
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-fast:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the fast subscriber to get every event, got %d", i)
+		}
+	}
+}
+
+func TestBlockingSubscribersGetEveryEvent(t *testing.T) {
+	bus := NewBus()
+
+	release := make(chan struct{})
+	handled := make(chan string, 5)
+	bus.SubscribeBlocking("audit", 1, func(event Event) {
+		<-release
+		handled <- event.TargetID
+	})
+
+	published := make(chan struct{})
+	go func() {
+		for _, id := range []string{"a", "b", "c", "d", "e"} {
+			bus.Publish(Event{Type: USER_UPDATED, TargetID: id})
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("expected publishing to wait for a blocking subscriber that has fallen behind")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("expected publishing to continue once the blocking subscriber caught up")
+	}
+
+	bus.Close(time.Second)
+	close(handled)
+
+	ids := []string{}
+	for id := range handled {
+		ids = append(ids, id)
+	}
+	if len(ids) != 5 {
+		t.Fatalf("expected none of the events to be dropped, got %v", ids)
+	}
+}
@@ -0,0 +1,50 @@
+package events
+
+import "time"
+
+const (
+	USER_LOGIN   string = "user.login"
+	USER_CREATED string = "user.created"
+	USER_UPDATED string = "user.updated"
+	USER_DELETED string = "user.deleted"
+
+	USERS_ZONE_ASSIGNED string = "users.zone_assigned"
+
+	ZONE_CREATED  string = "zone.created"
+	ZONE_UPDATED  string = "zone.updated"
+	ZONE_DELETED  string = "zone.deleted"
+	ZONE_RESTORED string = "zone.restored"
+
+	RECORD_CREATED string = "record.created"
+	RECORD_UPDATED string = "record.updated"
+	RECORD_DELETED string = "record.deleted"
+
+	BREAK_GLASS_USED string = "break_glass.used"
+
+	APPROVAL_REQUESTED string = "approval.requested"
+	APPROVAL_APPROVED  string = "approval.approved"
+	APPROVAL_REJECTED  string = "approval.rejected"
+
+	BUNDLE_EXPORTED string = "bundle.exported"
+	BUNDLE_IMPORTED string = "bundle.imported"
+
+	TENANT_CREATED string = "tenant.created"
+
+	INSTANCE_DRAINING string = "instance.draining"
+
+	TARGET_USER   string = "user"
+	TARGET_ZONE   string = "zone"
+	TARGET_RECORD string = "record"
+	TARGET_ACTION string = "pending_action"
+	TARGET_TENANT string = "tenant"
+)
+
+type Event struct {
+	Type       string      `json:"type"`
+	TenantID   string      `json:"tenantId,omitempty"`
+	Actor      string      `json:"actor"`
+	TargetType string      `json:"targetType"`
+	TargetID   string      `json:"targetId"`
+	Data       interface{} `json:"data"`
+	Time       time.Time   `json:"time"`
+}
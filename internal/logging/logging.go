@@ -51,7 +51,18 @@ func Configure() {
 		Log = zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
 	}
 
-	switch config.LogLevel {
+	if !SetLevel(config.LogLevel) {
+		fmt.Printf("%s is an invalid log level, setting to INFO\n", config.LogLevel)
+	}
+
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+
+	Log.Info().Str("log_level", config.LogLevel).Str("app_mode", config.AppMode).Msg("logging configured")
+}
+
+// Change the level of every logger, returning false if the level is unknown
+func SetLevel(level string) bool {
+	switch level {
 	case "DEBUG":
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	case "INFO":
@@ -59,10 +70,17 @@ func Configure() {
 	case "ERROR":
 		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
 	default:
-		fmt.Printf("%s is an invalid log level, setting to INFO\n", config.LogLevel)
+		return false
 	}
 
-	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	return true
+}
 
-	Log.Info().Str("log_level", config.LogLevel).Str("app_mode", config.AppMode).Msg("logging configured")
+func ValidLevel(level string) bool {
+	switch level {
+	case "DEBUG", "INFO", "ERROR":
+		return true
+	}
+
+	return false
 }
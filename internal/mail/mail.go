@@ -0,0 +1,106 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/logging"
+)
+
+// Send a plain text email through SMTP_HOST, authenticating only if SMTP_USERNAME is set
+// The SMTP client upgrades to TLS with STARTTLS whenever the server offers it
+func Send(to, subject, body string) error {
+	if config.SMTPHost == "" {
+		return fmt.Errorf("no SMTP server is configured")
+	}
+
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("email headers must not contain line breaks")
+	}
+
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+
+	message := strings.Join([]string{
+		"From: " + config.SMTPFrom,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", config.SMTPHost, config.SMTPPort), auth, config.SMTPFrom, []string{to}, []byte(message))
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Queue sends messages in the background from a bounded buffer, retrying failed sends with a doubling backoff
+// Messages are only held in memory, anything still queued when the process exits is lost
+type Queue struct {
+	messages chan Message
+	attempts int
+	backoff  time.Duration
+	send     func(to, subject, body string) error
+	log      logging.Logger
+}
+
+// A queue holding up to size messages, each sent with send up to attempts times, waiting backoff after the first failure
+func NewQueue(size, attempts int, backoff time.Duration, send func(to, subject, body string) error) *Queue {
+	return &Queue{
+		messages: make(chan Message, size),
+		attempts: attempts,
+		backoff:  backoff,
+		send:     send,
+		log:      logging.Log.With().Str("package", "mail").Logger(),
+	}
+}
+
+// Queue a message without waiting for it to be sent, returning false if the queue is full and it was dropped
+func (q *Queue) Enqueue(message Message) bool {
+	select {
+	case q.messages <- message:
+		return true
+	default:
+		q.log.Warn().Str("subject", message.Subject).Msg("mail queue full, dropping message")
+		return false
+	}
+}
+
+// Send queued messages one at a time until the process exits
+func (q *Queue) Run() {
+	for message := range q.messages {
+		q.deliver(message)
+	}
+}
+
+func (q *Queue) deliver(message Message) {
+	wait := q.backoff
+
+	for attempt := 1; ; attempt++ {
+		sendErr := q.send(message.To, message.Subject, message.Body)
+		if sendErr == nil {
+			return
+		}
+
+		if attempt >= q.attempts {
+			q.log.Error().Err(sendErr).Str("subject", message.Subject).Int("attempts", attempt).Msg("unable to send message, giving up")
+			return
+		}
+
+		q.log.Warn().Err(sendErr).Str("subject", message.Subject).Int("attempt", attempt).Dur("retry_in", wait).Msg("unable to send message, retrying")
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
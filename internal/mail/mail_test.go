@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueRetriesFailedSends(t *testing.T) {
+	attempts := 0
+	delivered := make(chan Message, 1)
+
+	queue := NewQueue(1, 3, time.Millisecond, func(to, subject, body string) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("451 try again later")
+		}
+
+		delivered <- Message{To: to, Subject: subject, Body: body}
+		return nil
+	})
+	go queue.Run()
+
+	if !queue.Enqueue(Message{To: "alice@example.com", Subject: "hello"}) {
+		t.Fatal("expected the message to be queued")
+	}
+
+	select {
+	case message := <-delivered:
+		if message.To != "alice@example.com" || attempts != 3 {
+			t.Fatalf("expected the message to be sent on the third attempt, got %+v after %d", message, attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a transiently failing send to eventually succeed")
+	}
+}
+
+func TestQueueDropsMessagesWhenFull(t *testing.T) {
+	queue := NewQueue(1, 1, 0, func(to, subject, body string) error { return nil })
+
+	if !queue.Enqueue(Message{To: "alice@example.com"}) {
+		t.Fatal("expected the first message to be queued")
+	}
+
+	if queue.Enqueue(Message{To: "bob@example.com"}) {
+		t.Fatal("expected a message to be dropped rather than waited for once the queue is full")
+	}
+}
@@ -0,0 +1,225 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/monoxane/vxconnect/internal/logging"
+	"gorm.io/gorm"
+)
+
+// Returned instead of querying the DB while the circuit breaker is open
+var ErrCircuitOpen = errors.New("database is unavailable, circuit breaker is open")
+
+const (
+	BREAKER_DISABLED  string = "disabled"
+	BREAKER_CLOSED    string = "closed"
+	BREAKER_OPEN      string = "open"
+	BREAKER_HALF_OPEN string = "half-open" // probing the DB after the cooldown, queries are let through and the first outcome decides
+)
+
+// Published through expvar as database_breaker, the current state and how often the breaker has moved to each state
+// along with how many queries it refused while open
+var breakerMetrics = expvar.NewMap("database_breaker")
+
+func init() {
+	state := &expvar.String{}
+	state.Set(BREAKER_DISABLED)
+	breakerMetrics.Set("state", state)
+
+	for _, counter := range []string{BREAKER_OPEN, BREAKER_HALF_OPEN, BREAKER_CLOSED, "rejected"} {
+		breakerMetrics.Add(counter, 0)
+	}
+}
+
+// Stops sending queries to a DB that keeps failing so requests fail straight away instead of each waiting on a timeout
+// Only infrastructure failures count towards opening it, not found and constraint errors mean the DB is working fine
+type breaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+	log       logging.Logger
+}
+
+func (b *breaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state == BREAKER_OPEN
+}
+
+// Refuse a query while the breaker is open, counting it
+func (b *breaker) reject() bool {
+	if !b.open() {
+		return false
+	}
+
+	breakerMetrics.Add("rejected", 1)
+	return true
+}
+
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BREAKER_OPEN:
+		return
+	case BREAKER_HALF_OPEN:
+		if infrastructureFailure(err) {
+			b.transition(BREAKER_OPEN)
+			b.log.Error().Err(err).Dur("cooldown", b.cooldown).Msg("database circuit breaker opened again, the database is still failing")
+			return
+		}
+
+		b.transition(BREAKER_CLOSED)
+		b.log.Info().Msg("database circuit breaker closed")
+		return
+	}
+
+	if !infrastructureFailure(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if failures := b.failures; failures >= b.threshold {
+		b.transition(BREAKER_OPEN)
+		b.log.Error().Err(err).Int("failures", failures).Dur("cooldown", b.cooldown).Msg("database circuit breaker opened")
+	}
+}
+
+// Once the cooldown has passed let queries through again and ping the DB, closing the breaker if it answers
+// A query failing while half-open opens it again just as a failed ping does
+func (b *breaker) probe(ping func() error) {
+	b.mu.Lock()
+	if b.state != BREAKER_OPEN || time.Since(b.openedAt) < b.cooldown {
+		b.mu.Unlock()
+		return
+	}
+	b.transition(BREAKER_HALF_OPEN)
+	b.mu.Unlock()
+
+	b.record(ping())
+}
+
+// How long until the breaker could next be probed, the rest of its cooldown while it is open
+func (b *breaker) untilProbe() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BREAKER_OPEN {
+		return b.cooldown
+	}
+
+	return time.Until(b.openedAt.Add(b.cooldown))
+}
+
+// Move to a new state, the caller must hold mu
+func (b *breaker) transition(state string) {
+	b.state = state
+	b.failures = 0
+	if state == BREAKER_OPEN {
+		b.openedAt = time.Now()
+	}
+
+	breakerMetrics.Get("state").(*expvar.String).Set(state)
+	breakerMetrics.Add(state, 1)
+}
+
+// Whether an error means the DB couldn't be reached or didn't answer, rather than it answering with an error
+func infrastructureFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// Trip the breaker after threshold consecutive infrastructure failures, then probe the DB once cooldown has passed since it opened until it answers again
+func (s *MariaDBStore) EnableCircuitBreaker(threshold int, cooldown time.Duration) error {
+	s.breaker = &breaker{
+		state:     BREAKER_CLOSED,
+		threshold: threshold,
+		cooldown:  cooldown,
+		log:       s.log.With().Str("component", "breaker").Logger(),
+	}
+	breakerMetrics.Get("state").(*expvar.String).Set(BREAKER_CLOSED)
+
+	before := func(db *gorm.DB) {
+		if s.breaker.reject() {
+			db.AddError(ErrCircuitOpen)
+		}
+	}
+
+	after := func(db *gorm.DB) {
+		if !errors.Is(db.Error, ErrCircuitOpen) {
+			s.breaker.record(db.Error)
+		}
+	}
+
+	callbacks := s.connection.Callback()
+
+	registrations := []error{
+		callbacks.Create().Before("gorm:create").Register("breaker:before_create", before),
+		callbacks.Create().After("gorm:create").Register("breaker:after_create", after),
+		callbacks.Query().Before("gorm:query").Register("breaker:before_query", before),
+		callbacks.Query().After("gorm:query").Register("breaker:after_query", after),
+		callbacks.Update().Before("gorm:update").Register("breaker:before_update", before),
+		callbacks.Update().After("gorm:update").Register("breaker:after_update", after),
+		callbacks.Delete().Before("gorm:delete").Register("breaker:before_delete", before),
+		callbacks.Delete().After("gorm:delete").Register("breaker:after_delete", after),
+		callbacks.Row().Before("gorm:row").Register("breaker:before_row", before),
+		callbacks.Row().After("gorm:row").Register("breaker:after_row", after),
+		callbacks.Raw().Before("gorm:raw").Register("breaker:before_raw", before),
+		callbacks.Raw().After("gorm:raw").Register("breaker:after_raw", after),
+	}
+
+	for _, err := range registrations {
+		if err != nil {
+			return err
+		}
+	}
+
+	go s.probeBreaker()
+
+	return nil
+}
+
+func (s *MariaDBStore) probeBreaker() {
+	for {
+		time.Sleep(s.breaker.untilProbe())
+		s.breaker.probe(s.Ping)
+	}
+}
+
+// The state of the circuit breaker, for readiness checks
+func (s *MariaDBStore) BreakerState() string {
+	if s.breaker == nil {
+		return BREAKER_DISABLED
+	}
+
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+
+	return s.breaker.state
+}
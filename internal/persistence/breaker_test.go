@@ -0,0 +1,156 @@
+package persistence
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"expvar"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+func newTestBreaker(threshold int) *breaker {
+	return &breaker{state: BREAKER_CLOSED, threshold: threshold}
+}
+
+func stateOf(b *breaker) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func breakerCount(name string) int64 {
+	return breakerMetrics.Get(name).(*expvar.Int).Value()
+}
+
+func breakerStateMetric() string {
+	return breakerMetrics.Get("state").(*expvar.String).Value()
+}
+
+func TestBreakerOpensAfterConsecutiveInfrastructureFailures(t *testing.T) {
+	b := newTestBreaker(3)
+	opened := breakerCount(BREAKER_OPEN)
+
+	b.record(driver.ErrBadConn)
+	b.record(driver.ErrBadConn)
+	b.record(gorm.ErrRecordNotFound)
+	b.record(driver.ErrBadConn)
+	b.record(driver.ErrBadConn)
+
+	if b.open() {
+		t.Fatal("expected an answer from the database to reset the failure count")
+	}
+
+	var output bytes.Buffer
+	b.log = zerolog.New(&output)
+
+	b.record(driver.ErrBadConn)
+	if !b.open() {
+		t.Fatal("expected the breaker to open after the threshold")
+	}
+
+	if !strings.Contains(output.String(), `"failures":3`) {
+		t.Fatalf("expected the opening to log the failures that opened it, got %s", output.String())
+	}
+
+	if breakerCount(BREAKER_OPEN) != opened+1 || breakerStateMetric() != BREAKER_OPEN {
+		t.Fatalf("expected the opening to be counted, got %s", breakerMetrics.String())
+	}
+
+	rejected := breakerCount("rejected")
+	if !b.reject() || breakerCount("rejected") != rejected+1 {
+		t.Fatal("expected queries to be refused and counted while open")
+	}
+}
+
+func TestBreakerClosesWhenTheProbeAnswers(t *testing.T) {
+	b := newTestBreaker(1)
+	b.record(driver.ErrBadConn)
+
+	halfOpened, closed := breakerCount(BREAKER_HALF_OPEN), breakerCount(BREAKER_CLOSED)
+
+	b.probe(func() error {
+		if stateOf(b) != BREAKER_HALF_OPEN || b.reject() {
+			t.Error("expected queries to be let through while the probe runs")
+		}
+		return nil
+	})
+
+	if stateOf(b) != BREAKER_CLOSED || breakerStateMetric() != BREAKER_CLOSED {
+		t.Fatalf("expected the breaker to close, got %s", stateOf(b))
+	}
+
+	if breakerCount(BREAKER_HALF_OPEN) != halfOpened+1 || breakerCount(BREAKER_CLOSED) != closed+1 {
+		t.Fatalf("expected the half-open and closed transitions to be counted, got %s", breakerMetrics.String())
+	}
+}
+
+func TestBreakerReopensWhenHalfOpenFails(t *testing.T) {
+	b := newTestBreaker(1)
+	b.record(driver.ErrBadConn)
+
+	b.probe(func() error { return driver.ErrBadConn })
+	if !b.open() {
+		t.Fatal("expected a failed probe to open the breaker again")
+	}
+
+	// A query failing while the probe runs opens it again too
+	b.probe(func() error {
+		b.record(driver.ErrBadConn)
+		return nil
+	})
+	if stateOf(b) != BREAKER_OPEN {
+		t.Fatalf("expected a query failing while half-open to open the breaker, got %s", stateOf(b))
+	}
+
+	// Only an open breaker is probed
+	closed := newTestBreaker(1)
+	closed.probe(func() error {
+		t.Error("expected a closed breaker not to be probed")
+		return nil
+	})
+}
+
+func TestBreakerIsOnlyProbedOnceTheCooldownHasPassed(t *testing.T) {
+	b := newTestBreaker(1)
+	b.cooldown = 50 * time.Millisecond
+	b.record(driver.ErrBadConn)
+
+	b.probe(func() error {
+		t.Error("expected the breaker not to be probed during its cooldown")
+		return nil
+	})
+	if !b.open() {
+		t.Fatalf("expected the breaker to stay open during its cooldown, got %s", stateOf(b))
+	}
+
+	if wait := b.untilProbe(); wait <= 0 || wait > b.cooldown {
+		t.Fatalf("expected the next probe to wait out the rest of the cooldown, got %s", wait)
+	}
+
+	time.Sleep(b.cooldown)
+
+	probed := false
+	b.probe(func() error {
+		probed = true
+		if stateOf(b) != BREAKER_HALF_OPEN {
+			t.Errorf("expected the breaker to be half-open while probed, got %s", stateOf(b))
+		}
+		return nil
+	})
+
+	if !probed || stateOf(b) != BREAKER_CLOSED {
+		t.Fatalf("expected the breaker to be probed and close once the cooldown passed, got %s", stateOf(b))
+	}
+
+	// Opening again starts a new cooldown
+	b.record(driver.ErrBadConn)
+	b.probe(func() error {
+		t.Error("expected a breaker that opened again to wait out a new cooldown")
+		return nil
+	})
+}
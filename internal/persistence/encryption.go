@@ -0,0 +1,232 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/monoxane/vxconnect/internal/encryption"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// Stores a field encrypted when field encryption is enabled, and in plain text when it isn't
+// Strings are stored as is and everything else as JSON, so a column reads the same as before it was encrypted
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	value := reflect.New(field.FieldType)
+
+	if dbValue != nil {
+		var stored string
+		switch v := dbValue.(type) {
+		case []byte:
+			stored = string(v)
+		case string:
+			stored = v
+		default:
+			return fmt.Errorf("unable to read encrypted field %s: %#v", field.Name, dbValue)
+		}
+
+		data := []byte(stored)
+		if encryption.Encrypted(stored) {
+			plaintext, decryptErr := encryption.Decrypt(stored)
+			if decryptErr != nil {
+				return fmt.Errorf("unable to read encrypted field %s: %s", field.Name, decryptErr)
+			}
+			data = plaintext
+		}
+
+		switch {
+		case field.FieldType.Kind() == reflect.String:
+			value.Elem().SetString(string(data))
+		case field.FieldType.Kind() == reflect.Ptr && field.FieldType.Elem().Kind() == reflect.String:
+			text := reflect.New(field.FieldType.Elem())
+			text.Elem().SetString(string(data))
+			value.Elem().Set(text)
+		case len(data) > 0:
+			if jsonErr := json.Unmarshal(data, value.Interface()); jsonErr != nil {
+				return fmt.Errorf("unable to read encrypted field %s: %s", field.Name, jsonErr)
+			}
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(value.Elem())
+	return nil
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value := reflect.ValueOf(fieldValue)
+
+	// Only string fields are stored as is, a string held by an interface{} field is still JSON so it can be read back
+	var plaintext []byte
+	switch {
+	case !value.IsValid():
+		return nil, nil
+	case (value.Kind() == reflect.Ptr || value.Kind() == reflect.Map || value.Kind() == reflect.Slice) && value.IsNil():
+		return nil, nil
+	case field.FieldType.Kind() == reflect.String:
+		plaintext = []byte(value.String())
+	case field.FieldType.Kind() == reflect.Ptr && field.FieldType.Elem().Kind() == reflect.String:
+		plaintext = []byte(value.Elem().String())
+	default:
+		encoded, jsonErr := json.Marshal(fieldValue)
+		if jsonErr != nil {
+			return nil, jsonErr
+		}
+		plaintext = encoded
+	}
+
+	if !encryption.Enabled() {
+		return string(plaintext), nil
+	}
+
+	return encryption.Encrypt(plaintext)
+}
+
+// Keep the lookup hash of a user's email in step with it, it is only kept while field encryption is enabled
+func indexUser(user *entity.User) {
+	user.EmailIndex = nil
+
+	if encryption.Enabled() && user.Email != nil {
+		index := encryption.Index(*user.Email)
+		user.EmailIndex = &index
+	}
+}
+
+// The encrypted user columns as they are stored
+type storedUserFields struct {
+	ID         string
+	Email      sql.NullString
+	EmailIndex sql.NullString
+	Labels     sql.NullString
+}
+
+func (fields storedUserFields) stale() bool {
+	for _, column := range []sql.NullString{fields.Email, fields.Labels} {
+		if column.Valid && !encryption.Current(column.String) {
+			return true
+		}
+	}
+
+	return fields.Email.Valid && !fields.EmailIndex.Valid
+}
+
+// How many rows are read, and re-encrypted in a single transaction, at a time
+const encryptBatchSize int = 500
+
+// Encrypt the user fields written before encryption was enabled or under a key that has since been rotated,
+// including deleted users, so the previous key can be retired once this has run
+// Each batch is encrypted in its own transaction, so a restart part way through carries on from the batches that are left
+func (s *MariaDBStore) encryptUsers(conn *gorm.DB) (int, error) {
+	if !encryption.Enabled() {
+		return 0, nil
+	}
+
+	encrypted := 0
+	checked := 0
+	batch := []storedUserFields{}
+
+	result := conn.Table("users").Select("id", "email", "email_index", "labels").FindInBatches(&batch, encryptBatchSize, func(_ *gorm.DB, _ int) error {
+		stale := []string{}
+		for _, fields := range batch {
+			if fields.stale() {
+				stale = append(stale, fields.ID)
+			}
+		}
+		checked += len(batch)
+
+		if len(stale) > 0 {
+			transactionErr := conn.Transaction(func(tx *gorm.DB) error {
+				users := []*entity.User{}
+				if result := tx.Unscoped().Where("id IN ?", stale).Find(&users); result.Error != nil {
+					return result.Error
+				}
+
+				for _, user := range users {
+					indexUser(user)
+
+					if result := tx.Unscoped().Model(user).Select("email", "email_index", "labels").UpdateColumns(user); result.Error != nil {
+						return result.Error
+					}
+				}
+
+				return nil
+			})
+
+			if transactionErr != nil {
+				return transactionErr
+			}
+
+			encrypted += len(stale)
+			s.log.Info().Int("checked", checked).Int("encrypted", encrypted).Msg("encrypting user fields with the current key")
+		}
+
+		return nil
+	})
+
+	return encrypted, result.Error
+}
+
+// The encrypted audit column as it is stored
+type storedAuditData struct {
+	ID   string
+	Data sql.NullString
+}
+
+// Encrypt the data of audit entries written before encryption was enabled or under a key that has since been rotated,
+// these keep their own copy of the user fields of each change so have to follow the same keys
+func (s *MariaDBStore) encryptAuditEntries(conn *gorm.DB) (int, error) {
+	if !encryption.Enabled() {
+		return 0, nil
+	}
+
+	encrypted := 0
+	checked := 0
+	batch := []storedAuditData{}
+
+	result := conn.Table("audit_entries").Select("id", "data").FindInBatches(&batch, encryptBatchSize, func(_ *gorm.DB, _ int) error {
+		stale := []string{}
+		for _, stored := range batch {
+			if stored.Data.Valid && !encryption.Current(stored.Data.String) {
+				stale = append(stale, stored.ID)
+			}
+		}
+		checked += len(batch)
+
+		if len(stale) > 0 {
+			transactionErr := conn.Transaction(func(tx *gorm.DB) error {
+				entries := []*entity.AuditEntry{}
+				if result := tx.Where("id IN ?", stale).Find(&entries); result.Error != nil {
+					return result.Error
+				}
+
+				for _, entry := range entries {
+					if result := tx.Model(entry).Select("data").UpdateColumns(entry); result.Error != nil {
+						return result.Error
+					}
+				}
+
+				return nil
+			})
+
+			if transactionErr != nil {
+				return transactionErr
+			}
+
+			encrypted += len(stale)
+			s.log.Info().Int("checked", checked).Int("encrypted", encrypted).Msg("encrypting audit entries with the current key")
+		}
+
+		return nil
+	})
+
+	return encrypted, result.Error
+}
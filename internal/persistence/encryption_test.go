@@ -0,0 +1,217 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+	"github.com/monoxane/vxconnect/internal/encryption"
+	"github.com/monoxane/vxconnect/internal/entity"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func useKeys(t *testing.T, current string, ids ...string) {
+	t.Helper()
+
+	previousKeys, previousID, previousIndex := config.FieldEncryptionKeys, config.FieldEncryptionKeyID, config.FieldEncryptionIndexKey
+	t.Cleanup(func() {
+		config.FieldEncryptionKeys, config.FieldEncryptionKeyID, config.FieldEncryptionIndexKey = previousKeys, previousID, previousIndex
+	})
+
+	keys := map[string][]byte{}
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+
+	config.FieldEncryptionKeys = keys
+	config.FieldEncryptionKeyID = current
+	config.FieldEncryptionIndexKey = bytes.Repeat([]byte{0xff}, 32)
+}
+
+// A store whose statements are built but never sent, the values each insert would have written are passed to written
+func dryRunStore(t *testing.T, written func(values []interface{})) *MariaDBStore {
+	t.Helper()
+
+	conn, openErr := gorm.Open(mysql.New(mysql.Config{DSN: "test:test@tcp(127.0.0.1:1)/test", SkipInitializeWithVersion: true}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+
+	registerErr := conn.Callback().Create().After("gorm:create").Register("test:written", func(db *gorm.DB) {
+		values := []interface{}{}
+		for _, v := range db.Statement.Vars {
+			if valuer, ok := v.(driver.Valuer); ok {
+				value, valueErr := valuer.Value()
+				if valueErr != nil {
+					t.Fatal(valueErr)
+				}
+				v = value
+			}
+			values = append(values, v)
+		}
+		written(values)
+	})
+	if registerErr != nil {
+		t.Fatal(registerErr)
+	}
+
+	return &MariaDBStore{connection: conn}
+}
+
+// Read a stored value back into a user field the way gorm does when scanning a row
+func scanUserField(t *testing.T, user *entity.User, name string, stored interface{}) {
+	t.Helper()
+
+	parsed, parseErr := schema.Parse(&entity.User{}, &sync.Map{}, schema.NamingStrategy{})
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if scanErr := (encryptedSerializer{}).Scan(context.Background(), parsed.LookUpField(name), reflect.ValueOf(user).Elem(), stored); scanErr != nil {
+		t.Fatal(scanErr)
+	}
+}
+
+func TestUserFieldsAreStoredEncrypted(t *testing.T) {
+	useKeys(t, "2024", "2024")
+
+	values := []interface{}{}
+	store := dryRunStore(t, func(written []interface{}) { values = written })
+
+	email := "admin@example.com"
+	user := &entity.User{ID: "user", Username: "admin", Email: &email, Labels: map[string]string{"team": "network"}}
+	if createErr := store.CreateUser(user); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	encrypted := []string{}
+	for _, value := range values {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		if strings.Contains(text, email) || strings.Contains(text, "network") {
+			t.Fatalf("expected no plain text email or label to be written, got %q", text)
+		}
+
+		if encryption.Encrypted(text) {
+			encrypted = append(encrypted, text)
+		}
+	}
+
+	if len(encrypted) != 2 {
+		t.Fatalf("expected the email and labels to be written encrypted, got %v", values)
+	}
+
+	if user.EmailIndex == nil || *user.EmailIndex != encryption.Index(email) {
+		t.Fatal("expected the email to be indexed for lookups")
+	}
+
+	read := &entity.User{}
+	scanUserField(t, read, "Email", encrypted[0])
+	scanUserField(t, read, "Labels", encrypted[1])
+
+	if read.Email == nil || *read.Email != email || read.Labels["team"] != "network" {
+		t.Fatalf("expected the stored values to read back in plain text, got %v %v", read.Email, read.Labels)
+	}
+}
+
+func TestUserFieldsReadAfterRotation(t *testing.T) {
+	useKeys(t, "2024", "2024", "2025")
+
+	parsed, parseErr := schema.Parse(&entity.User{}, &sync.Map{}, schema.NamingStrategy{})
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	stored, valueErr := (encryptedSerializer{}).Value(context.Background(), parsed.LookUpField("Labels"), reflect.Value{}, map[string]string{"team": "network"})
+	if valueErr != nil {
+		t.Fatal(valueErr)
+	}
+
+	config.FieldEncryptionKeyID = "2025"
+
+	read := &entity.User{}
+	scanUserField(t, read, "Labels", stored)
+	if read.Labels["team"] != "network" {
+		t.Fatalf("expected labels under the previous key to still be read, got %v", read.Labels)
+	}
+
+	fields := storedUserFields{ID: "user", Labels: sql.NullString{String: stored.(string), Valid: true}}
+	if !fields.stale() {
+		t.Fatal("expected labels under the previous key to be re-encrypted on start-up")
+	}
+
+	current, _ := encryption.Encrypt([]byte(`{"team":"network"}`))
+	if (storedUserFields{ID: "user", Labels: sql.NullString{String: current, Valid: true}}).stale() {
+		t.Fatal("expected labels under the current key to be left alone")
+	}
+}
+
+func TestPlainTextUserFieldsStillRead(t *testing.T) {
+	useKeys(t, "2024", "2024")
+
+	read := &entity.User{}
+	scanUserField(t, read, "Email", "admin@example.com")
+	scanUserField(t, read, "Labels", []byte(`{"team":"network"}`))
+
+	if read.Email == nil || *read.Email != "admin@example.com" || read.Labels["team"] != "network" {
+		t.Fatalf("expected values written before encryption to still be read, got %v %v", read.Email, read.Labels)
+	}
+
+	if !(storedUserFields{ID: "user", Email: sql.NullString{String: "admin@example.com", Valid: true}}).stale() {
+		t.Fatal("expected a plain text email to be encrypted on start-up")
+	}
+}
+
+func TestAuditDataIsStoredEncrypted(t *testing.T) {
+	useKeys(t, "2024", "2024")
+
+	values := []interface{}{}
+	store := dryRunStore(t, func(written []interface{}) { values = written })
+
+	email := "admin@example.com"
+	entry := &entity.AuditEntry{ID: "entry", Type: "USER_UPDATED", Data: map[string]interface{}{"email": email}}
+	if createErr := store.CreateAuditEntry(entry); createErr != nil {
+		t.Fatal(createErr)
+	}
+
+	var stored string
+	for _, value := range values {
+		if text, ok := value.(string); ok && encryption.Encrypted(text) {
+			stored = text
+		}
+
+		if text, ok := value.(string); ok && strings.Contains(text, email) {
+			t.Fatalf("expected the audit data to not be written in plain text, got %q", text)
+		}
+	}
+
+	if stored == "" {
+		t.Fatalf("expected the audit data to be written encrypted, got %v", values)
+	}
+
+	parsed, parseErr := schema.Parse(&entity.AuditEntry{}, &sync.Map{}, schema.NamingStrategy{})
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	read := &entity.AuditEntry{}
+	if scanErr := (encryptedSerializer{}).Scan(context.Background(), parsed.LookUpField("Data"), reflect.ValueOf(read).Elem(), stored); scanErr != nil {
+		t.Fatal(scanErr)
+	}
+
+	data, ok := read.Data.(map[string]interface{})
+	if !ok || data["email"] != email {
+		t.Fatalf("expected the audit data to read back in plain text, got %#v", read.Data)
+	}
+}
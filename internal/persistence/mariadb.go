@@ -1,71 +1,433 @@
 package persistence
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/monoxane/vxconnect/internal/encryption"
 	"github.com/monoxane/vxconnect/internal/entity"
 	"github.com/monoxane/vxconnect/internal/logging"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	migrationLockName string = "vxconnect_migrate"
+
+	connectInitialBackoff = time.Second
+	connectMaxBackoff     = 10 * time.Second
+
+	// How many mutations can hold resource locks at once on this instance, further ones wait for a connection to be released
+	ResourceLockConnections = 32
 )
 
 type MariaDBStore struct {
-	hostname     string
-	port         int
-	username     string
-	password     string
-	databaseName string
-	connection   *gorm.DB
-	log          logging.Logger
+	hostname             string
+	port                 int
+	username             string
+	password             string
+	databaseName         string
+	migrationLockTimeout int
+	connection           *gorm.DB
+	locks                *sql.DB // Connections holding resource locks, see LockResources
+	breaker              *breaker
+	tenant               *string // Set on stores returned by ForTenant, nil sees every tenant
+	log                  logging.Logger
 }
 
-func NewMariaDBStore(host string, port int, user, pass, name string) (*MariaDBStore, error) {
+// Connect to MariaDB, retrying with backoff for up to connectTimeout seconds in case the server isn't ready yet
+func NewMariaDBStore(host string, port int, user, pass, name string, connectTimeout, migrationLockTimeout int) (*MariaDBStore, error) {
 	store := &MariaDBStore{
-		hostname:     host,
-		port:         port,
-		username:     user,
-		password:     pass,
-		databaseName: name,
-		log:          logging.Log.With().Str("package", "persistence").Str("store", "mariadb").Str("host", host).Logger(),
+		hostname:             host,
+		port:                 port,
+		username:             user,
+		password:             pass,
+		databaseName:         name,
+		migrationLockTimeout: migrationLockTimeout,
+		log:                  logging.Log.With().Str("package", "persistence").Str("store", "mariadb").Str("host", host).Logger(),
 	}
 
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local", store.username, store.password, store.hostname, store.port, store.databaseName)
-	conn, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	if err != nil {
-		return nil, fmt.Errorf("unable to connect to MariaDB server: %s", err)
+	deadline := time.Now().Add(time.Duration(connectTimeout) * time.Second)
+
+	var conn *gorm.DB
+	connectErr := retryUntil(deadline, connectInitialBackoff, store.log, func() error {
+		var err error
+		conn, err = gorm.Open(mysql.Open(dsn), &gorm.Config{TranslateError: true})
+		return err
+	})
+	if connectErr != nil {
+		return nil, fmt.Errorf("unable to connect to MariaDB server %s", connectErr)
 	}
 
 	store.connection = conn
 
+	// Resource locks hold their connection while the lock is held, so they get their own pool rather than starving queries
+	locks, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open resource lock connections: %s", err)
+	}
+	locks.SetMaxOpenConns(ResourceLockConnections)
+	store.locks = locks
+
 	store.log.Info().Msg("connected to Mariadb server")
 
 	return store, nil
 }
 
+// Call connect until it succeeds, doubling the wait between attempts up to connectMaxBackoff
+// Gives up with the last error once waiting for another attempt would pass the deadline
+func retryUntil(deadline time.Time, backoff time.Duration, log logging.Logger, connect func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := connect()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("after %d attempts: %s", attempt, err)
+		}
+
+		log.Warn().Err(err).Int("attempt", attempt).Dur("retry_in", backoff).Msg("unable to connect to MariaDB server, retrying")
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > connectMaxBackoff {
+			backoff = connectMaxBackoff
+		}
+	}
+}
+
+// Migrate the schema while holding a database wide advisory lock,
+// so when several instances start at once only one of them migrates and the rest wait for it
 func (s *MariaDBStore) Migrate() error {
-	var err error
-	err = s.connection.AutoMigrate(&entity.User{})
-	err = s.connection.AutoMigrate(&entity.Zone{})
-	err = s.connection.AutoMigrate(&entity.Record{})
+	return s.connection.Connection(func(conn *gorm.DB) error {
+		lock := &advisoryLock{conn: conn, name: migrationLockName, timeout: s.migrationLockTimeout}
 
-	if err != nil {
-		return fmt.Errorf("unable to migrate entity: %s", err)
+		return whileMigrationLocked(lock, s.migrationLockTimeout, func() error { return s.migrate(conn) })
+	})
+}
+
+// Held while migrating, so only one instance migrates at a time
+type migrationLock interface {
+	// Wait for the lock, false if its timeout passed first
+	acquire() (bool, error)
+	release()
+}
+
+// A MariaDB advisory lock held by a single connection
+type advisoryLock struct {
+	conn    *gorm.DB
+	name    string
+	timeout int
+}
+
+func (l *advisoryLock) acquire() (bool, error) {
+	var acquired sql.NullInt64
+	err := l.conn.Raw("SELECT GET_LOCK(?, ?)", l.name, l.timeout).Scan(&acquired).Error
+
+	return acquired.Valid && acquired.Int64 == 1, err
+}
+
+func (l *advisoryLock) release() {
+	l.conn.Exec("SELECT RELEASE_LOCK(?)", l.name)
+}
+
+// Run migrate while holding the lock, never running it if the lock couldn't be acquired within timeout seconds
+func whileMigrationLocked(lock migrationLock, timeout int, migrate func() error) error {
+	acquired, lockErr := lock.acquire()
+	if lockErr != nil {
+		return fmt.Errorf("unable to acquire migration lock: %s", lockErr)
+	}
+
+	if !acquired {
+		return fmt.Errorf("timed out after %d seconds waiting for migration lock", timeout)
+	}
+
+	defer lock.release()
+
+	return migrate()
+}
+
+// Migrate the schema and encrypt anything not yet under the current key, the caller must hold the migration lock on conn
+func (s *MariaDBStore) migrate(conn *gorm.DB) error {
+	if err := migrateEntities(conn); err != nil {
+		return err
 	}
 
 	s.log.Info().Msg("migrated entities")
+
+	if err := keepEmailsUnique(&emailColumnIndex{conn: conn}, encryption.Enabled()); err != nil {
+		return err
+	}
+
+	if err := dropGlobalZoneNameIndex(conn); err != nil {
+		return err
+	}
+
+	encrypted, encryptErr := s.encryptUsers(conn)
+	if encryptErr != nil {
+		return fmt.Errorf("unable to encrypt user fields: %s", encryptErr)
+	}
+
+	if encrypted > 0 {
+		s.log.Info().Int("users", encrypted).Msg("encrypted user fields with the current key")
+	}
+
+	encryptedEntries, encryptEntriesErr := s.encryptAuditEntries(conn)
+	if encryptEntriesErr != nil {
+		return fmt.Errorf("unable to encrypt audit entries: %s", encryptEntriesErr)
+	}
+
+	if encryptedEntries > 0 {
+		s.log.Info().Int("entries", encryptedEntries).Msg("encrypted audit entries with the current key")
+	}
+
+	return nil
+}
+
+// The unique index on the plain email column, named as MariaDB names the index of a unique column
+type emailUniqueIndex interface {
+	exists() bool
+	create() error
+	drop() error
+}
+
+type emailColumnIndex struct {
+	conn *gorm.DB
+}
+
+func (i *emailColumnIndex) exists() bool {
+	return i.conn.Migrator().HasIndex(&entity.User{}, "email")
+}
+
+func (i *emailColumnIndex) create() error {
+	return i.conn.Exec("CREATE UNIQUE INDEX email ON users (email)").Error
+}
+
+func (i *emailColumnIndex) drop() error {
+	return i.conn.Migrator().DropIndex(&entity.User{}, "email")
+}
+
+// Keep emails unique whether or not they are encrypted
+// Encrypted emails never repeat so a unique index on them enforces nothing, EmailIndex keeps them unique instead,
+// but it is only filled while encryption is enabled so without it the email column itself has to be unique
+func keepEmailsUnique(index emailUniqueIndex, encrypted bool) error {
+	if encrypted {
+		if index.exists() {
+			if dropErr := index.drop(); dropErr != nil {
+				return fmt.Errorf("unable to drop the unique index on encrypted emails: %s", dropErr)
+			}
+		}
+
+		return nil
+	}
+
+	if !index.exists() {
+		if createErr := index.create(); createErr != nil {
+			return fmt.Errorf("unable to make emails unique: %s", createErr)
+		}
+	}
+
+	return nil
+}
+
+// Zone names used to be unique across every tenant, now they are only unique within one
+// The per tenant index is created by the migration first so names are never left without one
+func dropGlobalZoneNameIndex(conn *gorm.DB) error {
+	if !conn.Migrator().HasIndex(&entity.Zone{}, "name") {
+		return nil
+	}
+
+	if dropErr := conn.Migrator().DropIndex(&entity.Zone{}, "name"); dropErr != nil {
+		return fmt.Errorf("unable to drop the deployment wide unique index on zone names: %s", dropErr)
+	}
+
+	return nil
+}
+
+// Every entity with a table, in the order they are migrated
+var migratedEntities = []interface{}{
+	&entity.User{},
+	&entity.Zone{},
+	&entity.Record{},
+	&entity.AuditEntry{},
+	&entity.BreakGlassUse{},
+	&entity.PendingAction{},
+	&entity.LoginAttempt{},
+	&entity.Tenant{},
+	&entity.MagicLink{},
+}
+
+// Migrate each entity in turn, stopping at the first that fails so its error isn't hidden by the ones after it
+func migrateEntities(conn *gorm.DB) error {
+	for _, model := range migratedEntities {
+		if err := conn.AutoMigrate(model); err != nil {
+			return fmt.Errorf("unable to migrate entity %T: %s", model, err)
+		}
+	}
+
+	return nil
+}
+
+// Returned by LockResources when another holder kept a lock for the whole timeout
+var ErrLockTimeout = errors.New("timed out waiting for resource lock")
+
+// Returned by CreateBreakGlassAdmin when the credential has already been used
+var ErrBreakGlassSpent = errors.New("break-glass credential has already been used")
+
+// Take named advisory locks shared by every instance using this database, in the order given, waiting up to timeout seconds for all of them
+// Every lock is held by one connection from the resource lock pool, so a mutation locking many resources still only needs one,
+// the connection is held until the returned func releases the locks. Waiting for a free connection counts towards the timeout
+func (s *MariaDBStore) LockResources(names []string, timeout int) (func(), error) {
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	waitCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	conn, err := s.locks.Conn(waitCtx)
+	cancel()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrLockTimeout
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a connection for resource lock: %s", err)
+	}
+
+	ctx := context.Background()
+
+	held := make([]string, 0, len(names))
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			if _, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", held[i]); err != nil {
+				s.log.Warn().Err(err).Str("lock", held[i]).Msg("unable to release resource lock")
+			}
+		}
+		conn.Close()
+	}
+
+	for _, name := range names {
+		lockName := "vxconnect_resource_" + name
+
+		// GET_LOCK only waits whole seconds, rounded up so a lock that is nearly free is still waited for
+		wait := int((time.Until(deadline) + time.Second - 1) / time.Second)
+		if wait < 0 {
+			wait = 0
+		}
+
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, wait).Scan(&acquired); err != nil {
+			release()
+			return nil, fmt.Errorf("unable to acquire resource lock: %s", err)
+		}
+
+		if !acquired.Valid || acquired.Int64 != 1 {
+			release()
+			return nil, ErrLockTimeout
+		}
+
+		held = append(held, lockName)
+	}
+
+	return release, nil
+}
+
+// A copy of the store that only sees, and only creates, the given tenant's data, "" being the provider's
+func (s *MariaDBStore) ForTenant(id string) Store {
+	scoped := *s
+	scoped.tenant = &id
+
+	return &scoped
+}
+
+// Run fn with a copy of the store whose changes are committed together once it returns, or rolled back if it returns an error
+// Resource locks are still taken on their own connections, so they aren't released by the transaction ending
+func (s *MariaDBStore) Transaction(fn func(store Store) error) error {
+	return s.connection.Transaction(func(tx *gorm.DB) error {
+		transactional := *s
+		transactional.connection = tx
+
+		return fn(&transactional)
+	})
+}
+
+// The connection restricted to the store's tenant by the tenant_id column of table
+func (s *MariaDBStore) scoped(table string) *gorm.DB {
+	return s.connection.Scopes(s.tenantScope(table))
+}
+
+// Records have no tenant of their own, they belong to the tenant of their zone even once it is soft deleted
+func (s *MariaDBStore) scopedRecords() *gorm.DB {
+	if s.tenant == nil {
+		return s.connection
+	}
+
+	return s.connection.Where("records.zone_id IN (?)", s.connection.Unscoped().Model(&entity.Zone{}).Select("id").Where("tenant_id = ?", *s.tenant))
+}
+
+// scoped for queries that have to start from a transaction rather than the store's connection
+func (s *MariaDBStore) tenantScope(table string) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		if s.tenant == nil {
+			return query
+		}
+
+		return query.Where(table+".tenant_id = ?", *s.tenant)
+	}
+}
+
+// The tenant rows created through this store belong to
+func (s *MariaDBStore) creatingTenant() string {
+	if s.tenant == nil {
+		return ""
+	}
+
+	return *s.tenant
+}
+
+// Check a row belongs to the store's tenant before saving it, Save inserts or overwrites the row when its update matches nothing
+func (s *MariaDBStore) owned(query *gorm.DB, model interface{}, id string) error {
+	if s.tenant == nil {
+		return nil
+	}
+
+	var count int64
+	result := query.Model(model).Where("id = ?", id).Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
 	return nil
 }
 
+// Apply an order to a query, the order must come from an allowlist as it is not escaped
+func (s *MariaDBStore) ordered(query *gorm.DB, order string) *gorm.DB {
+	if order == "" {
+		return query
+	}
+
+	return query.Order(order)
+}
+
 func (s *MariaDBStore) CreateUser(user *entity.User) error {
+	user.TenantID = s.creatingTenant()
+	indexUser(user)
 	result := s.connection.Create(user)
 
 	return result.Error
 }
 
-func (s *MariaDBStore) GetUsers() ([]*entity.User, error) {
+func (s *MariaDBStore) GetUsers(order string) ([]*entity.User, error) {
 	users := []*entity.User{}
-	result := s.connection.Find(&users)
+	result := s.ordered(s.scoped("users"), order).Find(&users)
 
 	if result.Error != nil {
 		return nil, fmt.Errorf("unable to query DB for users: %s", result.Error)
@@ -76,9 +438,9 @@ func (s *MariaDBStore) GetUsers() ([]*entity.User, error) {
 
 func (s *MariaDBStore) GetUserById(id string) (*entity.User, error) {
 	user := &entity.User{}
-	result := s.connection.First(user, "id = ?", id)
+	result := s.scoped("users").First(user, "id = ?", id)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return nil, fmt.Errorf("user not found: %s", result.Error)
+		return nil, fmt.Errorf("user not found: %w", result.Error)
 	}
 
 	if result.Error != nil {
@@ -88,11 +450,42 @@ func (s *MariaDBStore) GetUserById(id string) (*entity.User, error) {
 	return user, nil
 }
 
+// Get a user only if they have been soft deleted
+func (s *MariaDBStore) GetDeletedUserById(id string) (*entity.User, error) {
+	user := &entity.User{}
+	result := s.scoped("users").Unscoped().First(user, "id = ? AND deleted_at != 0", id)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("deleted user not found: %s", result.Error)
+	}
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("unable to query DB for deleted user by id: %s", result.Error)
+	}
+
+	return user, nil
+}
+
+func (s *MariaDBStore) GetUserByEmail(email string) (*entity.User, error) {
+	user := &entity.User{}
+	var result *gorm.DB
+	if encryption.Enabled() {
+		result = s.scoped("users").First(user, "email_index = ?", encryption.Index(email))
+	} else {
+		result = s.scoped("users").First(user, "email = ?", email)
+	}
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return user, nil
+}
+
 func (s *MariaDBStore) GetUserByUsername(username string) (*entity.User, error) {
 	user := &entity.User{}
-	result := s.connection.First(user, "username = ?", username)
+	result := s.scoped("users").First(user, "username = ?", username)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return nil, fmt.Errorf("username not found: %s", result.Error)
+		return nil, fmt.Errorf("username not found: %w", result.Error)
 	}
 
 	if result.Error != nil {
@@ -103,20 +496,98 @@ func (s *MariaDBStore) GetUserByUsername(username string) (*entity.User, error)
 }
 
 func (s *MariaDBStore) SaveUser(user *entity.User) error {
+	if err := s.owned(s.scoped("users"), &entity.User{}, user.ID); err != nil {
+		return err
+	}
+
+	indexUser(user)
 	result := s.connection.Save(user)
 
 	return result.Error
 }
 
 func (s *MariaDBStore) DeleteUser(id string) error {
-	result := s.connection.Delete(&entity.User{}, "id = ?", id)
+	result := s.scoped("users").Delete(&entity.User{}, "id = ?", id)
 
 	return result.Error
 }
 
-func (s *MariaDBStore) GetZones() ([]*entity.Zone, error) {
+// Enable or disable a set of users in a single transaction
+func (s *MariaDBStore) SetUsersDisabled(ids []string, disabled bool) error {
+	return s.connection.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entity.User{}).Where("id IN ?", ids).Scopes(s.tenantScope("users")).Update("disabled", disabled)
+
+		return result.Error
+	})
+}
+
+// Add a zone name to (or remove it from) those of a batch of users that match, in one transaction,
+// returning how many users matched and how many actually changed
+// Users are matched once their rows are locked, so a change to them made in the meantime is taken into account
+func (s *MariaDBStore) SetUsersZone(ids []string, match func(user *entity.User) bool, zone string, assigned bool) (int, int, error) {
+	matched := 0
+	changed := 0
+
+	err := s.connection.Transaction(func(tx *gorm.DB) error {
+		users := []*entity.User{}
+		if result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", ids).Scopes(s.tenantScope("users")).Find(&users); result.Error != nil {
+			return result.Error
+		}
+
+		for _, user := range users {
+			if !match(user) {
+				continue
+			}
+			matched++
+
+			zones := []string{}
+			has := false
+			for _, existing := range user.Zones {
+				if existing == zone {
+					has = true
+					if !assigned {
+						continue
+					}
+				}
+				zones = append(zones, existing)
+			}
+
+			if has == assigned {
+				continue
+			}
+
+			if assigned {
+				zones = append(zones, zone)
+			}
+
+			if result := tx.Model(user).Update("zones", zones); result.Error != nil {
+				return result.Error
+			}
+			changed++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return matched, changed, nil
+}
+
+func (s *MariaDBStore) Ping() error {
+	db, err := s.connection.DB()
+	if err != nil {
+		return err
+	}
+
+	return db.Ping()
+}
+
+func (s *MariaDBStore) GetZones(order string) ([]*entity.Zone, error) {
 	zones := []*entity.Zone{}
-	result := s.connection.Find(&zones)
+	result := s.ordered(s.scoped("zones"), order).Find(&zones)
 
 	if result.Error != nil {
 		return nil, fmt.Errorf("unable to query DB for zones: %s", result.Error)
@@ -127,7 +598,19 @@ func (s *MariaDBStore) GetZones() ([]*entity.Zone, error) {
 
 func (s *MariaDBStore) GetZoneByID(id string) (*entity.Zone, error) {
 	zone := &entity.Zone{}
-	result := s.connection.First(zone, "id = ?", id)
+	result := s.scoped("zones").First(zone, "id = ?", id)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return zone, nil
+}
+
+// Get a zone only if it has been soft deleted
+func (s *MariaDBStore) GetDeletedZoneByID(id string) (*entity.Zone, error) {
+	zone := &entity.Zone{}
+	result := s.scoped("zones").Unscoped().First(zone, "id = ? AND deleted_at != 0", id)
 
 	if result.Error != nil {
 		return nil, result.Error
@@ -137,32 +620,67 @@ func (s *MariaDBStore) GetZoneByID(id string) (*entity.Zone, error) {
 }
 
 func (s *MariaDBStore) CreateZone(zone *entity.Zone) error {
+	zone.TenantID = s.creatingTenant()
 	result := s.connection.Create(zone)
 
 	return result.Error
 }
 
 func (s *MariaDBStore) SaveZone(zone *entity.Zone) error {
+	if err := s.owned(s.scoped("zones"), &entity.Zone{}, zone.ID); err != nil {
+		return err
+	}
+
 	result := s.connection.Save(zone)
 
 	return result.Error
 }
 
 func (s *MariaDBStore) DeleteZone(id string) error {
-	result := s.connection.Delete(&entity.Zone{}, "id = ?", id)
+	result := s.scoped("zones").Delete(&entity.Zone{}, "id = ?", id)
 
 	return result.Error
 }
 
-func (s *MariaDBStore) DeleteZoneRecords(zone string) error {
-	result := s.connection.Delete(&entity.Record{}, "zone_id = ?", zone)
+// Restore a soft deleted zone, its records were left in place when it was deleted so they come back with it
+func (s *MariaDBStore) RestoreZone(id string) error {
+	result := s.scoped("zones").Unscoped().Model(&entity.Zone{}).Where("id = ? AND deleted_at != 0", id).Update("deleted_at", 0)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 
-	return result.Error
+	return nil
+}
+
+// Check which of the given zone names exist in a single query, names that are absent from the DB map to false
+// Zone names are DNS names so they match whatever their case, each is reported under the spelling it was asked with
+func (s *MariaDBStore) ZonesExist(names []string) (map[string]bool, error) {
+	found := []string{}
+	result := s.scoped("zones").Model(&entity.Zone{}).Where("name IN ?", names).Pluck("name", &found)
+	if result.Error != nil {
+		return nil, fmt.Errorf("unable to query DB for zones: %s", result.Error)
+	}
+
+	stored := make(map[string]bool, len(found))
+	for _, name := range found {
+		stored[strings.ToLower(name)] = true
+	}
+
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = stored[strings.ToLower(name)]
+	}
+
+	return exists, nil
 }
 
-func (s *MariaDBStore) GetZoneRecords(zone string) ([]*entity.Record, error) {
+func (s *MariaDBStore) GetZoneRecords(zone, order string) ([]*entity.Record, error) {
 	records := []*entity.Record{}
-	result := s.connection.Find(&records, "zone_id = ?", zone)
+	result := s.ordered(s.scopedRecords(), order).Find(&records, "zone_id = ?", zone)
 
 	if result.Error != nil {
 		return nil, fmt.Errorf("unable to query DB for zone records: %s", result.Error)
@@ -173,7 +691,7 @@ func (s *MariaDBStore) GetZoneRecords(zone string) ([]*entity.Record, error) {
 
 func (s *MariaDBStore) GetRecordByID(id string) (*entity.Record, error) {
 	record := &entity.Record{}
-	result := s.connection.First(&record, "id = ?", id)
+	result := s.scopedRecords().First(&record, "id = ?", id)
 
 	if result.Error != nil {
 		return nil, fmt.Errorf("unable to query DB for zone records: %s", result.Error)
@@ -184,7 +702,8 @@ func (s *MariaDBStore) GetRecordByID(id string) (*entity.Record, error) {
 
 func (s *MariaDBStore) GetRecordbyName(name string) (*entity.Record, error) {
 	record := &entity.Record{}
-	result := s.connection.First(&record, "name = ?", name)
+	// Records in a deleted zone are kept for restoring but must not resolve
+	result := s.connection.Joins("JOIN zones ON zones.id = records.zone_id AND zones.deleted_at = 0").First(&record, "records.name = ?", name)
 
 	if result.Error != nil {
 		return nil, fmt.Errorf("unable to query DB for zone records: %s", result.Error)
@@ -194,19 +713,282 @@ func (s *MariaDBStore) GetRecordbyName(name string) (*entity.Record, error) {
 }
 
 func (s *MariaDBStore) CreateRecord(record *entity.Record) error {
+	if err := s.owned(s.scoped("zones").Unscoped(), &entity.Zone{}, record.ZoneID); err != nil {
+		return err
+	}
+
 	result := s.connection.Create(record)
 
 	return result.Error
 }
 
 func (s *MariaDBStore) SaveRecord(record *entity.Record) error {
+	if err := s.owned(s.scopedRecords(), &entity.Record{}, record.ID); err != nil {
+		return err
+	}
+
 	result := s.connection.Save(record)
 
 	return result.Error
 }
 
 func (s *MariaDBStore) DeleteRecord(id string) error {
-	result := s.connection.Delete(&entity.Record{}, "id = ?", id)
+	result := s.scopedRecords().Delete(&entity.Record{}, "id = ?", id)
+
+	return result.Error
+}
+
+// Record a login attempt, pruning the user's history down to the newest keep attempts
+func (s *MariaDBStore) CreateLoginAttempt(attempt *entity.LoginAttempt, keep int) error {
+	result := s.connection.Create(attempt)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var cutoff []time.Time
+	cutoffResult := s.connection.Model(&entity.LoginAttempt{}).Where("user_id = ?", attempt.UserID).
+		Order("created_at DESC").Offset(keep-1).Limit(1).Pluck("created_at", &cutoff)
+	if cutoffResult.Error != nil {
+		return fmt.Errorf("unable to find login history to prune: %s", cutoffResult.Error)
+	}
+
+	if len(cutoff) == 0 {
+		return nil
+	}
+
+	pruneResult := s.connection.Where("user_id = ? AND created_at < ?", attempt.UserID, cutoff[0]).Delete(&entity.LoginAttempt{})
+	return pruneResult.Error
+}
+
+// Get a user's login history newest first
+func (s *MariaDBStore) GetLoginAttempts(userID string) ([]*entity.LoginAttempt, error) {
+	attempts := []*entity.LoginAttempt{}
+	query := s.connection.Order("created_at DESC")
+	if s.tenant != nil {
+		query = query.Where("user_id IN (?)", s.scoped("users").Unscoped().Model(&entity.User{}).Select("id"))
+	}
+
+	result := query.Find(&attempts, "user_id = ?", userID)
+	if result.Error != nil {
+		return nil, fmt.Errorf("unable to query DB for login history: %s", result.Error)
+	}
+
+	return attempts, nil
+}
+
+func (s *MariaDBStore) CreateAuditEntry(entry *entity.AuditEntry) error {
+	result := s.connection.Create(entry)
 
 	return result.Error
 }
+
+// Get audit entries newest first, optionally filtered to a single target type and id
+func (s *MariaDBStore) GetAuditEntries(targetType, targetID string, offset, limit int) ([]*entity.AuditEntry, int64, error) {
+	query := s.scoped("audit_entries").Model(&entity.AuditEntry{})
+
+	if targetType != "" {
+		query = query.Where("target_type = ?", targetType)
+	}
+
+	if targetID != "" {
+		query = query.Where("target_id = ?", targetID)
+	}
+
+	var total int64
+	countResult := query.Count(&total)
+	if countResult.Error != nil {
+		return nil, 0, fmt.Errorf("unable to count audit entries: %s", countResult.Error)
+	}
+
+	entries := []*entity.AuditEntry{}
+	result := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&entries)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("unable to query DB for audit entries: %s", result.Error)
+	}
+
+	return entries, total, nil
+}
+
+func (s *MariaDBStore) BreakGlassUsed(credentialHash string) (bool, error) {
+	var count int64
+	result := s.connection.Model(&entity.BreakGlassUse{}).Where("credential_hash = ?", credentialHash).Count(&count)
+	if result.Error != nil {
+		return false, fmt.Errorf("unable to query DB for break-glass uses: %s", result.Error)
+	}
+
+	return count > 0, nil
+}
+
+// Spend a break-glass credential and create the admin it was exchanged for in one transaction
+// The credential hash is the primary key of its use, so of two instances spending it at once only one can commit
+func (s *MariaDBStore) CreateBreakGlassAdmin(use *entity.BreakGlassUse, user *entity.User) error {
+	user.TenantID = s.creatingTenant()
+	indexUser(user)
+
+	return s.connection.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(use); result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+				return ErrBreakGlassSpent
+			}
+			return result.Error
+		}
+
+		return tx.Create(user).Error
+	})
+}
+
+func (s *MariaDBStore) CreatePendingAction(action *entity.PendingAction) error {
+	action.TenantID = s.creatingTenant()
+	result := s.connection.Create(action)
+
+	return result.Error
+}
+
+// Get pending actions newest first, optionally filtered to a single status
+func (s *MariaDBStore) GetPendingActions(status string) ([]*entity.PendingAction, error) {
+	query := s.scoped("pending_actions").Order("created_at DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	actions := []*entity.PendingAction{}
+	result := query.Find(&actions)
+	if result.Error != nil {
+		return nil, fmt.Errorf("unable to query DB for pending actions: %s", result.Error)
+	}
+
+	return actions, nil
+}
+
+func (s *MariaDBStore) GetPendingActionByID(id string) (*entity.PendingAction, error) {
+	action := &entity.PendingAction{}
+	result := s.scoped("pending_actions").First(action, "id = ?", id)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return action, nil
+}
+
+// Move a pending action to approved or rejected, only ever succeeding once per action
+// so two admins deciding at the same time can't both carry it out
+func (s *MariaDBStore) DecidePendingAction(id, status, decidedBy string) error {
+	result := s.scoped("pending_actions").Model(&entity.PendingAction{}).
+		Where("id = ? AND status = ?", id, entity.APPROVAL_PENDING).
+		Updates(map[string]interface{}{"status": status, "decided_by": decidedBy, "decided_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (s *MariaDBStore) SavePendingAction(action *entity.PendingAction) error {
+	if err := s.owned(s.scoped("pending_actions"), &entity.PendingAction{}, action.ID); err != nil {
+		return err
+	}
+
+	result := s.connection.Save(action)
+
+	return result.Error
+}
+
+func (s *MariaDBStore) GetTenants() ([]*entity.Tenant, error) {
+	tenants := []*entity.Tenant{}
+	result := s.connection.Order("name").Find(&tenants)
+	if result.Error != nil {
+		return nil, fmt.Errorf("unable to query DB for tenants: %s", result.Error)
+	}
+
+	return tenants, nil
+}
+
+func (s *MariaDBStore) GetTenantByID(id string) (*entity.Tenant, error) {
+	tenant := &entity.Tenant{}
+	result := s.connection.First(tenant, "id = ?", id)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tenant, nil
+}
+
+func (s *MariaDBStore) GetTenantByName(name string) (*entity.Tenant, error) {
+	tenant := &entity.Tenant{}
+	result := s.connection.First(tenant, "name = ?", name)
+
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return tenant, nil
+}
+
+// Create a tenant along with its first admin in one transaction, so a tenant never exists without anyone able to manage it
+func (s *MariaDBStore) CreateTenant(tenant *entity.Tenant, admin *entity.User) error {
+	return s.connection.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(tenant); result.Error != nil {
+			return result.Error
+		}
+
+		admin.TenantID = tenant.ID
+		indexUser(admin)
+		result := tx.Create(admin)
+
+		return result.Error
+	})
+}
+
+// Store a new magic link, clearing out expired ones
+// Links the user already has outstanding keep working, so requesting links for someone else's address can't lock them out
+func (s *MariaDBStore) CreateMagicLink(link *entity.MagicLink) error {
+	return s.connection.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Where("expires_at < ?", time.Now()).Delete(&entity.MagicLink{}); result.Error != nil {
+			return result.Error
+		}
+
+		result := tx.Create(link)
+
+		return result.Error
+	})
+}
+
+// Spend the magic link with this token hash, only ever succeeding once and only before it expires
+// The user's other outstanding links are spent along with it, they are replaced by the login
+func (s *MariaDBStore) UseMagicLink(tokenHash string) (*entity.MagicLink, error) {
+	now := time.Now()
+	link := &entity.MagicLink{}
+
+	err := s.connection.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entity.MagicLink{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if result := tx.First(link, "token_hash = ?", tokenHash); result.Error != nil {
+			return result.Error
+		}
+
+		result = tx.Model(&entity.MagicLink{}).Where("user_id = ? AND used_at IS NULL", link.UserID).Update("used_at", now)
+
+		return result.Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
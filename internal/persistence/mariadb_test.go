@@ -0,0 +1,234 @@
+package persistence
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monoxane/vxconnect/internal/entity"
+	"github.com/monoxane/vxconnect/internal/logging"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+func TestMigrationStopsAtTheFirstFailingEntity(t *testing.T) {
+	// Nothing listens here, so the very first entity fails
+	conn, openErr := gorm.Open(mysql.New(mysql.Config{DSN: "test:test@tcp(127.0.0.1:1)/test", SkipInitializeWithVersion: true}), &gorm.Config{DisableAutomaticPing: true, Logger: logger.Default.LogMode(logger.Silent)})
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+
+	migrateErr := migrateEntities(conn)
+	if migrateErr == nil || !strings.Contains(migrateErr.Error(), "*entity.User") {
+		t.Fatalf("expected the failure of the first entity to be returned, got %v", migrateErr)
+	}
+}
+
+func TestMigratedEntitiesParse(t *testing.T) {
+	for _, model := range migratedEntities {
+		if _, parseErr := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{}); parseErr != nil {
+			t.Fatalf("unable to parse %T: %s", model, parseErr)
+		}
+	}
+}
+
+func TestConnectingRetriesUntilTheDatabaseIsUp(t *testing.T) {
+	attempts := 0
+	connectErr := retryUntil(time.Now().Add(time.Second), time.Millisecond, logging.Log, func() error {
+		attempts++
+		if attempts < 4 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	if connectErr != nil || attempts != 4 {
+		t.Fatalf("expected to connect on the 4th attempt, got %d attempts and %v", attempts, connectErr)
+	}
+}
+
+func TestConnectingGivesUpAtTheDeadline(t *testing.T) {
+	attempts := 0
+	started := time.Now()
+	connectErr := retryUntil(started.Add(50*time.Millisecond), 10*time.Millisecond, logging.Log, func() error {
+		attempts++
+		return errors.New("connection refused")
+	})
+
+	if connectErr == nil || !strings.Contains(connectErr.Error(), "connection refused") {
+		t.Fatalf("expected the last error once the retry budget ran out, got %v", connectErr)
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second || attempts < 2 {
+		t.Fatalf("expected a few attempts within the budget, got %d in %s", attempts, elapsed)
+	}
+}
+
+// A migrationLock that behaves like GET_LOCK, waiting up to its timeout for whoever holds it
+type fakeMigrationLock struct {
+	held    chan struct{}
+	timeout time.Duration
+}
+
+func newFakeMigrationLock(timeout time.Duration) *fakeMigrationLock {
+	return &fakeMigrationLock{held: make(chan struct{}, 1), timeout: timeout}
+}
+
+func (l *fakeMigrationLock) acquire() (bool, error) {
+	select {
+	case l.held <- struct{}{}:
+		return true, nil
+	case <-time.After(l.timeout):
+		return false, nil
+	}
+}
+
+func (l *fakeMigrationLock) release() {
+	<-l.held
+}
+
+func TestConcurrentMigrationsRunOneAfterTheOther(t *testing.T) {
+	lock := newFakeMigrationLock(time.Second)
+
+	var mu sync.Mutex
+	running, overlapped, migrated := 0, false, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			migrateErr := whileMigrationLocked(lock, 1, func() error {
+				mu.Lock()
+				running++
+				overlapped = overlapped || running > 1
+				mu.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				migrated++
+				mu.Unlock()
+				return nil
+			})
+			if migrateErr != nil {
+				t.Error(migrateErr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if overlapped || migrated != 2 {
+		t.Fatalf("expected both migrations to run one after the other, got %d migrations overlapping %t", migrated, overlapped)
+	}
+}
+
+func TestMigrationLockTimeoutFailsWithoutMigrating(t *testing.T) {
+	lock := newFakeMigrationLock(20 * time.Millisecond)
+
+	// Another instance is still migrating
+	if acquired, _ := lock.acquire(); !acquired {
+		t.Fatal("expected the lock to be free")
+	}
+	defer lock.release()
+
+	migrateErr := whileMigrationLocked(lock, 1, func() error {
+		t.Error("expected nothing to be migrated without the lock")
+		return nil
+	})
+
+	if migrateErr == nil || !strings.Contains(migrateErr.Error(), "timed out") {
+		t.Fatalf("expected waiting too long for the lock to fail, got %v", migrateErr)
+	}
+}
+
+func TestMigrationLockErrorsFailWithoutMigrating(t *testing.T) {
+	migrateErr := whileMigrationLocked(failingMigrationLock{}, 1, func() error {
+		t.Error("expected nothing to be migrated without the lock")
+		return nil
+	})
+
+	if migrateErr == nil || !strings.Contains(migrateErr.Error(), "unable to acquire migration lock") {
+		t.Fatalf("expected a failure to take the lock to be returned, got %v", migrateErr)
+	}
+}
+
+type failingMigrationLock struct{}
+
+func (failingMigrationLock) acquire() (bool, error) { return false, errors.New("connection refused") }
+func (failingMigrationLock) release()               {}
+
+// An emailUniqueIndex recording what was done to it
+type fakeEmailIndex struct {
+	present bool
+	created int
+	dropped int
+}
+
+func (i *fakeEmailIndex) exists() bool { return i.present }
+
+func (i *fakeEmailIndex) create() error {
+	i.present = true
+	i.created++
+	return nil
+}
+
+func (i *fakeEmailIndex) drop() error {
+	i.present = false
+	i.dropped++
+	return nil
+}
+
+func TestEmailsStayUniqueWithoutEncryption(t *testing.T) {
+	// A fresh install, which gets no unique index from the schema
+	index := &fakeEmailIndex{}
+	if err := keepEmailsUnique(index, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if !index.present || index.created != 1 {
+		t.Fatalf("expected plain emails to be made unique, got %+v", index)
+	}
+
+	if err := keepEmailsUnique(index, false); err != nil || index.created != 1 {
+		t.Fatalf("expected an existing index to be kept as is, got %+v: %v", index, err)
+	}
+}
+
+func TestEncryptedEmailsAreUniqueByTheirIndexInstead(t *testing.T) {
+	index := &fakeEmailIndex{present: true}
+	if err := keepEmailsUnique(index, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if index.present || index.dropped != 1 {
+		t.Fatalf("expected the index on encrypted emails to be dropped, got %+v", index)
+	}
+
+	if err := keepEmailsUnique(index, true); err != nil || index.dropped != 1 || index.created != 0 {
+		t.Fatalf("expected nothing more to be done once the index is gone, got %+v: %v", index, err)
+	}
+}
+
+func TestZoneNamesAreUniquePerTenant(t *testing.T) {
+	zone, parseErr := schema.Parse(&entity.Zone{}, &sync.Map{}, schema.NamingStrategy{})
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+
+	if zone.LookUpField("Name").Unique {
+		t.Fatal("expected zone names not to be unique across tenants")
+	}
+
+	index, ok := zone.ParseIndexes()["idx_zones_tenant_name"]
+	if !ok || index.Class != "UNIQUE" || len(index.Fields) != 2 || index.Fields[0].DBName != "tenant_id" || index.Fields[1].DBName != "name" {
+		t.Fatalf("expected a unique index on the tenant and name of a zone, got %+v", index)
+	}
+}
@@ -6,25 +6,59 @@ import (
 
 type Store interface {
 	Migrate() error
+	Ping() error
+	BreakerState() string
+	LockResources(names []string, timeout int) (func(), error)
+	ForTenant(id string) Store
+	Transaction(fn func(store Store) error) error
 
-	GetUsers() ([]*entity.User, error)
+	GetUsers(order string) ([]*entity.User, error)
 	GetUserById(id string) (*entity.User, error)
+	GetDeletedUserById(id string) (*entity.User, error)
 	GetUserByUsername(username string) (*entity.User, error)
+	GetUserByEmail(email string) (*entity.User, error)
 	CreateUser(user *entity.User) error
 	SaveUser(user *entity.User) error
 	DeleteUser(id string) error
+	SetUsersDisabled(ids []string, disabled bool) error
+	SetUsersZone(ids []string, match func(user *entity.User) bool, zone string, assigned bool) (int, int, error)
 
-	GetZones() ([]*entity.Zone, error)
+	GetZones(order string) ([]*entity.Zone, error)
 	GetZoneByID(id string) (*entity.Zone, error)
+	GetDeletedZoneByID(id string) (*entity.Zone, error)
 	CreateZone(zone *entity.Zone) error
 	SaveZone(zone *entity.Zone) error
 	DeleteZone(id string) error
-	DeleteZoneRecords(id string) error
+	RestoreZone(id string) error
+	ZonesExist(names []string) (map[string]bool, error)
 
-	GetZoneRecords(zone string) ([]*entity.Record, error)
+	GetZoneRecords(zone, order string) ([]*entity.Record, error)
 	GetRecordByID(id string) (*entity.Record, error)
 	GetRecordbyName(name string) (*entity.Record, error)
 	CreateRecord(record *entity.Record) error
 	SaveRecord(record *entity.Record) error
 	DeleteRecord(id string) error
+
+	BreakGlassUsed(credentialHash string) (bool, error)
+	CreateBreakGlassAdmin(use *entity.BreakGlassUse, user *entity.User) error
+
+	CreatePendingAction(action *entity.PendingAction) error
+	GetPendingActions(status string) ([]*entity.PendingAction, error)
+	GetPendingActionByID(id string) (*entity.PendingAction, error)
+	DecidePendingAction(id, status, decidedBy string) error
+	SavePendingAction(action *entity.PendingAction) error
+
+	CreateMagicLink(link *entity.MagicLink) error
+	UseMagicLink(tokenHash string) (*entity.MagicLink, error)
+
+	CreateLoginAttempt(attempt *entity.LoginAttempt, keep int) error
+	GetLoginAttempts(userID string) ([]*entity.LoginAttempt, error)
+
+	CreateAuditEntry(entry *entity.AuditEntry) error
+	GetAuditEntries(targetType, targetID string, offset, limit int) ([]*entity.AuditEntry, int64, error)
+
+	GetTenants() ([]*entity.Tenant, error)
+	GetTenantByID(id string) (*entity.Tenant, error)
+	GetTenantByName(name string) (*entity.Tenant, error)
+	CreateTenant(tenant *entity.Tenant, admin *entity.User) error
 }
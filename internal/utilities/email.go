@@ -0,0 +1,66 @@
+package utilities
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+const maxEmailLength int = 254
+
+// Normalise an email address to the form it is stored and compared in,
+// returning an error if it isn't structurally deliverable
+// The domain is always lower cased, the local part only when EMAIL_LOWERCASE_LOCAL_PART is set
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", errors.New("email address is empty")
+	}
+
+	if len(email) > maxEmailLength {
+		return "", errors.New("email address is too long")
+	}
+
+	// Reject display names, comments and anything else that isn't a bare address
+	address, parseErr := mail.ParseAddress(email)
+	if parseErr != nil || address.Name != "" || address.Address != email {
+		return "", errors.New("email address is malformed")
+	}
+
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+
+	if !validEmailDomain(domain) {
+		return "", errors.New("email address domain is invalid")
+	}
+
+	if config.EmailLowercaseLocalPart {
+		local = strings.ToLower(local)
+	}
+
+	return local + "@" + domain, nil
+}
+
+// A domain must be a dotted host name, IP literals and single label hosts aren't deliverable for our purposes
+func validEmailDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+
+		for _, character := range label {
+			if !(character >= 'a' && character <= 'z' || character >= '0' && character <= '9' || character == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
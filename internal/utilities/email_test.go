@@ -0,0 +1,50 @@
+package utilities
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monoxane/vxconnect/internal/config"
+)
+
+func TestNormalizedEmailsAreEquivalent(t *testing.T) {
+	for _, email := range []string{"Alice@Example.COM", "  Alice@example.com\t", "Alice@EXAMPLE.com"} {
+		normalized, normalizeErr := NormalizeEmail(email)
+		if normalizeErr != nil {
+			t.Fatalf("expected %q to be accepted, got %s", email, normalizeErr)
+		}
+
+		if normalized != "Alice@example.com" {
+			t.Fatalf("expected %q to normalise to Alice@example.com, got %q", email, normalized)
+		}
+	}
+
+	previous := config.EmailLowercaseLocalPart
+	config.EmailLowercaseLocalPart = true
+	defer func() { config.EmailLowercaseLocalPart = previous }()
+
+	if normalized, _ := NormalizeEmail("Alice@Example.COM"); normalized != "alice@example.com" {
+		t.Fatalf("expected the local part to be lower cased when configured, got %q", normalized)
+	}
+}
+
+func TestMalformedEmailsAreRejected(t *testing.T) {
+	for _, email := range []string{
+		"",
+		"alice",
+		"alice@",
+		"@example.com",
+		"alice@localhost",
+		"alice@[192.0.2.1]",
+		"alice@example..com",
+		"alice@-example.com",
+		"alice@exa_mple.com",
+		"Alice <alice@example.com>",
+		"alice@example.com (work)",
+		strings.Repeat("a", 250) + "@example.com",
+	} {
+		if normalized, normalizeErr := NormalizeEmail(email); normalizeErr == nil {
+			t.Fatalf("expected %q to be rejected, got %q", email, normalized)
+		}
+	}
+}
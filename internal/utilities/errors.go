@@ -1,6 +1,9 @@
 package utilities
 
 import (
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/monoxane/vxconnect/internal/entity"
 )
@@ -19,3 +22,12 @@ func RESTError(context *gin.Context, code int, message string, err error) {
 		})
 	}
 }
+
+// Respond 410 Gone for a resource that has been deleted, deletedAt is nil when the caller may not see when
+func RESTGone(context *gin.Context, message string, deletedAt *time.Time) {
+	context.JSON(http.StatusGone, entity.RESTError{
+		StatusCode: http.StatusGone,
+		Message:    message,
+		DeletedAt:  deletedAt,
+	})
+}
@@ -0,0 +1,31 @@
+package utilities
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set a weak ETag for a resource version and check it against If-None-Match
+// Returns true (having responded with 304) if the client already has this version
+func NotModified(context *gin.Context, version string) bool {
+	etag := fmt.Sprintf("W/\"%s\"", version)
+	context.Header("ETag", etag)
+
+	ifNoneMatch := context.GetHeader("If-None-Match")
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			context.Status(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,25 @@
+package utilities
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/monoxane/vxconnect/internal/entity"
+)
+
+// Respond with a single resource in the standard result envelope
+func RESTResource(context *gin.Context, code int, resource interface{}) {
+	context.JSON(code, entity.RESTResult{
+		Results:      []interface{}{resource},
+		TotalResults: 1,
+	})
+}
+
+// Respond to a bulk request that was processed with the outcome of every item
+// This is always a 207 Multi-Status, whole request failures use a normal error response instead
+func RESTBulk(context *gin.Context, results []entity.BulkResult) {
+	context.JSON(http.StatusMultiStatus, entity.RESTResult{
+		Results:      results,
+		TotalResults: len(results),
+	})
+}
@@ -0,0 +1,8 @@
+package utilities
+
+import "github.com/monoxane/vxconnect/internal/config"
+
+// Build a link to a path on this server, respecting the configured base path
+func URL(path string) string {
+	return config.BasePath + path
+}